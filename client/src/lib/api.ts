@@ -1,4 +1,4 @@
-import type { MediaItem, TranscriptionStatus, MediaFilters } from './types';
+import type { MediaItem, Transcript, TranscriptRevision, TranscriptEditResult, TranscriptCleanup, TranscriptionStatus, TranscriptionQueueState, SpeakerSummary, MediaFilters, SimilarGroup, UploadFileResponse, ApplyLabelRulesResult, TranscriptVersion, TranscriptVersionDiff, BulkRetranscribeResult, SummaryState } from './types';
 
 /**
  * Fetches media items from the API
@@ -19,6 +19,12 @@ export async function fetchMediaItems(filters?: MediaFilters): Promise<MediaItem
       if (filters.labels && filters.labels.length > 0) {
         url.searchParams.set('labels', filters.labels.join(','));
       }
+      if (filters.speakers && filters.speakers.length > 0) {
+        url.searchParams.set('speaker', filters.speakers.join(','));
+      }
+      if (filters.languages && filters.languages.length > 0) {
+        url.searchParams.set('language', filters.languages.join(','));
+      }
     }
     
     const response = await fetch(url.toString());
@@ -34,11 +40,21 @@ export async function fetchMediaItems(filters?: MediaFilters): Promise<MediaItem
 
 /**
  * Fetches transcription status from the API
+ * @param status Optional states to include, e.g. ['queued', 'processing']
+ * @param filename Optional filename substring
  * @returns Promise with array of transcription statuses
  */
-export async function fetchTranscriptionStatus(): Promise<TranscriptionStatus[]> {
+export async function fetchTranscriptionStatus(status: string[] = [], filename = ''): Promise<TranscriptionStatus[]> {
   try {
-    const response = await fetch('/api/transcription/status');
+    const params = new URLSearchParams();
+    if (status.length > 0) {
+      params.append('status', status.join(','));
+    }
+    if (filename) {
+      params.append('filename', filename);
+    }
+    const query = params.toString();
+    const response = await fetch(`/api/transcription/status${query ? `?${query}` : ''}`);
     if (!response.ok) {
       throw new Error(`Failed to fetch transcription status: ${response.statusText}`);
     }
@@ -50,6 +66,521 @@ export async function fetchTranscriptionStatus(): Promise<TranscriptionStatus[]>
   }
 }
 
+/**
+ * Re-queues a failed transcription
+ * @param filename Media filename
+ * @returns Promise resolving to true if the job was re-queued
+ */
+export async function retryTranscription(filename: string): Promise<boolean> {
+  try {
+    const response = await fetch(`/api/transcription/${encodeURIComponent(filename)}/retry`, {
+      method: 'POST'
+    });
+    if (!response.ok) {
+      throw new Error(`Failed to retry transcription: ${await response.text()}`);
+    }
+    return true;
+  } catch (error) {
+    console.error('Error retrying transcription:', error);
+    return false;
+  }
+}
+
+/**
+ * Re-queues every failed transcription
+ * @returns Promise with the filenames that were re-queued
+ */
+export async function retryAllFailedTranscriptions(): Promise<string[]> {
+  try {
+    const response = await fetch('/api/transcription/retry-failed', { method: 'POST' });
+    if (!response.ok) {
+      throw new Error(`Failed to retry transcriptions: ${response.statusText}`);
+    }
+    const data = await response.json();
+    return Array.isArray(data.retried) ? data.retried : [];
+  } catch (error) {
+    console.error('Error retrying transcriptions:', error);
+    return [];
+  }
+}
+
+/**
+ * Runs an action on a transcription job ('cancel', 'front' or 'priority')
+ * @param filename Media filename
+ * @param action Action name
+ * @param body Optional JSON body
+ * @returns Promise resolving to true if the action succeeded
+ */
+async function transcriptionJobAction(filename: string, action: string, body?: unknown): Promise<boolean> {
+  try {
+    const response = await fetch(`/api/transcription/${encodeURIComponent(filename)}/${action}`, {
+      method: 'POST',
+      headers: body ? { 'Content-Type': 'application/json' } : undefined,
+      body: body ? JSON.stringify(body) : undefined
+    });
+    if (!response.ok) {
+      throw new Error(`Failed to ${action} transcription: ${await response.text()}`);
+    }
+    return true;
+  } catch (error) {
+    console.error(`Error running ${action} on transcription:`, error);
+    return false;
+  }
+}
+
+/**
+ * Queues a transcription even if the item already has a transcript,
+ * e.g. one imported from subtitles. The current transcript is kept as a version.
+ * @param model Optional model to run instead of the configured one
+ */
+export function transcribeMedia(filename: string, model?: string): Promise<boolean> {
+  return transcriptionJobAction(filename, 'transcribe', model ? { model } : undefined);
+}
+
+/**
+ * Transcribes every audio and video item matching the filters again
+ * @param filters Filters selecting the items, as for the media listing
+ * @param model Model to run, or empty for the configured one
+ * @param dryRun Only list the items that would be queued
+ * @returns Promise with the queued items, or null on failure
+ */
+export async function retranscribeMedia(filters: MediaFilters | undefined, model: string, dryRun: boolean): Promise<BulkRetranscribeResult | null> {
+  try {
+    const url = new URL('/api/transcription/retranscribe', window.location.origin);
+    if (filters?.startDate) {
+      url.searchParams.set('startDate', filters.startDate);
+    }
+    if (filters?.endDate) {
+      url.searchParams.set('endDate', filters.endDate);
+    }
+    if (filters?.labels && filters.labels.length > 0) {
+      url.searchParams.set('labels', filters.labels.join(','));
+    }
+    if (filters?.speakers && filters.speakers.length > 0) {
+      url.searchParams.set('speaker', filters.speakers.join(','));
+    }
+    if (filters?.languages && filters.languages.length > 0) {
+      url.searchParams.set('language', filters.languages.join(','));
+    }
+
+    const response = await fetch(url.toString(), {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ model, dryRun })
+    });
+    if (!response.ok) {
+      throw new Error(`Failed to queue transcriptions: ${await response.text()}`);
+    }
+    return await response.json();
+  } catch (error) {
+    console.error('Error queueing transcriptions:', error);
+    return null;
+  }
+}
+
+/**
+ * Cancels a queued or running transcription
+ */
+export function cancelTranscription(filename: string): Promise<boolean> {
+  return transcriptionJobAction(filename, 'cancel');
+}
+
+/**
+ * Moves a queued transcription to the front of the queue
+ */
+export function moveTranscriptionToFront(filename: string): Promise<boolean> {
+  return transcriptionJobAction(filename, 'front');
+}
+
+/**
+ * Sets the priority of a transcription job; higher runs first
+ */
+export function setTranscriptionPriority(filename: string, priority: number): Promise<boolean> {
+  return transcriptionJobAction(filename, 'priority', { priority });
+}
+
+/**
+ * Fetches whether the transcription queue is paused and how many jobs it holds
+ */
+export async function fetchTranscriptionQueue(): Promise<TranscriptionQueueState | null> {
+  try {
+    const response = await fetch('/api/transcription/queue');
+    if (!response.ok) {
+      throw new Error(`Failed to fetch transcription queue: ${response.statusText}`);
+    }
+    return await response.json();
+  } catch (error) {
+    console.error('Error fetching transcription queue:', error);
+    return null;
+  }
+}
+
+/**
+ * Pauses or resumes the transcription queue
+ * @param paused True to pause, false to resume
+ */
+export async function setTranscriptionQueuePaused(paused: boolean): Promise<TranscriptionQueueState | null> {
+  try {
+    const response = await fetch(`/api/transcription/${paused ? 'pause' : 'resume'}`, { method: 'POST' });
+    if (!response.ok) {
+      throw new Error(`Failed to ${paused ? 'pause' : 'resume'} transcription queue: ${response.statusText}`);
+    }
+    return await response.json();
+  } catch (error) {
+    console.error('Error updating transcription queue:', error);
+    return null;
+  }
+}
+
+/**
+ * Fetches the transcript of a media item
+ * @param id Media item ID
+ * @param granularity 'word' adds word timings and confidence
+ * @returns Promise with the transcript, or null if it couldn't be loaded
+ */
+export async function fetchTranscript(id: string, granularity: 'segment' | 'word' = 'segment'): Promise<Transcript | null> {
+  try {
+    const response = await fetch(`/api/media/${encodeURIComponent(id)}/transcript?granularity=${granularity}`);
+    if (!response.ok) {
+      throw new Error(`Failed to fetch transcript: ${response.statusText}`);
+    }
+    return await response.json();
+  } catch (error) {
+    console.error('Error fetching transcript:', error);
+    return null;
+  }
+}
+
+/**
+ * Sends a transcript edit and returns the new revision and segments
+ * @param id Media item ID
+ * @param path Edit path below the transcript, e.g. 'segments/3/merge'
+ * @param body Request body including the author
+ */
+async function postTranscriptEdit(id: string, path: string, body: Record<string, unknown>): Promise<TranscriptEditResult | null> {
+  try {
+    const response = await fetch(`/api/media/${encodeURIComponent(id)}/transcript/${path}`, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify(body)
+    });
+    if (!response.ok) {
+      throw new Error(`Failed to edit transcript: ${await response.text()}`);
+    }
+    return await response.json();
+  } catch (error) {
+    console.error('Error editing transcript:', error);
+    return null;
+  }
+}
+
+/**
+ * Edits a segment's text, speaker or boundaries
+ * @param id Media item ID
+ * @param segment Segment number
+ * @param changes Fields to change
+ * @param author Name recorded in the revision history
+ */
+export function editTranscriptSegment(
+  id: string,
+  segment: number,
+  changes: { text?: string; start?: number; end?: number; speaker?: string },
+  author?: string
+): Promise<TranscriptEditResult | null> {
+  return postTranscriptEdit(id, `segments/${segment}`, { ...changes, author });
+}
+
+/**
+ * Splits a segment in two at a time in seconds
+ */
+export function splitTranscriptSegment(id: string, segment: number, at: number, author?: string): Promise<TranscriptEditResult | null> {
+  return postTranscriptEdit(id, `segments/${segment}/split`, { at, author });
+}
+
+/**
+ * Merges a segment with the one after it
+ */
+export function mergeTranscriptSegments(id: string, segment: number, author?: string): Promise<TranscriptEditResult | null> {
+  return postTranscriptEdit(id, `segments/${segment}/merge`, { author });
+}
+
+/**
+ * Restores an earlier revision of a transcript
+ */
+export function restoreTranscriptRevision(id: string, revision: number, author?: string): Promise<TranscriptEditResult | null> {
+  return postTranscriptEdit(id, `revisions/${revision}/restore`, { author });
+}
+
+/**
+ * Re-applies the label rules to every item in the library
+ * @param dryRun Only report the labels that would be added
+ * @returns Promise with the labels added per item, or null on failure
+ */
+export async function applyLabelRules(dryRun: boolean): Promise<ApplyLabelRulesResult | null> {
+  try {
+    const response = await fetch('/api/labels/rules/apply', {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ dryRun })
+    });
+    if (!response.ok) {
+      throw new Error(`Failed to apply label rules: ${await response.text()}`);
+    }
+    return await response.json();
+  } catch (error) {
+    console.error('Error applying label rules:', error);
+    return null;
+  }
+}
+
+/**
+ * Sets the language of a media item
+ * @param id Media item ID
+ * @param language Language code, or empty to detect it
+ * @param retranscribe Whether to transcribe the item again in the new language
+ * @returns Promise with the updated media item
+ */
+export async function setMediaLanguage(id: string, language: string, retranscribe: boolean): Promise<MediaItem | null> {
+  try {
+    const response = await fetch(`/api/media/${encodeURIComponent(id)}/language`, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ language, retranscribe })
+    });
+    if (!response.ok) {
+      throw new Error(`Failed to set language: ${await response.text()}`);
+    }
+    return await response.json();
+  } catch (error) {
+    console.error('Error setting language:', error);
+    return null;
+  }
+}
+
+/**
+ * Fetches a media item's summary and chapters, and whether a new one is being made
+ * @param id Media item ID
+ */
+export async function fetchSummary(id: string): Promise<SummaryState | null> {
+  try {
+    const response = await fetch(`/api/media/${encodeURIComponent(id)}/summary`);
+    if (!response.ok) {
+      throw new Error(`Failed to fetch summary: ${response.statusText}`);
+    }
+    return await response.json();
+  } catch (error) {
+    console.error('Error fetching summary:', error);
+    return null;
+  }
+}
+
+/**
+ * Queues a new summary of a media item's transcript
+ * @param id Media item ID
+ */
+export async function requestSummary(id: string): Promise<SummaryState | null> {
+  try {
+    const response = await fetch(`/api/media/${encodeURIComponent(id)}/summary`, { method: 'POST' });
+    if (!response.ok) {
+      throw new Error(`Failed to request summary: ${await response.text()}`);
+    }
+    return await response.json();
+  } catch (error) {
+    console.error('Error requesting summary:', error);
+    return null;
+  }
+}
+
+/**
+ * Fetches what cleanup removed from a media item's transcript after transcription
+ * @param id Media item ID
+ */
+export async function fetchTranscriptCleanup(id: string): Promise<TranscriptCleanup> {
+  try {
+    const response = await fetch(`/api/media/${encodeURIComponent(id)}/transcript/cleanup`);
+    if (!response.ok) {
+      throw new Error(`Failed to fetch transcript cleanup: ${response.statusText}`);
+    }
+    return await response.json();
+  } catch (error) {
+    console.error('Error fetching transcript cleanup:', error);
+    return { changes: [] };
+  }
+}
+
+/**
+ * Puts back the engine's segments from before cleanup as a new revision
+ * @param id Media item ID
+ * @param author Name recorded in the revision history
+ */
+export function revertTranscriptCleanup(id: string, author?: string): Promise<TranscriptEditResult | null> {
+  return postTranscriptEdit(id, 'cleanup/revert', { author });
+}
+
+/**
+ * Fetches the revision history of a media item's transcript
+ * @param id Media item ID
+ */
+export async function fetchTranscriptRevisions(id: string): Promise<TranscriptRevision[]> {
+  try {
+    const response = await fetch(`/api/media/${encodeURIComponent(id)}/transcript/revisions`);
+    if (!response.ok) {
+      throw new Error(`Failed to fetch transcript revisions: ${response.statusText}`);
+    }
+    return await response.json();
+  } catch (error) {
+    console.error('Error fetching transcript revisions:', error);
+    return [];
+  }
+}
+
+/**
+ * Fetches the versions of a media item's transcript, one per transcription
+ * @param id Media item ID
+ */
+export async function fetchTranscriptVersions(id: string): Promise<TranscriptVersion[]> {
+  try {
+    const response = await fetch(`/api/media/${encodeURIComponent(id)}/transcript/versions`);
+    if (!response.ok) {
+      throw new Error(`Failed to fetch transcript versions: ${response.statusText}`);
+    }
+    return await response.json();
+  } catch (error) {
+    console.error('Error fetching transcript versions:', error);
+    return [];
+  }
+}
+
+/**
+ * Makes a version the active transcript of a media item
+ * @param author Name recorded in the revision history
+ * @returns Promise resolving to true if the version was activated
+ */
+export async function activateTranscriptVersion(id: string, version: number, author?: string): Promise<boolean> {
+  try {
+    const response = await fetch(`/api/media/${encodeURIComponent(id)}/transcript/versions/${version}/activate`, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ author })
+    });
+    if (!response.ok) {
+      throw new Error(`Failed to activate version: ${await response.text()}`);
+    }
+    return true;
+  } catch (error) {
+    console.error('Error activating transcript version:', error);
+    return false;
+  }
+}
+
+/**
+ * Compares two versions of a media item's transcript, aligned by time
+ * @param from Older version
+ * @param to Newer version; the active one if omitted
+ */
+export async function fetchTranscriptVersionDiff(id: string, from: number, to?: number): Promise<TranscriptVersionDiff | null> {
+  try {
+    const query = `from=${from}${to !== undefined ? `&to=${to}` : ''}`;
+    const response = await fetch(`/api/media/${encodeURIComponent(id)}/transcript/versions/diff?${query}`);
+    if (!response.ok) {
+      throw new Error(`Failed to compare versions: ${await response.text()}`);
+    }
+    return await response.json();
+  } catch (error) {
+    console.error('Error comparing transcript versions:', error);
+    return null;
+  }
+}
+
+/**
+ * URL of a media item's transcript in an export format
+ * @param id Media item ID
+ * @param format 'srt', 'vtt', 'txt' or 'json'
+ * @param options Download as an attachment and/or prefix speaker names
+ */
+export function transcriptExportUrl(
+  id: string,
+  format: 'srt' | 'vtt' | 'txt' | 'json',
+  options: { download?: boolean; speakers?: boolean } = {}
+): string {
+  const params = new URLSearchParams();
+  if (options.download) {
+    params.set('download', 'true');
+  }
+  if (options.speakers) {
+    params.set('speakers', 'true');
+  }
+  const query = params.toString();
+  return `/api/media/${encodeURIComponent(id)}/transcript.${format}${query ? `?${query}` : ''}`;
+}
+
+/**
+ * URL of a zip of the transcripts of every media item matching the filters
+ * @param format 'srt', 'vtt', 'txt' or 'json'
+ * @param filters Optional filters for date range, labels and speakers
+ */
+export function bulkTranscriptExportUrl(format: 'srt' | 'vtt' | 'txt' | 'json', filters?: MediaFilters): string {
+  const url = new URL('/api/transcripts/export', window.location.origin);
+  url.searchParams.set('format', format);
+  if (filters?.startDate) {
+    url.searchParams.set('startDate', filters.startDate);
+  }
+  if (filters?.endDate) {
+    url.searchParams.set('endDate', filters.endDate);
+  }
+  if (filters?.labels && filters.labels.length > 0) {
+    url.searchParams.set('labels', filters.labels.join(','));
+  }
+  if (filters?.speakers && filters.speakers.length > 0) {
+    url.searchParams.set('speaker', filters.speakers.join(','));
+  }
+  if (filters?.languages && filters.languages.length > 0) {
+    url.searchParams.set('language', filters.languages.join(','));
+  }
+  return url.pathname + url.search;
+}
+
+/**
+ * Fetches the speaker names used across the library
+ * @returns Promise with array of speakers and how often they appear
+ */
+export async function fetchSpeakers(): Promise<SpeakerSummary[]> {
+  try {
+    const response = await fetch('/api/speakers');
+    if (!response.ok) {
+      throw new Error(`Failed to fetch speakers: ${response.statusText}`);
+    }
+    return await response.json();
+  } catch (error) {
+    console.error('Error fetching speakers:', error);
+    return [];
+  }
+}
+
+/**
+ * Renames a speaker in one media item, or across the library if no ID is given
+ * @param from Speaker ID (e.g. SPEAKER_00) or current name
+ * @param to New name; empty reverts to the speaker ID
+ * @param id Optional media item ID
+ * @returns Promise resolving to true if the speaker was renamed
+ */
+export async function renameSpeaker(from: string, to: string, id?: string): Promise<boolean> {
+  try {
+    const response = await fetch('/api/speakers/rename', {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ id, from, to })
+    });
+    if (!response.ok) {
+      throw new Error(`Failed to rename speaker: ${await response.text()}`);
+    }
+    return true;
+  } catch (error) {
+    console.error('Error renaming speaker:', error);
+    return false;
+  }
+}
+
 /**
  * Updates labels for a media item
  * @param id Media item ID
@@ -78,4 +609,210 @@ export async function updateLabels(id: string, labels: string[]): Promise<MediaI
     console.error('Error updating labels:', error);
     return null;
   }
+}
+
+/**
+ * Fetches clusters of near-duplicate photos from the API
+ * @param maxDistance Optional maximum Hamming distance between perceptual hashes
+ * @returns Promise with array of similar photo groups
+ */
+export async function fetchDuplicateGroups(maxDistance?: number): Promise<SimilarGroup[]> {
+  try {
+    const url = new URL('/api/duplicates', window.location.origin);
+    if (maxDistance !== undefined) {
+      url.searchParams.set('maxDistance', maxDistance.toString());
+    }
+
+    const response = await fetch(url.toString());
+    if (!response.ok) {
+      throw new Error(`Failed to fetch duplicate groups: ${response.statusText}`);
+    }
+    const data = await response.json();
+    return Array.isArray(data) ? data : [];
+  } catch (error) {
+    console.error('Error fetching duplicate groups:', error);
+    return [];
+  }
+}
+
+/**
+ * Keeps one photo of a duplicate group and moves the others to the trash
+ * @param keep ID of the photo to keep
+ * @param trash IDs of the photos to trash
+ * @param maxDistance Maximum Hamming distance the group was listed with
+ * @returns Promise with the IDs that were trashed
+ */
+export async function resolveDuplicates(keep: string, trash: string[], maxDistance?: number): Promise<string[]> {
+  try {
+    const url = new URL('/api/duplicates/resolve', window.location.origin);
+    if (maxDistance !== undefined) {
+      url.searchParams.set('maxDistance', maxDistance.toString());
+    }
+    const response = await fetch(url.toString(), {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+      },
+      body: JSON.stringify({
+        keep,
+        trash
+      })
+    });
+
+    if (!response.ok) {
+      throw new Error(`Failed to resolve duplicates: ${await response.text()}`);
+    }
+
+    const data = await response.json();
+    return data.trashed || [];
+  } catch (error) {
+    console.error('Error resolving duplicates:', error);
+    return [];
+  }
+}
+
+// Size of each chunk sent by resumable uploads
+const UPLOAD_CHUNK_SIZE = 8 * 1024 * 1024;
+
+// Web Crypto can't hash incrementally, so only checksum files that comfortably fit in memory
+const UPLOAD_CHECKSUM_MAX_SIZE = 256 * 1024 * 1024;
+
+// Number of times a chunk is retried after a network error
+const UPLOAD_MAX_RETRIES = 5;
+
+async function sha256Hex(file: File): Promise<string> {
+  const digest = await crypto.subtle.digest('SHA-256', await file.arrayBuffer());
+  return Array.from(new Uint8Array(digest))
+    .map(b => b.toString(16).padStart(2, '0'))
+    .join('');
+}
+
+// Upload-Checksum header value for a chunk
+async function chunkChecksum(chunk: Blob): Promise<string> {
+  const digest = new Uint8Array(await crypto.subtle.digest('SHA-256', await chunk.arrayBuffer()));
+  return `sha256 ${btoa(String.fromCharCode(...digest))}`;
+}
+
+async function fetchUploadOffset(location: string): Promise<number | null> {
+  const response = await fetch(location, { method: 'HEAD' });
+  if (!response.ok) {
+    return null;
+  }
+  return parseInt(response.headers.get('Upload-Offset') || '0', 10);
+}
+
+/**
+ * Uploads a file in chunks using the resumable upload API.
+ * If an earlier upload of the same file was interrupted, it resumes where it stopped.
+ * @param file File to upload
+ * @param onProgress Optional callback receiving the number of bytes uploaded so far
+ * @param signal Optional signal for aborting the upload
+ * @param language Optional language of the recording; empty uses the library default
+ * @returns Promise with the upload result
+ */
+export async function uploadFileResumable(
+  file: File,
+  onProgress?: (loaded: number) => void,
+  signal?: AbortSignal,
+  language?: string,
+  transcribe?: boolean
+): Promise<UploadFileResponse> {
+  const storageKey = `upload:${file.name}:${file.size}:${file.lastModified}`;
+  let location = localStorage.getItem(storageKey);
+  let offset: number | null = null;
+
+  // Try to resume an earlier upload of the same file
+  if (location) {
+    offset = await fetchUploadOffset(location);
+  }
+
+  if (offset === null) {
+    const checksum = file.size <= UPLOAD_CHECKSUM_MAX_SIZE ? await sha256Hex(file) : undefined;
+    const response = await fetch('/api/uploads', {
+      method: 'POST',
+      headers: {
+        'Content-Type': 'application/json',
+      },
+      body: JSON.stringify({
+        filename: file.name,
+        size: file.size,
+        checksum,
+        language,
+        transcribe
+      }),
+      signal
+    });
+
+    if (!response.ok) {
+      throw new Error(`Failed to create upload: ${response.statusText}`);
+    }
+
+    location = response.headers.get('Location');
+    if (!location) {
+      throw new Error('Upload created without a location');
+    }
+    localStorage.setItem(storageKey, location);
+    offset = 0;
+  }
+
+  let retries = 0;
+  while (true) {
+    onProgress?.(offset);
+
+    try {
+      const chunk = file.slice(offset, offset + UPLOAD_CHUNK_SIZE);
+      const response = await fetch(location!, {
+        method: 'PATCH',
+        headers: {
+          'Content-Type': 'application/offset+octet-stream',
+          'Upload-Offset': offset.toString(),
+          'Upload-Checksum': await chunkChecksum(chunk),
+        },
+        body: chunk,
+        signal
+      });
+
+      if (response.status === 409) {
+        // Out of sync with the server; pick up from its offset
+        offset = parseInt(response.headers.get('Upload-Offset') || '0', 10);
+        continue;
+      }
+
+      if (response.status === 460) {
+        // The chunk was corrupted on the way; retry it like a network error
+        throw new Error('Chunk checksum mismatch');
+      }
+
+      if (!response.ok) {
+        // The server keeps an upload it failed to finalize, so uploading
+        // the same file again finishes it without sending it again
+        if (response.status < 500) {
+          localStorage.removeItem(storageKey);
+        }
+        throw new Error(`Upload failed: ${await response.text()}`);
+      }
+
+      offset = parseInt(response.headers.get('Upload-Offset') || '0', 10);
+      retries = 0;
+
+      if (response.status === 200) {
+        // The last chunk returns the processed file
+        localStorage.removeItem(storageKey);
+        onProgress?.(file.size);
+        return await response.json();
+      }
+    } catch (error) {
+      if (signal?.aborted || retries >= UPLOAD_MAX_RETRIES || (error instanceof Error && error.message.startsWith('Upload failed'))) {
+        throw error;
+      }
+
+      // Network error: back off, then ask the server how much it received
+      retries++;
+      await new Promise(resolve => setTimeout(resolve, 1000 * 2 ** retries));
+      const serverOffset = await fetchUploadOffset(location!).catch(() => null);
+      if (serverOffset !== null) {
+        offset = serverOffset;
+      }
+    }
+  }
 }
\ No newline at end of file
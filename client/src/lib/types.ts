@@ -5,6 +5,110 @@ export interface TranscriptEntry {
   segment: number;
   speaker?: string;
   metadata?: string;
+  words?: TranscriptWord[];
+}
+
+export interface TranscriptWord {
+  word: string;
+  start: number;
+  end: number;
+  score?: number;
+  speaker?: string;
+  segment?: number;
+  lowConfidence?: boolean;
+}
+
+export interface Transcript {
+  id: string;
+  filename: string;
+  engine?: string;
+  model?: string;
+  granularity: 'segment' | 'word';
+  speakers?: Record<string, string>;
+  segments: TranscriptEntry[];
+  words?: TranscriptWord[];
+}
+
+export interface SegmentChange {
+  type: 'changed' | 'added' | 'removed';
+  index: number;
+  before?: TranscriptEntry;
+  after?: TranscriptEntry;
+}
+
+export interface TranscriptRevision {
+  revision: number;
+  author: string;
+  createdAt: string;
+  action: 'transcribe' | 'edit' | 'split' | 'merge' | 'restore' | 'revert-cleanup' | 'activate-version';
+  version?: number;
+  restoredFrom?: number;
+  diff: SegmentChange[];
+  segments?: TranscriptEntry[];
+}
+
+export interface CleanupChange {
+  index: number;
+  reason: 'repetition' | 'duplicate' | 'low-confidence' | 'no-speech' | 'non-speech';
+  removed?: string;
+  before: TranscriptEntry;
+  after?: TranscriptEntry;
+}
+
+export interface TranscriptCleanup {
+  createdAt?: string;
+  changes: CleanupChange[];
+}
+
+export interface TranscriptVersion {
+  version: number;
+  engine: string;
+  model?: string;
+  createdAt: string;
+  editedAt?: string;
+  language?: string;
+  segments: number;
+  words: number;
+  active: boolean;
+}
+
+export interface VersionDiffRow {
+  type: 'same' | 'changed' | 'added' | 'removed';
+  start: number;
+  end: number;
+  from: TranscriptEntry[];
+  to: TranscriptEntry[];
+  words?: { type: 'same' | 'removed' | 'added'; text: string }[];
+}
+
+export interface TranscriptVersionDiff {
+  from: TranscriptVersion;
+  to: TranscriptVersion;
+  summary: {
+    rows: number;
+    same: number;
+    changed: number;
+    added: number;
+    removed: number;
+    wordsFrom: number;
+    wordsTo: number;
+    wordsAdded: number;
+    wordsRemoved: number;
+  };
+  rows: VersionDiffRow[];
+}
+
+export interface BulkRetranscribeResult {
+  model?: string;
+  dryRun: boolean;
+  count: number;
+  queued: string[];
+  skipped: { filename: string; reason: string }[];
+}
+
+export interface TranscriptEditResult {
+  revision: TranscriptRevision;
+  segments: TranscriptEntry[];
 }
 
 export interface MediaItem {
@@ -16,6 +120,34 @@ export interface MediaItem {
   transcription: string;
   labels: string[];
   transcripts?: TranscriptEntry[];
+  phash?: string;
+  speakers?: Record<string, string>;
+  language?: string;
+  detectedLanguage?: string;
+  languageProbability?: number;
+  cameraModel?: string;
+  transcriptEngine?: string;
+  transcriptModel?: string;
+  transcriptStatus?: string;
+  summary?: string;
+  chapters?: Chapter[];
+  summaryModel?: string;
+  summarizedAt?: string;
+}
+
+export interface Chapter {
+  start: number;
+  title: string;
+}
+
+export interface SummaryState {
+  summary?: string;
+  chapters: Chapter[];
+  model?: string;
+  summarizedAt?: string;
+  enabled: boolean;
+  status?: 'queued' | 'running';
+  error?: string;
 }
 
 export interface TimelineItem {
@@ -35,9 +167,34 @@ export interface Label {
 
 export interface TranscriptionStatus {
   filename: string;
-  status: 'queued' | 'processing' | 'completed' | 'failed';
+  status: 'queued' | 'processing' | 'retrying' | 'completed' | 'failed' | 'cancelled';
+  priority: number;
+  model?: string;
   error?: string;
+  errorKind?: 'transient' | 'permanent';
+  attempts?: number;
+  nextRetryAt?: string;
+  createdAt: string;
+  startedAt?: string;
+  finishedAt?: string;
   timestamp: string;
+  queuePosition?: number;
+  elapsed?: number;
+  audioDuration?: number;
+  progress?: number;
+  progressFrom?: 'engine' | 'estimate';
+}
+
+export interface TranscriptionQueueState {
+  paused: boolean;
+  queued: number;
+  running: number;
+}
+
+export interface SpeakerSummary {
+  name: string;
+  items: number;
+  segments: number;
 }
 
 export interface ViewConfig {
@@ -57,6 +214,8 @@ export interface MediaFilters {
   startDate?: string;
   endDate?: string;
   labels?: string[];
+  speakers?: string[];
+  languages?: string[];
 }
 
 export interface ZoomLevel {
@@ -64,4 +223,38 @@ export interface ZoomLevel {
   label: string;
   duration: number; // Duration in milliseconds
   snapTo: 'hour' | 'day' | 'week' | 'month' | 'year';
+}
+
+export interface SimilarMatch {
+  id: string;
+  filename: string;
+  path: string;
+  distance: number;
+}
+
+export interface SimilarGroup {
+  best: string;
+  items: SimilarMatch[];
+}
+
+
+export interface LabelRuleChange {
+  id: string;
+  filename: string;
+  added: string[];
+  rules: string[];
+}
+
+export interface ApplyLabelRulesResult {
+  dryRun: boolean;
+  checked: number;
+  changed: number;
+  changes: LabelRuleChange[];
+}
+
+export interface UploadFileResponse {
+  status: string;
+  filename: string;
+  path: string;
+  metadata: string;
 }
\ No newline at end of file
@@ -2,9 +2,12 @@ module timelineviewer
 
 go 1.24.4
 
+require (
+	github.com/adrg/frontmatter v0.2.0
+	gopkg.in/yaml.v2 v2.3.0
+)
+
 require (
 	github.com/BurntSushi/toml v0.3.1 // indirect
-	github.com/adrg/frontmatter v0.2.0 // indirect
 	github.com/rwcarlsen/goexif v0.0.0-20190401172101-9e8deecbddbd // indirect
-	gopkg.in/yaml.v2 v2.3.0 // indirect
 )
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Questions about the library ("when did we last talk about the kitchen
+// remodel?") are answered by a chat model from transcript segments found
+// for the question. Segments are found by keyword and, if enabled, in the
+// embedding index (see embeddings.go); the model is told to cite the
+// segments it uses by number, and the answer comes back with those
+// segments as citations.
+
+const (
+	// Most segments given to the model with a question
+	maxAskSources = 50
+	// Rank offset of reciprocal rank fusion; larger values flatten the ranking
+	rankFusionOffset = 60
+)
+
+// Words that say little about what a question is about
+var askStopwords = map[string]bool{
+	"a": true, "about": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "by": true, "can": true, "did": true, "do": true, "does": true, "for": true,
+	"from": true, "had": true, "has": true, "have": true, "how": true, "i": true, "in": true,
+	"is": true, "it": true, "last": true, "me": true, "my": true, "of": true, "on": true,
+	"or": true, "our": true, "said": true, "say": true, "so": true, "talk": true,
+	"talked": true, "that": true, "the": true, "their": true, "them": true, "there": true,
+	"they": true, "this": true, "to": true, "us": true, "was": true, "we": true, "were": true,
+	"what": true, "when": true, "where": true, "which": true, "who": true, "why": true,
+	"will": true, "with": true, "you": true, "your": true,
+}
+
+// Matches citations such as [2] or [1, 3] in an answer
+var citationPattern = regexp.MustCompile(`\[(\d+(?:\s*,\s*\d+)*)\]`)
+
+// AskRequest is a question about the library
+type AskRequest struct {
+	Question   string `json:"question"`
+	MaxSources int    `json:"maxSources,omitempty"` // Defaults to the configured maxSources
+}
+
+// AskSource is a transcript segment given to the model, numbered as it
+// was shown to it
+type AskSource struct {
+	Number    int     `json:"number"`
+	ID        string  `json:"id"`
+	Filename  string  `json:"filename"`
+	Timestamp string  `json:"timestamp"` // When the recording was made
+	Start     float64 `json:"start"`
+	End       float64 `json:"end"`
+	Segment   int     `json:"segment"`
+	Speaker   string  `json:"speaker,omitempty"` // Name, or ID if it has none
+	Text      string  `json:"text"`
+}
+
+// AskResponse is the model's answer with the sources it cited
+type AskResponse struct {
+	Answer    string      `json:"answer"`
+	Citations []AskSource `json:"citations"` // Sources cited in the answer, in order of first citation
+	Sources   []AskSource `json:"sources"`   // Every source given to the model
+	Model     string      `json:"model,omitempty"`
+}
+
+// askCandidate is a segment found for a question
+type askCandidate struct {
+	metadata *MediaMetadata
+	index    int // Into the item's segments
+	score    float64
+}
+
+func (c askCandidate) key() string {
+	return c.metadata.Filename + "\x00" + strconv.Itoa(c.index)
+}
+
+// Search terms of a question without its stopwords, or all of them if
+// nothing else is left
+func askTerms(question string) []string {
+	var terms []string
+	for _, term := range searchTerms(question) {
+		term = strings.Trim(term, ".,;:!?\"'()")
+		if term != "" && !askStopwords[term] {
+			terms = append(terms, term)
+		}
+	}
+	if len(terms) == 0 {
+		return searchTerms(question)
+	}
+	return terms
+}
+
+// Segments containing any of the terms, best first. Terms count by how rare
+// they are among all segments; ties go to the most recent recording.
+func keywordCandidates(items []MediaMetadata, terms []string) []askCandidate {
+	total := 0
+	frequency := make(map[string]int)
+	var candidates []askCandidate
+	matched := make(map[string][]string)
+	for i := range items {
+		for index, entry := range items[i].Transcripts {
+			total++
+			text := strings.ToLower(entry.Text)
+			var found []string
+			for _, term := range terms {
+				if strings.Contains(text, term) {
+					frequency[term]++
+					found = append(found, term)
+				}
+			}
+			if len(found) > 0 {
+				candidate := askCandidate{metadata: &items[i], index: index}
+				matched[candidate.key()] = found
+				candidates = append(candidates, candidate)
+			}
+		}
+	}
+
+	for i := range candidates {
+		for _, term := range matched[candidates[i].key()] {
+			candidates[i].score += math.Log(1 + float64(total)/float64(frequency[term]))
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if candidates[i].score != candidates[j].score {
+			return candidates[i].score > candidates[j].score
+		}
+		return candidates[i].metadata.Timestamp > candidates[j].metadata.Timestamp
+	})
+	return candidates
+}
+
+// Merge rankings by reciprocal rank fusion and keep the best limit segments
+func fuseCandidates(rankings [][]askCandidate, limit int) []askCandidate {
+	scores := make(map[string]*askCandidate)
+	var order []string
+	for _, ranking := range rankings {
+		for rank, candidate := range ranking {
+			key := candidate.key()
+			fused, ok := scores[key]
+			if !ok {
+				fused = &askCandidate{metadata: candidate.metadata, index: candidate.index}
+				scores[key] = fused
+				order = append(order, key)
+			}
+			fused.score += 1 / float64(rankFusionOffset+rank+1)
+		}
+	}
+
+	fused := make([]askCandidate, 0, len(order))
+	for _, key := range order {
+		fused = append(fused, *scores[key])
+	}
+	sort.SliceStable(fused, func(i, j int) bool { return fused[i].score > fused[j].score })
+	if len(fused) > limit {
+		fused = fused[:limit]
+	}
+	return fused
+}
+
+// Number the chosen segments in the order they were recorded, so the model
+// can tell earlier from later mentions
+func askSources(candidates []askCandidate) []AskSource {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		a, b := candidates[i], candidates[j]
+		if a.metadata.Timestamp != b.metadata.Timestamp {
+			return a.metadata.Timestamp < b.metadata.Timestamp
+		}
+		if a.metadata.Filename != b.metadata.Filename {
+			return a.metadata.Filename < b.metadata.Filename
+		}
+		return a.index < b.index
+	})
+
+	sources := make([]AskSource, len(candidates))
+	for i, candidate := range candidates {
+		metadata := candidate.metadata
+		entry := metadata.Transcripts[candidate.index]
+		source := AskSource{
+			Number:    i + 1,
+			ID:        metadata.ID,
+			Filename:  metadata.Filename,
+			Timestamp: metadata.Timestamp,
+			Start:     entry.Start,
+			End:       entry.End,
+			Segment:   candidate.index,
+			Text:      strings.TrimSpace(entry.Text),
+		}
+		if entry.Speaker != "" {
+			source.Speaker = speakerName(*metadata, entry.Speaker)
+		}
+		sources[i] = source
+	}
+	return sources
+}
+
+// When a source was said: the recording's time plus the segment's offset
+func sourceTime(source AskSource) string {
+	recorded, err := time.Parse(time.RFC3339, source.Timestamp)
+	if err != nil {
+		return source.Timestamp
+	}
+	return recorded.Add(time.Duration(source.Start * float64(time.Second))).Format("2006-01-02 15:04")
+}
+
+const askSystemPrompt = "You answer questions about a personal library of recordings, " +
+	"using only the transcript excerpts you are given. After each statement, cite the excerpts " +
+	"it is based on by their numbers in square brackets, like [2] or [1, 3]. If the excerpts " +
+	"don't answer the question, say so. Answer in the language of the question."
+
+// Prompt with the numbered sources and the question
+func askPrompt(question string, sources []AskSource, now time.Time) string {
+	var prompt strings.Builder
+	fmt.Fprintf(&prompt, "Today is %s.\n\nTranscript excerpts, oldest first:\n", now.Format("2006-01-02"))
+	for _, source := range sources {
+		fmt.Fprintf(&prompt, "[%d] %s, %s at %s", source.Number, sourceTime(source), source.Filename, strings.Trim(formatTimecode(source.Start), "[]"))
+		if source.Speaker != "" {
+			prompt.WriteString(", " + source.Speaker)
+		}
+		prompt.WriteString(": " + source.Text + "\n")
+	}
+	prompt.WriteString("\nQuestion: " + question)
+	return prompt.String()
+}
+
+// Sources cited in an answer, in the order they are first cited. Numbers
+// that don't belong to a source are ignored.
+func citedSources(answer string, sources []AskSource) []AskSource {
+	cited := []AskSource{}
+	seen := make(map[int]bool)
+	for _, match := range citationPattern.FindAllStringSubmatch(answer, -1) {
+		for _, part := range strings.Split(match[1], ",") {
+			number, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil || number < 1 || number > len(sources) || seen[number] {
+				continue
+			}
+			seen[number] = true
+			cited = append(cited, sources[number-1])
+		}
+	}
+	return cited
+}
+
+// Handler for questions about the library: POST /api/ask {"question": "..."}.
+// The media listing filters narrow the recordings searched.
+func handleAsk(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req AskRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.Question = strings.TrimSpace(req.Question)
+	if req.Question == "" {
+		http.Error(w, "question is required", http.StatusBadRequest)
+		return
+	}
+	limit := AppConfig.Ask.MaxSources
+	if req.MaxSources != 0 {
+		limit = req.MaxSources
+	}
+	if limit < 1 {
+		http.Error(w, "maxSources must be a positive integer", http.StatusBadRequest)
+		return
+	}
+	limit = min(limit, maxAskSources)
+
+	filter, err := parseMediaFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	items, err := filterMetadata(filter)
+	if err != nil {
+		http.Error(w, "Failed to read metadata", http.StatusInternalServerError)
+		return
+	}
+
+	rankings := [][]askCandidate{keywordCandidates(items, askTerms(req.Question))}
+	if AppConfig.Ask.Embeddings && EIndex != nil {
+		candidates, err := embeddingCandidates(r.Context(), EIndex, items, req.Question, limit*2)
+		if err != nil {
+			// Keyword results still give an answer
+			log.Printf("Embedding retrieval failed: %v", err)
+		} else {
+			rankings = append(rankings, candidates)
+		}
+	}
+	sources := askSources(fuseCandidates(rankings, limit))
+
+	llm := AppConfig.LLM
+	if AppConfig.Ask.Model != "" {
+		llm.Model = AppConfig.Ask.Model
+	}
+	response := AskResponse{Citations: []AskSource{}, Sources: sources, Model: llm.Model}
+	if len(sources) == 0 {
+		response.Answer = "No transcript mentions anything related to the question."
+		response.Model = ""
+	} else {
+		answer, err := newChatClient(llm).Complete(r.Context(), []ChatMessage{
+			{Role: "system", Content: askSystemPrompt},
+			{Role: "user", Content: askPrompt(req.Question, sources, time.Now())},
+		})
+		if err != nil {
+			log.Printf("Failed to answer question: %v", err)
+			http.Error(w, "Failed to get an answer from the language model", http.StatusBadGateway)
+			return
+		}
+		response.Answer = answer
+		response.Citations = citedSources(answer, sources)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,341 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Long recordings are transcribed in overlapping chunks, cut in silences
+// where there are any near the chunk length. Each chunk's segments are saved
+// to data/chunks/<filename>/ as soon as it is done, so a failed or
+// interrupted job resumes after the last finished chunk, and the segments so
+// far are written to the item's metadata while the job is still running.
+const chunksDir = "./data/chunks"
+
+// How far from the chunk length a cut may move to land in a silence, as a
+// fraction of the chunk length
+const chunkCutWindow = 0.25
+
+// Silences shorter than this, or louder than chunkSilenceNoise, aren't cut points
+const (
+	chunkSilenceNoise    = "-35dB"
+	chunkSilenceDuration = 0.4
+)
+
+var (
+	silenceStartPattern = regexp.MustCompile(`silence_start: (-?[0-9.]+)`)
+	silenceEndPattern   = regexp.MustCompile(`silence_end: ([0-9.]+)`)
+)
+
+// TranscriptionChunk is one part of a recording transcribed on its own.
+// Start and End include the overlap with the neighbouring chunks; segments
+// centred between KeepFrom and KeepUntil are kept, so every segment in an
+// overlap comes from exactly one chunk.
+type TranscriptionChunk struct {
+	Index     int     `json:"index"`
+	Start     float64 `json:"start"`
+	End       float64 `json:"end"`
+	KeepFrom  float64 `json:"keepFrom"`
+	KeepUntil float64 `json:"keepUntil"`
+}
+
+// ChunkPlan records how a recording was split, so a resumed job only reuses
+// checkpoints made with the same settings
+type ChunkPlan struct {
+	Duration       float64              `json:"duration"`
+	ChunkSeconds   float64              `json:"chunkSeconds"`
+	OverlapSeconds float64              `json:"overlapSeconds"`
+	Language       string               `json:"language,omitempty"` // Requested language; empty if detected
+	Model          string               `json:"model,omitempty"`    // Requested model; empty for the configured one
+	Chunks         []TranscriptionChunk `json:"chunks"`
+}
+
+// ChunkCheckpoint holds a finished chunk's kept segments, already shifted to
+// the recording's timeline
+type ChunkCheckpoint struct {
+	Index               int               `json:"index"`
+	Model               string            `json:"model,omitempty"`
+	Language            string            `json:"language,omitempty"`
+	LanguageProbability float64           `json:"languageProbability,omitempty"`
+	Segments            []TranscriptEntry `json:"segments"`
+}
+
+// silence is a quiet stretch of audio, in seconds
+type silence struct {
+	start, end float64
+}
+
+func chunkDir(filename string) string {
+	return filepath.Join(chunksDir, filename)
+}
+
+func chunkCheckpointPath(filename string, index int) string {
+	return filepath.Join(chunkDir(filename), fmt.Sprintf("chunk-%03d.json", index))
+}
+
+// Whether a recording is long enough to be transcribed in chunks
+func shouldChunk(duration float64) bool {
+	chunkSeconds := float64(AppConfig.Transcription.ChunkSeconds)
+	return chunkSeconds > 0 && duration > chunkSeconds*1.5
+}
+
+// Find the silences of at least minDuration seconds below the noise level in
+// an audio file with ffmpeg's silencedetect filter
+func detectSilences(ctx context.Context, audioPath, noise string, minDuration float64) ([]silence, error) {
+	filter := fmt.Sprintf("silencedetect=noise=%s:d=%g", noise, minDuration)
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-hide_banner", "-nostats", "-i", audioPath, "-af", filter, "-f", "null", "-")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg error: %v", err)
+	}
+
+	var silences []silence
+	start := -1.0
+	for _, line := range strings.Split(strings.ReplaceAll(string(output), "\r", "\n"), "\n") {
+		if match := silenceStartPattern.FindStringSubmatch(line); match != nil {
+			start, _ = strconv.ParseFloat(match[1], 64)
+			start = math.Max(start, 0)
+		} else if match := silenceEndPattern.FindStringSubmatch(line); match != nil && start >= 0 {
+			end, _ := strconv.ParseFloat(match[1], 64)
+			silences = append(silences, silence{start: start, end: end})
+			start = -1
+		}
+	}
+	return silences, nil
+}
+
+// Split a recording into chunks of about chunkSeconds, cutting in the middle
+// of the silence nearest each chunk's end when one is close enough
+func planChunks(duration, chunkSeconds, overlap float64, silences []silence) []TranscriptionChunk {
+	bounds := []float64{0}
+	pos := 0.0
+	for duration-pos > chunkSeconds*1.5 {
+		target := pos + chunkSeconds
+		cut := target
+		nearest := chunkSeconds * chunkCutWindow
+		for _, s := range silences {
+			middle := (s.start + s.end) / 2
+			if distance := math.Abs(middle - target); distance <= nearest {
+				cut, nearest = middle, distance
+			}
+		}
+		bounds = append(bounds, cut)
+		pos = cut
+	}
+	bounds = append(bounds, duration)
+
+	chunks := make([]TranscriptionChunk, 0, len(bounds)-1)
+	for i := 0; i < len(bounds)-1; i++ {
+		chunks = append(chunks, TranscriptionChunk{
+			Index:     i,
+			Start:     math.Max(0, bounds[i]-overlap),
+			End:       math.Min(duration, bounds[i+1]+overlap),
+			KeepFrom:  bounds[i],
+			KeepUntil: bounds[i+1],
+		})
+	}
+	return chunks
+}
+
+// Cut a chunk out of an audio file as 16 kHz mono WAV
+func extractAudioChunk(ctx context.Context, audioPath, chunkPath string, start, end float64) error {
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-y", "-ss", fmt.Sprintf("%.3f", start), "-t", fmt.Sprintf("%.3f", end-start),
+		"-i", audioPath, "-vn", "-acodec", "pcm_s16le", "-ar", "16000", "-ac", "1", chunkPath)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ffmpeg error: %v, output: %s", err, string(output))
+	}
+	return nil
+}
+
+// Read the plan of an earlier run, or make a new one and drop the earlier
+// run's checkpoints if its settings differ
+func loadOrPlanChunks(ctx context.Context, filename, audioPath string, duration float64, opts TranscribeOptions) (ChunkPlan, error) {
+	plan := ChunkPlan{
+		Duration:       duration,
+		ChunkSeconds:   float64(AppConfig.Transcription.ChunkSeconds),
+		OverlapSeconds: float64(AppConfig.Transcription.ChunkOverlapSeconds),
+		Language:       opts.Language,
+		Model:          opts.Model,
+	}
+
+	planPath := filepath.Join(chunkDir(filename), "plan.json")
+	if data, err := os.ReadFile(planPath); err == nil {
+		var previous ChunkPlan
+		if err := json.Unmarshal(data, &previous); err == nil &&
+			previous.Duration == plan.Duration && previous.ChunkSeconds == plan.ChunkSeconds &&
+			previous.OverlapSeconds == plan.OverlapSeconds && previous.Language == plan.Language &&
+			previous.Model == plan.Model {
+			return previous, nil
+		}
+		log.Printf("Discarding chunk checkpoints of %s made with other settings", filename)
+	}
+	if err := os.RemoveAll(chunkDir(filename)); err != nil {
+		return ChunkPlan{}, fmt.Errorf("failed to remove old chunks: %v", err)
+	}
+
+	silences, err := detectSilences(ctx, audioPath, chunkSilenceNoise, chunkSilenceDuration)
+	if err != nil {
+		log.Printf("Could not detect silences in %s, cutting at fixed lengths: %v", filename, err)
+	}
+	plan.Chunks = planChunks(duration, plan.ChunkSeconds, plan.OverlapSeconds, silences)
+
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return ChunkPlan{}, fmt.Errorf("failed to marshal chunk plan: %v", err)
+	}
+	if err := os.MkdirAll(chunkDir(filename), 0755); err != nil {
+		return ChunkPlan{}, fmt.Errorf("failed to create chunks directory: %v", err)
+	}
+	if err := os.WriteFile(planPath, data, 0644); err != nil {
+		return ChunkPlan{}, fmt.Errorf("failed to write chunk plan: %v", err)
+	}
+	return plan, nil
+}
+
+// Read a finished chunk's checkpoint. Returns false if the chunk isn't done.
+func readChunkCheckpoint(filename string, index int) (ChunkCheckpoint, bool) {
+	data, err := os.ReadFile(chunkCheckpointPath(filename, index))
+	if err != nil {
+		return ChunkCheckpoint{}, false
+	}
+	var checkpoint ChunkCheckpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		log.Printf("Ignoring unreadable checkpoint of chunk %d of %s: %v", index, filename, err)
+		return ChunkCheckpoint{}, false
+	}
+	return checkpoint, true
+}
+
+func writeChunkCheckpoint(filename string, checkpoint ChunkCheckpoint) error {
+	data, err := json.MarshalIndent(checkpoint, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal chunk checkpoint: %v", err)
+	}
+	if err := os.WriteFile(chunkCheckpointPath(filename, checkpoint.Index), data, 0644); err != nil {
+		return fmt.Errorf("failed to write chunk checkpoint: %v", err)
+	}
+	return nil
+}
+
+// Shift a chunk's segments onto the recording's timeline and keep those
+// centred in the chunk's own part of it
+func stitchChunkSegments(chunk TranscriptionChunk, last bool, segments []TranscriptEntry) []TranscriptEntry {
+	kept := []TranscriptEntry{}
+	for _, segment := range segments {
+		segment.Start += chunk.Start
+		segment.End += chunk.Start
+		for i := range segment.Words {
+			segment.Words[i].Start += chunk.Start
+			segment.Words[i].End += chunk.Start
+		}
+
+		middle := (segment.Start + segment.End) / 2
+		if (chunk.Index > 0 && middle < chunk.KeepFrom) || (!last && middle >= chunk.KeepUntil) {
+			continue
+		}
+		kept = append(kept, segment)
+	}
+	return kept
+}
+
+// Number segments in order
+func renumberSegments(segments []TranscriptEntry) []TranscriptEntry {
+	for i := range segments {
+		segments[i].Segment = i
+	}
+	return segments
+}
+
+// Transcribe a long recording chunk by chunk, resuming after the last
+// checkpoint of an earlier attempt. When the language is detected, the first
+// chunk's language is used for the rest so the chunks agree. Segments are on
+// the timeline of audioPath; partial transcripts are restored to the
+// original's with timeline.
+func transcribeInChunks(ctx context.Context, filename, audioPath string, duration float64, timeline audioTimeline, opts TranscribeOptions) (TranscribeResult, error) {
+	plan, err := loadOrPlanChunks(ctx, filename, audioPath, duration, opts)
+	if err != nil {
+		return TranscribeResult{}, err
+	}
+	log.Printf("Transcribing %s in %d chunks", filename, len(plan.Chunks))
+
+	result := TranscribeResult{Language: opts.Language}
+	var segments []TranscriptEntry
+	for _, chunk := range plan.Chunks {
+		checkpoint, done := readChunkCheckpoint(filename, chunk.Index)
+		if !done {
+			checkpoint, err = transcribeChunk(ctx, filename, audioPath, plan, chunk, TranscribeOptions{Language: result.Language, Model: opts.Model})
+			if err != nil {
+				return TranscribeResult{}, fmt.Errorf("chunk %d of %d: %v", chunk.Index+1, len(plan.Chunks), err)
+			}
+			if err := writeChunkCheckpoint(filename, checkpoint); err != nil {
+				return TranscribeResult{}, err
+			}
+		}
+
+		if chunk.Index == 0 {
+			result.Model = checkpoint.Model
+			result.Language = checkpoint.Language
+			result.LanguageProbability = checkpoint.LanguageProbability
+		}
+		segments = append(segments, checkpoint.Segments...)
+		reportProgress(ctx, chunk.KeepUntil)
+
+		// Show what's done so far
+		if chunk.Index < len(plan.Chunks)-1 {
+			if err := updateMetadataWithPartialTranscript(filename, timeline.restore(renumberSegments(segmentsWithoutWords(segments)))); err != nil {
+				log.Printf("Failed to write partial transcript of %s: %v", filename, err)
+			}
+		}
+	}
+
+	result.Segments = renumberSegments(segments)
+	return result, nil
+}
+
+// Transcribe one chunk of a recording
+func transcribeChunk(ctx context.Context, filename, audioPath string, plan ChunkPlan, chunk TranscriptionChunk, opts TranscribeOptions) (ChunkCheckpoint, error) {
+	chunkPath := filepath.Join(chunkDir(filename), fmt.Sprintf("chunk-%03d.wav", chunk.Index))
+	if err := extractAudioChunk(ctx, audioPath, chunkPath, chunk.Start, chunk.End); err != nil {
+		return ChunkCheckpoint{}, fmt.Errorf("failed to extract audio: %v", err)
+	}
+	defer func() {
+		if err := os.Remove(chunkPath); err != nil && !os.IsNotExist(err) {
+			log.Printf("Warning: Failed to remove chunk audio %s: %v", chunkPath, err)
+		}
+	}()
+
+	// Engine progress within the chunk counts from the chunk's start
+	chunkCtx := withProgress(ctx, func(seconds float64) {
+		reportProgress(ctx, chunk.Start+seconds)
+	})
+	result, err := activeTranscriber.Transcribe(chunkCtx, chunkPath, opts)
+	if err != nil {
+		return ChunkCheckpoint{}, err
+	}
+
+	last := chunk.Index == len(plan.Chunks)-1
+	return ChunkCheckpoint{
+		Index:               chunk.Index,
+		Model:               result.Model,
+		Language:            result.Language,
+		LanguageProbability: result.LanguageProbability,
+		Segments:            stitchChunkSegments(chunk, last, result.Segments),
+	}, nil
+}
+
+// Remove a job's chunk checkpoints once its transcript is written
+func removeChunks(filename string) {
+	if err := os.RemoveAll(chunkDir(filename)); err != nil {
+		log.Printf("Warning: Failed to remove chunks of %s: %v", filename, err)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPlanChunks(t *testing.T) {
+	tests := []struct {
+		name     string
+		duration float64
+		silences []silence
+		want     []TranscriptionChunk
+	}{
+		{"short enough for one chunk", 40, nil, []TranscriptionChunk{
+			{Index: 0, Start: 0, End: 40, KeepFrom: 0, KeepUntil: 40},
+		}},
+		{"no silences", 100, nil, []TranscriptionChunk{
+			{Index: 0, Start: 0, End: 32, KeepFrom: 0, KeepUntil: 30},
+			{Index: 1, Start: 28, End: 62, KeepFrom: 30, KeepUntil: 60},
+			{Index: 2, Start: 58, End: 100, KeepFrom: 60, KeepUntil: 100},
+		}},
+		// Cuts move to the middle of the nearest silence within 7.5s of the
+		// chunk length; the silence around 50s is too far from 64s
+		{"cut in silences", 100, []silence{{24, 26}, {33, 35}, {50, 51}, {70, 72}}, []TranscriptionChunk{
+			{Index: 0, Start: 0, End: 36, KeepFrom: 0, KeepUntil: 34},
+			{Index: 1, Start: 32, End: 73, KeepFrom: 34, KeepUntil: 71},
+			{Index: 2, Start: 69, End: 100, KeepFrom: 71, KeepUntil: 100},
+		}},
+		{"silence too far away", 60, []silence{{10, 12}}, []TranscriptionChunk{
+			{Index: 0, Start: 0, End: 32, KeepFrom: 0, KeepUntil: 30},
+			{Index: 1, Start: 28, End: 60, KeepFrom: 30, KeepUntil: 60},
+		}},
+	}
+	for _, test := range tests {
+		if got := planChunks(test.duration, 30, 2, test.silences); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: planChunks = %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestStitchChunkSegments(t *testing.T) {
+	chunks := planChunks(100, 30, 2, nil)
+
+	// Both chunks hear the sentence spoken across the cut at 30s
+	first := stitchChunkSegments(chunks[0], false, []TranscriptEntry{
+		{Start: 0, End: 5, Text: "Start."},
+		{Start: 27, End: 29.5, Text: "Before the cut."},
+		{Start: 29.5, End: 31, Text: "Across the cut."},
+	})
+	second := stitchChunkSegments(chunks[1], false, []TranscriptEntry{
+		{Start: 0, End: 1.5, Text: "the cut."},             // 28-29.5, the first chunk's
+		{Start: 1.5, End: 3, Text: "Across the cut."},      // 29.5-31, centred on the cut
+		{Start: 10, End: 12, Text: "Middle."},              // 38-40
+		{Start: 31, End: 33, Text: "Across the next cut."}, // 59-61, the third chunk's
+	})
+	last := stitchChunkSegments(chunks[2], true, []TranscriptEntry{
+		{Start: 0, End: 2, Text: "next cut."}, // 58-60, the second chunk's
+		{Start: 1, End: 3, Text: "Across the next cut."},
+		{Start: 38, End: 42, Text: "The end."}, // 96-100
+	})
+
+	var texts []string
+	for _, segment := range renumberSegments(append(append(first, second...), last...)) {
+		texts = append(texts, segment.Text)
+	}
+	want := []string{"Start.", "Before the cut.", "Across the cut.", "Middle.", "Across the next cut.", "The end."}
+	if !reflect.DeepEqual(texts, want) {
+		t.Errorf("stitched %q, want %q", texts, want)
+	}
+	if second[0].Start != 29.5 || second[0].End != 31 {
+		t.Errorf("segment across the cut at %v-%v, want 29.5-31", second[0].Start, second[0].End)
+	}
+	if last[0].Start != 59 || last[1].Start != 96 || last[1].End != 100 {
+		t.Errorf("last chunk's segments at %v and %v-%v", last[0].Start, last[1].Start, last[1].End)
+	}
+}
+
+func TestStitchChunkSegmentsShiftsWords(t *testing.T) {
+	chunk := TranscriptionChunk{Index: 1, Start: 28, End: 62, KeepFrom: 30, KeepUntil: 60}
+	segments := []TranscriptEntry{{Start: 4, End: 5, Text: "Hello you", Words: []TranscriptWord{
+		{Word: "Hello", Start: 4, End: 4.5},
+		{Word: "you", Start: 4.5, End: 5},
+	}}}
+	got := stitchChunkSegments(chunk, false, segments)
+	want := []TranscriptWord{{Word: "Hello", Start: 32, End: 32.5}, {Word: "you", Start: 32.5, End: 33}}
+	if len(got) != 1 || got[0].Start != 32 || got[0].End != 33 || !reflect.DeepEqual(got[0].Words, want) {
+		t.Errorf("stitched %+v", got)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Whisper models hallucinate on silence and music: the same phrase over and
+// over ("Feel it. Feel it. Feel it."), segments repeating the one before, and
+// text over stretches with no speech. If enabled, engine output is cleaned up
+// before it becomes the item's transcript. What was changed, and the engine's
+// original segments, are kept in the transcript file so the cleanup can be
+// reviewed and reverted.
+
+// Reasons a segment was changed or dropped
+const (
+	cleanupRepetition    = "repetition"     // Repeated words collapsed within a segment
+	cleanupDuplicate     = "duplicate"      // Same text as the segment before
+	cleanupLowConfidence = "low-confidence" // Mean word confidence below minConfidence
+	cleanupNoSpeech      = "no-speech"      // Engine thinks there is no speech
+	cleanupNonSpeech     = "non-speech"     // Only annotations like [Music] or punctuation
+)
+
+// Annotations engines write instead of speech: [BLANK_AUDIO], (music), ♪
+var nonSpeechPattern = regexp.MustCompile(`\[[^\]]*\]|\([^)]*\)|[♪♫]`)
+
+// CleanupReport records what cleanup changed in a transcript
+type CleanupReport struct {
+	CreatedAt string            `json:"createdAt"`
+	Changes   []CleanupChange   `json:"changes"`
+	Original  []TranscriptEntry `json:"original,omitempty"` // Segments as the engine produced them
+}
+
+// CleanupChange is one segment cleanup changed or dropped
+type CleanupChange struct {
+	Index   int              `json:"index"` // Position in the engine's segments
+	Reason  string           `json:"reason"`
+	Removed string           `json:"removed,omitempty"` // Repeated text taken out of the segment
+	Before  TranscriptEntry  `json:"before"`
+	After   *TranscriptEntry `json:"after,omitempty"` // Missing if the segment was dropped
+}
+
+// CleanupResponse is an item's cleanup report without the original segments
+type CleanupResponse struct {
+	CreatedAt string          `json:"createdAt,omitempty"`
+	Changes   []CleanupChange `json:"changes"`
+}
+
+// Clean up engine output. Returns the segments to keep and a report, which
+// is nil if nothing changed.
+func cleanupSegments(segments []TranscriptEntry, config CleanupConfig) ([]TranscriptEntry, *CleanupReport) {
+	if !config.Enabled {
+		return segments, nil
+	}
+
+	report := &CleanupReport{CreatedAt: time.Now().Format(time.RFC3339), Changes: []CleanupChange{}}
+	drop := func(i int, reason string) {
+		report.Changes = append(report.Changes, CleanupChange{Index: i, Reason: reason, Before: stripSegment(segments[i])})
+	}
+
+	kept := []TranscriptEntry{}
+	previous := ""
+	for i, segment := range segments {
+		if normalizeCleanupText(nonSpeechPattern.ReplaceAllString(segment.Text, "")) == "" {
+			drop(i, cleanupNonSpeech)
+			continue
+		}
+		if config.MaxNoSpeechProb > 0 && segment.NoSpeechProb != nil && *segment.NoSpeechProb >= config.MaxNoSpeechProb {
+			drop(i, cleanupNoSpeech)
+			continue
+		}
+		if confidence, ok := segmentConfidence(segment); ok && confidence < config.MinConfidence {
+			drop(i, cleanupLowConfidence)
+			continue
+		}
+
+		if text, removed, keptTokens := collapseRepeats(segment.Text, config.MaxNgram); removed != "" {
+			cleaned := segment
+			cleaned.Text = text
+			if len(segment.Words) == len(strings.Fields(segment.Text)) {
+				// One word per token, so the kept words keep their timings
+				cleaned.Words = make([]TranscriptWord, 0, len(keptTokens))
+				for _, k := range keptTokens {
+					cleaned.Words = append(cleaned.Words, segment.Words[k])
+				}
+			} else {
+				cleaned.Words = retextWords(segment, text)
+			}
+			after := stripSegment(cleaned)
+			report.Changes = append(report.Changes, CleanupChange{
+				Index: i, Reason: cleanupRepetition, Removed: removed, Before: stripSegment(segment), After: &after,
+			})
+			segment = cleaned
+		}
+
+		normalized := normalizeCleanupText(segment.Text)
+		if normalized == previous {
+			drop(i, cleanupDuplicate)
+			continue
+		}
+		previous = normalized
+		kept = append(kept, segment)
+	}
+
+	if len(report.Changes) == 0 {
+		return segments, nil
+	}
+	report.Original = segments
+	return renumberSegments(kept), report
+}
+
+// A segment as shown in a cleanup report, without words or engine scores
+func stripSegment(segment TranscriptEntry) TranscriptEntry {
+	segment.Words = nil
+	segment.NoSpeechProb = nil
+	return segment
+}
+
+// Mean confidence of a segment's words. Returns false if no word has one.
+func segmentConfidence(segment TranscriptEntry) (float64, bool) {
+	total, count := 0.0, 0
+	for _, word := range segment.Words {
+		if word.Score != nil {
+			total += *word.Score
+			count++
+		}
+	}
+	if count == 0 {
+		return 0, false
+	}
+	return total / float64(count), true
+}
+
+// Lowercase text without punctuation, for comparing words and segments
+func normalizeCleanupText(text string) string {
+	return strings.Join(strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r) && r != '\''
+	}), " ")
+}
+
+// Collapse runs of a repeated phrase of up to maxNgram words to its first
+// occurrence. A phrase must repeat at least twice, or three times for single
+// words so "very very" survives. Returns the new text, what was removed and
+// the positions of the kept words in the original text.
+func collapseRepeats(text string, maxNgram int) (string, string, []int) {
+	tokens := strings.Fields(text)
+	normalized := make([]string, len(tokens))
+	for i, token := range tokens {
+		normalized[i] = normalizeCleanupText(token)
+	}
+
+	same := func(a, b, n int) bool {
+		for k := 0; k < n; k++ {
+			if normalized[a+k] == "" || normalized[a+k] != normalized[b+k] {
+				return false
+			}
+		}
+		return true
+	}
+
+	var kept, removed []string
+	var keptTokens []int
+	for i := 0; i < len(tokens); {
+		collapsed := false
+		for n := min(maxNgram, (len(tokens)-i)/2); n >= 1; n-- {
+			repeats := 1
+			for i+(repeats+1)*n <= len(tokens) && same(i, i+repeats*n, n) {
+				repeats++
+			}
+			minRepeats := 2
+			if n == 1 {
+				minRepeats = 3
+			}
+			if repeats >= minRepeats {
+				kept = append(kept, tokens[i:i+n]...)
+				for k := i; k < i+n; k++ {
+					keptTokens = append(keptTokens, k)
+				}
+				removed = append(removed, tokens[i+n:i+repeats*n]...)
+				i += repeats * n
+				collapsed = true
+				break
+			}
+		}
+		if !collapsed {
+			kept = append(kept, tokens[i])
+			keptTokens = append(keptTokens, i)
+			i++
+		}
+	}
+	return strings.Join(kept, " "), strings.Join(removed, " "), keptTokens
+}
+
+// Handler for an item's cleanup report: GET /api/media/{id}/transcript/cleanup
+func handleGetCleanup(w http.ResponseWriter, r *http.Request, metadata MediaMetadata) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	response := CleanupResponse{Changes: []CleanupChange{}}
+	if report := loadTranscript(metadata).Cleanup; report != nil {
+		response.CreatedAt = report.CreatedAt
+		response.Changes = report.Changes
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Handler for putting back the engine's segments from before cleanup, saved
+// as a new revision: POST /api/media/{id}/transcript/cleanup/revert
+func handleRevertCleanup(w http.ResponseWriter, r *http.Request, metadata MediaMetadata) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req TranscriptEditRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	revert := func([]TranscriptEntry) ([]TranscriptEntry, error) {
+		report := loadTranscript(metadata).Cleanup
+		if report == nil || len(report.Original) == 0 {
+			return nil, invalidEdit("transcript was not cleaned up")
+		}
+		segments := make([]TranscriptEntry, len(report.Original))
+		copy(segments, report.Original)
+		return segments, nil
+	}
+	writeTranscriptEditResult(w, metadata, editAuthor(req.Author), "revert-cleanup", 0, revert)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCollapseRepeats(t *testing.T) {
+	tests := []struct {
+		text     string
+		maxNgram int
+		want     string
+		removed  string
+		kept     []int
+	}{
+		{"Feel it. Feel it. Feel it.", 8, "Feel it.", "Feel it. Feel it.", []int{0, 1}},
+		{"Feel it. Feel it.", 8, "Feel it.", "Feel it.", []int{0, 1}},
+		{"Into nothing. Into nothing.", 8, "Into nothing.", "Into nothing.", []int{0, 1}},
+		{"So. Feel it. Feel it. Now.", 8, "So. Feel it. Now.", "Feel it.", []int{0, 1, 2, 5}},
+		{"Thank you. thank you!", 8, "Thank you.", "thank you!", []int{0, 1}},
+		{"no no no way", 8, "no way", "no no", []int{0, 3}},
+		// Two of a single word, a phrase longer than maxNgram and
+		// punctuation are left alone
+		{"It was very very good.", 8, "It was very very good.", "", []int{0, 1, 2, 3, 4}},
+		{"Feel it. Feel it.", 1, "Feel it. Feel it.", "", []int{0, 1, 2, 3}},
+		{"- - -", 8, "- - -", "", []int{0, 1, 2}},
+		{"", 8, "", "", nil},
+	}
+	for _, test := range tests {
+		text, removed, kept := collapseRepeats(test.text, test.maxNgram)
+		if text != test.want || removed != test.removed || !reflect.DeepEqual(kept, test.kept) {
+			t.Errorf("collapseRepeats(%q, %d) = %q, %q, %v; want %q, %q, %v",
+				test.text, test.maxNgram, text, removed, kept, test.want, test.removed, test.kept)
+		}
+	}
+}
+
+// Engine output with every kind of hallucination cleanup takes out
+func hallucinatedSegments() []TranscriptEntry {
+	score := func(v float64) *float64 { return &v }
+	return []TranscriptEntry{
+		{Start: 0, End: 3, Text: "Feel it. Feel it. Feel it.", Words: []TranscriptWord{
+			{Word: "Feel", Start: 0, End: 0.5}, {Word: "it.", Start: 0.5, End: 1},
+			{Word: "Feel", Start: 1, End: 1.5}, {Word: "it.", Start: 1.5, End: 2},
+			{Word: "Feel", Start: 2, End: 2.5}, {Word: "it.", Start: 2.5, End: 3},
+		}},
+		{Start: 3, End: 4, Text: "Feel it."},
+		{Start: 4, End: 9, Text: "[Music]"},
+		{Start: 9, End: 12, Text: "Into nothing. Into nothing."},
+		{Start: 12, End: 14, Text: "into nothing"},
+		{Start: 14, End: 16, Text: "Hello.", NoSpeechProb: score(0.9)},
+		{Start: 16, End: 18, Text: "Mumble.", Words: []TranscriptWord{{Word: "Mumble.", Start: 16, End: 18, Score: score(0.1)}}},
+		{Start: 18, End: 20, Text: "Goodbye."},
+	}
+}
+
+func TestCleanupSegments(t *testing.T) {
+	config := defaultConfig().Transcription.Cleanup
+	config.Enabled = true
+	original := hallucinatedSegments()
+
+	kept, report := cleanupSegments(original, config)
+	if report == nil {
+		t.Fatal("nothing cleaned up")
+	}
+	var texts []string
+	for i, segment := range kept {
+		texts = append(texts, segment.Text)
+		if segment.Segment != i {
+			t.Errorf("segment %q numbered %d", segment.Text, segment.Segment)
+		}
+	}
+	if want := []string{"Feel it.", "Into nothing.", "Goodbye."}; !reflect.DeepEqual(texts, want) {
+		t.Errorf("kept %q, want %q", texts, want)
+	}
+	wantWords := []TranscriptWord{{Word: "Feel", Start: 0, End: 0.5}, {Word: "it.", Start: 0.5, End: 1}}
+	if !reflect.DeepEqual(kept[0].Words, wantWords) {
+		t.Errorf("collapsed segment has words %+v", kept[0].Words)
+	}
+
+	tests := []struct {
+		index   int
+		reason  string
+		removed string
+		after   string
+	}{
+		{0, cleanupRepetition, "Feel it. Feel it.", "Feel it."},
+		{1, cleanupDuplicate, "", ""},
+		{2, cleanupNonSpeech, "", ""},
+		{3, cleanupRepetition, "Into nothing.", "Into nothing."},
+		{4, cleanupDuplicate, "", ""},
+		{5, cleanupNoSpeech, "", ""},
+		{6, cleanupLowConfidence, "", ""},
+	}
+	if len(report.Changes) != len(tests) {
+		t.Fatalf("report has %d changes, want %d: %+v", len(report.Changes), len(tests), report.Changes)
+	}
+	for i, test := range tests {
+		change := report.Changes[i]
+		after := ""
+		if change.After != nil {
+			after = change.After.Text
+		}
+		if change.Index != test.index || change.Reason != test.reason || change.Removed != test.removed || after != test.after {
+			t.Errorf("change %d = %+v, after %q; want %+v", i, change, after, test)
+		}
+		if change.Before.Text != original[test.index].Text || change.Before.Words != nil {
+			t.Errorf("change %d before = %+v", i, change.Before)
+		}
+	}
+	if !reflect.DeepEqual(report.Original, hallucinatedSegments()) {
+		t.Errorf("report kept original %+v", report.Original)
+	}
+
+	clean := []TranscriptEntry{{Start: 0, End: 2, Text: "Nothing to do."}}
+	if kept, report := cleanupSegments(clean, config); report != nil || !reflect.DeepEqual(kept, clean) {
+		t.Errorf("clean transcript changed: %+v, %+v", kept, report)
+	}
+	config.Enabled = false
+	if kept, report := cleanupSegments(original, config); report != nil || len(kept) != len(original) {
+		t.Errorf("disabled cleanup changed the transcript: %d segments, %+v", len(kept), report)
+	}
+}
+
+func TestRevertCleanup(t *testing.T) {
+	setupTranscriptionTest(t)
+	AppConfig.Transcription.Cleanup.Enabled = true
+	filename := "song.mp3"
+	if err := writeMetadataFile(filepath.Join(metadataDir, filename+mdExt), MediaMetadata{ID: "1", Filename: filename, Type: "audio", Labels: []string{}}); err != nil {
+		t.Fatal(err)
+	}
+	segments, report := cleanupSegments(hallucinatedSegments(), AppConfig.Transcription.Cleanup)
+	if err := writeTranscriptFile(filename, activeTranscriber, TranscribeResult{Segments: segments}, false, report); err != nil {
+		t.Fatal(err)
+	}
+	if err := updateMetadataWithTranscript(filename, filepath.Join(transcriptsDir, filename+".json")); err != nil {
+		t.Fatal(err)
+	}
+
+	texts := func() []string {
+		var metadata MediaMetadata
+		if _, err := readMarkdownFile(filepath.Join(metadataDir, filename+mdExt), &metadata); err != nil {
+			t.Fatal(err)
+		}
+		var texts []string
+		for _, segment := range metadata.Transcripts {
+			texts = append(texts, segment.Text)
+		}
+		return texts
+	}
+	metadata := MediaMetadata{ID: "1", Filename: filename}
+
+	w := httptest.NewRecorder()
+	handleGetCleanup(w, httptest.NewRequest(http.MethodGet, "/api/media/1/transcript/cleanup", nil), metadata)
+	var response CleanupResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil || len(response.Changes) != 7 {
+		t.Errorf("cleanup report: %s", w.Body)
+	}
+	if got := texts(); !reflect.DeepEqual(got, []string{"Feel it.", "Into nothing.", "Goodbye."}) {
+		t.Errorf("cleaned transcript %q", got)
+	}
+
+	w = httptest.NewRecorder()
+	handleRevertCleanup(w, httptest.NewRequest(http.MethodPost, "/api/media/1/transcript/cleanup/revert", strings.NewReader(`{"author": "ann"}`)), metadata)
+	if w.Code != http.StatusOK {
+		t.Fatalf("revert: %d %s", w.Code, w.Body)
+	}
+	var want []string
+	for _, segment := range hallucinatedSegments() {
+		want = append(want, segment.Text)
+	}
+	if got := texts(); !reflect.DeepEqual(got, want) {
+		t.Errorf("reverted transcript %q, want %q", got, want)
+	}
+
+	// Without a cleanup report there is nothing to revert
+	other := MediaMetadata{ID: "2", Filename: "other.mp3", Type: "audio", Labels: []string{}, Transcripts: []TranscriptEntry{{Start: 0, End: 2, Text: "Hi."}}}
+	if err := writeMetadataFile(filepath.Join(metadataDir, other.Filename+mdExt), other); err != nil {
+		t.Fatal(err)
+	}
+	w = httptest.NewRecorder()
+	handleRevertCleanup(w, httptest.NewRequest(http.MethodPost, "/api/media/2/transcript/cleanup/revert", strings.NewReader(`{}`)), other)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("revert without a report: %d %s", w.Code, w.Body)
+	}
+}
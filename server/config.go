@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+)
+
+const (
+	configFile = "./data/config.json"
+)
+
+// Config holds the server settings read from data/config.json.
+// Every field is optional; missing values fall back to the defaults below.
+type Config struct {
+	Transcription TranscriptionConfig `json:"transcription"`
+	LLM           LLMConfig           `json:"llm"`
+	Summary       SummaryConfig       `json:"summary"`
+	Ask           AskConfig           `json:"ask"`
+	Embeddings    EmbeddingsConfig    `json:"embeddings"`
+	Import        ImportConfig        `json:"import"`
+}
+
+// ImportConfig limits what POST /api/import may read
+type ImportConfig struct {
+	// Directories the API may import from, along with everything below them.
+	// Empty disables importing through the API; the import command can read
+	// any directory.
+	Roots []string `json:"roots,omitempty"`
+}
+
+// TranscriptionConfig selects and configures the transcription backend
+type TranscriptionConfig struct {
+	Backend     string `json:"backend"`     // "whisperx", "whispercpp", "openai" or "fake"
+	Model       string `json:"model"`       // Model name passed to the backend
+	ComputeType string `json:"computeType"` // e.g. "int8", "float16" (whisperx only)
+	Concurrency int    `json:"concurrency"` // Number of files transcribed at once
+
+	// Default language of the library as an ISO 639-1 code, English if
+	// empty; "auto" detects each file's language. Items can override it.
+	Language string `json:"language,omitempty"`
+	// Model for languages other than English and for detection. Defaults to
+	// the model without its English suffix (base-en -> base).
+	MultilingualModel string `json:"multilingualModel,omitempty"`
+
+	// Recordings longer than one and a half chunks are transcribed in chunks
+	// of about ChunkSeconds, overlapping by ChunkOverlapSeconds; 0, the
+	// default, disables it
+	ChunkSeconds        int `json:"chunkSeconds"`
+	ChunkOverlapSeconds int `json:"chunkOverlapSeconds"`
+
+	// Filtering and silence trimming of the audio before transcription
+	Preprocess PreprocessConfig `json:"preprocess"`
+
+	// Post-processing of engine output against hallucinations. It drops
+	// segments, so it is off unless enabled.
+	Cleanup CleanupConfig `json:"cleanup"`
+
+	// Transient failures are retried after RetryDelaySeconds, doubling each
+	// time, until a job has run MaxAttempts times
+	MaxAttempts       int `json:"maxAttempts"`
+	RetryDelaySeconds int `json:"retryDelaySeconds"`
+
+	// Label segments with speaker IDs (SPEAKER_00, ...). Supported by the
+	// whisperx backend, which needs a Hugging Face token for the pyannote models.
+	Diarize     bool   `json:"diarize"`
+	MinSpeakers int    `json:"minSpeakers,omitempty"`
+	MaxSpeakers int    `json:"maxSpeakers,omitempty"`
+	HFToken     string `json:"hfToken,omitempty"`
+
+	// whisperx: container image, defaults to ghcr.io/jim60105/whisperx:<model>
+	Image string `json:"image,omitempty"`
+	// whisperx: podman volume or host directory that keeps the models the
+	// no_model image downloads, so they are fetched only once
+	ModelCache string `json:"modelCache,omitempty"`
+
+	// whisper.cpp: CLI binary and ggml model file
+	Binary    string `json:"binary,omitempty"`
+	ModelPath string `json:"modelPath,omitempty"`
+	// Multilingual ggml model, defaulting to modelPath without ".en" if that exists
+	MultilingualModelPath string `json:"multilingualModelPath,omitempty"`
+
+	// OpenAI-compatible server: base URL and optional API key
+	Endpoint string `json:"endpoint,omitempty"`
+	APIKey   string `json:"apiKey,omitempty"`
+}
+
+// CleanupConfig controls how transcripts are cleaned up after transcription
+type CleanupConfig struct {
+	Enabled         bool    `json:"enabled"`
+	MaxNgram        int     `json:"maxNgram"`        // Longest repeated phrase collapsed, in words
+	MinConfidence   float64 `json:"minConfidence"`   // Drop segments whose mean word confidence is lower; 0 keeps them
+	MaxNoSpeechProb float64 `json:"maxNoSpeechProb"` // Drop segments at least this likely to have no speech; 0 keeps them
+}
+
+// PreprocessConfig controls how audio is prepared before transcription
+type PreprocessConfig struct {
+	Enabled            bool    `json:"enabled"`
+	HighpassHz         int     `json:"highpassHz"`         // Cut rumble below this frequency; 0 keeps it
+	Denoise            bool    `json:"denoise"`            // FFT noise reduction
+	Loudnorm           bool    `json:"loudnorm"`           // EBU R128 loudness normalization
+	TrimSilenceSeconds float64 `json:"trimSilenceSeconds"` // Cut out silences longer than this; 0 keeps them
+	SilenceNoise       string  `json:"silenceNoise"`       // Level below which audio counts as silence
+	SilencePadding     float64 `json:"silencePadding"`     // Silence kept at each side of a cut, in seconds
+}
+
+// LLMConfig points at an OpenAI-compatible chat endpoint, usually a model
+// server on the local machine, used for summaries and questions
+type LLMConfig struct {
+	Endpoint       string  `json:"endpoint"` // Base URL, e.g. http://localhost:11434 for Ollama
+	APIKey         string  `json:"apiKey,omitempty"`
+	Model          string  `json:"model"`
+	Temperature    float64 `json:"temperature"`
+	TimeoutSeconds int     `json:"timeoutSeconds"` // Per request; 0 waits indefinitely
+	// Model for the /v1/embeddings endpoint of the same server
+	EmbeddingModel string `json:"embeddingModel"`
+}
+
+// SummaryConfig controls the summaries and chapters written after transcription
+type SummaryConfig struct {
+	Enabled       bool   `json:"enabled"`
+	Backend       string `json:"backend"`         // "openai" (the llm endpoint) or "fake"
+	Model         string `json:"model,omitempty"` // Overrides the llm model for summaries
+	MinSeconds    int    `json:"minSeconds"`      // Shorter recordings aren't summarized
+	MaxChunkChars int    `json:"maxChunkChars"`   // Longer transcripts are summarized in parts of about this size
+}
+
+// AskConfig controls how questions about the library are answered
+type AskConfig struct {
+	Model      string `json:"model,omitempty"` // Overrides the llm model for answers
+	MaxSources int    `json:"maxSources"`      // Segments given to the model with the question
+	// Also retrieve segments from the embedding index, which finds
+	// paraphrases; needs embeddings enabled
+	Embeddings bool `json:"embeddings"`
+}
+
+// EmbeddingsConfig controls the embedding index used by semantic search
+type EmbeddingsConfig struct {
+	Enabled  bool   `json:"enabled"`
+	Provider string `json:"provider"` // "openai" (the llm endpoint's embeddingModel) or "fake"
+}
+
+// Global configuration, replaced by loadConfig at startup
+var AppConfig = defaultConfig()
+
+func defaultConfig() Config {
+	return Config{
+		Transcription: TranscriptionConfig{
+			Backend:             "whisperx",
+			Model:               "base-en",
+			ComputeType:         "int8",
+			Concurrency:         1,
+			MaxAttempts:         4,
+			RetryDelaySeconds:   30,
+			ChunkSeconds:        0,
+			ChunkOverlapSeconds: 5,
+			Preprocess: PreprocessConfig{
+				HighpassHz:         80,
+				Denoise:            true,
+				Loudnorm:           true,
+				TrimSilenceSeconds: 2,
+				SilenceNoise:       "-35dB",
+				SilencePadding:     0.3,
+			},
+			Cleanup: CleanupConfig{
+				Enabled:         false,
+				MaxNgram:        8,
+				MinConfidence:   0.3,
+				MaxNoSpeechProb: 0.8,
+			},
+			ModelCache: "timelineviewer-whisperx-models",
+			Binary:     "whisper-cli",
+			Endpoint:   "http://localhost:8000",
+		},
+		LLM: LLMConfig{
+			Endpoint:       "http://localhost:11434",
+			Model:          "llama3.1:8b",
+			Temperature:    0.2,
+			TimeoutSeconds: 300,
+			EmbeddingModel: "nomic-embed-text",
+		},
+		Summary: SummaryConfig{
+			Backend:       "openai",
+			MinSeconds:    120,
+			MaxChunkChars: 12000,
+		},
+		Ask: AskConfig{
+			MaxSources: 12,
+		},
+		Embeddings: EmbeddingsConfig{
+			Provider: "openai",
+		},
+	}
+}
+
+// Load the configuration file on top of the defaults
+func loadConfig() error {
+	config := defaultConfig()
+
+	data, err := os.ReadFile(configFile)
+	if os.IsNotExist(err) {
+		AppConfig = config
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	if err := json.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("failed to parse config file: %v", err)
+	}
+
+	AppConfig = config
+	log.Printf("Loaded configuration from %s", configFile)
+	return nil
+}
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/gob"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Transcript segments and photo captions (the Markdown body of a photo's
+// metadata file) are embedded as vectors for semantic search, which finds
+// text that says the same thing in other words. The vectors are kept in
+// memory and saved per item under data/embeddings. Items are queued at
+// startup, when a transcript is written or edited and when a body is
+// changed outside the app; texts whose content changed are embedded again,
+// unchanged ones keep their vectors.
+
+const (
+	embeddingsDir = "./data/embeddings"
+
+	// Texts embedded per request to the provider
+	embeddingBatchSize = 64
+	// Length of the fake provider's vectors
+	fakeEmbeddingDimensions = 64
+	// Wait before trying an item again after its embedding failed
+	embeddingRetryDelay = time.Minute
+)
+
+// EmbeddingProvider turns texts into vectors
+type EmbeddingProvider interface {
+	Name() string
+	Model() string
+	Embed(ctx context.Context, texts []string) ([][]float64, error)
+}
+
+// Create the embedding provider selected in the configuration
+func newEmbeddingProvider(config Config) (EmbeddingProvider, error) {
+	switch config.Embeddings.Provider {
+	case "", "openai":
+		if config.LLM.Endpoint == "" || config.LLM.EmbeddingModel == "" {
+			return nil, fmt.Errorf("openai embedding provider requires an llm endpoint and embeddingModel")
+		}
+		return newEmbeddingClient(config.LLM), nil
+	case "fake":
+		return fakeEmbeddingProvider{}, nil
+	default:
+		return nil, fmt.Errorf("unknown embedding provider %q", config.Embeddings.Provider)
+	}
+}
+
+// fakeEmbeddingProvider hashes words into a fixed number of dimensions, so
+// texts sharing words are similar. For tests and for trying semantic search
+// without a model server; it doesn't find paraphrases.
+type fakeEmbeddingProvider struct{}
+
+func (fakeEmbeddingProvider) Name() string  { return "fake" }
+func (fakeEmbeddingProvider) Model() string { return "fake" }
+
+func (fakeEmbeddingProvider) Embed(ctx context.Context, texts []string) ([][]float64, error) {
+	vectors := make([][]float64, len(texts))
+	for i, text := range texts {
+		vector := make([]float64, fakeEmbeddingDimensions)
+		for _, word := range searchTerms(text) {
+			word = strings.Trim(word, ".,;:!?\"'()")
+			if word == "" {
+				continue
+			}
+			hash := fnv.New32a()
+			hash.Write([]byte(word))
+			vector[hash.Sum32()%fakeEmbeddingDimensions]++
+		}
+		vectors[i] = vector
+	}
+	return vectors, nil
+}
+
+// embeddedText is an embedded segment or caption of an item
+type embeddedText struct {
+	Kind    string // "segment" or "caption"
+	Segment int    // Index of the segment
+	Hash    [sha256.Size]byte
+	Vector  []float32 // Unit length, so similarity is the dot product
+}
+
+// itemEmbeddings is everything embedded for one item, as saved on disk
+type itemEmbeddings struct {
+	Model string
+	Texts []embeddedText
+}
+
+// textToEmbed is a segment or caption of an item as it is now
+type textToEmbed struct {
+	kind    string
+	segment int
+	text    string
+}
+
+// vectorMatch is an embedded text near a query
+type vectorMatch struct {
+	Filename string
+	Text     embeddedText
+	Score    float64
+}
+
+// vectorIndex holds the embeddings of the library and embeds changed items
+// in the background
+type vectorIndex struct {
+	provider EmbeddingProvider
+
+	mu    sync.RWMutex
+	items map[string]itemEmbeddings // By filename
+
+	queueMu sync.Mutex
+	queue   []string
+	queued  map[string]bool
+	wake    chan struct{}
+}
+
+// Global embedding index, nil while embeddings are disabled
+var EIndex *vectorIndex
+
+// Load the saved embeddings and start embedding changed items, if
+// embeddings are enabled
+func InitEmbeddingIndex() {
+	if !AppConfig.Embeddings.Enabled {
+		if AppConfig.Ask.Embeddings {
+			log.Printf("Warning: ask.embeddings needs embeddings.enabled; questions use keyword retrieval only")
+		}
+		return
+	}
+	provider, err := newEmbeddingProvider(AppConfig)
+	if err != nil {
+		log.Printf("Embeddings disabled: %v", err)
+		return
+	}
+	if err := os.MkdirAll(embeddingsDir, 0755); err != nil {
+		log.Printf("Embeddings disabled: failed to create %s: %v", embeddingsDir, err)
+		return
+	}
+
+	index := &vectorIndex{
+		provider: provider,
+		items:    make(map[string]itemEmbeddings),
+		queued:   make(map[string]bool),
+		wake:     make(chan struct{}, 1),
+	}
+	if err := index.load(); err != nil {
+		log.Printf("Failed to load embeddings: %v", err)
+	}
+	EIndex = index
+	go index.worker()
+	log.Printf("Embedding with %s (%s), %d items loaded", provider.Name(), provider.Model(), len(index.items))
+
+	// Check every item, which embeds the whole library the first time and
+	// after the model changes
+	files, err := os.ReadDir(metadataDir)
+	if err != nil {
+		log.Printf("Failed to read metadata directory: %v", err)
+		return
+	}
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), mdExt) {
+			queueEmbedding(strings.TrimSuffix(file.Name(), mdExt))
+		}
+	}
+}
+
+func embeddingFilePath(filename string) string {
+	return filepath.Join(embeddingsDir, filename+".gob")
+}
+
+// Read every saved item
+func (x *vectorIndex) load() error {
+	files, err := os.ReadDir(embeddingsDir)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".gob") {
+			continue
+		}
+		f, err := os.Open(filepath.Join(embeddingsDir, file.Name()))
+		if err != nil {
+			log.Printf("Failed to read embeddings %s: %v", file.Name(), err)
+			continue
+		}
+		var item itemEmbeddings
+		err = gob.NewDecoder(f).Decode(&item)
+		f.Close()
+		if err != nil {
+			log.Printf("Failed to parse embeddings %s: %v", file.Name(), err)
+			continue
+		}
+
+		// Items deleted while the server was down
+		filename := strings.TrimSuffix(file.Name(), ".gob")
+		if _, err := os.Stat(filepath.Join(metadataDir, filename+mdExt)); os.IsNotExist(err) {
+			os.Remove(filepath.Join(embeddingsDir, file.Name()))
+			continue
+		}
+		x.items[filename] = item
+	}
+	return nil
+}
+
+// Write an item's embeddings atomically
+func saveItemEmbeddings(filename string, item itemEmbeddings) error {
+	path := embeddingFilePath(filename)
+	tempPath := path + ".tmp"
+	f, err := os.Create(tempPath)
+	if err != nil {
+		return fmt.Errorf("failed to create embeddings file: %v", err)
+	}
+	if err := gob.NewEncoder(f).Encode(item); err != nil {
+		f.Close()
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to write embeddings: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to write embeddings: %v", err)
+	}
+	if err := os.Rename(tempPath, path); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to replace embeddings file: %v", err)
+	}
+	return nil
+}
+
+// Queue an item to have its changed texts embedded. Does nothing while
+// embeddings are disabled.
+func queueEmbedding(filename string) {
+	x := EIndex
+	if x == nil {
+		return
+	}
+	x.queueMu.Lock()
+	defer x.queueMu.Unlock()
+	if x.queued[filename] {
+		return
+	}
+	x.queued[filename] = true
+	x.queue = append(x.queue, filename)
+	select {
+	case x.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Drop a deleted item's embeddings
+func removeEmbeddings(filename string) {
+	x := EIndex
+	if x == nil {
+		return
+	}
+	x.mu.Lock()
+	delete(x.items, filename)
+	x.mu.Unlock()
+	if err := os.Remove(embeddingFilePath(filename)); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove embeddings of %s: %v", filename, err)
+	}
+}
+
+func (x *vectorIndex) worker() {
+	for {
+		x.queueMu.Lock()
+		if len(x.queue) == 0 {
+			x.queueMu.Unlock()
+			<-x.wake
+			continue
+		}
+		filename := x.queue[0]
+		x.queue = x.queue[1:]
+		delete(x.queued, filename)
+		x.queueMu.Unlock()
+
+		if err := x.update(filename); err != nil {
+			log.Printf("Failed to embed %s, retrying in %s: %v", filename, embeddingRetryDelay, err)
+			time.AfterFunc(embeddingRetryDelay, func() { queueEmbedding(filename) })
+		}
+	}
+}
+
+// Segments and caption of an item
+func textsToEmbed(metadata MediaMetadata, body string) []textToEmbed {
+	var texts []textToEmbed
+	for i, entry := range metadata.Transcripts {
+		if text := strings.TrimSpace(entry.Text); text != "" {
+			texts = append(texts, textToEmbed{kind: "segment", segment: i, text: text})
+		}
+	}
+	if metadata.Type == "photo" {
+		if caption := strings.TrimSpace(body); caption != "" {
+			texts = append(texts, textToEmbed{kind: "caption", text: caption})
+		}
+	}
+	return texts
+}
+
+// Scale a vector to unit length
+func normalizeVector(vector []float64) []float32 {
+	var norm float64
+	for _, v := range vector {
+		norm += v * v
+	}
+	norm = math.Sqrt(norm)
+	normalized := make([]float32, len(vector))
+	if norm == 0 {
+		return normalized
+	}
+	for i, v := range vector {
+		normalized[i] = float32(v / norm)
+	}
+	return normalized
+}
+
+// Bring an item's embeddings in line with its metadata, embedding only the
+// texts that are new or changed
+func (x *vectorIndex) update(filename string) error {
+	metadataPath := filepath.Join(metadataDir, filename+mdExt)
+	if _, err := os.Stat(metadataPath); os.IsNotExist(err) {
+		removeEmbeddings(filename)
+		return nil
+	}
+	var metadata MediaMetadata
+	body, err := readMarkdownFile(metadataPath, &metadata)
+	if err != nil {
+		return fmt.Errorf("failed to read metadata file: %v", err)
+	}
+
+	texts := textsToEmbed(metadata, body)
+	model := x.provider.Model()
+	x.mu.RLock()
+	previous, known := x.items[filename]
+	x.mu.RUnlock()
+
+	// Vectors of texts that haven't changed are kept, even if they moved
+	vectors := make(map[[sha256.Size]byte][]float32)
+	if previous.Model == model {
+		for _, embedded := range previous.Texts {
+			vectors[embedded.Hash] = embedded.Vector
+		}
+	}
+
+	item := itemEmbeddings{Model: model, Texts: make([]embeddedText, len(texts))}
+	var missing []int
+	changed := !known || previous.Model != model || len(previous.Texts) != len(texts)
+	for i, text := range texts {
+		hash := sha256.Sum256([]byte(text.text))
+		item.Texts[i] = embeddedText{Kind: text.kind, Segment: text.segment, Hash: hash, Vector: vectors[hash]}
+		if item.Texts[i].Vector == nil {
+			missing = append(missing, i)
+		}
+		if !changed {
+			old := previous.Texts[i]
+			changed = old.Kind != text.kind || old.Segment != text.segment || old.Hash != hash
+		}
+	}
+	if !changed {
+		return nil
+	}
+	if len(texts) == 0 {
+		removeEmbeddings(filename)
+		return nil
+	}
+
+	start := time.Now()
+	for i := 0; i < len(missing); i += embeddingBatchSize {
+		batch := missing[i:min(i+embeddingBatchSize, len(missing))]
+		inputs := make([]string, len(batch))
+		for k, index := range batch {
+			inputs[k] = texts[index].text
+		}
+		embedded, err := x.provider.Embed(context.Background(), inputs)
+		if err != nil {
+			return err
+		}
+		if len(embedded) != len(inputs) {
+			return fmt.Errorf("provider returned %d vectors for %d texts", len(embedded), len(inputs))
+		}
+		for k, index := range batch {
+			item.Texts[index].Vector = normalizeVector(embedded[k])
+		}
+	}
+
+	if err := saveItemEmbeddings(filename, item); err != nil {
+		return err
+	}
+	x.mu.Lock()
+	x.items[filename] = item
+	x.mu.Unlock()
+	if len(missing) > 0 {
+		log.Printf("Embedded %d of %d texts of %s in %s", len(missing), len(texts), filename, time.Since(start).Round(time.Millisecond))
+	}
+	return nil
+}
+
+// Embed a query the way the index's texts were embedded
+func (x *vectorIndex) embedQuery(ctx context.Context, query string) ([]float32, error) {
+	vectors, err := x.provider.Embed(ctx, []string{query})
+	if err != nil {
+		return nil, err
+	}
+	if len(vectors) != 1 {
+		return nil, fmt.Errorf("provider returned %d vectors for 1 text", len(vectors))
+	}
+	return normalizeVector(vectors[0]), nil
+}
+
+// Texts most similar to the query vector, best first. keep, if given,
+// decides which texts are searched.
+func (x *vectorIndex) nearest(query []float32, limit int, keep func(filename string, text embeddedText) bool) []vectorMatch {
+	model := x.provider.Model()
+	var matches []vectorMatch
+
+	x.mu.RLock()
+	for filename, item := range x.items {
+		if item.Model != model {
+			continue // Waiting to be embedded with the current model
+		}
+		for _, text := range item.Texts {
+			if len(text.Vector) != len(query) || (keep != nil && !keep(filename, text)) {
+				continue
+			}
+			var score float64
+			for i, v := range text.Vector {
+				score += float64(v) * float64(query[i])
+			}
+			matches = append(matches, vectorMatch{Filename: filename, Text: text, Score: score})
+		}
+	}
+	x.mu.RUnlock()
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		if matches[i].Filename != matches[j].Filename {
+			return matches[i].Filename < matches[j].Filename
+		}
+		return matches[i].Text.Segment < matches[j].Text.Segment
+	})
+	if limit > 0 && len(matches) > limit {
+		matches = matches[:limit]
+	}
+	return matches
+}
+
+// Segments nearest to a question for /api/ask, best first.
+// Segments edited since they were embedded are left out.
+func embeddingCandidates(ctx context.Context, index *vectorIndex, items []MediaMetadata, question string, limit int) ([]askCandidate, error) {
+	query, err := index.embedQuery(ctx, question)
+	if err != nil {
+		return nil, err
+	}
+
+	byFilename := make(map[string]*MediaMetadata)
+	for i := range items {
+		byFilename[items[i].Filename] = &items[i]
+	}
+	keep := func(filename string, text embeddedText) bool {
+		metadata, ok := byFilename[filename]
+		return ok && text.Kind == "segment" && text.Segment < len(metadata.Transcripts) &&
+			text.Hash == sha256.Sum256([]byte(strings.TrimSpace(metadata.Transcripts[text.Segment].Text)))
+	}
+
+	var candidates []askCandidate
+	for _, match := range index.nearest(query, limit, keep) {
+		candidates = append(candidates, askCandidate{metadata: byFilename[match.Filename], index: match.Text.Segment, score: match.Score})
+	}
+	return candidates, nil
+}
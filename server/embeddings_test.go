@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// countingProvider is the fake provider, recording the texts it embeds
+type countingProvider struct {
+	fakeEmbeddingProvider
+	embedded []string
+}
+
+func (p *countingProvider) Embed(ctx context.Context, texts []string) ([][]float64, error) {
+	p.embedded = append(p.embedded, texts...)
+	return p.fakeEmbeddingProvider.Embed(ctx, texts)
+}
+
+// Run tests in an empty data directory with an embedding index as the
+// global one. Its worker isn't started, so queued items stay queued.
+func setupEmbeddingTest(t *testing.T) (*vectorIndex, *countingProvider) {
+	t.Helper()
+	setupTranscriptionTest(t)
+	if err := os.MkdirAll(embeddingsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	provider := &countingProvider{}
+	index := &vectorIndex{
+		provider: provider,
+		items:    make(map[string]itemEmbeddings),
+		queued:   make(map[string]bool),
+		wake:     make(chan struct{}, 1),
+	}
+	previous := EIndex
+	t.Cleanup(func() { EIndex = previous })
+	EIndex = index
+	return index, provider
+}
+
+// Write an item with a segment for each text, or a photo with a caption
+func writeEmbeddingTestItem(t *testing.T, filename, caption string, texts ...string) {
+	t.Helper()
+	metadata := MediaMetadata{ID: filename, Filename: filename, Type: "audio", Labels: []string{}}
+	if caption != "" {
+		metadata.Type = "photo"
+		metadata.Transcription = caption
+	}
+	for i, text := range texts {
+		metadata.Transcripts = append(metadata.Transcripts, TranscriptEntry{Start: float64(10 * i), End: float64(10*i + 4), Text: text, Segment: i})
+	}
+	if err := writeMetadataFile(filepath.Join(metadataDir, filename+mdExt), metadata); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVectorIndexUpdateEmbedsOnlyChangedTexts(t *testing.T) {
+	index, provider := setupEmbeddingTest(t)
+	writeBodyTestItem(t, "talk.mp3")
+
+	if err := index.update("talk.mp3"); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if len(provider.embedded) != 3 {
+		t.Fatalf("embedded %q, want all three segments", provider.embedded)
+	}
+	before := index.items["talk.mp3"]
+
+	// Correct the second segment and drop the first, which moves the third
+	_, _, err := applyTranscriptEdit("talk.mp3", "tester", "edit", 0, func(segments []TranscriptEntry) ([]TranscriptEntry, error) {
+		segments[1].Text = "Second, corrected paragraph."
+		return segments[1:], nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	provider.embedded = nil
+	if err := index.update("talk.mp3"); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if !reflect.DeepEqual(provider.embedded, []string{"Second, corrected paragraph."}) {
+		t.Errorf("embedded %q, want only the corrected segment", provider.embedded)
+	}
+	after := index.items["talk.mp3"]
+	if len(after.Texts) != 2 || after.Texts[1].Segment != 1 {
+		t.Fatalf("unexpected texts %+v", after.Texts)
+	}
+	if after.Texts[1].Hash != before.Texts[2].Hash || !reflect.DeepEqual(after.Texts[1].Vector, before.Texts[2].Vector) {
+		t.Errorf("moved segment lost its vector")
+	}
+
+	// Nothing to do when nothing changed
+	provider.embedded = nil
+	if err := index.update("talk.mp3"); err != nil || len(provider.embedded) != 0 {
+		t.Errorf("update of an unchanged item embedded %q, %v", provider.embedded, err)
+	}
+
+	// A deleted item's embeddings are dropped
+	if err := os.Remove(filepath.Join(metadataDir, "talk.mp3"+mdExt)); err != nil {
+		t.Fatal(err)
+	}
+	if err := index.update("talk.mp3"); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if _, ok := index.items["talk.mp3"]; ok {
+		t.Error("deleted item still in the index")
+	}
+	if _, err := os.Stat(embeddingFilePath("talk.mp3")); !os.IsNotExist(err) {
+		t.Errorf("deleted item's embeddings file is left: %v", err)
+	}
+}
+
+func TestTranscriptWritesQueueEmbedding(t *testing.T) {
+	index, _ := setupEmbeddingTest(t)
+	writeEmbeddingTestItem(t, "talk.mp3", "")
+
+	transcript := TranscriptFile{Engine: "fake", Segments: []TranscriptEntry{{Start: 0, End: 4, Text: "Hello there."}}}
+	if err := writeTranscriptToMetadata("talk.mp3", transcript, "fake", "transcribe"); err != nil {
+		t.Fatal(err)
+	}
+	if !index.queued["talk.mp3"] {
+		t.Error("new transcript not queued for embedding")
+	}
+
+	index.queue, index.queued = nil, make(map[string]bool)
+	_, _, err := applyTranscriptEdit("talk.mp3", "tester", "edit", 0, func(segments []TranscriptEntry) ([]TranscriptEntry, error) {
+		segments[0].Text = "Hello again."
+		return segments, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !index.queued["talk.mp3"] {
+		t.Error("edited transcript not queued for embedding")
+	}
+}
+
+func TestItemEmbeddingsRoundTrip(t *testing.T) {
+	index, _ := setupEmbeddingTest(t)
+	writeBodyTestItem(t, "talk.mp3")
+	writeEmbeddingTestItem(t, "beach.jpg", "Sunset at the beach.")
+	for _, filename := range []string{"talk.mp3", "beach.jpg"} {
+		if err := index.update(filename); err != nil {
+			t.Fatalf("update %s: %v", filename, err)
+		}
+	}
+
+	// Embeddings of an item deleted while the server was down
+	if err := saveItemEmbeddings("gone.mp3", index.items["talk.mp3"]); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := &vectorIndex{provider: index.provider, items: make(map[string]itemEmbeddings)}
+	if err := loaded.load(); err != nil {
+		t.Fatalf("load: %v", err)
+	}
+	if !reflect.DeepEqual(loaded.items, index.items) {
+		t.Errorf("loaded %+v, want %+v", loaded.items, index.items)
+	}
+	if _, err := os.Stat(embeddingFilePath("gone.mp3")); !os.IsNotExist(err) {
+		t.Errorf("embeddings of a deleted item weren't removed: %v", err)
+	}
+	if temp, _ := filepath.Glob(filepath.Join(embeddingsDir, "*.tmp")); len(temp) != 0 {
+		t.Errorf("temporary files left: %v", temp)
+	}
+}
+
+func TestVectorIndexNearest(t *testing.T) {
+	index, _ := setupEmbeddingTest(t)
+	writeEmbeddingTestItem(t, "a.mp3", "", "blue kitchen tiles", "the garden fence")
+	writeEmbeddingTestItem(t, "b.mp3", "", "weather today", "blue kitchen tiles")
+	writeEmbeddingTestItem(t, "c.jpg", "The new kitchen.")
+	for _, filename := range []string{"a.mp3", "b.mp3", "c.jpg"} {
+		if err := index.update(filename); err != nil {
+			t.Fatalf("update %s: %v", filename, err)
+		}
+	}
+
+	query, err := index.embedQuery(context.Background(), "Blue kitchen tiles?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	matches := index.nearest(query, 0, nil)
+	if len(matches) != 5 {
+		t.Fatalf("%d matches, want all 5 texts", len(matches))
+	}
+	// Equal scores are ordered by filename
+	if matches[0].Filename != "a.mp3" || matches[0].Text.Segment != 0 ||
+		matches[1].Filename != "b.mp3" || matches[1].Text.Segment != 1 {
+		t.Errorf("best matches are %+v and %+v", matches[0], matches[1])
+	}
+	if matches[0].Score < 0.999 {
+		t.Errorf("identical text scored %v", matches[0].Score)
+	}
+	for i := 1; i < len(matches); i++ {
+		if matches[i].Score > matches[i-1].Score {
+			t.Errorf("match %d scores %v, more than the one before", i, matches[i].Score)
+		}
+	}
+
+	if limited := index.nearest(query, 2, nil); len(limited) != 2 {
+		t.Errorf("%d matches with a limit of 2", len(limited))
+	}
+	captions := index.nearest(query, 0, func(filename string, text embeddedText) bool { return text.Kind == "caption" })
+	if len(captions) != 1 || captions[0].Filename != "c.jpg" {
+		t.Errorf("caption matches %+v", captions)
+	}
+
+	// Items embedded with another model are left out until embedded again
+	item := index.items["a.mp3"]
+	item.Model = "other"
+	index.items["a.mp3"] = item
+	for _, match := range index.nearest(query, 0, nil) {
+		if match.Filename == "a.mp3" {
+			t.Errorf("match from another model: %+v", match)
+		}
+	}
+}
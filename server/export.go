@@ -0,0 +1,357 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Line length used when ?lineLength= isn't given: a common subtitle limit,
+// and a comfortable width for plain text
+const (
+	defaultSubtitleLineLength = 42
+	defaultTextLineLength     = 80
+
+	// Subtitle cues longer than this many lines are split into several cues
+	maxCueLines = 2
+)
+
+// Content types of the export formats
+var exportContentTypes = map[string]string{
+	"srt":  "application/x-subrip; charset=utf-8",
+	"vtt":  "text/vtt; charset=utf-8",
+	"txt":  "text/plain; charset=utf-8",
+	"json": "application/json",
+}
+
+// ExportOptions controls how a transcript is rendered
+type ExportOptions struct {
+	Format     string // "srt", "vtt", "txt" or "json"
+	LineLength int    // Wrap lines at this many characters; 0 disables wrapping
+	Speakers   bool   // Prefix text with speaker names
+}
+
+// subtitleCue is one timed block of subtitle text
+type subtitleCue struct {
+	Start   float64
+	End     float64
+	Speaker string // Display name, empty if unknown
+	Lines   []string
+}
+
+// Parse ?lineLength= and ?speakers= for an export format
+func parseExportOptions(format string, queryParams url.Values) (ExportOptions, error) {
+	if _, ok := exportContentTypes[format]; !ok {
+		return ExportOptions{}, fmt.Errorf("unsupported export format: %s", format)
+	}
+
+	opts := ExportOptions{Format: format, LineLength: defaultSubtitleLineLength}
+	if format == "txt" {
+		opts.LineLength = defaultTextLineLength
+	}
+	if value := queryParams.Get("lineLength"); value != "" {
+		lineLength, err := strconv.Atoi(value)
+		if err != nil || lineLength < 0 {
+			return ExportOptions{}, fmt.Errorf("lineLength must be a non-negative integer")
+		}
+		opts.LineLength = lineLength
+	}
+	if value := queryParams.Get("speakers"); value != "" {
+		speakers, err := strconv.ParseBool(value)
+		if err != nil {
+			return ExportOptions{}, fmt.Errorf("speakers must be true or false")
+		}
+		opts.Speakers = speakers
+	}
+
+	return opts, nil
+}
+
+// Greedily wrap words into lines of at most lineLength characters, returning
+// the index of the first word of each line. A word longer than the limit
+// gets a line of its own.
+func wrapWords(words []string, lineLength int) []int {
+	if len(words) == 0 {
+		return nil
+	}
+
+	starts := []int{0}
+	length := len(words[0])
+	for i := 1; i < len(words); i++ {
+		if lineLength > 0 && length+1+len(words[i]) > lineLength {
+			starts = append(starts, i)
+			length = len(words[i])
+			continue
+		}
+		length += 1 + len(words[i])
+	}
+	return starts
+}
+
+// Split segments into subtitle cues of at most maxLines wrapped lines.
+// Cue times come from word timings when the engine provided them, and are
+// otherwise interpolated by character position within the segment.
+func buildCues(metadata MediaMetadata, segments []TranscriptEntry, lineLength, maxLines int) []subtitleCue {
+	var cues []subtitleCue
+	for _, segment := range segments {
+		words := strings.Fields(segment.Text)
+		if len(words) == 0 {
+			continue
+		}
+
+		speaker := ""
+		if segment.Speaker != "" {
+			speaker = speakerName(metadata, segment.Speaker)
+		}
+
+		// Character offset of every word, for interpolating times
+		offsets := make([]int, len(words)+1)
+		for i, word := range words {
+			offsets[i+1] = offsets[i] + len(word) + 1
+		}
+		aligned := len(segment.Words) == len(words)
+		interpolate := func(i int) float64 {
+			return segment.Start + (segment.End-segment.Start)*float64(offsets[i])/float64(offsets[len(words)])
+		}
+		startOf := func(i int) float64 { // Start of word i
+			if aligned {
+				return segment.Words[i].Start
+			}
+			return interpolate(i)
+		}
+		endBefore := func(i int) float64 { // End of word i-1
+			if aligned {
+				return segment.Words[i-1].End
+			}
+			return interpolate(i)
+		}
+
+		starts := wrapWords(words, lineLength)
+		starts = append(starts, len(words))
+		for first := 0; first < len(starts)-1; first += maxLines {
+			last := min(first+maxLines, len(starts)-1)
+
+			cue := subtitleCue{
+				Start:   startOf(starts[first]),
+				End:     endBefore(starts[last]),
+				Speaker: speaker,
+			}
+			if cue.End <= cue.Start {
+				cue.End = segment.End
+			}
+			for line := first; line < last; line++ {
+				cue.Lines = append(cue.Lines, strings.Join(words[starts[line]:starts[line+1]], " "))
+			}
+			cues = append(cues, cue)
+		}
+	}
+	return cues
+}
+
+// Format seconds as hh:mm:ss followed by the separator and milliseconds
+func formatCueTime(seconds float64, separator string) string {
+	if seconds < 0 {
+		seconds = 0
+	}
+	millis := int64(seconds*1000 + 0.5)
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", millis/3600000, millis/60000%60, millis/1000%60, separator, millis%1000)
+}
+
+// Render an item's transcript in an export format
+func renderTranscript(metadata MediaMetadata, transcript TranscriptFile, opts ExportOptions) ([]byte, error) {
+	var buf bytes.Buffer
+
+	switch opts.Format {
+	case "json":
+		response := TranscriptResponse{
+			ID:          metadata.ID,
+			Filename:    metadata.Filename,
+			Engine:      transcript.Engine,
+			Model:       transcript.Model,
+			Granularity: "word",
+			Speakers:    metadata.Speakers,
+			Segments:    transcript.Segments,
+		}
+		if response.Segments == nil {
+			response.Segments = []TranscriptEntry{}
+		}
+		data, err := json.MarshalIndent(response, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal transcript: %v", err)
+		}
+		buf.Write(data)
+
+	case "srt":
+		previousSpeaker := ""
+		for i, cue := range buildCues(metadata, transcript.Segments, opts.LineLength, maxCueLines) {
+			// Name the speaker whenever it changes
+			if opts.Speakers && cue.Speaker != "" && cue.Speaker != previousSpeaker {
+				cue.Lines[0] = cue.Speaker + ": " + cue.Lines[0]
+			}
+			previousSpeaker = cue.Speaker
+
+			fmt.Fprintf(&buf, "%d\n%s --> %s\n%s\n\n", i+1,
+				formatCueTime(cue.Start, ","), formatCueTime(cue.End, ","), strings.Join(cue.Lines, "\n"))
+		}
+
+	case "vtt":
+		buf.WriteString("WEBVTT\n\n")
+		for _, cue := range buildCues(metadata, transcript.Segments, opts.LineLength, maxCueLines) {
+			// WebVTT text must not contain "-->" or unescaped markup characters
+			escape := strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace
+			text := escape(strings.Join(cue.Lines, "\n"))
+			if opts.Speakers && cue.Speaker != "" {
+				text = "<v " + escape(cue.Speaker) + ">" + text
+			}
+			fmt.Fprintf(&buf, "%s --> %s\n%s\n\n", formatCueTime(cue.Start, "."), formatCueTime(cue.End, "."), text)
+		}
+
+	case "txt":
+		// One paragraph per run of segments by the same speaker
+		previousSpeaker := ""
+		var paragraph []string
+		flush := func() {
+			if len(paragraph) == 0 {
+				return
+			}
+			words := strings.Fields(strings.Join(paragraph, " "))
+			starts := append(wrapWords(words, opts.LineLength), len(words))
+			for i := 0; i < len(starts)-1; i++ {
+				buf.WriteString(strings.Join(words[starts[i]:starts[i+1]], " "))
+				buf.WriteString("\n")
+			}
+			buf.WriteString("\n")
+			paragraph = nil
+		}
+		for _, segment := range transcript.Segments {
+			if strings.TrimSpace(segment.Text) == "" {
+				continue
+			}
+			speaker := ""
+			if segment.Speaker != "" {
+				speaker = speakerName(metadata, segment.Speaker)
+			}
+			if speaker != previousSpeaker {
+				flush()
+				if opts.Speakers && speaker != "" {
+					paragraph = append(paragraph, speaker+":")
+				}
+				previousSpeaker = speaker
+			}
+			paragraph = append(paragraph, segment.Text)
+		}
+		flush()
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Name of an exported transcript: the media filename with the format's extension
+func exportFilename(mediaFilename, format string) string {
+	return strings.TrimSuffix(mediaFilename, filepath.Ext(mediaFilename)) + "." + format
+}
+
+// Serve /api/media/{id}/transcript.{srt,vtt,txt,json}. ?download=true sends
+// it as an attachment instead of inline, as used by a <track> element.
+func handleTranscriptExport(w http.ResponseWriter, r *http.Request, metadata MediaMetadata, format string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	opts, err := parseExportOptions(format, r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	data, err := renderTranscript(metadata, loadTranscript(metadata), opts)
+	if err != nil {
+		log.Printf("Error exporting transcript for %s: %v", metadata.Filename, err)
+		http.Error(w, "Failed to export transcript", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", exportContentTypes[format])
+	if download, _ := strconv.ParseBool(r.URL.Query().Get("download")); download {
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", exportFilename(metadata.Filename, format)))
+	}
+	w.Write(data)
+}
+
+// Handler for exporting the transcripts of a filtered selection as a zip:
+// /api/transcripts/export?format=srt&labels=work
+func handleBulkTranscriptExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	queryParams := r.URL.Query()
+	format := queryParams.Get("format")
+	if format == "" {
+		format = "srt"
+	}
+	opts, err := parseExportOptions(format, queryParams)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter, err := parseMediaFilter(queryParams)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	items, err := filterMetadata(filter)
+	if err != nil {
+		http.Error(w, "Failed to read metadata", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "transcripts-"+format+".zip"))
+
+	archive := zip.NewWriter(w)
+	used := make(map[string]bool)
+	exported := 0
+	for _, metadata := range items {
+		transcript := loadTranscript(metadata)
+		if len(transcript.Segments) == 0 {
+			continue
+		}
+
+		data, err := renderTranscript(metadata, transcript, opts)
+		if err != nil {
+			log.Printf("Error exporting transcript for %s: %v", metadata.Filename, err)
+			continue
+		}
+
+		// Items like talk.mp3 and talk.mp4 would otherwise share a name
+		name := exportFilename(metadata.Filename, format)
+		for i := 1; used[name]; i++ {
+			name = exportFilename(fmt.Sprintf("%s-%d%s", strings.TrimSuffix(metadata.Filename, filepath.Ext(metadata.Filename)), i, filepath.Ext(metadata.Filename)), format)
+		}
+		used[name] = true
+
+		file, err := archive.Create(name)
+		if err != nil {
+			log.Printf("Error adding %s to transcript export: %v", name, err)
+			break
+		}
+		file.Write(data)
+		exported++
+	}
+
+	if err := archive.Close(); err != nil {
+		log.Printf("Error finishing transcript export: %v", err)
+	}
+	log.Printf("Exported %d transcripts as %s", exported, format)
+}
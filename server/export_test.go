@@ -0,0 +1,184 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWrapWords(t *testing.T) {
+	tests := []struct {
+		text       string
+		lineLength int
+		want       []int
+	}{
+		{"", 10, nil},
+		{"one", 10, []int{0}},
+		{"abc de", 6, []int{0}},
+		{"abc de", 5, []int{0, 1}},
+		{"no wrapping at all however long", 0, []int{0}},
+		// A word longer than a line gets one of its own
+		{"supercalifragilistic a b", 5, []int{0, 1}},
+		{"a longword b", 4, []int{0, 1, 2}},
+	}
+	for _, test := range tests {
+		if got := wrapWords(strings.Fields(test.text), test.lineLength); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("wrapWords(%q, %d) = %v, want %v", test.text, test.lineLength, got, test.want)
+		}
+	}
+}
+
+func TestFormatCueTime(t *testing.T) {
+	tests := []struct {
+		seconds   float64
+		separator string
+		want      string
+	}{
+		{0, ",", "00:00:00,000"},
+		{-1, ",", "00:00:00,000"},
+		{1.0005, ",", "00:00:01,001"},
+		{59.9996, ".", "00:01:00.000"},
+		{3725.25, ".", "01:02:05.250"},
+		{360000, ",", "100:00:00,000"},
+	}
+	for _, test := range tests {
+		if got := formatCueTime(test.seconds, test.separator); got != test.want {
+			t.Errorf("formatCueTime(%v, %q) = %q, want %q", test.seconds, test.separator, got, test.want)
+		}
+	}
+}
+
+func TestBuildCuesInterpolatesTimes(t *testing.T) {
+	segments := []TranscriptEntry{
+		// Without word timings, times are spread by character position
+		{Start: 10, End: 20, Text: "aaaa bbbb cccc dddd"},
+		// Words all at one instant give cues the segment's end
+		{Start: 22, End: 25, Text: "a b", Words: []TranscriptWord{{Word: "a", Start: 23, End: 23}, {Word: "b", Start: 23, End: 23}}},
+		{Start: 26, End: 27, Text: " "},
+	}
+	cues := buildCues(MediaMetadata{}, segments, 4, 1)
+	want := []subtitleCue{
+		{Start: 10, End: 12.5, Lines: []string{"aaaa"}},
+		{Start: 12.5, End: 15, Lines: []string{"bbbb"}},
+		{Start: 15, End: 17.5, Lines: []string{"cccc"}},
+		{Start: 17.5, End: 20, Lines: []string{"dddd"}},
+		{Start: 23, End: 25, Lines: []string{"a b"}},
+	}
+	if !reflect.DeepEqual(cues, want) {
+		t.Errorf("buildCues = %+v, want %+v", cues, want)
+	}
+}
+
+// A transcript with a named speaker, a segment with word timings that wraps
+// to four lines, markup characters and a blank segment
+func exportTestTranscript() (MediaMetadata, TranscriptFile) {
+	var words []TranscriptWord
+	for i, word := range strings.Fields("one two three four five six seven eight nine ten eleven twelve") {
+		start := 5 + float64(i)*0.5
+		words = append(words, TranscriptWord{Word: word, Start: start, End: start + 0.4})
+	}
+	metadata := MediaMetadata{ID: "1", Filename: "show.mp3", Speakers: map[string]string{"SPEAKER_00": "Alice"}}
+	transcript := TranscriptFile{Segments: []TranscriptEntry{
+		{Start: 0, End: 4, Text: "Hello and welcome to the show.", Speaker: "SPEAKER_00"},
+		{Start: 5, End: 11, Text: "one two three four five six seven eight nine ten eleven twelve", Words: words},
+		{Start: 12, End: 14.0005, Text: "Tom & Jerry <3 -->", Speaker: "SPEAKER_00"},
+		{Start: 14.5, End: 16, Text: "Still Alice.", Speaker: "SPEAKER_00"},
+		{Start: 17, End: 18, Text: "  ", Speaker: "SPEAKER_00"},
+	}}
+	return metadata, transcript
+}
+
+func TestRenderTranscript(t *testing.T) {
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"srt", `1
+00:00:00,000 --> 00:00:04,000
+Alice: Hello and welcome to
+the show.
+
+2
+00:00:05,000 --> 00:00:08,900
+one two three four
+five six seven eight
+
+3
+00:00:09,000 --> 00:00:10,900
+nine ten eleven
+twelve
+
+4
+00:00:12,000 --> 00:00:14,001
+Alice: Tom & Jerry <3 -->
+
+5
+00:00:14,500 --> 00:00:16,000
+Still Alice.
+
+`},
+		{"vtt", `WEBVTT
+
+00:00:00.000 --> 00:00:04.000
+<v Alice>Hello and welcome to
+the show.
+
+00:00:05.000 --> 00:00:08.900
+one two three four
+five six seven eight
+
+00:00:09.000 --> 00:00:10.900
+nine ten eleven
+twelve
+
+00:00:12.000 --> 00:00:14.001
+<v Alice>Tom &amp; Jerry &lt;3 --&gt;
+
+00:00:14.500 --> 00:00:16.000
+<v Alice>Still Alice.
+
+`},
+		{"txt", `Alice: Hello and
+welcome to the show.
+
+one two three four
+five six seven eight
+nine ten eleven
+twelve
+
+Alice: Tom & Jerry
+<3 --> Still Alice.
+
+`},
+	}
+	metadata, transcript := exportTestTranscript()
+	for _, test := range tests {
+		data, err := renderTranscript(metadata, transcript, ExportOptions{Format: test.format, LineLength: 20, Speakers: true})
+		if err != nil {
+			t.Errorf("%s: %v", test.format, err)
+			continue
+		}
+		if string(data) != test.want {
+			t.Errorf("%s export:\n%s\nwant:\n%s", test.format, data, test.want)
+		}
+	}
+}
+
+func TestRenderTranscriptWithoutSpeakers(t *testing.T) {
+	metadata, transcript := exportTestTranscript()
+	data, err := renderTranscript(metadata, transcript, ExportOptions{Format: "txt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "Hello and welcome to the show.\n\n" +
+		"one two three four five six seven eight nine ten eleven twelve\n\n" +
+		"Tom & Jerry <3 --> Still Alice.\n\n"
+	if string(data) != want {
+		t.Errorf("txt export without speakers or wrapping:\n%s\nwant:\n%s", data, want)
+	}
+
+	data, _ = renderTranscript(metadata, TranscriptFile{}, ExportOptions{Format: "vtt", LineLength: 42})
+	if string(data) != "WEBVTT\n\n" {
+		t.Errorf("vtt export of no segments: %q", data)
+	}
+}
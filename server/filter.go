@@ -0,0 +1,161 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MediaFilter selects media items by the query parameters shared by the
+// listing, export and bulk endpoints
+type MediaFilter struct {
+	StartDate string // RFC3339; items before it are excluded
+	EndDate   string // RFC3339; items after it are excluded
+	Labels    []string
+	Speakers  []string
+	Types     []string // "photo", "audio" or "video"
+	Languages []string // Chosen or detected language codes
+
+	// Items whose language was detected with less confidence are excluded
+	MinLanguageProbability float64
+
+	startTime, endTime time.Time
+}
+
+// Parse ?startDate=, ?endDate=, ?labels=, ?speaker=, ?type=, ?language= and
+// ?minLanguageProbability= into a filter
+func parseMediaFilter(queryParams url.Values) (MediaFilter, error) {
+	filter := MediaFilter{
+		StartDate: queryParams.Get("startDate"),
+		EndDate:   queryParams.Get("endDate"),
+		Labels:    splitListParam(queryParams.Get("labels")),
+		Speakers:  splitListParam(queryParams.Get("speaker")),
+		Types:     splitListParam(queryParams.Get("type")),
+	}
+
+	for _, value := range splitListParam(queryParams.Get("language")) {
+		language, err := normalizeLanguage(value)
+		if err != nil {
+			return filter, err
+		}
+		if language == autoLanguage {
+			continue
+		}
+		filter.Languages = append(filter.Languages, language)
+	}
+	if value := queryParams.Get("minLanguageProbability"); value != "" {
+		probability, err := strconv.ParseFloat(value, 64)
+		if err != nil || probability < 0 || probability > 1 {
+			return filter, fmt.Errorf("minLanguageProbability must be a number between 0 and 1")
+		}
+		filter.MinLanguageProbability = probability
+	}
+
+	var err error
+	if filter.StartDate != "" {
+		filter.startTime, err = time.Parse(time.RFC3339, filter.StartDate)
+		if err != nil {
+			return filter, fmt.Errorf("Invalid startDate format. Use RFC3339 format (e.g., 2023-01-01T00:00:00Z)")
+		}
+	}
+	if filter.EndDate != "" {
+		filter.endTime, err = time.Parse(time.RFC3339, filter.EndDate)
+		if err != nil {
+			return filter, fmt.Errorf("Invalid endDate format. Use RFC3339 format (e.g., 2023-12-31T23:59:59Z)")
+		}
+	}
+
+	return filter, nil
+}
+
+// Whether an item passes the filter
+func (f MediaFilter) matches(metadata MediaMetadata) bool {
+	// Apply date range filtering
+	if f.StartDate != "" || f.EndDate != "" {
+		itemTime, err := time.Parse(time.RFC3339, metadata.Timestamp)
+		if err != nil {
+			log.Printf("Failed to parse timestamp for %s: %v", metadata.Filename, err)
+			return false
+		}
+		if f.StartDate != "" && itemTime.Before(f.startTime) {
+			return false
+		}
+		if f.EndDate != "" && itemTime.After(f.endTime) {
+			return false
+		}
+	}
+
+	// Apply label filtering: any of the labels matches
+	if len(f.Labels) > 0 {
+		hasMatchingLabel := false
+		for _, filterLabel := range f.Labels {
+			for _, itemLabel := range metadata.Labels {
+				if strings.EqualFold(strings.TrimSpace(itemLabel), filterLabel) {
+					hasMatchingLabel = true
+					break
+				}
+			}
+		}
+		if !hasMatchingLabel {
+			return false
+		}
+	}
+
+	// Apply speaker filtering
+	if len(f.Speakers) > 0 && !metadataHasSpeaker(metadata, f.Speakers) {
+		return false
+	}
+
+	// Apply type filtering
+	if len(f.Types) > 0 {
+		hasType := false
+		for _, mediaType := range f.Types {
+			if strings.EqualFold(mediaType, metadata.Type) {
+				hasType = true
+				break
+			}
+		}
+		if !hasType {
+			return false
+		}
+	}
+
+	// Apply language filtering. A language chosen for the item counts as certain.
+	if len(f.Languages) > 0 {
+		language := itemLanguage(metadata)
+		hasLanguage := false
+		for _, filterLanguage := range f.Languages {
+			if filterLanguage == language && language != "" {
+				hasLanguage = true
+				break
+			}
+		}
+		if !hasLanguage {
+			return false
+		}
+	}
+	if f.MinLanguageProbability > 0 && !languageChosen(metadata) && metadata.LanguageProbability < f.MinLanguageProbability {
+		return false
+	}
+
+	return true
+}
+
+// Read every metadata file and keep the items that pass the filter
+func filterMetadata(filter MediaFilter) ([]MediaMetadata, error) {
+	allMetadata, err := readAllMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	filtered := []MediaMetadata{}
+	for _, metadata := range allMetadata {
+		if filter.matches(metadata) {
+			filtered = append(filtered, metadata)
+		}
+	}
+	return filtered, nil
+}
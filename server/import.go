@@ -0,0 +1,436 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ImportOptions controls a bulk import of an existing directory tree
+type ImportOptions struct {
+	Root         string `json:"path"`
+	Link         bool   `json:"link"`         // Hard-link instead of copying where possible
+	FolderLabels bool   `json:"folderLabels"` // Turn folder names into labels
+	DryRun       bool   `json:"dryRun"`       // Report what would happen without touching the library
+	Language     string `json:"language"`     // Language of the imported recordings; empty uses the library default
+	Transcribe   bool   `json:"transcribe"`   // Transcribe recordings even if they have sidecar subtitles
+}
+
+// ImportEntry describes what happened (or would happen) to one source file
+type ImportEntry struct {
+	Source   string   `json:"source"`
+	Filename string   `json:"filename,omitempty"`
+	Action   string   `json:"action"` // "copy", "link", "skip" or "error"
+	Reason   string   `json:"reason,omitempty"`
+	Labels   []string `json:"labels,omitempty"`
+	Sidecar  string   `json:"sidecar,omitempty"` // Subtitle file imported as the transcript
+}
+
+// ImportReport summarises a bulk import
+type ImportReport struct {
+	Root     string        `json:"root"`
+	DryRun   bool          `json:"dryRun"`
+	Imported int           `json:"imported"`
+	Skipped  int           `json:"skipped"`
+	Failed   int           `json:"failed"`
+	Entries  []ImportEntry `json:"entries"`
+}
+
+// Import every supported media file under a directory into the library.
+// Files are matched to earlier imports by their source path, and to existing
+// library files with the same name by content, so re-running an import on the
+// same tree doesn't create duplicates.
+func importDirectory(opts ImportOptions) (ImportReport, error) {
+	root, err := filepath.Abs(opts.Root)
+	if err != nil {
+		return ImportReport{}, fmt.Errorf("invalid import path: %v", err)
+	}
+	if opts.Language, err = normalizeLanguage(opts.Language); err != nil {
+		return ImportReport{}, err
+	}
+	if info, err := os.Stat(root); err != nil {
+		return ImportReport{}, fmt.Errorf("cannot read import path: %v", err)
+	} else if !info.IsDir() {
+		return ImportReport{}, fmt.Errorf("import path is not a directory: %s", root)
+	}
+
+	// Index earlier imports by their source path
+	allMetadata, err := readAllMetadata()
+	if err != nil {
+		return ImportReport{}, fmt.Errorf("failed to read metadata: %v", err)
+	}
+	imported := make(map[string]string)
+	for _, metadata := range allMetadata {
+		if metadata.Source != "" {
+			imported[metadata.Source] = metadata.Filename
+		}
+	}
+
+	report := ImportReport{Root: root, DryRun: opts.DryRun, Entries: []ImportEntry{}}
+	reserved := make(map[string]bool) // Library filenames claimed during this run
+
+	walkErr := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			log.Printf("Error walking %s: %v", path, err)
+			report.Entries = append(report.Entries, ImportEntry{Source: path, Action: "error", Reason: err.Error()})
+			report.Failed++
+			if d != nil && d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// Skip hidden files and directories such as .stfolder or .DS_Store
+		if path != root && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || !d.Type().IsRegular() || detectMediaType(d.Name()) == "unknown" {
+			return nil
+		}
+
+		entry := importFile(path, root, opts, imported, reserved)
+		switch entry.Action {
+		case "skip":
+			report.Skipped++
+		case "error":
+			report.Failed++
+		default:
+			report.Imported++
+		}
+		report.Entries = append(report.Entries, entry)
+		return nil
+	})
+	if walkErr != nil {
+		return report, fmt.Errorf("failed to walk import path: %v", walkErr)
+	}
+
+	return report, nil
+}
+
+// Import a single file, returning the report entry describing the outcome
+func importFile(source, root string, opts ImportOptions, imported map[string]string, reserved map[string]bool) ImportEntry {
+	entry := ImportEntry{Source: source}
+
+	if filename, ok := imported[source]; ok {
+		entry.Filename = filename
+		entry.Action = "skip"
+		entry.Reason = "already imported"
+		return entry
+	}
+
+	// Find a library filename, reusing an identical file that's already there
+	filename, existing, err := chooseLibraryFilename(source, source, reserved)
+	if err != nil {
+		entry.Action = "error"
+		entry.Reason = err.Error()
+		return entry
+	}
+	entry.Filename = filename
+	reserved[filename] = true
+
+	if existing {
+		entry.Action = "skip"
+		entry.Reason = "identical file already in library"
+		return entry
+	}
+
+	if opts.FolderLabels {
+		entry.Labels = folderLabels(source, root)
+	}
+
+	mediaType := detectMediaType(filename)
+	var sidecar string
+	if mediaType == "audio" || mediaType == "video" {
+		sidecar, _ = findSidecarSubtitle(filepath.Dir(source), filepath.Base(source))
+		entry.Sidecar = sidecar
+	}
+
+	entry.Action = "copy"
+	if opts.Link {
+		entry.Action = "link"
+	}
+	if opts.DryRun {
+		return entry
+	}
+
+	destination := filepath.Join(mediaDir, filename)
+	if opts.Link {
+		if err := os.Link(source, destination); err != nil {
+			// Hard links can't cross filesystems; fall back to a copy
+			log.Printf("Hard link failed for %s, copying instead: %v", source, err)
+			entry.Action = "copy"
+		}
+	}
+	if entry.Action == "copy" {
+		if err := copyFile(source, destination); err != nil {
+			entry.Action = "error"
+			entry.Reason = err.Error()
+			return entry
+		}
+	}
+
+	// Subtitles go next to the media in the library, where ingestion finds them
+	var sidecarDestination string
+	if sidecar != "" {
+		sidecarDestination = filepath.Join(mediaDir, librarySidecarName(sidecar, filename))
+		if err := copyFile(sidecar, sidecarDestination); err != nil {
+			log.Printf("Failed to copy subtitles %s: %v", sidecar, err)
+			entry.Sidecar = ""
+			sidecarDestination = ""
+		}
+	}
+
+	ingestOpts := IngestOptions{Labels: entry.Labels, Source: source, Language: opts.Language, Transcribe: opts.Transcribe}
+	if _, err := ingestMediaFile(filename, ingestOpts); err != nil {
+		os.Remove(destination)
+		if sidecarDestination != "" {
+			os.Remove(sidecarDestination)
+		}
+		entry.Action = "error"
+		entry.Reason = err.Error()
+		return entry
+	}
+
+	imported[source] = filename
+	return entry
+}
+
+// Pick the library filename for a source file to be stored as name. Returns
+// existing=true if a file with the same name and content is already in the
+// library.
+func chooseLibraryFilename(source, name string, reserved map[string]bool) (string, bool, error) {
+	base := filepath.Base(name)
+	ext := filepath.Ext(base)
+	stem := strings.TrimSuffix(base, ext)
+
+	var sourceSum string
+	for i := 0; ; i++ {
+		candidate := base
+		if i > 0 {
+			candidate = fmt.Sprintf("%s-%d%s", stem, i, ext)
+		}
+		if reserved[candidate] {
+			continue
+		}
+
+		info, err := os.Stat(filepath.Join(mediaDir, candidate))
+		if os.IsNotExist(err) {
+			return candidate, false, nil
+		}
+		if err != nil {
+			return "", false, fmt.Errorf("failed to check library file: %v", err)
+		}
+
+		// Same name: only treat it as the same file if the content matches
+		sourceInfo, err := os.Stat(source)
+		if err != nil {
+			return "", false, fmt.Errorf("failed to stat source file: %v", err)
+		}
+		if info.Size() != sourceInfo.Size() {
+			continue
+		}
+		if sourceSum == "" {
+			if sourceSum, err = fileSHA256(source); err != nil {
+				return "", false, fmt.Errorf("failed to checksum source file: %v", err)
+			}
+		}
+		librarySum, err := fileSHA256(filepath.Join(mediaDir, candidate))
+		if err != nil {
+			return "", false, fmt.Errorf("failed to checksum library file: %v", err)
+		}
+		if librarySum == sourceSum {
+			return candidate, true, nil
+		}
+	}
+}
+
+// Labels derived from the folders between the import root and a file
+func folderLabels(source, root string) []string {
+	rel, err := filepath.Rel(root, filepath.Dir(source))
+	if err != nil || rel == "." {
+		return nil
+	}
+
+	var labels []string
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		if part = strings.TrimSpace(part); part != "" {
+			labels = append(labels, part)
+		}
+	}
+	return labels
+}
+
+// Copy a file into place, writing to a temporary name first so a failed
+// copy never leaves a truncated file in the library
+func copyFile(source, destination string) error {
+	src, err := os.Open(source)
+	if err != nil {
+		return fmt.Errorf("failed to open source file: %v", err)
+	}
+	defer src.Close()
+
+	tempPath := destination + ".importing"
+	dst, err := os.Create(tempPath)
+	if err != nil {
+		return fmt.Errorf("failed to create library file: %v", err)
+	}
+
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to copy file: %v", err)
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to copy file: %v", err)
+	}
+
+	if err := os.Rename(tempPath, destination); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to move file into library: %v", err)
+	}
+	return nil
+}
+
+// Entry point for the import subcommand
+func runImportCommand(args []string) {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	link := flags.Bool("link", false, "hard-link files into the library instead of copying")
+	labels := flags.Bool("folder-labels", false, "turn folder names into labels")
+	dryRun := flags.Bool("dry-run", false, "report what would be imported without changing the library")
+	language := flags.String("language", "", "language of the recordings, e.g. de (default: the library default)")
+	transcribe := flags.Bool("transcribe", false, "transcribe recordings even if they have subtitle files")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s import [flags] <directory>\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	// Jobs are only recorded; the server runs them when it starts
+	if !*dryRun {
+		if err := openJobStore(); err != nil {
+			log.Fatalf("Failed to open transcription jobs: %v", err)
+		}
+	}
+
+	report, err := importDirectory(ImportOptions{
+		Root:         flags.Arg(0),
+		Link:         *link,
+		FolderLabels: *labels,
+		DryRun:       *dryRun,
+		Language:     *language,
+		Transcribe:   *transcribe,
+	})
+	if err != nil {
+		log.Fatalf("Import failed: %v", err)
+	}
+
+	for _, entry := range report.Entries {
+		line := fmt.Sprintf("%-5s %s", entry.Action, entry.Source)
+		if entry.Filename != "" {
+			line += " -> " + entry.Filename
+		}
+		if len(entry.Labels) > 0 {
+			line += fmt.Sprintf(" [%s]", strings.Join(entry.Labels, ", "))
+		}
+		if entry.Sidecar != "" {
+			line += " + " + filepath.Base(entry.Sidecar)
+		}
+		if entry.Reason != "" {
+			line += " (" + entry.Reason + ")"
+		}
+		fmt.Println(line)
+	}
+
+	verb := "Imported"
+	if report.DryRun {
+		verb = "Would import"
+	}
+	fmt.Printf("%s %d files, skipped %d, failed %d\n", verb, report.Imported, report.Skipped, report.Failed)
+	if report.DryRun {
+		return
+	}
+	for _, entry := range report.Entries {
+		if mediaType := detectMediaType(entry.Filename); (entry.Action == "copy" || entry.Action == "link") && (mediaType == "audio" || mediaType == "video") {
+			fmt.Println("Audio and video files will be queued for transcription when the server starts")
+			break
+		}
+	}
+}
+
+// Whether a path is one of the configured import roots or inside one.
+// Symlinks are resolved first, so a link can't lead out of a root.
+func withinImportRoots(path string) bool {
+	resolved, err := resolvePath(path)
+	if err != nil {
+		return false
+	}
+	for _, root := range AppConfig.Import.Roots {
+		resolvedRoot, err := resolvePath(root)
+		if err != nil {
+			log.Printf("Ignoring import root %s: %v", root, err)
+			continue
+		}
+		rel, err := filepath.Rel(resolvedRoot, resolved)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Absolute path with every symlink resolved
+func resolvePath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(abs)
+}
+
+// Handler for importing a directory on the server from a configured import root
+func handleImport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var opts ImportOptions
+	if err := json.NewDecoder(r.Body).Decode(&opts); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if opts.Root == "" {
+		http.Error(w, "path is required", http.StatusBadRequest)
+		return
+	}
+	if !withinImportRoots(opts.Root) {
+		http.Error(w, "path is not inside a configured import root", http.StatusForbidden)
+		return
+	}
+
+	report, err := importDirectory(opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// Write the files of an import source under root
+func writeImportSource(t *testing.T, root string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func dirNames(t *testing.T, dir string) []string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestImportDirectory(t *testing.T) {
+	setupTranscriptionTest(t)
+	queue := TQueue
+	t.Cleanup(func() { TQueue = queue })
+	TQueue = newTranscriptionQueue()
+
+	source := t.TempDir()
+	writeImportSource(t, source, map[string]string{
+		"2023/beach/talk.mp3":   "beach talk",
+		"2023/kitchen/talk.mp3": "kitchen talk", // Same name, other content
+		"memo.wav":              "memo",
+		".stfolder/hidden.mp3":  "hidden",
+		"2023/notes.txt":        "not media",
+	})
+	opts := ImportOptions{Root: source, FolderLabels: true}
+
+	// A dry run reports the plan and writes nothing
+	dryRun := opts
+	dryRun.DryRun = true
+	report, err := importDirectory(dryRun)
+	if err != nil {
+		t.Fatalf("dry run: %v", err)
+	}
+	if report.Imported != 3 || report.Skipped != 0 || report.Failed != 0 {
+		t.Errorf("dry run report %+v", report)
+	}
+	for _, dir := range []string{mediaDir, metadataDir, jobsDir} {
+		if names := dirNames(t, dir); len(names) != 0 {
+			t.Errorf("dry run wrote %v to %s", names, dir)
+		}
+	}
+	if len(TQueue.Jobs) != 0 {
+		t.Errorf("dry run queued %d transcriptions", len(TQueue.Jobs))
+	}
+
+	report, err = importDirectory(opts)
+	if err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	if report.Imported != 3 || report.Skipped != 0 || report.Failed != 0 {
+		t.Errorf("import report %+v", report)
+	}
+	wantMedia := []string{"memo.wav", "talk-1.mp3", "talk.mp3"}
+	if names := dirNames(t, mediaDir); !reflect.DeepEqual(names, wantMedia) {
+		t.Errorf("library has %v, want %v", names, wantMedia)
+	}
+
+	labels := make(map[string][]string)
+	sources := make(map[string]string)
+	items, err := readAllMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, metadata := range items {
+		labels[metadata.Filename] = metadata.Labels
+		sources[metadata.Filename] = metadata.Source
+	}
+	wantLabels := map[string][]string{
+		"memo.wav":   {},
+		"talk.mp3":   {"2023", "beach"},
+		"talk-1.mp3": {"2023", "kitchen"},
+	}
+	if !reflect.DeepEqual(labels, wantLabels) {
+		t.Errorf("labels %v, want %v", labels, wantLabels)
+	}
+	if sources["talk-1.mp3"] != filepath.Join(source, "2023/kitchen/talk.mp3") {
+		t.Errorf("source of talk-1.mp3 is %q", sources["talk-1.mp3"])
+	}
+	if len(TQueue.Jobs) != 3 {
+		t.Errorf("%d transcriptions queued, want 3", len(TQueue.Jobs))
+	}
+
+	// Running it again imports nothing new, nor a copy of a file that is
+	// already in the library
+	writeImportSource(t, source, map[string]string{"2024/talk.mp3": "beach talk"})
+	report, err = importDirectory(opts)
+	if err != nil {
+		t.Fatalf("second import: %v", err)
+	}
+	if report.Imported != 0 || report.Skipped != 4 || report.Failed != 0 {
+		t.Errorf("second import report %+v", report)
+	}
+	if names := dirNames(t, mediaDir); !reflect.DeepEqual(names, wantMedia) {
+		t.Errorf("library has %v after the second import, want %v", names, wantMedia)
+	}
+	if names := dirNames(t, metadataDir); len(names) != 3 {
+		t.Errorf("metadata files %v after the second import", names)
+	}
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Files dropped into the inbox (e.g. by Syncthing) are picked up by a polling
+// watcher once they stop changing, then moved into the library through the
+// same pipeline as uploads. Files that can't be ingested are moved to
+// inbox/rejected next to a .reason.txt file explaining why.
+
+const (
+	inboxDir         = "./data/inbox"
+	inboxRejectedDir = "./data/inbox/rejected"
+
+	// How often the inbox is scanned
+	inboxPollInterval = 5 * time.Second
+
+	// How long a file's size and modification time must stay unchanged before it's ingested
+	inboxStableAfter = 10 * time.Second
+)
+
+// inboxFileState records what a file looked like when it was last seen
+type inboxFileState struct {
+	size      int64
+	modTime   time.Time
+	unchanged time.Time // When the size and mtime were first seen at their current values
+}
+
+// Initialize the inbox watcher
+func InitInboxWatcher() {
+	for _, dir := range []string{inboxDir, inboxRejectedDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			log.Fatalf("Failed to create inbox directory %s: %v", dir, err)
+		}
+	}
+
+	go inboxWatcher()
+}
+
+// Worker that polls the inbox and ingests files once they are fully written
+func inboxWatcher() {
+	seen := make(map[string]*inboxFileState)
+	for {
+		scanInbox(seen, time.Now())
+		time.Sleep(inboxPollInterval)
+	}
+}
+
+// Scan the inbox once, ingesting every file that has been stable long enough
+func scanInbox(seen map[string]*inboxFileState, now time.Time) {
+	present := make(map[string]bool)
+
+	err := filepath.WalkDir(inboxDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			log.Printf("Error scanning inbox path %s: %v", path, err)
+			return nil
+		}
+		if d.IsDir() {
+			// Don't descend into the rejected folder or hidden folders such as .stfolder
+			if path != inboxDir && (filepath.Clean(path) == filepath.Clean(inboxRejectedDir) || strings.HasPrefix(d.Name(), ".")) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.Type().IsRegular() || isInboxTempFile(d.Name()) {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		present[path] = true
+
+		state, ok := seen[path]
+		if !ok || state.size != info.Size() || !state.modTime.Equal(info.ModTime()) {
+			// New or still being written; wait until it settles
+			seen[path] = &inboxFileState{size: info.Size(), modTime: info.ModTime(), unchanged: now}
+			return nil
+		}
+		if now.Sub(state.unchanged) < inboxStableAfter || now.Sub(info.ModTime()) < inboxStableAfter {
+			return nil
+		}
+
+		ingestInboxFile(path)
+		delete(seen, path)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Failed to scan inbox: %v", err)
+	}
+
+	// Forget files that disappeared between scans
+	for path := range seen {
+		if !present[path] {
+			delete(seen, path)
+		}
+	}
+}
+
+// Whether a file is a sync tool's partial download or otherwise not meant to be ingested
+func isInboxTempFile(name string) bool {
+	lowerName := strings.ToLower(name)
+	return strings.HasPrefix(name, ".") ||
+		strings.HasPrefix(name, "~syncthing~") ||
+		strings.HasSuffix(lowerName, ".tmp") ||
+		strings.HasSuffix(lowerName, ".part") ||
+		strings.HasSuffix(lowerName, ".crdownload")
+}
+
+// The name a sync tool's partial download will have once it is complete,
+// such as clip.mp4 for ~syncthing~clip.mp4.tmp; other names are returned
+// unchanged
+func partialDownloadName(name string) string {
+	if !isInboxTempFile(name) {
+		return name
+	}
+	name = strings.TrimPrefix(strings.TrimPrefix(name, "."), "~syncthing~")
+	lowerName := strings.ToLower(name)
+	for _, suffix := range []string{".tmp", ".part", ".crdownload"} {
+		if strings.HasSuffix(lowerName, suffix) {
+			return name[:len(name)-len(suffix)]
+		}
+	}
+	return name
+}
+
+// Move a stable inbox file into the library and run the upload pipeline on it
+func ingestInboxFile(path string) {
+	name := filepath.Base(path)
+
+	if isSubtitleFile(name) {
+		ingestInboxSubtitle(path)
+		return
+	}
+	if detectMediaType(name) == "unknown" {
+		rejectInboxFile(path, "unsupported file type")
+		return
+	}
+
+	filename, existing, err := chooseLibraryFilename(path, name, nil)
+	if err != nil {
+		rejectInboxFile(path, err.Error())
+		return
+	}
+	if existing {
+		rejectInboxFile(path, fmt.Sprintf("identical file already in library as %s", filename))
+		return
+	}
+
+	destination := filepath.Join(mediaDir, filename)
+	if err := moveFile(path, destination); err != nil {
+		rejectInboxFile(path, err.Error())
+		return
+	}
+
+	// Subtitles that arrived with the recording follow it into the library
+	if sidecar, _ := findSidecarSubtitle(filepath.Dir(path), name); sidecar != "" {
+		if err := moveFile(sidecar, filepath.Join(mediaDir, librarySidecarName(sidecar, filename))); err != nil {
+			log.Printf("Failed to move subtitles %s: %v", filepath.Base(sidecar), err)
+		}
+	}
+
+	source := path
+	if rel, err := filepath.Rel(inboxDir, path); err == nil {
+		source = filepath.Join("inbox", rel)
+	}
+
+	log.Printf("Ingesting %s from inbox as %s", source, filename)
+	if _, err := ingestMediaFile(filename, IngestOptions{Source: source}); err != nil {
+		rejectInboxFile(destination, err.Error())
+		return
+	}
+}
+
+// Subtitles wait in the inbox for their recording, which takes them along
+// when it is ingested. Subtitles for a recording that is already in the
+// library are moved next to it and imported; those for no recording in
+// either place are rejected.
+func ingestInboxSubtitle(path string) {
+	name := filepath.Base(path)
+	base, _ := splitSubtitleName(name)
+
+	files, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		recording := partialDownloadName(file.Name())
+		mediaType := detectMediaType(recording)
+		if mediaBase(recording) == base && (mediaType == "audio" || mediaType == "video") {
+			return // The recording is still in the inbox, or still arriving
+		}
+	}
+
+	if !libraryHasRecording(base) {
+		rejectInboxFile(path, fmt.Sprintf("no recording named %s in the inbox or the library", base))
+		return
+	}
+	destination := filepath.Join(mediaDir, name)
+	if _, err := os.Stat(destination); err == nil {
+		rejectInboxFile(path, fmt.Sprintf("subtitles %s already in library", name))
+		return
+	}
+	if err := moveFile(path, destination); err != nil {
+		rejectInboxFile(path, err.Error())
+		return
+	}
+
+	attached, err := attachSidecarSubtitle(name)
+	if err != nil {
+		log.Printf("Failed to import subtitles %s from inbox: %v", name, err)
+		return
+	}
+	if len(attached) == 0 {
+		log.Printf("Moved subtitles %s from inbox; no recording to attach them to yet", name)
+	}
+}
+
+// Whether the library has an ingested audio or video file with a basename
+func libraryHasRecording(base string) bool {
+	files, err := os.ReadDir(mediaDir)
+	if err != nil {
+		return false
+	}
+	for _, file := range files {
+		mediaType := detectMediaType(file.Name())
+		if mediaBase(file.Name()) != base || (mediaType != "audio" && mediaType != "video") {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(metadataDir, file.Name()+mdExt)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Move a file, falling back to copy and delete when the inbox is on another filesystem
+func moveFile(source, destination string) error {
+	if err := os.Rename(source, destination); err == nil {
+		return nil
+	}
+
+	if err := copyFile(source, destination); err != nil {
+		return err
+	}
+	if err := os.Remove(source); err != nil {
+		log.Printf("Warning: Failed to remove %s after copying it: %v", source, err)
+	}
+	return nil
+}
+
+// Move a file into the rejected folder and write a reason file next to it
+func rejectInboxFile(path, reason string) {
+	name := filepath.Base(path)
+	log.Printf("Rejecting inbox file %s: %s", name, reason)
+
+	// Don't overwrite an earlier rejection with the same name
+	destination := filepath.Join(inboxRejectedDir, name)
+	ext := filepath.Ext(name)
+	for i := 1; ; i++ {
+		if _, err := os.Stat(destination); os.IsNotExist(err) {
+			break
+		}
+		destination = filepath.Join(inboxRejectedDir, fmt.Sprintf("%s-%d%s", strings.TrimSuffix(name, ext), i, ext))
+	}
+
+	if err := moveFile(path, destination); err != nil {
+		log.Printf("Failed to move %s to rejected folder: %v", name, err)
+		return
+	}
+
+	content := fmt.Sprintf("File: %s\nRejected: %s\nReason: %s\n", name, time.Now().Format(time.RFC3339), reason)
+	if err := os.WriteFile(destination+".reason.txt", []byte(content), 0644); err != nil {
+		log.Printf("Failed to write reason file for %s: %v", name, err)
+	}
+}
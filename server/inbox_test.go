@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Scan the inbox twice, the second time once every file has been stable
+// long enough to be ingested
+func scanInboxSettled() {
+	seen := make(map[string]*inboxFileState)
+	later := time.Now().Add(time.Minute)
+	scanInbox(seen, later)
+	scanInbox(seen, later.Add(inboxStableAfter))
+}
+
+func TestInboxSubtitlesWithoutRecording(t *testing.T) {
+	setupTranscriptionTest(t)
+	if err := os.MkdirAll(inboxRejectedDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	subtitles := "1\n00:00:00,000 --> 00:00:02,000\nHello\n"
+	for _, name := range []string{"orphan.srt", "arriving.srt", "~syncthing~arriving.mp4.tmp"} {
+		if err := os.WriteFile(filepath.Join(inboxDir, name), []byte(subtitles), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	scanInboxSettled()
+
+	// Subtitles for a recording that is still being synced wait for it
+	if _, err := os.Stat(filepath.Join(inboxDir, "arriving.srt")); err != nil {
+		t.Errorf("subtitles of an arriving recording left the inbox: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(inboxDir, "orphan.srt")); !os.IsNotExist(err) {
+		t.Errorf("subtitles without a recording are still in the inbox: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(inboxRejectedDir, "orphan.srt.reason.txt")); err != nil {
+		t.Errorf("subtitles without a recording weren't rejected: %v", err)
+	}
+}
+
+func TestPartialDownloadName(t *testing.T) {
+	tests := map[string]string{
+		"clip.mp4":                 "clip.mp4",
+		"~syncthing~clip.mp4.tmp":  "clip.mp4",
+		".~syncthing~clip.mp4.tmp": "clip.mp4",
+		"clip.mp4.part":            "clip.mp4",
+		"clip.MP4.crdownload":      "clip.MP4",
+		".hidden.mp4":              "hidden.mp4",
+	}
+	for name, want := range tests {
+		if got := partialDownloadName(name); got != want {
+			t.Errorf("partialDownloadName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	jobsDir = "./data/jobs"
+
+	// Present while the transcription queue is paused
+	queuePausedFile = "./data/jobs/paused"
+)
+
+func jobFilePath(filename string) string {
+	return filepath.Join(jobsDir, filename+".json")
+}
+
+// Write a job file atomically so a crash never leaves a truncated job behind
+func saveJobFile(job *TranscriptionJob) error {
+	data, err := json.MarshalIndent(job, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal job: %v", err)
+	}
+
+	path := jobFilePath(job.Filename)
+	tempPath := path + ".tmp"
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write job file: %v", err)
+	}
+	if err := os.Rename(tempPath, path); err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed to replace job file: %v", err)
+	}
+
+	return nil
+}
+
+// Read every persisted job
+func loadJobFiles() ([]*TranscriptionJob, error) {
+	files, err := os.ReadDir(jobsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []*TranscriptionJob
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(jobsDir, file.Name()))
+		if err != nil {
+			log.Printf("Failed to read job file %s: %v", file.Name(), err)
+			continue
+		}
+
+		var job TranscriptionJob
+		if err := json.Unmarshal(data, &job); err != nil {
+			log.Printf("Failed to parse job file %s: %v", file.Name(), err)
+			continue
+		}
+		if job.Filename == "" {
+			job.Filename = strings.TrimSuffix(file.Name(), ".json")
+		}
+		jobs = append(jobs, &job)
+	}
+
+	return jobs, nil
+}
+
+// Whether the queue was paused when the server last ran
+func loadQueuePaused() bool {
+	_, err := os.Stat(queuePausedFile)
+	return err == nil
+}
+
+// Persist the queue's pause state
+func saveQueuePaused(paused bool) error {
+	if !paused {
+		if err := os.Remove(queuePausedFile); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return os.WriteFile(queuePausedFile, nil, 0644)
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Labels can be added automatically by rules in data/label-rules.json. A rule
+// adds its labels to every item that meets all of its conditions. Rules only
+// ever add labels, so labels set by hand are kept.
+const labelRulesFile = "./data/label-rules.json"
+
+// LabelRule adds labels to items that match every condition it sets
+type LabelRule struct {
+	Name   string   `json:"name"`
+	Labels []string `json:"labels"`
+
+	Keywords    []string `json:"keywords,omitempty"`    // Any of these words or phrases in the transcript, ignoring case
+	Pattern     string   `json:"pattern,omitempty"`     // Regular expression matched against the transcript
+	CameraModel string   `json:"cameraModel,omitempty"` // Text in the EXIF camera model, ignoring case
+	Types       []string `json:"types,omitempty"`       // Media types: photo, audio or video
+	Filename    string   `json:"filename,omitempty"`    // Glob pattern matched against the filename, ignoring case
+	TimeOfDay   string   `json:"timeOfDay,omitempty"`   // Range of the item's local time, e.g. "06:00-12:00" or "22:00-04:00"
+
+	keywords   []*regexp.Regexp
+	pattern    *regexp.Regexp
+	startOfDay time.Duration
+	endOfDay   time.Duration
+}
+
+// LabelRulesFile is the content of data/label-rules.json
+type LabelRulesFile struct {
+	Rules []LabelRule `json:"rules"`
+}
+
+// ApplyLabelRulesRequest represents the request body for re-applying rules to the library
+type ApplyLabelRulesRequest struct {
+	DryRun bool `json:"dryRun"` // Report the labels that would be added without changing anything
+}
+
+// LabelRuleChange lists the labels rules add to one item
+type LabelRuleChange struct {
+	ID       string   `json:"id"`
+	Filename string   `json:"filename"`
+	Added    []string `json:"added"`
+	Rules    []string `json:"rules"`
+}
+
+// ApplyLabelRulesResponse reports what re-applying rules changed, or would change
+type ApplyLabelRulesResponse struct {
+	DryRun  bool              `json:"dryRun"`
+	Checked int               `json:"checked"`
+	Changed int               `json:"changed"`
+	Changes []LabelRuleChange `json:"changes"`
+}
+
+// Rules are read again whenever the file changes
+var labelRules struct {
+	mu      sync.Mutex
+	modTime time.Time
+	rules   []LabelRule
+}
+
+// Current rules, reloaded if the rules file changed. A missing file means no rules.
+func loadLabelRules() ([]LabelRule, error) {
+	labelRules.mu.Lock()
+	defer labelRules.mu.Unlock()
+
+	info, err := os.Stat(labelRulesFile)
+	if os.IsNotExist(err) {
+		labelRules.rules = nil
+		labelRules.modTime = time.Time{}
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if info.ModTime().Equal(labelRules.modTime) {
+		return labelRules.rules, nil
+	}
+
+	data, err := os.ReadFile(labelRulesFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read label rules: %v", err)
+	}
+	var file LabelRulesFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse label rules: %v", err)
+	}
+	for i := range file.Rules {
+		if err := compileLabelRule(&file.Rules[i]); err != nil {
+			return nil, fmt.Errorf("label rule %d: %v", i+1, err)
+		}
+	}
+
+	labelRules.rules = file.Rules
+	labelRules.modTime = info.ModTime()
+	log.Printf("Loaded %d label rules", len(file.Rules))
+	return file.Rules, nil
+}
+
+// Check a rule and prepare its patterns
+func compileLabelRule(rule *LabelRule) error {
+	if rule.Name == "" {
+		rule.Name = strings.Join(rule.Labels, ", ")
+	}
+	if len(rule.Labels) == 0 {
+		return fmt.Errorf("no labels to add")
+	}
+	if len(rule.Keywords) == 0 && rule.Pattern == "" && rule.CameraModel == "" &&
+		len(rule.Types) == 0 && rule.Filename == "" && rule.TimeOfDay == "" {
+		return fmt.Errorf("no conditions")
+	}
+
+	for _, keyword := range rule.Keywords {
+		keyword = strings.TrimSpace(keyword)
+		if keyword == "" {
+			continue
+		}
+		rule.keywords = append(rule.keywords, regexp.MustCompile(`(?i)\b`+regexp.QuoteMeta(keyword)+`\b`))
+	}
+	if rule.Pattern != "" {
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid pattern: %v", err)
+		}
+		rule.pattern = pattern
+	}
+	for _, mediaType := range rule.Types {
+		if mediaType != "photo" && mediaType != "audio" && mediaType != "video" {
+			return fmt.Errorf("unknown media type: %s", mediaType)
+		}
+	}
+	if rule.Filename != "" {
+		if _, err := filepath.Match(rule.Filename, ""); err != nil {
+			return fmt.Errorf("invalid filename pattern: %v", err)
+		}
+	}
+	if rule.TimeOfDay != "" {
+		start, end, ok := strings.Cut(rule.TimeOfDay, "-")
+		var err error
+		if ok {
+			if rule.startOfDay, err = parseClockTime(start); err == nil {
+				rule.endOfDay, err = parseClockTime(end)
+			}
+		}
+		if !ok || err != nil {
+			return fmt.Errorf("invalid time of day %q, expected HH:MM-HH:MM", rule.TimeOfDay)
+		}
+		if rule.startOfDay == rule.endOfDay {
+			return fmt.Errorf("time of day %q is an empty range; leave it out to match the whole day", rule.TimeOfDay)
+		}
+	}
+	return nil
+}
+
+// Parse a time of day such as "06:30" into the time since midnight
+func parseClockTime(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Whether an item meets every condition of a rule
+func (rule *LabelRule) matches(metadata MediaMetadata, transcript string) bool {
+	if len(rule.keywords) > 0 {
+		found := false
+		for _, keyword := range rule.keywords {
+			if keyword.MatchString(transcript) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if rule.pattern != nil && !rule.pattern.MatchString(transcript) {
+		return false
+	}
+	if rule.CameraModel != "" && !strings.Contains(strings.ToLower(metadata.CameraModel), strings.ToLower(rule.CameraModel)) {
+		return false
+	}
+	if len(rule.Types) > 0 && !containsString(rule.Types, metadata.Type) {
+		return false
+	}
+	if rule.Filename != "" {
+		if matched, _ := filepath.Match(strings.ToLower(rule.Filename), strings.ToLower(metadata.Filename)); !matched {
+			return false
+		}
+	}
+	if rule.TimeOfDay != "" {
+		// The clock time the item was recorded at, in the timestamp's own zone
+		timestamp, err := time.Parse(time.RFC3339, metadata.Timestamp)
+		if err != nil {
+			return false
+		}
+		clock := time.Duration(timestamp.Hour())*time.Hour + time.Duration(timestamp.Minute())*time.Minute
+		if rule.startOfDay <= rule.endOfDay {
+			if clock < rule.startOfDay || clock >= rule.endOfDay {
+				return false
+			}
+		} else if clock < rule.startOfDay && clock >= rule.endOfDay {
+			return false // Range wraps past midnight
+		}
+	}
+	return true
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Labels the rules add to an item that it doesn't have yet, and the rules that added them
+func matchLabelRules(rules []LabelRule, metadata MediaMetadata) ([]string, []string) {
+	transcript := transcriptText(metadata.Transcripts)
+	if len(metadata.Transcripts) == 0 {
+		transcript = metadata.Transcription
+	}
+
+	have := make(map[string]bool)
+	for _, label := range metadata.Labels {
+		have[label] = true
+	}
+
+	var added, matched []string
+	for i := range rules {
+		if !rules[i].matches(metadata, transcript) {
+			continue
+		}
+		matched = append(matched, rules[i].Name)
+		for _, label := range rules[i].Labels {
+			if !have[label] {
+				have[label] = true
+				added = append(added, label)
+			}
+		}
+	}
+	return added, matched
+}
+
+// Add the labels of every matching rule to an item. Returns the labels added.
+// Problems with the rules file are logged and leave the labels as they are.
+func applyLabelRules(metadata *MediaMetadata) []string {
+	rules, err := loadLabelRules()
+	if err != nil {
+		log.Printf("Not applying label rules: %v", err)
+		return nil
+	}
+	added, _ := matchLabelRules(rules, *metadata)
+	if len(added) > 0 {
+		metadata.Labels = append(metadata.Labels, added...)
+		log.Printf("Label rules added %s to %s", strings.Join(added, ", "), metadata.Filename)
+	}
+	return added
+}
+
+// Handler for listing the label rules
+func handleLabelRules(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rules, err := loadLabelRules()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if rules == nil {
+		rules = []LabelRule{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(LabelRulesFile{Rules: rules})
+}
+
+// Handler for re-applying the label rules to every item in the library
+func handleApplyLabelRules(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ApplyLabelRulesRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	rules, err := loadLabelRules()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	allMetadata, err := readAllMetadata()
+	if err != nil {
+		http.Error(w, "Failed to read metadata", http.StatusInternalServerError)
+		return
+	}
+
+	response := ApplyLabelRulesResponse{DryRun: req.DryRun, Checked: len(allMetadata), Changes: []LabelRuleChange{}}
+	for _, metadata := range allMetadata {
+		var added, matched []string
+		if req.DryRun {
+			added, matched = matchLabelRules(rules, metadata)
+		} else {
+			// Match and label the file as it is now, not as it was listed
+			_, _, err := updateMetadata(metadata.Filename, func(current *MediaMetadata) bool {
+				added, matched = matchLabelRules(rules, *current)
+				current.Labels = append(current.Labels, added...)
+				return len(added) > 0
+			})
+			if err != nil {
+				log.Printf("Failed to add rule labels to %s: %v", metadata.Filename, err)
+				continue
+			}
+		}
+		if len(added) == 0 {
+			continue
+		}
+		response.Changes = append(response.Changes, LabelRuleChange{
+			ID:       metadata.ID,
+			Filename: metadata.Filename,
+			Added:    added,
+			Rules:    matched,
+		})
+	}
+	response.Changed = len(response.Changes)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLabelRuleMatches(t *testing.T) {
+	item := MediaMetadata{
+		Filename:    "IMG_0042.JPG",
+		Type:        "photo",
+		CameraModel: "Canon EOS 5D",
+		Timestamp:   "2024-06-01T23:30:00+02:00",
+	}
+	tests := []struct {
+		name       string
+		rule       LabelRule
+		transcript string
+		want       bool
+	}{
+		{"keyword", LabelRule{Keywords: []string{"birthday cake"}}, "Happy Birthday Cake time", true},
+		{"keyword inside a word", LabelRule{Keywords: []string{"cake"}}, "cupcakes", false},
+		{"any keyword", LabelRule{Keywords: []string{"wedding", " ", "cake"}}, "more cake", true},
+		{"pattern", LabelRule{Pattern: `\d{3}`}, "room 101", true},
+		{"pattern missing", LabelRule{Pattern: `\d{3}`}, "room one", false},
+		{"camera", LabelRule{CameraModel: "eos"}, "", true},
+		{"other camera", LabelRule{CameraModel: "iphone"}, "", false},
+		{"type", LabelRule{Types: []string{"audio", "photo"}}, "", true},
+		{"other type", LabelRule{Types: []string{"video"}}, "", false},
+		{"filename", LabelRule{Filename: "img_*.jpg"}, "", true},
+		{"other filename", LabelRule{Filename: "*.png"}, "", false},
+		{"all conditions", LabelRule{Types: []string{"photo"}, Keywords: []string{"cake"}}, "no", false},
+		// Times are the item's own clock time, 23:30
+		{"time of day", LabelRule{TimeOfDay: "23:00-23:45"}, "", true},
+		{"before the range", LabelRule{TimeOfDay: "06:00-12:00"}, "", false},
+		{"range ends at the time", LabelRule{TimeOfDay: "22:00-23:30"}, "", false},
+		{"range starts at the time", LabelRule{TimeOfDay: "23:30-23:31"}, "", true},
+		{"range past midnight", LabelRule{TimeOfDay: "22:00-04:00"}, "", true},
+		{"range past midnight, after it", LabelRule{TimeOfDay: "23:45-04:00"}, "", false},
+	}
+	for _, test := range tests {
+		test.rule.Labels = []string{"label"}
+		if err := compileLabelRule(&test.rule); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if got := test.rule.matches(item, test.transcript); got != test.want {
+			t.Errorf("%s: matches = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestLabelRuleTimeOfDayPastMidnight(t *testing.T) {
+	rule := LabelRule{Labels: []string{"night"}, TimeOfDay: "22:00-04:00"}
+	if err := compileLabelRule(&rule); err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]bool{
+		"2024-06-01T21:59:00Z": false,
+		"2024-06-01T22:00:00Z": true,
+		"2024-06-01T00:00:00Z": true,
+		"2024-06-01T03:59:00Z": true,
+		"2024-06-01T04:00:00Z": false,
+		"2024-06-01T12:00:00Z": false,
+		"not a time":           false,
+	}
+	for timestamp, want := range tests {
+		if got := rule.matches(MediaMetadata{Timestamp: timestamp}, ""); got != want {
+			t.Errorf("matches at %s = %v, want %v", timestamp, got, want)
+		}
+	}
+}
+
+func TestCompileLabelRuleErrors(t *testing.T) {
+	tests := map[string]LabelRule{
+		"no labels":          {Types: []string{"photo"}},
+		"no conditions":      {Labels: []string{"a"}},
+		"bad pattern":        {Labels: []string{"a"}, Pattern: "("},
+		"unknown type":       {Labels: []string{"a"}, Types: []string{"document"}},
+		"bad filename":       {Labels: []string{"a"}, Filename: "["},
+		"bad time of day":    {Labels: []string{"a"}, TimeOfDay: "06:00"},
+		"time out of range":  {Labels: []string{"a"}, TimeOfDay: "06:00-25:00"},
+		"empty time of day":  {Labels: []string{"a"}, TimeOfDay: "00:00-00:00"},
+		"empty time, spaced": {Labels: []string{"a"}, TimeOfDay: "08:15 - 08:15"},
+	}
+	for name, rule := range tests {
+		if err := compileLabelRule(&rule); err == nil {
+			t.Errorf("%s: compiled %+v", name, rule)
+		}
+	}
+}
+
+// Write the label rules file, and forget the rules loaded before
+func writeLabelRules(t *testing.T, content string) {
+	t.Helper()
+	if err := os.WriteFile(labelRulesFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	labelRules.mu.Lock()
+	labelRules.modTime = time.Time{}
+	labelRules.mu.Unlock()
+}
+
+func TestApplyLabelRules(t *testing.T) {
+	setupTranscriptionTest(t)
+	writeLabelRules(t, `{"rules": [{"name": "cakes", "labels": ["party"], "keywords": ["cake"]}]}`)
+	t.Cleanup(func() { writeLabelRules(t, `{"rules": []}`) })
+
+	for filename, text := range map[string]string{"a.mp3": "Cake!", "b.mp3": "Nothing here."} {
+		err := writeMetadataFile(filepath.Join(metadataDir, filename+mdExt), MediaMetadata{
+			ID:          filename,
+			Filename:    filename,
+			Type:        "audio",
+			Labels:      []string{"family"},
+			Transcripts: []TranscriptEntry{{Start: 0, End: 2, Text: text}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	apply := func(body string) string {
+		w := httptest.NewRecorder()
+		handleApplyLabelRules(w, httptest.NewRequest(http.MethodPost, "/api/label-rules/apply", strings.NewReader(body)))
+		if w.Code != http.StatusOK {
+			t.Fatalf("apply: %d %s", w.Code, w.Body)
+		}
+		return w.Body.String()
+	}
+	labels := func(filename string) []string {
+		var metadata MediaMetadata
+		if _, err := readMarkdownFile(filepath.Join(metadataDir, filename+mdExt), &metadata); err != nil {
+			t.Fatal(err)
+		}
+		return metadata.Labels
+	}
+
+	if body := apply(`{"dryRun": true}`); !strings.Contains(body, `"changed":1`) {
+		t.Errorf("dry run: %s", body)
+	}
+	if got := labels("a.mp3"); !reflect.DeepEqual(got, []string{"family"}) {
+		t.Errorf("dry run labelled a.mp3 %v", got)
+	}
+
+	if body := apply(`{}`); !strings.Contains(body, `"changed":1`) || !strings.Contains(body, `"added":["party"]`) {
+		t.Errorf("apply: %s", body)
+	}
+	if got := labels("a.mp3"); !reflect.DeepEqual(got, []string{"family", "party"}) {
+		t.Errorf("a.mp3 labelled %v", got)
+	}
+	if got := labels("b.mp3"); !reflect.DeepEqual(got, []string{"family"}) {
+		t.Errorf("b.mp3 labelled %v", got)
+	}
+
+	// Applying again changes nothing
+	if body := apply(`{}`); !strings.Contains(body, `"changed":0`) {
+		t.Errorf("second apply: %s", body)
+	}
+}
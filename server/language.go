@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Languages are stored as ISO 639-1 codes. An item's language can also be
+// "auto" to have it detected whatever the library default is, or empty to
+// use the library default.
+const autoLanguage = "auto"
+
+// Library default when none is configured. English runs the configured
+// English model, so detection, which needs the multilingual one, is opt-in.
+const defaultLanguage = "en"
+
+// Names some engines report instead of codes (OpenAI-compatible servers
+// return e.g. "german"), and that are accepted when setting a language
+var languageNames = map[string]string{
+	"arabic":     "ar",
+	"chinese":    "zh",
+	"czech":      "cs",
+	"danish":     "da",
+	"dutch":      "nl",
+	"english":    "en",
+	"finnish":    "fi",
+	"french":     "fr",
+	"german":     "de",
+	"greek":      "el",
+	"hindi":      "hi",
+	"hungarian":  "hu",
+	"italian":    "it",
+	"japanese":   "ja",
+	"korean":     "ko",
+	"norwegian":  "no",
+	"polish":     "pl",
+	"portuguese": "pt",
+	"romanian":   "ro",
+	"russian":    "ru",
+	"spanish":    "es",
+	"swedish":    "sv",
+	"turkish":    "tr",
+	"ukrainian":  "uk",
+}
+
+var languageCodePattern = regexp.MustCompile(`^[a-z]{2,3}$`)
+
+// SetLanguageRequest represents the request body for changing an item's language
+type SetLanguageRequest struct {
+	Language     string `json:"language"`     // Language code or name, "auto" to detect it, or empty for the library default
+	Retranscribe bool   `json:"retranscribe"` // Transcribe the item again in the new language
+}
+
+// Convert a language code or name to a code. Empty and "auto" are kept.
+func normalizeLanguage(value string) (string, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" || value == autoLanguage {
+		return value, nil
+	}
+	if code, ok := languageNames[value]; ok {
+		return code, nil
+	}
+	if !languageCodePattern.MatchString(value) {
+		return "", fmt.Errorf("unknown language: %s", value)
+	}
+	return value, nil
+}
+
+// Language to transcribe an item in: its own, else the library default,
+// else English. Empty means the engine should detect it.
+func transcriptionLanguage(metadata MediaMetadata) string {
+	language := metadata.Language
+	if language == "" {
+		var err error
+		language, err = normalizeLanguage(AppConfig.Transcription.Language)
+		if err != nil {
+			log.Printf("Ignoring default transcription language: %v", err)
+			language = ""
+		}
+		if language == "" {
+			language = defaultLanguage
+		}
+	}
+	if language == autoLanguage {
+		return ""
+	}
+	return language
+}
+
+// Whether a language was chosen for an item rather than left to detection
+func languageChosen(metadata MediaMetadata) bool {
+	return metadata.Language != "" && metadata.Language != autoLanguage
+}
+
+// Language an item is in, as chosen or detected
+func itemLanguage(metadata MediaMetadata) string {
+	if languageChosen(metadata) {
+		return metadata.Language
+	}
+	return metadata.DetectedLanguage
+}
+
+// Model to run for a language. English-only models (base-en, base.en) can't
+// transcribe or detect other languages, so anything but English uses the
+// multilingual model: the configured one, or the English model without its
+// English suffix.
+func modelForLanguage(model, multilingual, language string) string {
+	if language == "en" {
+		return model
+	}
+	if multilingual != "" {
+		return multilingual
+	}
+	for _, suffix := range []string{"-en", ".en"} {
+		if strings.HasSuffix(model, suffix) {
+			return strings.TrimSuffix(model, suffix)
+		}
+	}
+	return model
+}
+
+// Find the language an engine reports detecting in its log output. The
+// pattern's first group is the language and the second its probability.
+func parseDetectedLanguage(output string, pattern *regexp.Regexp) (string, float64) {
+	match := pattern.FindStringSubmatch(output)
+	if match == nil {
+		return "", 0
+	}
+	language, _ := normalizeLanguage(match[1])
+	probability, _ := strconv.ParseFloat(match[2], 64)
+	return language, probability
+}
+
+// Handler for changing an item's language, optionally transcribing it again
+func handleSetLanguage(w http.ResponseWriter, r *http.Request, metadata MediaMetadata) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req SetLanguageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	language, err := normalizeLanguage(req.Language)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Retranscribe && metadata.Type != "audio" && metadata.Type != "video" {
+		http.Error(w, "Only audio and video can be transcribed", http.StatusBadRequest)
+		return
+	}
+
+	// Set it on the file as it is now, not as it was read for the request
+	updated, _, err := updateMetadata(metadata.Filename, func(metadata *MediaMetadata) bool {
+		metadata.Language = language
+		return true
+	})
+	if err != nil {
+		log.Printf("Failed to set language of %s: %v", metadata.Filename, err)
+		http.Error(w, "Failed to update metadata", http.StatusInternalServerError)
+		return
+	}
+	metadata = updated
+
+	if req.Retranscribe {
+		if err := TQueue.Retranscribe(metadata.Filename, ""); err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(metadata)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTranscriptionLanguage(t *testing.T) {
+	config := AppConfig
+	t.Cleanup(func() { AppConfig = config })
+
+	tests := []struct {
+		library, item, want string
+	}{
+		{"", "", "en"},
+		{"", "de", "de"},
+		{"", "auto", ""},
+		{"auto", "", ""},
+		{"auto", "fr", "fr"},
+		{"German", "", "de"},
+		{"not a language", "", "en"},
+	}
+	for _, test := range tests {
+		AppConfig = defaultConfig()
+		AppConfig.Transcription.Language = test.library
+		got := transcriptionLanguage(MediaMetadata{Language: test.item})
+		if got != test.want {
+			t.Errorf("library %q, item %q: got %q, want %q", test.library, test.item, got, test.want)
+		}
+	}
+}
+
+func TestModelForLanguage(t *testing.T) {
+	tests := []struct {
+		model, multilingual, language, want string
+	}{
+		{"base-en", "", "en", "base-en"},
+		{"base-en", "", "de", "base"},
+		{"base-en", "", "", "base"},
+		{"base.en", "", "", "base"},
+		{"base-en", "large-v3", "de", "large-v3"},
+		{"large-v3", "", "de", "large-v3"},
+	}
+	for _, test := range tests {
+		if got := modelForLanguage(test.model, test.multilingual, test.language); got != test.want {
+			t.Errorf("modelForLanguage(%q, %q, %q) = %q, want %q", test.model, test.multilingual, test.language, got, test.want)
+		}
+	}
+}
+
+func TestSetLanguageKeepsConcurrentChanges(t *testing.T) {
+	setupTranscriptionTest(t)
+	path := filepath.Join(metadataDir, "talk.mp3"+mdExt)
+	metadata := MediaMetadata{ID: "1", Filename: "talk.mp3", Type: "audio", Labels: []string{}}
+	if err := writeMetadataFile(path, metadata); err != nil {
+		t.Fatal(err)
+	}
+
+	// A summary is written after the request read the item
+	summarized := metadata
+	summarized.Summary = "Plans for the kitchen."
+	if err := writeMetadataFile(path, summarized); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	handleSetLanguage(w, httptest.NewRequest(http.MethodPost, "/api/media/1/language", strings.NewReader(`{"language": "de"}`)), metadata)
+	if w.Code != http.StatusOK {
+		t.Fatalf("set language: %d %s", w.Code, w.Body)
+	}
+
+	var written MediaMetadata
+	if _, err := readMarkdownFile(path, &written); err != nil {
+		t.Fatal(err)
+	}
+	if written.Language != "de" || written.Summary != "Plans for the kitchen." {
+		t.Errorf("file has language %q and summary %q", written.Language, written.Summary)
+	}
+}
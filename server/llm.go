@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ChatMessage is one message of a chat completion request
+type ChatMessage struct {
+	Role    string `json:"role"` // "system", "user" or "assistant"
+	Content string `json:"content"`
+}
+
+// chatClient sends prompts to an OpenAI-compatible /v1/chat/completions
+// endpoint, such as a local llama.cpp, Ollama or vLLM server
+type chatClient struct {
+	endpoint    string
+	apiKey      string
+	model       string
+	temperature float64
+	timeout     time.Duration
+}
+
+func newChatClient(config LLMConfig) *chatClient {
+	return &chatClient{
+		endpoint:    config.Endpoint,
+		apiKey:      config.APIKey,
+		model:       config.Model,
+		temperature: config.Temperature,
+		timeout:     time.Duration(config.TimeoutSeconds) * time.Second,
+	}
+}
+
+// Send a conversation and return the text of the model's reply
+func (c *chatClient) Complete(ctx context.Context, messages []ChatMessage) (string, error) {
+	payload, err := json.Marshal(struct {
+		Model       string        `json:"model"`
+		Messages    []ChatMessage `json:"messages"`
+		Temperature float64       `json:"temperature"`
+		Stream      bool          `json:"stream"`
+	}{c.model, messages, c.temperature, false})
+	if err != nil {
+		return "", fmt.Errorf("failed to encode chat request: %v", err)
+	}
+
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	url := strings.TrimSuffix(c.endpoint, "/") + "/v1/chat/completions"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("chat request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read chat response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("chat endpoint returned %s: %s", resp.Status, string(bytes.TrimSpace(data)))
+	}
+
+	var completion struct {
+		Choices []struct {
+			Message ChatMessage `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.Unmarshal(data, &completion); err != nil {
+		return "", fmt.Errorf("failed to parse chat response: %v", err)
+	}
+	if len(completion.Choices) == 0 {
+		return "", fmt.Errorf("chat endpoint returned no choices")
+	}
+	return strings.TrimSpace(completion.Choices[0].Message.Content), nil
+}
+
+// Cut the JSON object out of a model's reply, which may wrap it in a code
+// fence or explanations
+func extractJSONObject(reply string) (string, error) {
+	start := strings.Index(reply, "{")
+	end := strings.LastIndex(reply, "}")
+	if start < 0 || end < start {
+		return "", fmt.Errorf("reply contains no JSON object: %q", reply)
+	}
+	return reply[start : end+1], nil
+}
+
+// embeddingClient gets embeddings from an OpenAI-compatible /v1/embeddings
+// endpoint
+type embeddingClient struct {
+	endpoint string
+	apiKey   string
+	model    string
+	timeout  time.Duration
+}
+
+func (c *embeddingClient) Name() string  { return "openai" }
+func (c *embeddingClient) Model() string { return c.model }
+
+func newEmbeddingClient(config LLMConfig) *embeddingClient {
+	return &embeddingClient{
+		endpoint: config.Endpoint,
+		apiKey:   config.APIKey,
+		model:    config.EmbeddingModel,
+		timeout:  time.Duration(config.TimeoutSeconds) * time.Second,
+	}
+}
+
+// Embed texts, returning one vector per text in the same order
+func (c *embeddingClient) Embed(ctx context.Context, texts []string) ([][]float64, error) {
+	payload, err := json.Marshal(struct {
+		Model string   `json:"model"`
+		Input []string `json:"input"`
+	}{c.model, texts})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode embedding request: %v", err)
+	}
+
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	url := strings.TrimSuffix(c.endpoint, "/") + "/v1/embeddings"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("embedding request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read embedding response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("embedding endpoint returned %s: %s", resp.Status, string(bytes.TrimSpace(data)))
+	}
+
+	var parsed struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float64 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse embedding response: %v", err)
+	}
+	if len(parsed.Data) != len(texts) {
+		return nil, fmt.Errorf("embedding endpoint returned %d vectors for %d texts", len(parsed.Data), len(texts))
+	}
+	vectors := make([][]float64, len(texts))
+	for _, item := range parsed.Data {
+		if item.Index < 0 || item.Index >= len(texts) {
+			return nil, fmt.Errorf("embedding endpoint returned index %d out of range", item.Index)
+		}
+		vectors[item.Index] = item.Embedding
+	}
+	return vectors, nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// chatRequest is what the chat client sent to the test server
+type chatRequest struct {
+	Model       string        `json:"model"`
+	Messages    []ChatMessage `json:"messages"`
+	Temperature float64       `json:"temperature"`
+	Stream      bool          `json:"stream"`
+	auth        string
+}
+
+// Start an OpenAI-compatible chat server that answers each request with the
+// content reply returns, and records the requests
+func newChatServer(t *testing.T, reply func(req chatRequest) string) (*httptest.Server, *[]chatRequest) {
+	t.Helper()
+	var mu sync.Mutex
+	var requests []chatRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/chat/completions" {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+		var req chatRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		req.auth = r.Header.Get("Authorization")
+		mu.Lock()
+		requests = append(requests, req)
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"choices": []map[string]interface{}{
+				{"message": ChatMessage{Role: "assistant", Content: reply(req)}},
+			},
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server, &requests
+}
+
+func TestChatClientComplete(t *testing.T) {
+	server, requests := newChatServer(t, func(req chatRequest) string {
+		return "  Hello from " + req.Model + "\n"
+	})
+	client := newChatClient(LLMConfig{Endpoint: server.URL + "/", APIKey: "secret", Model: "tiny", Temperature: 0.2})
+
+	reply, err := client.Complete(context.Background(), []ChatMessage{
+		{Role: "system", Content: "Be brief."},
+		{Role: "user", Content: "Hi"},
+	})
+	if err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	if reply != "Hello from tiny" {
+		t.Errorf("reply = %q", reply)
+	}
+
+	if len(*requests) != 1 {
+		t.Fatalf("%d requests, want 1", len(*requests))
+	}
+	req := (*requests)[0]
+	if req.Model != "tiny" || req.Temperature != 0.2 || req.Stream || req.auth != "Bearer secret" {
+		t.Errorf("unexpected request %+v", req)
+	}
+	if len(req.Messages) != 2 || req.Messages[0].Role != "system" || req.Messages[1].Content != "Hi" {
+		t.Errorf("unexpected messages %+v", req.Messages)
+	}
+}
+
+func TestChatClientErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    string
+	}{
+		{"status", func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "model not loaded", http.StatusServiceUnavailable)
+		}, "503 Service Unavailable: model not loaded"},
+		{"malformed", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "<html>not json</html>")
+		}, "failed to parse chat response"},
+		{"no choices", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, `{"choices": []}`)
+		}, "returned no choices"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := httptest.NewServer(test.handler)
+			defer server.Close()
+
+			_, err := newChatClient(LLMConfig{Endpoint: server.URL, Model: "tiny"}).Complete(context.Background(), nil)
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("error = %v, want one containing %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestChatClientTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client := newChatClient(LLMConfig{Endpoint: server.URL, Model: "tiny"})
+	client.timeout = 50 * time.Millisecond
+	if _, err := client.Complete(context.Background(), nil); err == nil {
+		t.Error("Complete returned no error after the timeout")
+	}
+}
+
+func TestExtractJSONObject(t *testing.T) {
+	tests := []struct {
+		reply, want string
+	}{
+		{`{"summary": "x"}`, `{"summary": "x"}`},
+		{"```json\n{\"summary\": \"x\"}\n```", `{"summary": "x"}`},
+		{`Sure! Here it is: {"a": {"b": 1}} Hope that helps.`, `{"a": {"b": 1}}`},
+	}
+	for _, test := range tests {
+		got, err := extractJSONObject(test.reply)
+		if err != nil || got != test.want {
+			t.Errorf("extractJSONObject(%q) = %q, %v; want %q", test.reply, got, err, test.want)
+		}
+	}
+
+	for _, reply := range []string{"", "no json here", "} backwards {"} {
+		if _, err := extractJSONObject(reply); err == nil {
+			t.Errorf("extractJSONObject(%q) returned no error", reply)
+		}
+	}
+}
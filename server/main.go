@@ -1,7 +1,7 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -9,11 +9,13 @@ import (
 	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
-
-	"github.com/adrg/frontmatter"
 )
 
 // TimelineItem represents a single item in the timeline
@@ -36,7 +38,30 @@ type MediaMetadata struct {
 	Duration      float64           `yaml:"duration,omitempty" json:"duration,omitempty"`
 	Transcription string            `json:"transcription"` // This will be stored in the Markdown body
 	Labels        []string          `yaml:"labels" json:"labels"`
+	PHash         string            `yaml:"phash,omitempty" json:"phash,omitempty"`
+	Source        string            `yaml:"source,omitempty" json:"source,omitempty"`
+	CameraModel   string            `yaml:"cameramodel,omitempty" json:"cameraModel,omitempty"`
 	Transcripts   []TranscriptEntry `yaml:"transcripts,omitempty" json:"transcripts,omitempty"`
+
+	// Engine and model that produced the transcript
+	TranscriptEngine string `yaml:"transcriptengine,omitempty" json:"transcriptEngine,omitempty"`
+	TranscriptModel  string `yaml:"transcriptmodel,omitempty" json:"transcriptModel,omitempty"`
+	// "partial" while a chunked transcription has only written some of the segments
+	TranscriptStatus string `yaml:"transcriptstatus,omitempty" json:"transcriptStatus,omitempty"`
+
+	// Names given to diarized speaker IDs, e.g. SPEAKER_00 -> Alice
+	Speakers map[string]string `yaml:"speakers,omitempty" json:"speakers,omitempty"`
+
+	// Language chosen for the item, or the one the engine detected and how sure it was
+	Language            string  `yaml:"language,omitempty" json:"language,omitempty"`
+	DetectedLanguage    string  `yaml:"detectedlanguage,omitempty" json:"detectedLanguage,omitempty"`
+	LanguageProbability float64 `yaml:"languageprobability,omitempty" json:"languageProbability,omitempty"`
+
+	// Summary and chapters written by the summarizer after transcription
+	Summary      string    `yaml:"summary,omitempty" json:"summary,omitempty"`
+	Chapters     []Chapter `yaml:"chapters,omitempty" json:"chapters,omitempty"`
+	SummaryModel string    `yaml:"summarymodel,omitempty" json:"summaryModel,omitempty"`
+	SummarizedAt string    `yaml:"summarizedat,omitempty" json:"summarizedAt,omitempty"`
 }
 
 // MediaItem represents a media item in the mock data
@@ -58,6 +83,20 @@ type TranscriptEntry struct {
 	Segment  int     `yaml:"segment" json:"segment"`
 	Speaker  string  `yaml:"speaker,omitempty" json:"speaker,omitempty"`
 	Metadata string  `yaml:"metadata,omitempty" json:"metadata,omitempty"`
+
+	// Word-level timings, kept in the transcript JSON but not in metadata files
+	Words []TranscriptWord `yaml:"-" json:"words,omitempty"`
+	// Engine's probability that the segment has no speech, if reported
+	NoSpeechProb *float64 `yaml:"-" json:"noSpeechProb,omitempty"`
+}
+
+// TranscriptWord is a single aligned word of a transcript segment
+type TranscriptWord struct {
+	Word    string   `json:"word"`
+	Start   float64  `json:"start"`
+	End     float64  `json:"end"`
+	Score   *float64 `json:"score,omitempty"` // Engine confidence from 0 to 1, if reported
+	Speaker string   `json:"speaker,omitempty"`
 }
 
 const (
@@ -76,16 +115,71 @@ func main() {
 	// Ensure data directories exist
 	ensureDirectories()
 
+	// Load settings from data/config.json
+	if err := loadConfig(); err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	// Run a subcommand instead of the server if one was given
+	if len(os.Args) > 1 && os.Args[1] == "import" {
+		runImportCommand(os.Args[2:])
+		return
+	}
+
 	// Initialize transcription system
 	InitTranscriptionSystem()
 
+	// Initialize resumable uploads
+	InitResumableUploads()
+
+	// Watch the inbox folder for synced files
+	InitInboxWatcher()
+
+	// Load the embedding index for semantic search, if enabled
+	InitEmbeddingIndex()
+
+	// Apply transcript edits made to metadata bodies outside the app, and
+	// embed bodies changed outside it
+	InitTranscriptBodyWatcher()
+
+	// Summarize new transcripts if summaries are enabled
+	InitSummarizer()
+
+	// Compute perceptual hashes for photos uploaded before hashing existed
+	go func() {
+		if _, err := backfillPerceptualHashes(); err != nil {
+			log.Printf("Perceptual hash backfill failed: %v", err)
+		}
+	}()
+
 	// API routes
 	http.HandleFunc("/api/timeline", handleTimeline)
 	http.HandleFunc("/api/upload", handleUpload)
+	http.HandleFunc("/api/uploads", handleCreateUpload)
+	http.HandleFunc("/api/uploads/", handleUploadSession)
+	http.HandleFunc("/api/import", handleImport)
 	http.HandleFunc("/api/metadata/", handleMetadata)
 	http.HandleFunc("/api/media", handleMedia)
+	http.HandleFunc("/api/media/", handleMediaItem)
+	http.HandleFunc("/api/transcripts/export", handleBulkTranscriptExport)
 	http.HandleFunc("/api/transcription/status", handleTranscriptionStatus)
+	http.HandleFunc("/api/transcription/retry-failed", handleRetryAllFailed)
+	http.HandleFunc("/api/transcription/retranscribe", handleBulkRetranscribe)
+	http.HandleFunc("/api/transcription/queue", handleTranscriptionQueue)
+	http.HandleFunc("/api/transcription/pause", handleTranscriptionQueue)
+	http.HandleFunc("/api/transcription/resume", handleTranscriptionQueue)
+	http.HandleFunc("/api/transcription/", handleTranscriptionJob)
 	http.HandleFunc("/api/labels/update", handleUpdateLabels)
+	http.HandleFunc("/api/labels/rules", handleLabelRules)
+	http.HandleFunc("/api/labels/rules/apply", handleApplyLabelRules)
+	http.HandleFunc("/api/speakers", handleSpeakers)
+	http.HandleFunc("/api/speakers/rename", handleRenameSpeaker)
+	http.HandleFunc("/api/search", handleSearch)
+	http.HandleFunc("/api/ask", handleAsk)
+	http.HandleFunc("/api/similar/", handleSimilar)
+	http.HandleFunc("/api/duplicates", handleDuplicates)
+	http.HandleFunc("/api/duplicates/resolve", handleResolveDuplicates)
+	http.HandleFunc("/api/phash/backfill", handlePHashBackfill)
 
 	// Serve media files
 	http.HandleFunc("/media/", handleMediaFiles)
@@ -95,12 +189,32 @@ func main() {
 
 	// Start server
 	port := 8080
-	fmt.Printf("Server starting on http://localhost:%d\n", port)
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", port), nil))
+	server := &http.Server{Addr: fmt.Sprintf(":%d", port)}
+	go func() {
+		fmt.Printf("Server starting on http://localhost:%d\n", port)
+		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	// Wait for a shutdown signal
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	<-ctx.Done()
+	stop()
+	log.Printf("Shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Error shutting down HTTP server: %v", err)
+	}
+
+	// Let running transcriptions finish, or checkpoint them for the next start
+	TQueue.Shutdown(transcriptionDrainTimeout)
 }
 
 func ensureDirectories() {
-	dirs := []string{dataDir, mediaDir, metadataDir, timelineDir}
+	dirs := []string{dataDir, mediaDir, metadataDir, timelineDir, trashDir}
 	for _, dir := range dirs {
 		if err := os.MkdirAll(dir, 0755); err != nil {
 			log.Fatalf("Failed to create directory %s: %v", dir, err)
@@ -108,99 +222,153 @@ func ensureDirectories() {
 	}
 }
 
-// Helper function to read a Markdown file with frontmatter
-func readMarkdownFile(filePath string, data interface{}) (string, error) {
-	// Read the file
-	content, err := os.ReadFile(filePath)
-	if err != nil {
-		return "", fmt.Errorf("failed to read file: %v", err)
-	}
+// Held while an item's metadata, transcript or revision files are read,
+// changed and rewritten, so concurrent changes to an item aren't lost
+var metadataMu sync.Mutex
 
-	// Parse frontmatter
-	body, err := frontmatter.Parse(bytes.NewReader(content), data)
+// Change an item's metadata: the file is read again under metadataMu, so
+// what other writers changed since the caller read it is kept, and written
+// back if change reports that it changed something
+func updateMetadata(filename string, change func(metadata *MediaMetadata) bool) (MediaMetadata, bool, error) {
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+
+	metadataPath := filepath.Join(metadataDir, filename+mdExt)
+	var metadata MediaMetadata
+	body, err := readMarkdownFile(metadataPath, &metadata)
 	if err != nil {
-		return "", fmt.Errorf("failed to parse frontmatter: %v", err)
+		return MediaMetadata{}, false, err
 	}
-
-	return string(body), nil
+	metadata.Transcription = body
+	if !change(&metadata) {
+		return metadata, false, nil
+	}
+	if err := writeMetadataFile(metadataPath, metadata); err != nil {
+		return MediaMetadata{}, false, err
+	}
+	return metadata, true, nil
 }
 
-// Helper function to write a Markdown file with frontmatter
-func writeMarkdownFile(filePath string, data interface{}, body string) error {
-	// Create a buffer to store the file content
-	var buf bytes.Buffer
+// Helper function to write a media metadata file, keeping every frontmatter field
+func writeMetadataFile(filePath string, metadata MediaMetadata) error {
+	// Create frontmatter data
+	frontmatterData := struct {
+		ID          string            `yaml:"id"`
+		Filename    string            `yaml:"filename"`
+		Path        string            `yaml:"path"`
+		Type        string            `yaml:"type"`
+		Timestamp   string            `yaml:"timestamp"`
+		Duration    float64           `yaml:"duration,omitempty"`
+		Labels      []string          `yaml:"labels"`
+		PHash       string            `yaml:"phash,omitempty"`
+		Source      string            `yaml:"source,omitempty"`
+		CameraModel string            `yaml:"cameramodel,omitempty"`
+		Transcripts []TranscriptEntry `yaml:"transcripts,omitempty"`
+
+		TranscriptEngine string            `yaml:"transcriptengine,omitempty"`
+		TranscriptModel  string            `yaml:"transcriptmodel,omitempty"`
+		TranscriptStatus string            `yaml:"transcriptstatus,omitempty"`
+		Speakers         map[string]string `yaml:"speakers,omitempty"`
+
+		Language            string  `yaml:"language,omitempty"`
+		DetectedLanguage    string  `yaml:"detectedlanguage,omitempty"`
+		LanguageProbability float64 `yaml:"languageprobability,omitempty"`
+
+		Summary      string    `yaml:"summary,omitempty"`
+		Chapters     []Chapter `yaml:"chapters,omitempty"`
+		SummaryModel string    `yaml:"summarymodel,omitempty"`
+		SummarizedAt string    `yaml:"summarizedat,omitempty"`
+	}{
+		ID:          metadata.ID,
+		Filename:    metadata.Filename,
+		Path:        metadata.Path,
+		Type:        metadata.Type,
+		Timestamp:   metadata.Timestamp,
+		Duration:    metadata.Duration,
+		Labels:      metadata.Labels,
+		PHash:       metadata.PHash,
+		Source:      metadata.Source,
+		CameraModel: metadata.CameraModel,
+		Transcripts: segmentsWithoutWords(metadata.Transcripts),
+
+		TranscriptEngine: metadata.TranscriptEngine,
+		TranscriptModel:  metadata.TranscriptModel,
+		TranscriptStatus: metadata.TranscriptStatus,
+		Speakers:         metadata.Speakers,
+
+		Language:            metadata.Language,
+		DetectedLanguage:    metadata.DetectedLanguage,
+		LanguageProbability: metadata.LanguageProbability,
+
+		Summary:      metadata.Summary,
+		Chapters:     metadata.Chapters,
+		SummaryModel: metadata.SummaryModel,
+		SummarizedAt: metadata.SummarizedAt,
+	}
+
+	// The body shows the transcript, rendered from the segments so it's
+	// always in step with them
+	body := metadata.Transcription
+	if len(metadata.Transcripts) > 0 {
+		body = transcriptBody(metadata)
+	}
+	filename := strings.TrimSuffix(filepath.Base(filePath), mdExt)
+	keepExternalBodyEdit(filename, filePath, body)
+
+	// Write the Markdown file with frontmatter
+	if err := writeMarkdownFile(filePath, frontmatterData, body); err != nil {
+		return err
+	}
+	rememberBody(filename, body)
+	return nil
+}
 
-	// Write frontmatter with delimiters
-	buf.WriteString("---\n")
+// Helper function to read every media metadata file
+func readAllMetadata() ([]MediaMetadata, error) {
+	files, err := os.ReadDir(metadataDir)
+	if err != nil {
+		return nil, err
+	}
 
-	// If data is not nil, write the YAML frontmatter
-	if data != nil {
-		// Convert struct to map to ensure lowercase keys
-		jsonData, err := json.Marshal(data)
-		if err != nil {
-			return fmt.Errorf("failed to marshal frontmatter data: %v", err)
+	var allMetadata []MediaMetadata
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), mdExt) {
+			continue
 		}
 
-		var dataMap map[string]interface{}
-		if err := json.Unmarshal(jsonData, &dataMap); err != nil {
-			return fmt.Errorf("failed to unmarshal frontmatter data: %v", err)
+		var metadata MediaMetadata
+		content, readErr := readMarkdownFile(filepath.Join(metadataDir, file.Name()), &metadata)
+		if readErr != nil {
+			log.Printf("Failed to read metadata file %s: %v", file.Name(), readErr)
+			continue
 		}
+		metadata.Transcription = content
 
-		// Write each key-value pair in YAML format
-		for key, value := range dataMap {
-			// Convert key to lowercase
-			key = strings.ToLower(key)
-
-			// Handle different value types
-			switch v := value.(type) {
-			case nil:
-				continue // Skip nil values
-			case string:
-				if v == "" {
-					continue // Skip empty strings
-				}
-				buf.WriteString(fmt.Sprintf("%s: \"%s\"\n", key, v))
-			case []interface{}:
-				if len(v) == 0 {
-					continue // Skip empty arrays
-				}
-				buf.WriteString(fmt.Sprintf("%s:\n", key))
-				for _, item := range v {
-					switch i := item.(type) {
-					case string:
-						buf.WriteString(fmt.Sprintf("  - \"%s\"\n", i))
-					default:
-						buf.WriteString(fmt.Sprintf("  - %v\n", i))
-					}
-				}
-			case map[string]interface{}:
-				if len(v) == 0 {
-					continue // Skip empty maps
-				}
-				buf.WriteString(fmt.Sprintf("%s:\n", key))
-				for k, val := range v {
-					buf.WriteString(fmt.Sprintf("  %s: %v\n", k, val))
-				}
-			default:
-				// For numbers, booleans, etc.
-				buf.WriteString(fmt.Sprintf("%s: %v\n", key, v))
-			}
+		// Ensure Labels is never nil
+		if metadata.Labels == nil {
+			metadata.Labels = []string{}
 		}
+		allMetadata = append(allMetadata, metadata)
 	}
 
-	buf.WriteString("---\n\n")
+	return allMetadata, nil
+}
 
-	// Write body
-	if body != "" {
-		buf.WriteString(body)
+// Helper function to find a metadata file by media ID.
+// Returns an os.ErrNotExist error if no item has the given ID.
+func findMetadataByID(id string) (string, MediaMetadata, error) {
+	allMetadata, err := readAllMetadata()
+	if err != nil {
+		return "", MediaMetadata{}, err
 	}
 
-	// Write to file
-	if err := os.WriteFile(filePath, buf.Bytes(), 0644); err != nil {
-		return fmt.Errorf("failed to write file: %v", err)
+	for _, metadata := range allMetadata {
+		if metadata.ID == id {
+			return filepath.Join(metadataDir, metadata.Filename+mdExt), metadata, nil
+		}
 	}
 
-	return nil
+	return "", MediaMetadata{}, fmt.Errorf("media item %s: %w", id, os.ErrNotExist)
 }
 
 func handleTimeline(w http.ResponseWriter, r *http.Request) {
@@ -243,6 +411,14 @@ func handleTimeline(w http.ResponseWriter, r *http.Request) {
 	w.Write(data)
 }
 
+// UploadFileResponse represents the result of uploading a single file
+type UploadFileResponse struct {
+	Status   string `json:"status"`
+	Filename string `json:"filename"`
+	Path     string `json:"path"`
+	Metadata string `json:"metadata"`
+}
+
 func handleUpload(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -255,6 +431,16 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Optional transcription priority and language for every file in the request.
+	// transcribe=true transcribes files even if they come with subtitles.
+	priority, _ := strconv.Atoi(r.FormValue("priority"))
+	transcribe, _ := strconv.ParseBool(r.FormValue("transcribe"))
+	language, err := normalizeLanguage(r.FormValue("language"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// Get all files from the form
 	files := r.MultipartForm.File["files"]
 	if len(files) == 0 {
@@ -262,14 +448,7 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Response data for all files
-	type FileResponse struct {
-		Status   string `json:"status"`
-		Filename string `json:"filename"`
-		Path     string `json:"path"`
-		Metadata string `json:"metadata"`
-	}
-	responses := make([]FileResponse, 0, len(files))
+	responses := make([]UploadFileResponse, 0, len(files))
 
 	// Process each file
 	for _, fileHeader := range files {
@@ -278,161 +457,216 @@ func handleUpload(w http.ResponseWriter, r *http.Request) {
 			log.Printf("Error opening file %s: %v", fileHeader.Filename, err)
 			continue
 		}
-		defer file.Close()
-
-		// Create file path
-		filename := fileHeader.Filename
-		filePath := filepath.Join(mediaDir, filename)
 
-		// Create a temporary buffer to store the file content
-		// We need this to read EXIF data and then save the file
-		fileBytes, err := io.ReadAll(file)
+		// Stream the file content to disk next to resumable uploads, then
+		// move it into the library without replacing a file there
+		dst, err := os.CreateTemp(uploadsDir, multipartUploadPattern)
 		if err != nil {
-			log.Printf("Error reading file %s: %v", filename, err)
+			log.Printf("Error creating file for %s: %v", fileHeader.Filename, err)
+			file.Close()
 			continue
 		}
-
-		// Create file
-		dst, err := os.Create(filePath)
+		_, err = io.Copy(dst, file)
+		file.Close()
+		dst.Close()
+		if err != nil {
+			log.Printf("Error saving file %s: %v", fileHeader.Filename, err)
+			os.Remove(dst.Name())
+			continue
+		}
+		filename, err := moveIntoLibrary(dst.Name(), filepath.Base(fileHeader.Filename))
 		if err != nil {
-			log.Printf("Error creating file %s: %v", filename, err)
+			log.Printf("Error storing file %s: %v", fileHeader.Filename, err)
+			os.Remove(dst.Name())
 			continue
 		}
-		defer dst.Close()
 
-		// Copy file content
-		if _, err := dst.Write(fileBytes); err != nil {
-			log.Printf("Error saving file %s: %v", filename, err)
+		// Subtitles are kept next to the media and become its transcript
+		if isSubtitleFile(filename) {
+			response, err := ingestSubtitleFile(filename)
+			if err != nil {
+				log.Printf("Error importing subtitles %s: %v", filename, err)
+				continue
+			}
+			responses = append(responses, response)
 			continue
 		}
 
-		// Create metadata
-		mediaType := "unknown"
-		if strings.HasSuffix(strings.ToLower(filename), ".mp3") || strings.HasSuffix(strings.ToLower(filename), ".wav") {
-			mediaType = "audio"
-		} else if strings.HasSuffix(strings.ToLower(filename), ".mp4") || strings.HasSuffix(strings.ToLower(filename), ".mov") {
-			mediaType = "video"
-		} else if strings.HasSuffix(strings.ToLower(filename), ".jpg") || strings.HasSuffix(strings.ToLower(filename), ".jpeg") {
-			mediaType = "photo"
+		response, err := ingestMediaFile(filename, IngestOptions{Priority: priority, Language: language, Transcribe: transcribe})
+		if err != nil {
+			log.Printf("Error processing file %s: %v", filename, err)
+			continue
 		}
 
-		// Try to extract timestamp from EXIF data for photos and videos
-		timestamp := time.Now().Format(time.RFC3339)
-		log.Printf("Processing EXIF data for file: %s (type: %s)", filename, mediaType)
+		// Add to responses
+		responses = append(responses, response)
+	}
 
-		if mediaType == "photo" || mediaType == "video" {
-			// Use exiftool to extract metadata in JSON format
-			log.Printf("Running exiftool on file: %s", filePath)
-			cmd := exec.Command("exiftool", "-json", filePath)
-			output, err := cmd.Output()
-			if err != nil {
-				log.Printf("Error running exiftool: %v", err)
+	// Return success response with all files
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "success",
+		"files":  responses,
+		"count":  len(responses),
+	})
+}
+
+// IngestOptions holds optional metadata for a file entering the library
+type IngestOptions struct {
+	Labels   []string // Initial labels
+	Source   string   // Original location of an imported file
+	Priority int      // Transcription priority for audio and video
+	Language string   // Language of audio and video; empty uses the library default
+
+	// Transcribe audio and video even if sidecar subtitles were imported
+	Transcribe bool
+}
+
+// Determine the media type of a file from its extension
+func detectMediaType(filename string) string {
+	lowerFilename := strings.ToLower(filename)
+	if strings.HasSuffix(lowerFilename, ".mp3") || strings.HasSuffix(lowerFilename, ".wav") {
+		return "audio"
+	} else if strings.HasSuffix(lowerFilename, ".mp4") || strings.HasSuffix(lowerFilename, ".mov") {
+		return "video"
+	} else if strings.HasSuffix(lowerFilename, ".jpg") || strings.HasSuffix(lowerFilename, ".jpeg") {
+		return "photo"
+	}
+	return "unknown"
+}
+
+// Run the post-upload pipeline for a file that is already in the media directory:
+// detect its type, extract a timestamp, write metadata and queue transcription.
+func ingestMediaFile(filename string, opts IngestOptions) (UploadFileResponse, error) {
+	filePath := filepath.Join(mediaDir, filename)
+
+	// Create metadata
+	mediaType := detectMediaType(filename)
+
+	// Try to extract timestamp from EXIF data for photos and videos
+	timestamp := time.Now().Format(time.RFC3339)
+	var cameraModel string
+	log.Printf("Processing EXIF data for file: %s (type: %s)", filename, mediaType)
+
+	if mediaType == "photo" || mediaType == "video" {
+		// Use exiftool to extract metadata in JSON format
+		log.Printf("Running exiftool on file: %s", filePath)
+		cmd := exec.Command("exiftool", "-json", filePath)
+		output, err := cmd.Output()
+		if err != nil {
+			log.Printf("Error running exiftool: %v", err)
+		} else {
+			log.Printf("Exiftool output length: %d bytes", len(output))
+
+			// Parse the JSON output
+			var exifData []map[string]interface{}
+			if err := json.Unmarshal(output, &exifData); err != nil {
+				log.Printf("Error parsing exiftool JSON output: %v", err)
+			} else if len(exifData) == 0 {
+				log.Printf("No EXIF data found in exiftool output")
 			} else {
-				log.Printf("Exiftool output length: %d bytes", len(output))
-
-				// Parse the JSON output
-				var exifData []map[string]interface{}
-				if err := json.Unmarshal(output, &exifData); err != nil {
-					log.Printf("Error parsing exiftool JSON output: %v", err)
-				} else if len(exifData) == 0 {
-					log.Printf("No EXIF data found in exiftool output")
-				} else {
-					// Log available tags for debugging
-					log.Printf("Available EXIF tags:")
-					for key := range exifData[0] {
-						log.Printf("  - %s: %v", key, exifData[0][key])
-					}
+				// Log available tags for debugging
+				log.Printf("Available EXIF tags:")
+				for key := range exifData[0] {
+					log.Printf("  - %s: %v", key, exifData[0][key])
+				}
+
+				if model, ok := exifData[0]["Model"].(string); ok {
+					cameraModel = strings.TrimSpace(model)
+				}
 
-					// Try to get DateTimeOriginal first
-					if dateTimeStr, ok := exifData[0]["DateTimeOriginal"].(string); ok && dateTimeStr != "" {
-						log.Printf("Found DateTimeOriginal: %s", dateTimeStr)
-						// Parse the date string (format typically: "YYYY:MM:DD HH:MM:SS")
-						if dateTime, err := time.Parse("2006:01:02 15:04:05", dateTimeStr); err != nil {
-							log.Printf("Error parsing DateTimeOriginal: %v", err)
-						} else {
-							timestamp = dateTime.Format(time.RFC3339)
-							log.Printf("Using DateTimeOriginal as timestamp: %s", timestamp)
-						}
-					} else if createDateStr, ok := exifData[0]["CreateDate"].(string); ok && createDateStr != "" {
-						// Fallback to CreateDate if DateTimeOriginal doesn't exist
-						log.Printf("DateTimeOriginal not found, using CreateDate: %s", createDateStr)
-						if dateTime, err := time.Parse("2006:01:02 15:04:05", createDateStr); err != nil {
-							log.Printf("Error parsing CreateDate: %v", err)
-						} else {
-							timestamp = dateTime.Format(time.RFC3339)
-							log.Printf("Using CreateDate as timestamp: %s", timestamp)
-						}
+				// Try to get DateTimeOriginal first
+				if dateTimeStr, ok := exifData[0]["DateTimeOriginal"].(string); ok && dateTimeStr != "" {
+					log.Printf("Found DateTimeOriginal: %s", dateTimeStr)
+					// Parse the date string (format typically: "YYYY:MM:DD HH:MM:SS")
+					if dateTime, err := time.Parse("2006:01:02 15:04:05", dateTimeStr); err != nil {
+						log.Printf("Error parsing DateTimeOriginal: %v", err)
 					} else {
-						log.Printf("Neither DateTimeOriginal nor CreateDate found in EXIF data")
+						timestamp = dateTime.Format(time.RFC3339)
+						log.Printf("Using DateTimeOriginal as timestamp: %s", timestamp)
 					}
+				} else if createDateStr, ok := exifData[0]["CreateDate"].(string); ok && createDateStr != "" {
+					// Fallback to CreateDate if DateTimeOriginal doesn't exist
+					log.Printf("DateTimeOriginal not found, using CreateDate: %s", createDateStr)
+					if dateTime, err := time.Parse("2006:01:02 15:04:05", createDateStr); err != nil {
+						log.Printf("Error parsing CreateDate: %v", err)
+					} else {
+						timestamp = dateTime.Format(time.RFC3339)
+						log.Printf("Using CreateDate as timestamp: %s", timestamp)
+					}
+				} else {
+					log.Printf("Neither DateTimeOriginal nor CreateDate found in EXIF data")
 				}
 			}
-		} else {
-			log.Printf("Skipping EXIF extraction for non-photo/video file type: %s", mediaType)
 		}
+	} else {
+		log.Printf("Skipping EXIF extraction for non-photo/video file type: %s", mediaType)
+	}
 
-		log.Printf("Final timestamp for file %s: %s", filename, timestamp)
+	log.Printf("Final timestamp for file %s: %s", filename, timestamp)
 
-		metadata := MediaMetadata{
-			ID:            fmt.Sprintf("%d", time.Now().UnixNano()),
-			Filename:      filename,
-			Path:          "/media/" + filename,
-			Type:          mediaType,
-			Timestamp:     timestamp,
-			Transcription: "",
-			Labels:        []string{},
+	// Subtitles with the same basename replace transcription
+	var subtitlePath string
+	if mediaType == "audio" || mediaType == "video" {
+		var subtitleLanguage string
+		subtitlePath, subtitleLanguage = findSidecarSubtitle(mediaDir, filename)
+		if opts.Language == "" {
+			opts.Language = subtitleLanguage
 		}
+	}
 
-		// Save metadata as Markdown with frontmatter
-		metadataPath := filepath.Join(metadataDir, filename+mdExt)
-
-		// Create frontmatter data
-		frontmatterData := struct {
-			ID        string   `yaml:"id"`
-			Filename  string   `yaml:"filename"`
-			Path      string   `yaml:"path"`
-			Type      string   `yaml:"type"`
-			Timestamp string   `yaml:"timestamp"`
-			Duration  float64  `yaml:"duration,omitempty"`
-			Labels    []string `yaml:"labels"`
-		}{
-			ID:        metadata.ID,
-			Filename:  metadata.Filename,
-			Path:      metadata.Path,
-			Type:      metadata.Type,
-			Timestamp: metadata.Timestamp,
-			Duration:  metadata.Duration,
-			Labels:    metadata.Labels,
-		}
+	metadata := MediaMetadata{
+		ID:            fmt.Sprintf("%d", time.Now().UnixNano()),
+		Filename:      filename,
+		Path:          "/media/" + filename,
+		Type:          mediaType,
+		Timestamp:     timestamp,
+		Transcription: "",
+		Labels:        []string{},
+		Source:        opts.Source,
+		CameraModel:   cameraModel,
+		Language:      opts.Language,
+	}
+	if opts.Labels != nil {
+		metadata.Labels = opts.Labels
+	}
+	applyLabelRules(&metadata)
 
-		// Write the Markdown file with frontmatter
-		if err := writeMarkdownFile(metadataPath, frontmatterData, metadata.Transcription); err != nil {
-			log.Printf("Error saving metadata for %s: %v", filename, err)
-			continue
+	// Compute a perceptual hash for photos so near duplicates can be found
+	if mediaType == "photo" {
+		if hash, err := computeDHash(filePath); err != nil {
+			log.Printf("Error computing perceptual hash for %s: %v", filename, err)
+		} else {
+			metadata.PHash = formatPHash(hash)
 		}
+	}
 
-		// Add to transcription queue if it's an audio or video file
-		if mediaType == "audio" || mediaType == "video" {
-			log.Printf("Adding %s to transcription queue", filename)
-			TQueue.AddToQueue(filename)
-		}
+	// Save metadata as Markdown with frontmatter
+	metadataPath := filepath.Join(metadataDir, filename+mdExt)
+	if err := writeMetadataFile(metadataPath, metadata); err != nil {
+		return UploadFileResponse{}, fmt.Errorf("failed to save metadata: %v", err)
+	}
 
-		// Add to responses
-		responses = append(responses, FileResponse{
-			Status:   "success",
-			Filename: filename,
-			Path:     "/media/" + filename,
-			Metadata: "/api/metadata/" + filename,
-		})
+	// Import sidecar subtitles, or add to the transcription queue if it's an audio or video file
+	sidecarImported := false
+	if subtitlePath != "" {
+		if err := importSidecarTranscript(filename, subtitlePath); err != nil {
+			log.Printf("Failed to import subtitles for %s, transcribing instead: %v", filename, err)
+		} else {
+			sidecarImported = true
+		}
+	}
+	if (mediaType == "audio" || mediaType == "video") && (!sidecarImported || opts.Transcribe) {
+		log.Printf("Adding %s to transcription queue", filename)
+		TQueue.AddToQueue(filename, opts.Priority)
 	}
 
-	// Return success response with all files
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"status": "success",
-		"files":  responses,
-		"count":  len(responses),
-	})
+	return UploadFileResponse{
+		Status:   "success",
+		Filename: filename,
+		Path:     "/media/" + filename,
+		Metadata: "/api/metadata/" + filename,
+	}, nil
 }
 
 func handleMetadata(w http.ResponseWriter, r *http.Request) {
@@ -445,105 +679,18 @@ func handleMetadata(w http.ResponseWriter, r *http.Request) {
 
 	// If no filename is provided, return all metadata files (with optional filtering)
 	if filename == "" {
-		// Parse query parameters for filtering
-		queryParams := r.URL.Query()
-		startDate := queryParams.Get("startDate")
-		endDate := queryParams.Get("endDate")
-		labelsParam := queryParams.Get("labels")
-
-		var filterLabels []string
-		if labelsParam != "" {
-			filterLabels = strings.Split(labelsParam, ",")
-			// Trim whitespace from each label
-			for i, label := range filterLabels {
-				filterLabels[i] = strings.TrimSpace(label)
-			}
-		}
-
-		// Parse date filters if provided
-		var startTime, endTime time.Time
-		var err error
-		if startDate != "" {
-			startTime, err = time.Parse(time.RFC3339, startDate)
-			if err != nil {
-				http.Error(w, "Invalid startDate format. Use RFC3339 format (e.g., 2023-01-01T00:00:00Z)", http.StatusBadRequest)
-				return
-			}
-		}
-		if endDate != "" {
-			endTime, err = time.Parse(time.RFC3339, endDate)
-			if err != nil {
-				http.Error(w, "Invalid endDate format. Use RFC3339 format (e.g., 2023-12-31T23:59:59Z)", http.StatusBadRequest)
-				return
-			}
+		filter, err := parseMediaFilter(r.URL.Query())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
 
-		// Read all Markdown files from the metadata directory
-		files, err := os.ReadDir(metadataDir)
+		allMetadata, err := filterMetadata(filter)
 		if err != nil {
 			http.Error(w, "Failed to read metadata directory", http.StatusInternalServerError)
 			return
 		}
 
-		var allMetadata []MediaMetadata
-		for _, file := range files {
-			if !file.IsDir() && strings.HasSuffix(file.Name(), mdExt) {
-				filePath := filepath.Join(metadataDir, file.Name())
-
-				var metadata MediaMetadata
-
-				// Read Markdown file with frontmatter
-				content, readErr := readMarkdownFile(filePath, &metadata)
-				if readErr != nil {
-					log.Printf("Failed to read metadata file %s: %v", file.Name(), readErr)
-					continue
-				}
-				metadata.Transcription = content
-
-				// Ensure Labels is never nil
-				if metadata.Labels == nil {
-					metadata.Labels = []string{}
-				}
-				// Apply date range filtering
-				if startDate != "" || endDate != "" {
-					itemTime, parseErr := time.Parse(time.RFC3339, metadata.Timestamp)
-					if parseErr != nil {
-						log.Printf("Failed to parse timestamp for %s: %v", file.Name(), parseErr)
-						continue
-					}
-
-					// Check if item falls within date range
-					if startDate != "" && itemTime.Before(startTime) {
-						continue
-					}
-					if endDate != "" && itemTime.After(endTime) {
-						continue
-					}
-				}
-
-				// Apply label filtering
-				if len(filterLabels) > 0 {
-					hasMatchingLabel := false
-					for _, filterLabel := range filterLabels {
-						for _, itemLabel := range metadata.Labels {
-							if strings.EqualFold(strings.TrimSpace(itemLabel), filterLabel) {
-								hasMatchingLabel = true
-								break
-							}
-						}
-						if hasMatchingLabel {
-							break
-						}
-					}
-					if !hasMatchingLabel {
-						continue
-					}
-				}
-
-				allMetadata = append(allMetadata, metadata)
-			}
-		}
-
 		// Marshal the combined metadata
 		responseData, err := json.Marshal(allMetadata)
 		if err != nil {
@@ -610,104 +757,18 @@ func handleMedia(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Parse query parameters for filtering
-	queryParams := r.URL.Query()
-	startDate := queryParams.Get("startDate")
-	endDate := queryParams.Get("endDate")
-	labelsParam := queryParams.Get("labels")
-
-	var filterLabels []string
-	if labelsParam != "" {
-		filterLabels = strings.Split(labelsParam, ",")
-		// Trim whitespace from each label
-		for i, label := range filterLabels {
-			filterLabels[i] = strings.TrimSpace(label)
-		}
-	}
-
-	// Parse date filters if provided
-	var startTime, endTime time.Time
-	var err error
-	if startDate != "" {
-		startTime, err = time.Parse(time.RFC3339, startDate)
-		if err != nil {
-			http.Error(w, "Invalid startDate format. Use RFC3339 format (e.g., 2023-01-01T00:00:00Z)", http.StatusBadRequest)
-			return
-		}
-	}
-	if endDate != "" {
-		endTime, err = time.Parse(time.RFC3339, endDate)
-		if err != nil {
-			http.Error(w, "Invalid endDate format. Use RFC3339 format (e.g., 2023-12-31T23:59:59Z)", http.StatusBadRequest)
-			return
-		}
+	filter, err := parseMediaFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	// Read all files from the metadata directory
-	files, err := os.ReadDir(metadataDir)
+	allMetadata, err := filterMetadata(filter)
 	if err != nil {
 		http.Error(w, "Failed to read metadata directory", http.StatusInternalServerError)
 		return
 	}
 
-	var allMetadata []MediaMetadata
-	for _, file := range files {
-		if !file.IsDir() && strings.HasSuffix(file.Name(), mdExt) {
-			filePath := filepath.Join(metadataDir, file.Name())
-
-			var metadata MediaMetadata
-
-			// Read Markdown file with frontmatter
-			content, readErr := readMarkdownFile(filePath, &metadata)
-			if readErr != nil {
-				log.Printf("Failed to read metadata file %s: %v", file.Name(), readErr)
-				continue
-			}
-			metadata.Transcription = content
-
-			// Ensure Labels is never nil
-			if metadata.Labels == nil {
-				metadata.Labels = []string{}
-			}
-			// Apply date range filtering
-			if startDate != "" || endDate != "" {
-				itemTime, parseErr := time.Parse(time.RFC3339, metadata.Timestamp)
-				if parseErr != nil {
-					log.Printf("Failed to parse timestamp for %s: %v", file.Name(), parseErr)
-					continue
-				}
-
-				// Check if item falls within date range
-				if startDate != "" && itemTime.Before(startTime) {
-					continue
-				}
-				if endDate != "" && itemTime.After(endTime) {
-					continue
-				}
-			}
-
-			// Apply label filtering
-			if len(filterLabels) > 0 {
-				hasMatchingLabel := false
-				for _, filterLabel := range filterLabels {
-					for _, itemLabel := range metadata.Labels {
-						if strings.EqualFold(strings.TrimSpace(itemLabel), filterLabel) {
-							hasMatchingLabel = true
-							break
-						}
-					}
-					if hasMatchingLabel {
-						break
-					}
-				}
-				if !hasMatchingLabel {
-					continue
-				}
-			}
-
-			allMetadata = append(allMetadata, metadata)
-		}
-	}
-
 	// Marshal the combined metadata
 	responseData, err := json.Marshal(allMetadata)
 	if err != nil {
@@ -741,8 +802,15 @@ func handleTranscriptionStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Get all transcription statuses
-	statuses := TQueue.GetAllStatuses()
+	// Filter by state (?status=queued,processing) and filename (?filename=part)
+	filter := TranscriptionStatusFilter{Filename: r.URL.Query().Get("filename")}
+	for _, status := range strings.Split(r.URL.Query().Get("status"), ",") {
+		if status = strings.TrimSpace(status); status != "" {
+			filter.Statuses = append(filter.Statuses, status)
+		}
+	}
+
+	statuses := TQueue.GetAllStatuses(filter)
 
 	// Return as JSON
 	w.Header().Set("Content-Type", "application/json")
@@ -776,64 +844,21 @@ func handleUpdateLabels(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Find the metadata file by ID
-	// We need to search through all metadata files to find the one with matching ID
-	files, err := os.ReadDir(metadataDir)
+	targetFile, metadata, err := findMetadataByID(req.ID)
 	if err != nil {
-		http.Error(w, "Failed to read metadata directory", http.StatusInternalServerError)
-		return
-	}
-
-	var targetFile string
-	var metadata MediaMetadata
-	for _, file := range files {
-		if !file.IsDir() && strings.HasSuffix(file.Name(), mdExt) {
-			filePath := filepath.Join(metadataDir, file.Name())
-
-			var tempMetadata MediaMetadata
-			content, readErr := readMarkdownFile(filePath, &tempMetadata)
-			if readErr != nil {
-				log.Printf("Failed to read metadata file %s: %v", file.Name(), readErr)
-				continue
-			}
-
-			if tempMetadata.ID == req.ID {
-				targetFile = filePath
-				metadata = tempMetadata
-				metadata.Transcription = content
-				break
-			}
+		if os.IsNotExist(err) {
+			http.Error(w, "Media item not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to read metadata directory", http.StatusInternalServerError)
 		}
-	}
-
-	if targetFile == "" {
-		http.Error(w, "Media item not found", http.StatusNotFound)
 		return
 	}
 
 	// Update the labels
 	metadata.Labels = req.Labels
 
-	// Create frontmatter data for writing
-	frontmatterData := struct {
-		ID        string   `yaml:"id"`
-		Filename  string   `yaml:"filename"`
-		Path      string   `yaml:"path"`
-		Type      string   `yaml:"type"`
-		Timestamp string   `yaml:"timestamp"`
-		Duration  float64  `yaml:"duration,omitempty"`
-		Labels    []string `yaml:"labels"`
-	}{
-		ID:        metadata.ID,
-		Filename:  metadata.Filename,
-		Path:      metadata.Path,
-		Type:      metadata.Type,
-		Timestamp: metadata.Timestamp,
-		Duration:  metadata.Duration,
-		Labels:    metadata.Labels,
-	}
-
 	// Write the updated metadata back to the file
-	if err := writeMarkdownFile(targetFile, frontmatterData, metadata.Transcription); err != nil {
+	if err := writeMetadataFile(targetFile, metadata); err != nil {
 		log.Printf("Error updating metadata file %s: %v", targetFile, err)
 		http.Error(w, "Failed to update labels", http.StatusInternalServerError)
 		return
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/adrg/frontmatter"
+	"gopkg.in/yaml.v2"
+)
+
+// Metadata and timeline files are Markdown with a YAML frontmatter block.
+// Keys are written in lowercase so they match the yaml tags the files are
+// read back with.
+
+// Helper function to read a Markdown file with frontmatter
+func readMarkdownFile(filePath string, data interface{}) (string, error) {
+	// Read the file
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to read file: %v", err)
+	}
+
+	// Parse frontmatter
+	body, err := frontmatter.Parse(bytes.NewReader(content), data)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse frontmatter: %v", err)
+	}
+
+	return string(body), nil
+}
+
+// Helper function to write a Markdown file with frontmatter
+func writeMarkdownFile(filePath string, data interface{}, body string) error {
+	// Create a buffer to store the file content
+	var buf bytes.Buffer
+
+	// Write frontmatter with delimiters
+	buf.WriteString("---\n")
+
+	// If data is not nil, write the YAML frontmatter
+	if data != nil {
+		// Convert struct to map to ensure lowercase keys
+		jsonData, err := json.Marshal(data)
+		if err != nil {
+			return fmt.Errorf("failed to marshal frontmatter data: %v", err)
+		}
+
+		var dataMap map[string]interface{}
+		if err := json.Unmarshal(jsonData, &dataMap); err != nil {
+			return fmt.Errorf("failed to unmarshal frontmatter data: %v", err)
+		}
+
+		// Write each key-value pair in YAML format
+		for key, value := range dataMap {
+			// Convert key to lowercase
+			key = strings.ToLower(key)
+
+			// Handle different value types
+			switch v := value.(type) {
+			case nil:
+				continue // Skip nil values
+			case string:
+				if v == "" {
+					continue // Skip empty strings
+				}
+				buf.WriteString(fmt.Sprintf("%s: %s\n", key, strconv.Quote(v)))
+			case []interface{}:
+				if len(v) == 0 {
+					continue // Skip empty arrays
+				}
+				buf.WriteString(fmt.Sprintf("%s:\n", key))
+				for _, item := range v {
+					switch i := item.(type) {
+					case string:
+						buf.WriteString(fmt.Sprintf("  - %s\n", strconv.Quote(i)))
+					case map[string]interface{}, []interface{}:
+						// Nested structures such as transcript segments
+						nested, err := yaml.Marshal(i)
+						if err != nil {
+							return fmt.Errorf("failed to marshal frontmatter value %s: %v", key, err)
+						}
+						buf.WriteString(indentYAML(nested, "  - ", "    "))
+					default:
+						buf.WriteString(fmt.Sprintf("  - %v\n", i))
+					}
+				}
+			case map[string]interface{}:
+				if len(v) == 0 {
+					continue // Skip empty maps
+				}
+				nested, err := yaml.Marshal(v)
+				if err != nil {
+					return fmt.Errorf("failed to marshal frontmatter value %s: %v", key, err)
+				}
+				buf.WriteString(fmt.Sprintf("%s:\n", key))
+				buf.WriteString(indentYAML(nested, "  ", "  "))
+			default:
+				// For numbers, booleans, etc.
+				buf.WriteString(fmt.Sprintf("%s: %v\n", key, v))
+			}
+		}
+	}
+
+	buf.WriteString("---\n\n")
+
+	// Write body, dropping the blank line a previous read left at its start
+	if body != "" {
+		buf.WriteString(strings.TrimLeft(body, "\n"))
+	}
+
+	return writeFileAtomic(filePath, buf.Bytes())
+}
+
+// Write a file through a temporary file that replaces it, so readers such as
+// the transcript body watcher never see it half written
+func writeFileAtomic(filePath string, data []byte) error {
+	temp, err := os.CreateTemp(filepath.Dir(filePath), "."+filepath.Base(filePath)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %v", err)
+	}
+	defer os.Remove(temp.Name())
+
+	_, err = temp.Write(data)
+	if err == nil {
+		err = temp.Chmod(0644)
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write file: %v", err)
+	}
+	if err := os.Rename(temp.Name(), filePath); err != nil {
+		return fmt.Errorf("failed to replace file: %v", err)
+	}
+	return nil
+}
+
+// Helper function to indent a YAML document for nesting under a frontmatter key.
+// The first line gets firstPrefix (e.g. a list marker) and the rest get prefix.
+func indentYAML(doc []byte, firstPrefix, prefix string) string {
+	var b strings.Builder
+	for i, line := range strings.Split(strings.TrimRight(string(doc), "\n"), "\n") {
+		if i == 0 {
+			b.WriteString(firstPrefix)
+		} else {
+			b.WriteString(prefix)
+		}
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMarkdownFileRoundTrip(t *testing.T) {
+	metadata := MediaMetadata{
+		ID:        "1700000000000000000",
+		Filename:  "kitchen: take 2.mp4",
+		Path:      "/media/kitchen: take 2.mp4",
+		Type:      "video",
+		Timestamp: "2024-05-01T10:00:00Z",
+		Duration:  93.5,
+		Labels:    []string{"family", "yes", "null", "123", `say "hi"`, "- dash"},
+		Source:    "inbox/phone/#1.mp4",
+		Transcripts: []TranscriptEntry{
+			{Start: 0, End: 2.25, Text: " Hello: world", Segment: 0, Speaker: "SPEAKER_00"},
+			{Start: 2.25, End: 5, Text: "\"quoted\" and 'single', ünïcödé\nsecond line", Segment: 1},
+			{Start: 5, End: 7.125, Text: "true", Segment: 2, Metadata: "{\"score\": 0.5}"},
+		},
+		TranscriptEngine:    "whisperx",
+		TranscriptModel:     "large-v3",
+		Speakers:            map[string]string{"SPEAKER_00": "Alice: Mom", "SPEAKER_01": "#2"},
+		Language:            "de",
+		DetectedLanguage:    "de",
+		LanguageProbability: 0.97,
+		Summary:             "Plans for the kitchen.\n\nTiles: blue, not green.",
+		Chapters:            []Chapter{{Start: 0, Title: "Intro: why"}, {Start: 60, Title: "Tiles"}},
+	}
+	body := "# Notes\n\nSome --- dashes and *markdown*.\n"
+
+	path := filepath.Join(t.TempDir(), "item.md")
+	if err := writeMarkdownFile(path, metadata, body); err != nil {
+		t.Fatalf("writeMarkdownFile: %v", err)
+	}
+
+	var read MediaMetadata
+	readBody, err := readMarkdownFile(path, &read)
+	if err != nil {
+		t.Fatalf("readMarkdownFile: %v", err)
+	}
+	if !reflect.DeepEqual(read, metadata) {
+		t.Errorf("metadata changed in round trip:\ngot  %+v\nwant %+v", read, metadata)
+	}
+	if readBody != "\n"+body {
+		t.Errorf("body = %q, want %q", readBody, "\n"+body)
+	}
+
+	// Writing what was read leaves the file as it was
+	if err := writeMarkdownFile(path, read, readBody); err != nil {
+		t.Fatalf("writeMarkdownFile: %v", err)
+	}
+	var reread MediaMetadata
+	rereadBody, err := readMarkdownFile(path, &reread)
+	if err != nil {
+		t.Fatalf("readMarkdownFile: %v", err)
+	}
+	if !reflect.DeepEqual(reread, metadata) || rereadBody != readBody {
+		t.Errorf("second round trip changed the file: %+v %q", reread, rereadBody)
+	}
+}
+
+func TestMarkdownFileSkipsEmptyValues(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "item.md")
+	if err := writeMarkdownFile(path, MediaMetadata{ID: "1", Labels: []string{}}, ""); err != nil {
+		t.Fatalf("writeMarkdownFile: %v", err)
+	}
+
+	var read MediaMetadata
+	if _, err := readMarkdownFile(path, &read); err != nil {
+		t.Fatalf("readMarkdownFile: %v", err)
+	}
+	if read.ID != "1" || read.Filename != "" || len(read.Labels) != 0 || read.Transcripts != nil {
+		t.Errorf("unexpected metadata %+v", read)
+	}
+}
+
+func TestIndentYAML(t *testing.T) {
+	got := indentYAML([]byte("start: 1\ntext: hi\n"), "  - ", "    ")
+	want := "  - start: 1\n    text: hi\n"
+	if got != want {
+		t.Errorf("indentYAML = %q, want %q", got, want)
+	}
+}
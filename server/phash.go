@@ -0,0 +1,553 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"log"
+	"math/bits"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	trashDir = "./data/trash"
+
+	// Default maximum Hamming distance for two photos to count as near duplicates
+	defaultSimilarDistance = 10
+)
+
+// SimilarMatch represents a photo and its distance to a reference photo
+type SimilarMatch struct {
+	ID       string `json:"id"`
+	Filename string `json:"filename"`
+	Path     string `json:"path"`
+	Distance int    `json:"distance"`
+}
+
+// SimilarGroup represents a cluster of near-duplicate photos
+type SimilarGroup struct {
+	Best  string         `json:"best"` // ID of the suggested shot to keep
+	Items []SimilarMatch `json:"items"`
+}
+
+// ResolveDuplicatesRequest represents the request body for resolving a group of duplicates
+type ResolveDuplicatesRequest struct {
+	Keep  string   `json:"keep"`
+	Trash []string `json:"trash"`
+}
+
+// Serialises backfill runs so the startup job and the API don't race
+var phashBackfillMu sync.Mutex
+
+// Compute a difference hash (dHash) for an image file.
+// The image is reduced to 9x8 grayscale cells and each bit records whether a
+// cell is brighter than its right-hand neighbour.
+func computeDHash(filePath string) (uint64, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open image: %v", err)
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return 0, fmt.Errorf("failed to decode image: %v", err)
+	}
+
+	bounds := img.Bounds()
+	if bounds.Dx() < 9 || bounds.Dy() < 8 {
+		return 0, fmt.Errorf("image too small to hash: %dx%d", bounds.Dx(), bounds.Dy())
+	}
+
+	// Average the luminance of each cell, sampling at most 16x16 pixels per cell
+	var cells [8][9]float64
+	for cy := 0; cy < 8; cy++ {
+		y0 := bounds.Min.Y + cy*bounds.Dy()/8
+		y1 := bounds.Min.Y + (cy+1)*bounds.Dy()/8
+		yStep := max(1, (y1-y0)/16)
+		for cx := 0; cx < 9; cx++ {
+			x0 := bounds.Min.X + cx*bounds.Dx()/9
+			x1 := bounds.Min.X + (cx+1)*bounds.Dx()/9
+			xStep := max(1, (x1-x0)/16)
+
+			var sum float64
+			var count int
+			for y := y0; y < y1; y += yStep {
+				for x := x0; x < x1; x += xStep {
+					r, g, b, _ := img.At(x, y).RGBA()
+					sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+					count++
+				}
+			}
+			cells[cy][cx] = sum / float64(count)
+		}
+	}
+
+	var hash uint64
+	for cy := 0; cy < 8; cy++ {
+		for cx := 0; cx < 8; cx++ {
+			hash <<= 1
+			if cells[cy][cx] > cells[cy][cx+1] {
+				hash |= 1
+			}
+		}
+	}
+
+	return hash, nil
+}
+
+// Format a perceptual hash for storage in frontmatter
+func formatPHash(hash uint64) string {
+	return fmt.Sprintf("%016x", hash)
+}
+
+// Parse a perceptual hash stored in frontmatter
+func parsePHash(s string) (uint64, error) {
+	return strconv.ParseUint(s, 16, 64)
+}
+
+// Number of differing bits between two perceptual hashes
+func hammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// Compute perceptual hashes for photos that don't have one yet.
+// Returns the number of metadata files updated.
+func backfillPerceptualHashes() (int, error) {
+	phashBackfillMu.Lock()
+	defer phashBackfillMu.Unlock()
+
+	allMetadata, err := readAllMetadata()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read metadata: %v", err)
+	}
+
+	updated := 0
+	for _, metadata := range allMetadata {
+		if metadata.Type != "photo" || metadata.PHash != "" {
+			continue
+		}
+
+		hash, err := computeDHash(filepath.Join(mediaDir, metadata.Filename))
+		if err != nil {
+			log.Printf("Failed to compute perceptual hash for %s: %v", metadata.Filename, err)
+			continue
+		}
+		if err := savePerceptualHash(metadata.Filename, formatPHash(hash)); err != nil {
+			log.Printf("Failed to save perceptual hash for %s: %v", metadata.Filename, err)
+			continue
+		}
+		updated++
+	}
+
+	if updated > 0 {
+		log.Printf("Computed perceptual hashes for %d photos", updated)
+	}
+	return updated, nil
+}
+
+// Write a photo's perceptual hash to its metadata. The file is read again
+// under the metadata lock so edits made while hashing aren't lost.
+func savePerceptualHash(filename, phash string) error {
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+
+	metadataPath := filepath.Join(metadataDir, filename+mdExt)
+	var metadata MediaMetadata
+	body, err := readMarkdownFile(metadataPath, &metadata)
+	if err != nil {
+		return err
+	}
+	metadata.Transcription = body
+	metadata.PHash = phash
+	return writeMetadataFile(metadataPath, metadata)
+}
+
+// Collect the photos that have a usable perceptual hash
+func hashedPhotos() ([]MediaMetadata, []uint64, error) {
+	allMetadata, err := readAllMetadata()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var photos []MediaMetadata
+	var hashes []uint64
+	for _, metadata := range allMetadata {
+		if metadata.Type != "photo" || metadata.PHash == "" {
+			continue
+		}
+		hash, err := parsePHash(metadata.PHash)
+		if err != nil {
+			log.Printf("Invalid perceptual hash for %s: %v", metadata.Filename, err)
+			continue
+		}
+		photos = append(photos, metadata)
+		hashes = append(hashes, hash)
+	}
+
+	return photos, hashes, nil
+}
+
+// Group photos into clusters of near duplicates using single-linkage clustering
+func groupSimilarPhotos(photos []MediaMetadata, hashes []uint64, maxDistance int) []SimilarGroup {
+	// Union-find over photo indices
+	parent := make([]int, len(photos))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	for i := range photos {
+		for j := i + 1; j < len(photos); j++ {
+			if hammingDistance(hashes[i], hashes[j]) <= maxDistance {
+				parent[find(i)] = find(j)
+			}
+		}
+	}
+
+	members := make(map[int][]int)
+	for i := range photos {
+		root := find(i)
+		members[root] = append(members[root], i)
+	}
+
+	var groups []SimilarGroup
+	for _, indices := range members {
+		if len(indices) < 2 {
+			continue
+		}
+
+		// Suggest the shot with the most pixels (then the largest file) as the keeper
+		best := indices[0]
+		bestScore := photoQualityScore(photos[best])
+		for _, i := range indices[1:] {
+			if score := photoQualityScore(photos[i]); score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+
+		group := SimilarGroup{Best: photos[best].ID}
+		for _, i := range indices {
+			group.Items = append(group.Items, SimilarMatch{
+				ID:       photos[i].ID,
+				Filename: photos[i].Filename,
+				Path:     photos[i].Path,
+				Distance: hammingDistance(hashes[best], hashes[i]),
+			})
+		}
+		sort.Slice(group.Items, func(a, b int) bool {
+			if group.Items[a].Distance != group.Items[b].Distance {
+				return group.Items[a].Distance < group.Items[b].Distance
+			}
+			return group.Items[a].Filename < group.Items[b].Filename
+		})
+		groups = append(groups, group)
+	}
+
+	// Largest clusters first
+	sort.Slice(groups, func(a, b int) bool {
+		if len(groups[a].Items) != len(groups[b].Items) {
+			return len(groups[a].Items) > len(groups[b].Items)
+		}
+		return groups[a].Items[0].Filename < groups[b].Items[0].Filename
+	})
+
+	return groups
+}
+
+// Rough quality score used to pick the best shot of a cluster
+func photoQualityScore(metadata MediaMetadata) float64 {
+	filePath := filepath.Join(mediaDir, metadata.Filename)
+
+	var score float64
+	if file, err := os.Open(filePath); err == nil {
+		if config, _, err := image.DecodeConfig(file); err == nil {
+			score = float64(config.Width) * float64(config.Height)
+		}
+		file.Close()
+	}
+
+	// File size breaks ties between equal resolutions (less compression)
+	if info, err := os.Stat(filePath); err == nil {
+		score += float64(info.Size()) / 1e12
+	}
+
+	return score
+}
+
+// Move a media file and its metadata into the trash directory
+func moveToTrash(metadata MediaMetadata) error {
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+
+	trashMediaDir := filepath.Join(trashDir, "media")
+	trashMetadataDir := filepath.Join(trashDir, "metadata")
+	for _, dir := range []string{trashMediaDir, trashMetadataDir} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create trash directory: %v", err)
+		}
+	}
+
+	// A photo trashed before may have had the same name
+	name, err := chooseTrashFilename(metadata.Filename)
+	if err != nil {
+		return err
+	}
+
+	mediaPath := filepath.Join(mediaDir, metadata.Filename)
+	if err := os.Rename(mediaPath, filepath.Join(trashMediaDir, name)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to move media file: %v", err)
+	}
+
+	metadataPath := filepath.Join(metadataDir, metadata.Filename+mdExt)
+	if err := os.Rename(metadataPath, filepath.Join(trashMetadataDir, name+mdExt)); err != nil {
+		return fmt.Errorf("failed to move metadata file: %v", err)
+	}
+
+	return nil
+}
+
+// Pick a name for a file in the trash that neither a trashed media file nor
+// a trashed metadata file has, adding -1, -2, ... to its stem as needed
+func chooseTrashFilename(filename string) (string, error) {
+	ext := filepath.Ext(filename)
+	stem := strings.TrimSuffix(filename, ext)
+	for i := 0; ; i++ {
+		candidate := filename
+		if i > 0 {
+			candidate = fmt.Sprintf("%s-%d%s", stem, i, ext)
+		}
+		taken := false
+		for _, path := range []string{
+			filepath.Join(trashDir, "media", candidate),
+			filepath.Join(trashDir, "metadata", candidate+mdExt),
+		} {
+			_, err := os.Lstat(path)
+			if err == nil {
+				taken = true
+			} else if !os.IsNotExist(err) {
+				return "", fmt.Errorf("failed to check trash: %v", err)
+			}
+		}
+		if !taken {
+			return candidate, nil
+		}
+	}
+}
+
+// Parse the optional maxDistance query parameter
+func parseMaxDistance(r *http.Request) (int, error) {
+	param := r.URL.Query().Get("maxDistance")
+	if param == "" {
+		return defaultSimilarDistance, nil
+	}
+	maxDistance, err := strconv.Atoi(param)
+	if err != nil || maxDistance < 0 || maxDistance > 64 {
+		return 0, fmt.Errorf("maxDistance must be an integer between 0 and 64")
+	}
+	return maxDistance, nil
+}
+
+// Handler for listing near duplicates of a single photo
+func handleSimilar(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, "/api/similar/")
+	if id == "" {
+		http.Error(w, "ID is required", http.StatusBadRequest)
+		return
+	}
+
+	maxDistance, err := parseMaxDistance(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	photos, hashes, err := hashedPhotos()
+	if err != nil {
+		http.Error(w, "Failed to read metadata directory", http.StatusInternalServerError)
+		return
+	}
+
+	target := -1
+	for i, photo := range photos {
+		if photo.ID == id {
+			target = i
+			break
+		}
+	}
+	if target == -1 {
+		http.Error(w, "Photo not found or has no perceptual hash", http.StatusNotFound)
+		return
+	}
+
+	matches := []SimilarMatch{}
+	for i, photo := range photos {
+		if i == target {
+			continue
+		}
+		distance := hammingDistance(hashes[target], hashes[i])
+		if distance > maxDistance {
+			continue
+		}
+		matches = append(matches, SimilarMatch{
+			ID:       photo.ID,
+			Filename: photo.Filename,
+			Path:     photo.Path,
+			Distance: distance,
+		})
+	}
+
+	// Closest matches first
+	sort.Slice(matches, func(a, b int) bool {
+		if matches[a].Distance != matches[b].Distance {
+			return matches[a].Distance < matches[b].Distance
+		}
+		return matches[a].Filename < matches[b].Filename
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(matches)
+}
+
+// Handler for listing clusters of near-duplicate photos
+func handleDuplicates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	maxDistance, err := parseMaxDistance(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	photos, hashes, err := hashedPhotos()
+	if err != nil {
+		http.Error(w, "Failed to read metadata directory", http.StatusInternalServerError)
+		return
+	}
+
+	groups := groupSimilarPhotos(photos, hashes, maxDistance)
+	if groups == nil {
+		groups = []SimilarGroup{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(groups)
+}
+
+// Handler for keeping one photo of a cluster and trashing the rest
+func handleResolveDuplicates(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req ResolveDuplicatesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.Keep == "" || len(req.Trash) == 0 {
+		http.Error(w, "keep and trash are required", http.StatusBadRequest)
+		return
+	}
+
+	maxDistance, err := parseMaxDistance(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	photos, hashes, err := hashedPhotos()
+	if err != nil {
+		http.Error(w, "Failed to read metadata directory", http.StatusInternalServerError)
+		return
+	}
+
+	// Only photos in the same cluster as the one kept may be trashed
+	var cluster map[string]MediaMetadata
+	for _, group := range groupSimilarPhotos(photos, hashes, maxDistance) {
+		members := make(map[string]MediaMetadata)
+		for _, item := range group.Items {
+			for _, photo := range photos {
+				if photo.ID == item.ID {
+					members[item.ID] = photo
+				}
+			}
+		}
+		if _, ok := members[req.Keep]; ok {
+			cluster = members
+			break
+		}
+	}
+	if cluster == nil {
+		http.Error(w, "keep is not a photo with near duplicates", http.StatusBadRequest)
+		return
+	}
+	for _, id := range req.Trash {
+		if _, ok := cluster[id]; !ok {
+			http.Error(w, fmt.Sprintf("%s is not a near duplicate of %s", id, req.Keep), http.StatusBadRequest)
+			return
+		}
+	}
+
+	trashed := []string{}
+	for _, id := range req.Trash {
+		if id == req.Keep {
+			continue
+		}
+
+		if err := moveToTrash(cluster[id]); err != nil {
+			log.Printf("Failed to trash %s: %v", cluster[id].Filename, err)
+			continue
+		}
+		trashed = append(trashed, id)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"kept":    req.Keep,
+		"trashed": trashed,
+	})
+}
+
+// Handler for running the perceptual hash backfill job
+func handlePHashBackfill(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	updated, err := backfillPerceptualHashes()
+	if err != nil {
+		http.Error(w, "Failed to backfill perceptual hashes", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "success",
+		"updated": updated,
+	})
+}
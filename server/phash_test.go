@@ -0,0 +1,177 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// A grayscale image whose pixels are shade(x, y)
+func grayImage(width, height int, shade func(x, y int) uint8) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetGray(x, y, color.Gray{Y: shade(x, y)})
+		}
+	}
+	return img
+}
+
+// Write an image as a PNG in the media directory, padded with extra bytes
+// to make the file larger
+func writeTestPhoto(t *testing.T, filename string, img image.Image, padding int) {
+	t.Helper()
+	file, err := os.Create(filepath.Join(mediaDir, filename))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := png.Encode(file, img); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := file.Write(make([]byte, padding)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestComputeDHash(t *testing.T) {
+	setupTranscriptionTest(t)
+
+	tests := []struct {
+		name          string
+		width, height int
+		shade         func(x, y int) uint8
+		want          uint64
+	}{
+		{"uniform", 90, 80, func(x, y int) uint8 { return 128 }, 0},
+		{"brighter to the right", 90, 80, func(x, y int) uint8 { return uint8(x * 2) }, 0},
+		{"darker to the right", 90, 80, func(x, y int) uint8 { return uint8(255 - x*2) }, ^uint64(0)},
+		{"darker to the right, larger", 900, 800, func(x, y int) uint8 { return uint8(255 - x/4) }, ^uint64(0)},
+		// Rows alternate between getting darker and brighter
+		{"stripes", 90, 80, func(x, y int) uint8 {
+			if (y/10)%2 == 0 {
+				return uint8(255 - x*2)
+			}
+			return uint8(x * 2)
+		}, 0xFF00FF00FF00FF00},
+	}
+	for _, test := range tests {
+		writeTestPhoto(t, "photo.png", grayImage(test.width, test.height, test.shade), 0)
+		hash, err := computeDHash(filepath.Join(mediaDir, "photo.png"))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if hash != test.want {
+			t.Errorf("%s: hash %s, want %s", test.name, formatPHash(hash), formatPHash(test.want))
+		}
+	}
+
+	writeTestPhoto(t, "tiny.png", grayImage(8, 8, func(x, y int) uint8 { return 0 }), 0)
+	if _, err := computeDHash(filepath.Join(mediaDir, "tiny.png")); err == nil {
+		t.Error("hashed an image smaller than the hash")
+	}
+	if err := os.WriteFile(filepath.Join(mediaDir, "text.png"), []byte("not an image"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := computeDHash(filepath.Join(mediaDir, "text.png")); err == nil {
+		t.Error("hashed a file that isn't an image")
+	}
+}
+
+func TestPhotoQualityScore(t *testing.T) {
+	setupTranscriptionTest(t)
+	blank := func(x, y int) uint8 { return 0 }
+	writeTestPhoto(t, "small.png", grayImage(20, 10, blank), 0)
+	writeTestPhoto(t, "small-padded.png", grayImage(20, 10, blank), 1000)
+	writeTestPhoto(t, "large.png", grayImage(40, 20, blank), 0)
+
+	small := photoQualityScore(MediaMetadata{Filename: "small.png"})
+	padded := photoQualityScore(MediaMetadata{Filename: "small-padded.png"})
+	large := photoQualityScore(MediaMetadata{Filename: "large.png"})
+	if int(small) != 200 || int(large) != 800 {
+		t.Errorf("scores %v and %v, want the pixel counts 200 and 800", small, large)
+	}
+	if !(small < padded && padded < large) {
+		t.Errorf("scores small %v, small but larger file %v, large %v", small, padded, large)
+	}
+	if score := photoQualityScore(MediaMetadata{Filename: "missing.png"}); score != 0 {
+		t.Errorf("missing file scored %v", score)
+	}
+}
+
+func TestGroupSimilarPhotos(t *testing.T) {
+	setupTranscriptionTest(t)
+	blank := func(x, y int) uint8 { return 0 }
+	writeTestPhoto(t, "b.png", grayImage(40, 20, blank), 0) // The sharpest of its cluster
+
+	photos := []MediaMetadata{
+		{ID: "a", Filename: "a.png"},
+		{ID: "b", Filename: "b.png"},
+		{ID: "c", Filename: "c.png"},
+		{ID: "d", Filename: "d.png"},
+		{ID: "e", Filename: "e.png"},
+		{ID: "f", Filename: "f.png"},
+	}
+	hashes := []uint64{
+		0b0000, // a
+		0b0011, // b: 2 from a
+		0b1111, // c: 2 from b, 4 from a, so in their cluster only through b
+		0xFF00, // d
+		0xFF01, // e: 1 from d
+		0xF0F0, // f: far from everything
+	}
+
+	groups := groupSimilarPhotos(photos, hashes, 2)
+	want := []SimilarGroup{
+		{Best: "b", Items: []SimilarMatch{
+			{ID: "b", Filename: "b.png", Distance: 0},
+			{ID: "a", Filename: "a.png", Distance: 2},
+			{ID: "c", Filename: "c.png", Distance: 2},
+		}},
+		{Best: "d", Items: []SimilarMatch{
+			{ID: "d", Filename: "d.png", Distance: 0},
+			{ID: "e", Filename: "e.png", Distance: 1},
+		}},
+	}
+	if !reflect.DeepEqual(groups, want) {
+		t.Errorf("groups = %+v, want %+v", groups, want)
+	}
+
+	if groups := groupSimilarPhotos(photos, hashes, 0); len(groups) != 0 {
+		t.Errorf("distance 0 grouped distinct hashes: %+v", groups)
+	}
+	if groups := groupSimilarPhotos(photos, hashes, 64); len(groups) != 1 || len(groups[0].Items) != len(photos) {
+		t.Errorf("distance 64 made %+v, want one group of everything", groups)
+	}
+}
+
+func TestMoveToTrashKeepsEarlierTrashedFiles(t *testing.T) {
+	setupTranscriptionTest(t)
+
+	for _, content := range []string{"first burst", "second burst"} {
+		if err := os.WriteFile(filepath.Join(mediaDir, "IMG_0001.jpg"), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		metadata := MediaMetadata{ID: "1", Filename: "IMG_0001.jpg", Type: "photo", Labels: []string{}}
+		if err := writeMetadataFile(filepath.Join(metadataDir, "IMG_0001.jpg"+mdExt), metadata); err != nil {
+			t.Fatal(err)
+		}
+		if err := moveToTrash(metadata); err != nil {
+			t.Fatalf("moveToTrash: %v", err)
+		}
+	}
+
+	for name, want := range map[string]string{"IMG_0001.jpg": "first burst", "IMG_0001-1.jpg": "second burst"} {
+		if data, err := os.ReadFile(filepath.Join(trashDir, "media", name)); err != nil || string(data) != want {
+			t.Errorf("trashed %s has %q, %v; want %q", name, data, err, want)
+		}
+		if _, err := os.Stat(filepath.Join(trashDir, "metadata", name+mdExt)); err != nil {
+			t.Errorf("metadata of %s not trashed: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// Audio can be prepared before transcription: a high-pass filter against
+// rumble, FFT noise reduction, loudness normalization, and cutting long
+// silences so the engine has less to do and less silence to hallucinate on.
+// The stretches that were kept are recorded, so segment times from the
+// engine are moved back onto the original recording's timeline.
+
+// audioRegion is a stretch of the original audio kept in the preprocessed
+// audio, starting there at offset
+type audioRegion struct {
+	start, end, offset float64
+}
+
+// audioTimeline maps times in preprocessed audio back to the original. An
+// empty timeline means nothing was cut.
+type audioTimeline []audioRegion
+
+// Time in the original audio of a time in the preprocessed audio. An end
+// time on the boundary of two regions belongs to the earlier one, so a
+// segment ending at a cut doesn't stretch over the removed silence.
+func (timeline audioTimeline) originalTime(seconds float64, end bool) float64 {
+	if len(timeline) == 0 {
+		return seconds
+	}
+	region := timeline[0]
+	for _, r := range timeline[1:] {
+		if seconds > r.offset || (!end && seconds == r.offset) {
+			region = r
+		}
+	}
+	return region.start + seconds - region.offset
+}
+
+// Move segments and their words onto the original audio's timeline
+func (timeline audioTimeline) restore(segments []TranscriptEntry) []TranscriptEntry {
+	if len(timeline) == 0 {
+		return segments
+	}
+	restored := make([]TranscriptEntry, len(segments))
+	for i, segment := range segments {
+		segment.Start = timeline.originalTime(segment.Start, false)
+		segment.End = timeline.originalTime(segment.End, true)
+		if segment.Words != nil {
+			words := make([]TranscriptWord, len(segment.Words))
+			for k, word := range segment.Words {
+				word.Start = timeline.originalTime(word.Start, false)
+				word.End = timeline.originalTime(word.End, true)
+				words[k] = word
+			}
+			segment.Words = words
+		}
+		restored[i] = segment
+	}
+	return restored
+}
+
+// ffmpeg filters for the configured clean-up, in the order they run
+func preprocessFilters(config PreprocessConfig) []string {
+	var filters []string
+	if config.HighpassHz > 0 {
+		filters = append(filters, fmt.Sprintf("highpass=f=%d", config.HighpassHz))
+	}
+	if config.Denoise {
+		filters = append(filters, "afftdn")
+	}
+	if config.Loudnorm {
+		filters = append(filters, "loudnorm")
+	}
+	return filters
+}
+
+// Stretches of audio to keep: everything except the middle of silences
+// longer than minSilence, leaving padding seconds of silence at each side
+func keptRegions(duration float64, silences []silence, minSilence, padding float64) audioTimeline {
+	var timeline audioTimeline
+	pos, offset := 0.0, 0.0
+	for _, s := range silences {
+		if s.end-s.start <= minSilence || s.start+padding <= pos {
+			continue
+		}
+		cutStart, cutEnd := s.start+padding, min(s.end, duration)-padding
+		if cutEnd <= cutStart {
+			continue
+		}
+		timeline = append(timeline, audioRegion{start: pos, end: cutStart, offset: offset})
+		offset += cutStart - pos
+		pos = cutEnd
+	}
+	if timeline == nil {
+		return nil // Nothing to cut
+	}
+	return append(timeline, audioRegion{start: pos, end: duration, offset: offset})
+}
+
+// Run ffmpeg to write 16 kHz mono WAV
+func runFFmpegAudio(ctx context.Context, inputPath, outputPath, filter string) error {
+	args := []string{"-y", "-i", inputPath, "-vn"}
+	if filter != "" {
+		args = append(args, "-af", filter)
+	}
+	args = append(args, "-acodec", "pcm_s16le", "-ar", "16000", "-ac", "1", outputPath)
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("ffmpeg error: %v, output: %s", err, string(output))
+	}
+	return nil
+}
+
+// Prepare a recording for transcription as 16 kHz mono WAV at audioPath.
+// Returns the timeline of the kept audio, empty if no silence was cut.
+func preprocessAudio(ctx context.Context, mediaPath, audioPath string, config PreprocessConfig) (audioTimeline, error) {
+	filter := strings.Join(preprocessFilters(config), ",")
+	if config.TrimSilenceSeconds <= 0 {
+		return nil, runFFmpegAudio(ctx, mediaPath, audioPath, filter)
+	}
+
+	// Silences are found after the clean-up, which makes them easier to tell apart
+	filteredPath := strings.TrimSuffix(audioPath, ".wav") + ".filtered.wav"
+	if err := runFFmpegAudio(ctx, mediaPath, filteredPath, filter); err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := os.Remove(filteredPath); err != nil && !os.IsNotExist(err) {
+			log.Printf("Warning: Failed to remove temporary audio file %s: %v", filteredPath, err)
+		}
+	}()
+
+	duration, err := probeAudioDuration(ctx, filteredPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to determine duration: %v", err)
+	}
+	silences, err := detectSilences(ctx, filteredPath, config.SilenceNoise, config.TrimSilenceSeconds)
+	if err != nil {
+		return nil, fmt.Errorf("failed to detect silences: %v", err)
+	}
+	timeline := keptRegions(duration, silences, config.TrimSilenceSeconds, config.SilencePadding)
+	if len(timeline) == 0 {
+		return nil, os.Rename(filteredPath, audioPath)
+	}
+
+	var selections []string
+	for _, region := range timeline {
+		selections = append(selections, fmt.Sprintf("between(t,%.3f,%.3f)", region.start, region.end))
+	}
+	selectFilter := fmt.Sprintf("aselect='%s',asetpts=N/SR/TB", strings.Join(selections, "+"))
+	if err := runFFmpegAudio(ctx, filteredPath, audioPath, selectFilter); err != nil {
+		return nil, err
+	}
+
+	last := timeline[len(timeline)-1]
+	log.Printf("Cut %.1f s of silence from %s (%d cuts)", duration-(last.offset+last.end-last.start), mediaPath, len(timeline)-1)
+	return timeline, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestKeptRegions(t *testing.T) {
+	tests := []struct {
+		name     string
+		silences []silence
+		want     audioTimeline
+	}{
+		{"no silences", nil, nil},
+		{"silences too short", []silence{{10, 11.5}, {30, 32}}, nil},
+		{"several cuts", []silence{{10, 20}, {30, 31}, {50, 70}}, audioTimeline{
+			{start: 0, end: 10.5, offset: 0},
+			{start: 19.5, end: 50.5, offset: 10.5},
+			{start: 69.5, end: 100, offset: 41.5},
+		}},
+		{"silence at the start", []silence{{0, 5}}, audioTimeline{
+			{start: 0, end: 0.5, offset: 0},
+			{start: 4.5, end: 100, offset: 0.5},
+		}},
+		// ffmpeg can report a silence ending past the end of the audio
+		{"silence at the end", []silence{{95, 110}}, audioTimeline{
+			{start: 0, end: 95.5, offset: 0},
+			{start: 99.5, end: 100, offset: 95.5},
+		}},
+		{"overlapping silences", []silence{{10, 20}, {15, 25}}, audioTimeline{
+			{start: 0, end: 10.5, offset: 0},
+			{start: 19.5, end: 100, offset: 10.5},
+		}},
+	}
+	for _, test := range tests {
+		if got := keptRegions(100, test.silences, 2, 0.5); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: keptRegions = %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestAudioTimelineOriginalTime(t *testing.T) {
+	timeline := keptRegions(100, []silence{{10, 20}, {50, 70}}, 2, 0.5)
+	tests := []struct {
+		seconds float64
+		end     bool
+		want    float64
+	}{
+		{0, false, 0},
+		{5, false, 5},
+		// On the first cut, a start is after the silence and an end before it
+		{10.5, false, 19.5},
+		{10.5, true, 10.5},
+		{12, false, 21},
+		{12, true, 21},
+		{41.5, false, 69.5},
+		{41.5, true, 50.5},
+		{50, false, 78},
+		{72, true, 100},
+	}
+	for _, test := range tests {
+		if got := timeline.originalTime(test.seconds, test.end); got != test.want {
+			t.Errorf("originalTime(%v, %v) = %v, want %v", test.seconds, test.end, got, test.want)
+		}
+	}
+	if got := audioTimeline(nil).originalTime(12, false); got != 12 {
+		t.Errorf("empty timeline moved 12 to %v", got)
+	}
+}
+
+func TestAudioTimelineRestore(t *testing.T) {
+	timeline := keptRegions(100, []silence{{10, 20}, {50, 70}}, 2, 0.5)
+	segments := []TranscriptEntry{
+		{Start: 2, End: 10.5, Text: "Before the first cut."},
+		{Start: 10.5, End: 12, Text: "Across it", Words: []TranscriptWord{
+			{Word: "Across", Start: 10, End: 10.5},
+			{Word: "it", Start: 10.5, End: 12},
+		}},
+		{Start: 45, End: 50, Text: "After the second cut."},
+	}
+	want := []TranscriptEntry{
+		{Start: 2, End: 10.5, Text: "Before the first cut."},
+		{Start: 19.5, End: 21, Text: "Across it", Words: []TranscriptWord{
+			{Word: "Across", Start: 10, End: 10.5},
+			{Word: "it", Start: 19.5, End: 21},
+		}},
+		{Start: 73, End: 78, Text: "After the second cut."},
+	}
+	if got := timeline.restore(segments); !reflect.DeepEqual(got, want) {
+		t.Errorf("restore = %+v, want %+v", got, want)
+	}
+	if segments[1].Words[1].Start != 10.5 {
+		t.Errorf("restore changed the engine's words: %+v", segments[1].Words)
+	}
+	if got := audioTimeline(nil).restore(segments); !reflect.DeepEqual(got, segments) {
+		t.Errorf("empty timeline moved segments: %+v", got)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Transcription progress is measured in seconds of audio processed. Engines
+// that print segments as they go ("[00:01.000 --> 00:04.500] text", as both
+// whisperx and whisper.cpp do) report it through the job's context, and the
+// queue turns it into a percentage using the audio duration from ffprobe.
+
+type progressKey struct{}
+
+// Attach a progress callback to a job's context
+func withProgress(ctx context.Context, report func(seconds float64)) context.Context {
+	return context.WithValue(ctx, progressKey{}, report)
+}
+
+// Report how many seconds of audio have been transcribed so far
+func reportProgress(ctx context.Context, seconds float64) {
+	if report, ok := ctx.Value(progressKey{}).(func(float64)); ok {
+		report(seconds)
+	}
+}
+
+// Matches the end time of a segment line in engine output
+var segmentTimePattern = regexp.MustCompile(`\[[0-9:.,]+ --> ([0-9:.,]+)\]`)
+
+// progressWriter scans engine output line by line and reports the end time
+// of every segment it prints
+type progressWriter struct {
+	ctx  context.Context
+	line []byte
+}
+
+func newProgressWriter(ctx context.Context) *progressWriter {
+	return &progressWriter{ctx: ctx}
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	for _, b := range p {
+		if b != '\n' && b != '\r' {
+			w.line = append(w.line, b)
+			continue
+		}
+		if match := segmentTimePattern.FindSubmatch(w.line); match != nil {
+			if seconds, err := parseTimestamp(string(match[1])); err == nil {
+				reportProgress(w.ctx, seconds)
+			}
+		}
+		w.line = w.line[:0]
+	}
+	return len(p), nil
+}
+
+// Parse a "[hh:]mm:ss.fff" timestamp into seconds
+func parseTimestamp(timestamp string) (float64, error) {
+	seconds := 0.0
+	for _, part := range strings.Split(strings.ReplaceAll(timestamp, ",", "."), ":") {
+		value, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid timestamp: %s", timestamp)
+		}
+		seconds = seconds*60 + value
+	}
+	return seconds, nil
+}
+
+// Get the duration of an audio or video file in seconds using ffprobe
+func probeAudioDuration(ctx context.Context, filePath string) (float64, error) {
+	cmd := exec.CommandContext(ctx, "ffprobe", "-v", "error", "-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1", filePath)
+	output, err := cmd.Output()
+	if err != nil {
+		return 0, fmt.Errorf("ffprobe error: %v", err)
+	}
+
+	duration, err := strconv.ParseFloat(strings.TrimSpace(string(output)), 64)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse duration: %v", err)
+	}
+	return duration, nil
+}
@@ -0,0 +1,472 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Resumable uploads follow the shape of the tus protocol: a POST creates an
+// upload, PATCH requests append chunks at the offset reported by HEAD, and the
+// file is only moved into the media directory once every byte has arrived.
+// Every chunk carries an Upload-Checksum header and is only kept if it
+// matches, so a corrupted chunk is never written into the upload; the
+// optional checksum of the whole file is verified once it is complete.
+
+const (
+	uploadsDir = "./data/uploads"
+
+	// Partial uploads untouched for this long are removed at startup
+	staleUploadAge = 7 * 24 * time.Hour
+
+	// Temporary files of form uploads, left behind only by a crash
+	multipartUploadPattern = "multipart-*.tmp"
+)
+
+// UploadSession represents an in-progress resumable upload
+type UploadSession struct {
+	ID       string `json:"id"`
+	Filename string `json:"filename"`
+	Size     int64  `json:"size"`
+	Checksum string `json:"checksum,omitempty"` // hex-encoded SHA-256 of the whole file
+	Priority int    `json:"priority,omitempty"` // Transcription priority once complete
+	Language string `json:"language,omitempty"` // Language of the recording, if known
+	// Transcribe even if the recording has sidecar subtitles
+	Transcribe bool   `json:"transcribe,omitempty"`
+	Offset     int64  `json:"offset"`
+	CreatedAt  string `json:"createdAt"`
+}
+
+// CreateUploadRequest represents the request body for starting a resumable upload
+type CreateUploadRequest struct {
+	Filename   string `json:"filename"`
+	Size       int64  `json:"size"`
+	Checksum   string `json:"checksum"`
+	Priority   int    `json:"priority"`
+	Language   string `json:"language"`
+	Transcribe bool   `json:"transcribe"`
+}
+
+// Per-upload locks so two PATCH requests can't write the same file at once
+var uploadLocks sync.Map
+
+// A complete upload whose content doesn't match the checksum it was created
+// with; it can't be resumed and is discarded
+var errUploadChecksum = errors.New("upload does not match its checksum")
+
+// Initialize the resumable upload system
+func InitResumableUploads() {
+	if err := os.MkdirAll(uploadsDir, 0755); err != nil {
+		log.Fatalf("Failed to create uploads directory: %v", err)
+	}
+
+	cleanupStaleUploads()
+}
+
+// Remove partial uploads that haven't been touched in a long time
+func cleanupStaleUploads() {
+	files, err := os.ReadDir(uploadsDir)
+	if err != nil {
+		log.Printf("Failed to read uploads directory: %v", err)
+		return
+	}
+
+	for _, file := range files {
+		if matched, _ := filepath.Match(multipartUploadPattern, file.Name()); matched {
+			os.Remove(filepath.Join(uploadsDir, file.Name()))
+			continue
+		}
+		if !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		id := strings.TrimSuffix(file.Name(), ".json")
+
+		// The partial file is appended to on every chunk, so its mtime is the last activity
+		lastActivity := time.Time{}
+		if info, err := os.Stat(uploadPartPath(id)); err == nil {
+			lastActivity = info.ModTime()
+		} else if info, err := file.Info(); err == nil {
+			lastActivity = info.ModTime()
+		}
+
+		if time.Since(lastActivity) > staleUploadAge {
+			log.Printf("Removing stale upload %s", id)
+			unlock := lockUploadSession(id)
+			removeUploadSession(id)
+			unlock()
+		}
+	}
+}
+
+func uploadSessionPath(id string) string {
+	return filepath.Join(uploadsDir, id+".json")
+}
+
+func uploadPartPath(id string) string {
+	return filepath.Join(uploadsDir, id+".part")
+}
+
+// Lock an upload session, returning the function that unlocks it. The lock
+// is forgotten once it is released after the session was removed; requests
+// still waiting on it then find no session.
+func lockUploadSession(id string) func() {
+	value, _ := uploadLocks.LoadOrStore(id, &sync.Mutex{})
+	mu := value.(*sync.Mutex)
+	mu.Lock()
+	return func() {
+		mu.Unlock()
+		if _, err := os.Stat(uploadSessionPath(id)); os.IsNotExist(err) {
+			uploadLocks.CompareAndDelete(id, mu)
+		}
+	}
+}
+
+// Load an upload session from disk.
+// The offset is taken from the size of the partial file, so bytes written
+// before a dropped connection or a server restart are never lost.
+func loadUploadSession(id string) (*UploadSession, error) {
+	data, err := os.ReadFile(uploadSessionPath(id))
+	if err != nil {
+		return nil, err
+	}
+
+	var session UploadSession
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, fmt.Errorf("failed to parse upload session: %v", err)
+	}
+
+	info, err := os.Stat(uploadPartPath(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat partial upload: %v", err)
+	}
+	session.Offset = info.Size()
+
+	return &session, nil
+}
+
+func saveUploadSession(session *UploadSession) error {
+	data, err := json.MarshalIndent(session, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal upload session: %v", err)
+	}
+	return os.WriteFile(uploadSessionPath(session.ID), data, 0644)
+}
+
+// Remove an upload's files. The caller holds the session's lock.
+func removeUploadSession(id string) {
+	os.Remove(uploadPartPath(id))
+	os.Remove(uploadSessionPath(id))
+}
+
+// Parse an Upload-Checksum header ("sha256 <base64 digest>")
+func parseUploadChecksum(header string) ([]byte, error) {
+	if header == "" {
+		return nil, fmt.Errorf("Upload-Checksum header is required")
+	}
+	algorithm, encoded, _ := strings.Cut(header, " ")
+	if algorithm != "sha256" {
+		return nil, fmt.Errorf("unsupported checksum algorithm %q", algorithm)
+	}
+	digest, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(digest) != sha256.Size {
+		return nil, fmt.Errorf("Upload-Checksum must be a base64-encoded SHA-256 digest")
+	}
+	return digest, nil
+}
+
+// Compute the hex-encoded SHA-256 of a file without loading it into memory
+func fileSHA256(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Verify a completed upload, move it into the media directory and run the
+// normal ingest pipeline
+func finalizeUpload(session *UploadSession) (UploadFileResponse, error) {
+	partPath := uploadPartPath(session.ID)
+
+	if session.Checksum != "" {
+		sum, err := fileSHA256(partPath)
+		if err != nil {
+			return UploadFileResponse{}, fmt.Errorf("failed to checksum upload: %v", err)
+		}
+		if !strings.EqualFold(sum, session.Checksum) {
+			return UploadFileResponse{}, fmt.Errorf("%w: expected %s, got %s", errUploadChecksum, session.Checksum, sum)
+		}
+	}
+
+	filename, err := moveIntoLibrary(partPath, session.Filename)
+	if err != nil {
+		return UploadFileResponse{}, err
+	}
+	libraryPath := filepath.Join(mediaDir, filename)
+
+	var response UploadFileResponse
+	if isSubtitleFile(filename) {
+		response, err = ingestSubtitleFile(filename)
+	} else {
+		response, err = ingestMediaFile(filename, IngestOptions{
+			Priority:   session.Priority,
+			Language:   session.Language,
+			Transcribe: session.Transcribe,
+		})
+	}
+	if err != nil {
+		// Put the file back so finalizing can be tried again
+		if renameErr := os.Rename(libraryPath, partPath); renameErr != nil {
+			log.Printf("Error returning %s to upload %s: %v", filename, session.ID, renameErr)
+		}
+		return UploadFileResponse{}, err
+	}
+	return response, nil
+}
+
+// Write the offset headers clients use to resume an upload
+func setUploadHeaders(w http.ResponseWriter, session *UploadSession) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(session.Size, 10))
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+// Move an uploaded file into the media directory as name, returning the
+// name it got. A library file is never replaced: subtitles must keep their
+// name to match their recording, other files get a free name.
+func moveIntoLibrary(source, name string) (string, error) {
+	filename := name
+	if isSubtitleFile(filename) {
+		if _, err := os.Stat(filepath.Join(mediaDir, filename)); err == nil {
+			return "", fmt.Errorf("subtitles %s already in library", filename)
+		}
+	} else {
+		var existing bool
+		var err error
+		filename, existing, err = chooseLibraryFilename(source, filename, nil)
+		if err != nil {
+			return "", err
+		}
+		if existing {
+			return "", fmt.Errorf("identical file already in library as %s", filename)
+		}
+	}
+
+	if err := os.Rename(source, filepath.Join(mediaDir, filename)); err != nil {
+		return "", fmt.Errorf("failed to move upload into media directory: %v", err)
+	}
+	return filename, nil
+}
+
+// Handler for creating a resumable upload
+func handleCreateUpload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req CreateUploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	filename := filepath.Base(req.Filename)
+	if req.Filename == "" || filename == "." || filename == ".." || filename == string(filepath.Separator) {
+		http.Error(w, "A valid filename is required", http.StatusBadRequest)
+		return
+	}
+	if req.Size <= 0 {
+		http.Error(w, "Size must be greater than zero", http.StatusBadRequest)
+		return
+	}
+	checksum := strings.ToLower(strings.TrimPrefix(req.Checksum, "sha256:"))
+	if checksum != "" {
+		if decoded, err := hex.DecodeString(checksum); err != nil || len(decoded) != sha256.Size {
+			http.Error(w, "Checksum must be a hex-encoded SHA-256 digest", http.StatusBadRequest)
+			return
+		}
+	}
+
+	language, err := normalizeLanguage(req.Language)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	session := &UploadSession{
+		ID:         fmt.Sprintf("%d", time.Now().UnixNano()),
+		Filename:   filename,
+		Size:       req.Size,
+		Checksum:   checksum,
+		Priority:   req.Priority,
+		Language:   language,
+		Transcribe: req.Transcribe,
+		CreatedAt:  time.Now().Format(time.RFC3339),
+	}
+
+	// Create the empty partial file before the session so a session never lacks one
+	part, err := os.Create(uploadPartPath(session.ID))
+	if err != nil {
+		log.Printf("Error creating partial upload for %s: %v", filename, err)
+		http.Error(w, "Failed to create upload", http.StatusInternalServerError)
+		return
+	}
+	part.Close()
+
+	if err := saveUploadSession(session); err != nil {
+		log.Printf("Error saving upload session for %s: %v", filename, err)
+		removeUploadSession(session.ID)
+		http.Error(w, "Failed to create upload", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("Created resumable upload %s for %s (%d bytes)", session.ID, filename, session.Size)
+
+	setUploadHeaders(w, session)
+	w.Header().Set("Location", "/api/uploads/"+session.ID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(session)
+}
+
+// Handler for querying, appending to and aborting a resumable upload
+func handleUploadSession(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, "/api/uploads/")
+	if id == "" || strings.Contains(id, "/") {
+		http.Error(w, "Upload ID required", http.StatusBadRequest)
+		return
+	}
+
+	unlock := lockUploadSession(id)
+	defer unlock()
+
+	session, err := loadUploadSession(id)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, "Upload not found", http.StatusNotFound)
+		} else {
+			log.Printf("Error loading upload %s: %v", id, err)
+			http.Error(w, "Failed to load upload", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	switch r.Method {
+	case http.MethodHead:
+		setUploadHeaders(w, session)
+		w.WriteHeader(http.StatusOK)
+
+	case http.MethodGet:
+		setUploadHeaders(w, session)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(session)
+
+	case http.MethodPatch:
+		appendUploadChunk(w, r, session)
+
+	case http.MethodDelete:
+		removeUploadSession(id)
+		log.Printf("Aborted resumable upload %s for %s", id, session.Filename)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Append a chunk to an upload, finalizing it once the last byte arrives
+func appendUploadChunk(w http.ResponseWriter, r *http.Request, session *UploadSession) {
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		http.Error(w, "Upload-Offset header is required", http.StatusBadRequest)
+		return
+	}
+	if offset != session.Offset {
+		setUploadHeaders(w, session)
+		http.Error(w, "Upload-Offset does not match the current offset", http.StatusConflict)
+		return
+	}
+	expected, err := parseUploadChecksum(r.Header.Get("Upload-Checksum"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	part, err := os.OpenFile(uploadPartPath(session.ID), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		log.Printf("Error opening partial upload %s: %v", session.ID, err)
+		http.Error(w, "Failed to open upload", http.StatusInternalServerError)
+		return
+	}
+
+	// Stream the chunk straight to disk, never past the declared size
+	hash := sha256.New()
+	remaining := session.Size - session.Offset
+	written, copyErr := io.Copy(io.MultiWriter(part, hash), io.LimitReader(r.Body, remaining))
+	part.Close()
+
+	if copyErr != nil || !bytes.Equal(hash.Sum(nil), expected) {
+		// A chunk that can't be verified is dropped; the client sends it again
+		if err := os.Truncate(uploadPartPath(session.ID), session.Offset); err != nil {
+			log.Printf("Error truncating upload %s: %v", session.ID, err)
+		}
+		setUploadHeaders(w, session)
+		if copyErr != nil {
+			log.Printf("Upload %s interrupted at offset %d: %v", session.ID, session.Offset, copyErr)
+			http.Error(w, "Failed to read chunk", http.StatusBadRequest)
+		} else {
+			// Status of the tus checksum extension for a mismatch
+			http.Error(w, "Upload-Checksum does not match the chunk", 460)
+		}
+		return
+	}
+	session.Offset += written
+
+	if session.Offset < session.Size {
+		setUploadHeaders(w, session)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// Every byte has arrived: verify, move into place and ingest
+	log.Printf("Upload %s for %s complete, finalizing", session.ID, session.Filename)
+	response, err := finalizeUpload(session)
+	if err != nil {
+		log.Printf("Error finalizing upload %s for %s: %v", session.ID, session.Filename, err)
+		setUploadHeaders(w, session)
+		if errors.Is(err, errUploadChecksum) {
+			removeUploadSession(session.ID)
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		// Anything else may pass: the upload is kept, and a PATCH at its
+		// final offset with an empty chunk finalizes it again
+		http.Error(w, "Failed to finalize upload: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	removeUploadSession(session.ID)
+
+	setUploadHeaders(w, session)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
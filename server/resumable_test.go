@@ -0,0 +1,250 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Run upload tests in an empty data directory
+func setupUploadTest(t *testing.T) {
+	t.Helper()
+	setupTranscriptionTest(t)
+	if err := os.MkdirAll(uploadsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Create a resumable upload of content, with the checksum of the whole file
+// if whole is set, and return its ID
+func createUpload(t *testing.T, filename string, content []byte, whole bool) string {
+	t.Helper()
+	req := CreateUploadRequest{Filename: filename, Size: int64(len(content))}
+	if whole {
+		sum := sha256.Sum256(content)
+		req.Checksum = hex.EncodeToString(sum[:])
+	}
+	body, _ := json.Marshal(req)
+	w := httptest.NewRecorder()
+	handleCreateUpload(w, httptest.NewRequest(http.MethodPost, "/api/uploads", bytes.NewReader(body)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("create upload: %d %s", w.Code, w.Body)
+	}
+	return strings.TrimPrefix(w.Header().Get("Location"), "/api/uploads/")
+}
+
+func uploadChecksum(chunk []byte) string {
+	sum := sha256.Sum256(chunk)
+	return "sha256 " + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Send a request to an upload. A PATCH body is sent at offset with the
+// given Upload-Checksum header, if any.
+func uploadRequest(t *testing.T, method, id string, offset int64, body io.Reader, checksum string) *httptest.ResponseRecorder {
+	t.Helper()
+	r := httptest.NewRequest(method, "/api/uploads/"+id, body)
+	if method == http.MethodPatch {
+		r.Header.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+		if checksum != "" {
+			r.Header.Set("Upload-Checksum", checksum)
+		}
+	}
+	w := httptest.NewRecorder()
+	handleUploadSession(w, r)
+	return w
+}
+
+func patchChunk(t *testing.T, id string, offset int64, chunk []byte) *httptest.ResponseRecorder {
+	t.Helper()
+	return uploadRequest(t, http.MethodPatch, id, offset, bytes.NewReader(chunk), uploadChecksum(chunk))
+}
+
+func responseOffset(t *testing.T, w *httptest.ResponseRecorder) int64 {
+	t.Helper()
+	offset, err := strconv.ParseInt(w.Header().Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		t.Fatalf("response has no Upload-Offset: %v", w.Header())
+	}
+	return offset
+}
+
+// A body that fails after its data, like a dropped connection
+type droppedBody struct{ data io.Reader }
+
+func (b droppedBody) Read(p []byte) (int, error) {
+	n, err := b.data.Read(p)
+	if err == io.EOF {
+		return n, errors.New("connection reset")
+	}
+	return n, err
+}
+
+func TestUploadChunkOffsetsAndChecksums(t *testing.T) {
+	setupUploadTest(t)
+	content := []byte("0123456789abcdefghij")
+	id := createUpload(t, "notes.dat", content, true)
+
+	if w := patchChunk(t, id, 5, content[5:10]); w.Code != http.StatusConflict || responseOffset(t, w) != 0 {
+		t.Errorf("chunk at the wrong offset: %d, offset %s", w.Code, w.Header().Get("Upload-Offset"))
+	}
+	if w := uploadRequest(t, http.MethodPatch, id, 0, bytes.NewReader(content[:5]), ""); w.Code != http.StatusBadRequest {
+		t.Errorf("chunk without a checksum: %d", w.Code)
+	}
+
+	if w := patchChunk(t, id, 0, content[:10]); w.Code != http.StatusNoContent || responseOffset(t, w) != 10 {
+		t.Fatalf("first chunk: %d, offset %s", w.Code, w.Header().Get("Upload-Offset"))
+	}
+
+	// A chunk corrupted on the way is dropped
+	corrupted := []byte("ABCDEfghij")
+	w := uploadRequest(t, http.MethodPatch, id, 10, bytes.NewReader(corrupted), uploadChecksum(content[10:]))
+	if w.Code != 460 || responseOffset(t, w) != 10 {
+		t.Errorf("corrupted chunk: %d, offset %s", w.Code, w.Header().Get("Upload-Offset"))
+	}
+	if info, err := os.Stat(uploadPartPath(id)); err != nil || info.Size() != 10 {
+		t.Errorf("partial upload not truncated to the last good chunk: %v, %v", info.Size(), err)
+	}
+	if w := uploadRequest(t, http.MethodHead, id, 0, nil, ""); responseOffset(t, w) != 10 {
+		t.Errorf("HEAD reports offset %s after a corrupted chunk", w.Header().Get("Upload-Offset"))
+	}
+
+	w = patchChunk(t, id, 10, content[10:])
+	if w.Code != http.StatusOK {
+		t.Fatalf("last chunk: %d %s", w.Code, w.Body)
+	}
+	if data, err := os.ReadFile(filepath.Join(mediaDir, "notes.dat")); err != nil || !bytes.Equal(data, content) {
+		t.Errorf("library file has %q, %v", data, err)
+	}
+	for _, path := range []string{uploadSessionPath(id), uploadPartPath(id)} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s left after the upload finished: %v", path, err)
+		}
+	}
+}
+
+func TestUploadResumesAfterPartialChunk(t *testing.T) {
+	setupUploadTest(t)
+	content := []byte("0123456789abcdefghij")
+	id := createUpload(t, "notes.dat", content, true)
+
+	// A connection dropped half way through a chunk keeps nothing of it
+	body := droppedBody{data: bytes.NewReader(content[:7])}
+	if w := uploadRequest(t, http.MethodPatch, id, 0, body, uploadChecksum(content[:10])); w.Code != http.StatusBadRequest {
+		t.Errorf("interrupted chunk: %d", w.Code)
+	}
+	if w := uploadRequest(t, http.MethodHead, id, 0, nil, ""); responseOffset(t, w) != 0 {
+		t.Errorf("offset %s after an interrupted chunk", w.Header().Get("Upload-Offset"))
+	}
+
+	// Bytes on disk when the server stopped count, and the client resumes
+	// from the offset HEAD reports
+	if err := os.WriteFile(uploadPartPath(id), content[:4], 0644); err != nil {
+		t.Fatal(err)
+	}
+	offset := responseOffset(t, uploadRequest(t, http.MethodHead, id, 0, nil, ""))
+	if offset != 4 {
+		t.Fatalf("HEAD reports offset %d, want 4", offset)
+	}
+	if w := patchChunk(t, id, offset, content[offset:]); w.Code != http.StatusOK {
+		t.Fatalf("resumed chunk: %d %s", w.Code, w.Body)
+	}
+	if data, err := os.ReadFile(filepath.Join(mediaDir, "notes.dat")); err != nil || !bytes.Equal(data, content) {
+		t.Errorf("library file has %q, %v", data, err)
+	}
+}
+
+func TestUploadFinalize(t *testing.T) {
+	setupUploadTest(t)
+
+	// A file that doesn't match its checksum can't be saved by resuming
+	id := createUpload(t, "notes.dat", []byte("expected"), true)
+	if w := patchChunk(t, id, 0, []byte("received")); w.Code != http.StatusUnprocessableEntity {
+		t.Errorf("file with the wrong checksum: %d", w.Code)
+	}
+	if _, err := os.Stat(uploadSessionPath(id)); !os.IsNotExist(err) {
+		t.Errorf("corrupt upload kept: %v", err)
+	}
+
+	// A different file with the same name gets a free name
+	if err := os.WriteFile(filepath.Join(mediaDir, "notes.dat"), []byte("already here"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	id = createUpload(t, "notes.dat", []byte("new notes"), false)
+	w := patchChunk(t, id, 0, []byte("new notes"))
+	var response UploadFileResponse
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &response) != nil || response.Filename != "notes-1.dat" {
+		t.Errorf("upload of a taken name: %d %s", w.Code, w.Body)
+	}
+	if data, _ := os.ReadFile(filepath.Join(mediaDir, "notes.dat")); string(data) != "already here" {
+		t.Errorf("library file replaced with %q", data)
+	}
+
+	// When processing fails the upload is kept, and an empty chunk at the
+	// end finalizes it again
+	id = createUpload(t, "later.dat", []byte("later"), true)
+	if err := os.Rename(metadataDir, metadataDir+".away"); err != nil {
+		t.Fatal(err)
+	}
+	if w := patchChunk(t, id, 0, []byte("later")); w.Code != http.StatusInternalServerError {
+		t.Errorf("upload that failed to process: %d %s", w.Code, w.Body)
+	}
+	if _, err := os.Stat(filepath.Join(mediaDir, "later.dat")); !os.IsNotExist(err) {
+		t.Errorf("unprocessed file left in the library: %v", err)
+	}
+	if offset := responseOffset(t, uploadRequest(t, http.MethodHead, id, 0, nil, "")); offset != 5 {
+		t.Errorf("kept upload has offset %d, want 5", offset)
+	}
+	if err := os.Rename(metadataDir+".away", metadataDir); err != nil {
+		t.Fatal(err)
+	}
+	if w := patchChunk(t, id, 5, nil); w.Code != http.StatusOK {
+		t.Fatalf("finalizing again: %d %s", w.Code, w.Body)
+	}
+	if data, _ := os.ReadFile(filepath.Join(mediaDir, "later.dat")); string(data) != "later" {
+		t.Errorf("library file has %q", data)
+	}
+}
+
+func TestFormUploadKeepsLibraryFiles(t *testing.T) {
+	setupUploadTest(t)
+	if err := os.WriteFile(filepath.Join(mediaDir, "notes.dat"), []byte("already here"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	part, _ := form.CreateFormFile("files", "notes.dat")
+	part.Write([]byte("new notes"))
+	form.Close()
+	r := httptest.NewRequest(http.MethodPost, "/api/upload", &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	w := httptest.NewRecorder()
+	handleUpload(w, r)
+
+	var response struct{ Files []UploadFileResponse }
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &response) != nil ||
+		len(response.Files) != 1 || response.Files[0].Filename != "notes-1.dat" {
+		t.Fatalf("form upload of a taken name: %d %s", w.Code, w.Body)
+	}
+	if data, _ := os.ReadFile(filepath.Join(mediaDir, "notes.dat")); string(data) != "already here" {
+		t.Errorf("library file replaced with %q", data)
+	}
+	if data, _ := os.ReadFile(filepath.Join(mediaDir, "notes-1.dat")); string(data) != "new notes" {
+		t.Errorf("uploaded file has %q", data)
+	}
+	if temp, _ := filepath.Glob(filepath.Join(uploadsDir, multipartUploadPattern)); len(temp) != 0 {
+		t.Errorf("temporary files left: %v", temp)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"time"
+)
+
+// Longest wait between automatic retries
+const maxRetryDelay = time.Hour
+
+// permanentErr marks a transcription error that retrying can't fix,
+// such as an unsupported file type
+type permanentErr struct {
+	err error
+}
+
+func (e *permanentErr) Error() string { return e.err.Error() }
+func (e *permanentErr) Unwrap() error { return e.err }
+
+// Wrap an error to mark it as permanent
+func permanentError(err error) error {
+	return &permanentErr{err: err}
+}
+
+// Whether an error, or any error it wraps, is permanent
+func isPermanentError(err error) bool {
+	var permanent *permanentErr
+	return errors.As(err, &permanent)
+}
+
+// Delay before the retry that follows the given attempt: the configured base
+// delay doubled for every earlier attempt
+func retryDelay(attempt int) time.Duration {
+	delay := time.Duration(AppConfig.Transcription.RetryDelaySeconds) * time.Second
+	for i := 1; i < attempt && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
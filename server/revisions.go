@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Every change to an item's transcript is kept as a numbered revision in
+// data/revisions/<filename>/, one file per revision so recording an edit
+// never rewrites the whole history. Revisions are a single history of the
+// item's active transcript across its versions (see versions.go): each
+// records the version it changed, a new transcription or a switch to
+// another version is a revision too, and its diff is against whatever was
+// active before. Restoring a revision puts its segments into the version
+// that is active now.
+const revisionsDir = "./data/revisions"
+
+// TranscriptRevision is one saved version of an item's transcript
+type TranscriptRevision struct {
+	Revision     int               `json:"revision"`
+	Author       string            `json:"author"`
+	CreatedAt    string            `json:"createdAt"`
+	Action       string            `json:"action"`            // "transcribe", "edit", "split", "merge", "restore", "revert-cleanup" or "activate-version"
+	Version      int               `json:"version,omitempty"` // Transcript version the segments belong to
+	RestoredFrom int               `json:"restoredFrom,omitempty"`
+	Diff         []SegmentChange   `json:"diff"` // Changes from the previous revision
+	Segments     []TranscriptEntry `json:"segments,omitempty"`
+}
+
+// SegmentChange is a segment that differs between two revisions
+type SegmentChange struct {
+	Type   string           `json:"type"`  // "changed", "added" or "removed"
+	Index  int              `json:"index"` // Position in the newer revision, or in the older one for removals
+	Before *TranscriptEntry `json:"before,omitempty"`
+	After  *TranscriptEntry `json:"after,omitempty"`
+}
+
+func revisionDir(filename string) string {
+	return filepath.Join(revisionsDir, filename)
+}
+
+func revisionFilePath(filename string, revision int) string {
+	return filepath.Join(revisionDir(filename), fmt.Sprintf("%06d.json", revision))
+}
+
+// Revision numbers saved for an item, oldest first
+func listRevisionNumbers(filename string) ([]int, error) {
+	return listNumberedFiles(revisionDir(filename))
+}
+
+// Numbers of the <number>.json files in a directory, lowest first
+func listNumberedFiles(dir string) ([]int, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var numbers []int
+	for _, file := range files {
+		name := strings.TrimSuffix(file.Name(), ".json")
+		if file.IsDir() || name == file.Name() {
+			continue
+		}
+		number, err := strconv.Atoi(name)
+		if err != nil {
+			continue
+		}
+		numbers = append(numbers, number)
+	}
+	sort.Ints(numbers)
+	return numbers, nil
+}
+
+// Read a single revision. Returns an os.ErrNotExist error if it doesn't exist.
+func readTranscriptRevision(filename string, revision int) (TranscriptRevision, error) {
+	data, err := os.ReadFile(revisionFilePath(filename, revision))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return TranscriptRevision{}, fmt.Errorf("revision %d of %s: %w", revision, filename, os.ErrNotExist)
+		}
+		return TranscriptRevision{}, fmt.Errorf("failed to read revision: %v", err)
+	}
+
+	var rev TranscriptRevision
+	if err := json.Unmarshal(data, &rev); err != nil {
+		return TranscriptRevision{}, fmt.Errorf("failed to parse revision: %v", err)
+	}
+	return rev, nil
+}
+
+// Read every revision of an item without their segments, oldest first
+func listTranscriptRevisions(filename string) ([]TranscriptRevision, error) {
+	numbers, err := listRevisionNumbers(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	revisions := []TranscriptRevision{}
+	for _, number := range numbers {
+		rev, err := readTranscriptRevision(filename, number)
+		if err != nil {
+			log.Printf("Skipping revision %d of %s: %v", number, filename, err)
+			continue
+		}
+		rev.Segments = nil
+		revisions = append(revisions, rev)
+	}
+	return revisions, nil
+}
+
+// Save segments of a transcript version as the item's newest revision,
+// recording how they differ from the previous one. Caller must hold
+// metadataMu, so two changes can't take the same number.
+func saveTranscriptRevision(filename, author, action string, version, restoredFrom int, segments []TranscriptEntry) (TranscriptRevision, error) {
+	numbers, err := listRevisionNumbers(filename)
+	if err != nil {
+		return TranscriptRevision{}, err
+	}
+
+	var previous []TranscriptEntry
+	number := 1
+	if len(numbers) > 0 {
+		last := numbers[len(numbers)-1]
+		number = last + 1
+		if rev, err := readTranscriptRevision(filename, last); err == nil {
+			previous = rev.Segments
+		} else {
+			log.Printf("Failed to read previous revision of %s: %v", filename, err)
+		}
+	}
+
+	rev := TranscriptRevision{
+		Revision:     number,
+		Author:       author,
+		CreatedAt:    time.Now().Format(time.RFC3339),
+		Action:       action,
+		Version:      version,
+		RestoredFrom: restoredFrom,
+		Diff:         diffSegments(previous, segments),
+		Segments:     segments,
+	}
+
+	data, err := json.MarshalIndent(rev, "", "  ")
+	if err != nil {
+		return TranscriptRevision{}, fmt.Errorf("failed to marshal revision: %v", err)
+	}
+	if err := os.MkdirAll(revisionDir(filename), 0755); err != nil {
+		return TranscriptRevision{}, fmt.Errorf("failed to create revisions directory: %v", err)
+	}
+	// Never replace a revision, even if the lock was bypassed
+	file, err := os.OpenFile(revisionFilePath(filename, number), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return TranscriptRevision{}, fmt.Errorf("failed to create revision: %v", err)
+	}
+	_, err = file.Write(data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return TranscriptRevision{}, fmt.Errorf("failed to write revision: %v", err)
+	}
+
+	rev.Segments = nil
+	return rev, nil
+}
+
+// Whether two segments read the same, ignoring numbering and word timings
+func sameSegment(a, b TranscriptEntry) bool {
+	return a.Start == b.Start && a.End == b.End && a.Text == b.Text && a.Speaker == b.Speaker
+}
+
+// List the segments that differ between two versions of a transcript.
+// Segments outside the unchanged start and end are paired in order, so an
+// edit shows up as one change, a split as a change plus an addition and a
+// merge as a change plus a removal.
+func diffSegments(before, after []TranscriptEntry) []SegmentChange {
+	prefix := 0
+	for prefix < len(before) && prefix < len(after) && sameSegment(before[prefix], after[prefix]) {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(before)-prefix && suffix < len(after)-prefix &&
+		sameSegment(before[len(before)-1-suffix], after[len(after)-1-suffix]) {
+		suffix++
+	}
+
+	oldSegments := segmentsWithoutWords(before[prefix : len(before)-suffix])
+	newSegments := segmentsWithoutWords(after[prefix : len(after)-suffix])
+
+	changes := []SegmentChange{}
+	for i := 0; i < len(oldSegments) || i < len(newSegments); i++ {
+		switch {
+		case i < len(oldSegments) && i < len(newSegments):
+			changes = append(changes, SegmentChange{Type: "changed", Index: prefix + i, Before: &oldSegments[i], After: &newSegments[i]})
+		case i < len(newSegments):
+			changes = append(changes, SegmentChange{Type: "added", Index: prefix + i, After: &newSegments[i]})
+		default:
+			changes = append(changes, SegmentChange{Type: "removed", Index: prefix + i, Before: &oldSegments[i]})
+		}
+	}
+	return changes
+}
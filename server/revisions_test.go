@@ -0,0 +1,128 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffSegments(t *testing.T) {
+	a := TranscriptEntry{Start: 0, End: 2, Text: "One."}
+	b := TranscriptEntry{Start: 2, End: 6, Text: "Two and three."}
+	c := TranscriptEntry{Start: 6, End: 8, Text: "Four."}
+	bEdited := TranscriptEntry{Start: 2, End: 6, Text: "Two and 3."}
+	bFirst := TranscriptEntry{Start: 2, End: 4, Text: "Two"}
+	bSecond := TranscriptEntry{Start: 4, End: 6, Text: "and three."}
+	bWithWords := b
+	bWithWords.Words = []TranscriptWord{{Word: "Two", Start: 2, End: 3}}
+	bWithWords.Segment = 7
+	d := TranscriptEntry{Start: 8, End: 9, Text: "Five."}
+
+	type change struct {
+		kind          string
+		index         int
+		before, after string
+	}
+	tests := []struct {
+		name          string
+		before, after []TranscriptEntry
+		want          []change
+	}{
+		{"unchanged", []TranscriptEntry{a, b, c}, []TranscriptEntry{a, b, c}, nil},
+		{"numbering and words ignored", []TranscriptEntry{a, b, c}, []TranscriptEntry{a, bWithWords, c}, nil},
+		{"edit", []TranscriptEntry{a, b, c}, []TranscriptEntry{a, bEdited, c}, []change{{"changed", 1, "Two and three.", "Two and 3."}}},
+		{"split", []TranscriptEntry{a, b, c}, []TranscriptEntry{a, bFirst, bSecond, c}, []change{
+			{"changed", 1, "Two and three.", "Two"},
+			{"added", 2, "", "and three."},
+		}},
+		{"merge", []TranscriptEntry{a, bFirst, bSecond, c}, []TranscriptEntry{a, b, c}, []change{
+			{"changed", 1, "Two", "Two and three."},
+			{"removed", 2, "and three.", ""},
+		}},
+		{"added at the end", []TranscriptEntry{a, b}, []TranscriptEntry{a, b, c, d}, []change{
+			{"added", 2, "", "Four."},
+			{"added", 3, "", "Five."},
+		}},
+		{"removed at the start", []TranscriptEntry{a, b, c}, []TranscriptEntry{b, c}, []change{{"removed", 0, "One.", ""}}},
+		{"all new", nil, []TranscriptEntry{a}, []change{{"added", 0, "", "One."}}},
+	}
+	for _, test := range tests {
+		var got []change
+		for _, c := range diffSegments(test.before, test.after) {
+			var before, after string
+			if c.Before != nil {
+				before = c.Before.Text
+				if c.Before.Words != nil {
+					t.Errorf("%s: change has words: %+v", test.name, c.Before)
+				}
+			}
+			if c.After != nil {
+				after = c.After.Text
+			}
+			got = append(got, change{c.Type, c.Index, before, after})
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: diffSegments = %+v, want %+v", test.name, got, test.want)
+		}
+	}
+	if changes := diffSegments(nil, nil); changes == nil {
+		t.Error("diff of nothing is nil, want an empty list")
+	}
+}
+
+func TestRestoreRevision(t *testing.T) {
+	setupTranscriptionTest(t)
+	metadataPath := writeBodyTestItem(t, "restore.mp3")
+	original, _ := readBodyTestItem(t, metadataPath)
+
+	text := "Second paragraph, corrected."
+	edit := func(segments []TranscriptEntry) ([]TranscriptEntry, error) {
+		return editSegment(segments, 1, EditSegmentRequest{Text: &text})
+	}
+	if _, _, err := applyTranscriptEdit("restore.mp3", "ann", "edit", 0, edit); err != nil {
+		t.Fatal(err)
+	}
+	merge := func(segments []TranscriptEntry) ([]TranscriptEntry, error) {
+		return mergeSegments(segments, 0)
+	}
+	if _, _, err := applyTranscriptEdit("restore.mp3", "ann", "merge", 0, merge); err != nil {
+		t.Fatal(err)
+	}
+
+	// The transcript as it was before the first edit is saved as revision 1
+	metadata := MediaMetadata{ID: "1", Filename: "restore.mp3"}
+	w := httptest.NewRecorder()
+	handleRestoreRevision(w, httptest.NewRequest(http.MethodPost, "/api/media/1/transcript/revisions/1/restore", strings.NewReader(`{"author": "bob"}`)), metadata, 1)
+	if w.Code != http.StatusOK {
+		t.Fatalf("restore: %d %s", w.Code, w.Body)
+	}
+
+	restored, _ := readBodyTestItem(t, metadataPath)
+	if !reflect.DeepEqual(restored.Transcripts, original.Transcripts) {
+		t.Errorf("restored segments %+v, want %+v", restored.Transcripts, original.Transcripts)
+	}
+	revisions, err := listTranscriptRevisions("restore.mp3")
+	if err != nil || len(revisions) != 4 {
+		t.Fatalf("revisions %+v, %v", revisions, err)
+	}
+	actions := []string{}
+	for _, rev := range revisions {
+		actions = append(actions, rev.Action)
+	}
+	if want := []string{"transcribe", "edit", "merge", "restore"}; !reflect.DeepEqual(actions, want) {
+		t.Errorf("revision actions %v, want %v", actions, want)
+	}
+	last := revisions[3]
+	if last.Author != "bob" || last.RestoredFrom != 1 || len(last.Diff) != 2 ||
+		last.Diff[0].Type != "changed" || last.Diff[1].Type != "added" {
+		t.Errorf("restore revision %+v", last)
+	}
+
+	w = httptest.NewRecorder()
+	handleRestoreRevision(w, httptest.NewRequest(http.MethodPost, "/api/media/1/transcript/revisions/9/restore", strings.NewReader(`{}`)), metadata, 9)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("restoring a missing revision: %d", w.Code)
+	}
+}
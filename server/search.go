@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Default and maximum number of items returned by a search
+const (
+	defaultSearchLimit = 50
+	maxSearchLimit     = 500
+)
+
+// SearchResult is a media item matching a search, with the transcript
+// segments that matched
+type SearchResult struct {
+	ID        string            `json:"id"`
+	Filename  string            `json:"filename"`
+	Type      string            `json:"type"`
+	Timestamp string            `json:"timestamp"`
+	Labels    []string          `json:"labels"`
+	Speakers  map[string]string `json:"speakers,omitempty"`
+	Segments  []TranscriptEntry `json:"segments"`
+	Caption   string            `json:"caption,omitempty"` // A photo's caption, if it matched a semantic search
+	Score     float64           `json:"score"`
+}
+
+// Split a query into lowercase search terms
+func searchTerms(query string) []string {
+	return strings.Fields(strings.ToLower(query))
+}
+
+// Count how often the terms occur in a piece of text
+func countTermMatches(text string, terms []string) int {
+	text = strings.ToLower(text)
+	count := 0
+	for _, term := range terms {
+		count += strings.Count(text, term)
+	}
+	return count
+}
+
+// Find items whose transcript, filename or labels contain every term.
+// If speakers are given, only their segments are searched; with no terms
+// every segment by those speakers matches. Results are best match first.
+func keywordSearch(allMetadata []MediaMetadata, query string, speakers []string, limit int) []SearchResult {
+	terms := searchTerms(query)
+	results := []SearchResult{}
+
+	for _, metadata := range allMetadata {
+		// Text the terms are looked for in
+		var text strings.Builder
+		if len(speakers) == 0 {
+			text.WriteString(metadata.Filename + " " + strings.Join(metadata.Labels, " ") + " ")
+		}
+
+		var segments []TranscriptEntry
+		for _, entry := range metadata.Transcripts {
+			if len(speakers) > 0 && !segmentHasSpeaker(metadata, entry, speakers) {
+				continue
+			}
+			text.WriteString(entry.Text + " ")
+			if len(terms) == 0 || countTermMatches(entry.Text, terms) > 0 {
+				segments = append(segments, entry)
+			}
+		}
+		if len(metadata.Transcripts) == 0 && len(speakers) == 0 {
+			text.WriteString(metadata.Transcription)
+		}
+
+		if len(speakers) > 0 && len(segments) == 0 {
+			continue
+		}
+		lowerText := strings.ToLower(text.String())
+		matchesAll := true
+		for _, term := range terms {
+			if !strings.Contains(lowerText, term) {
+				matchesAll = false
+				break
+			}
+		}
+		if !matchesAll || (len(terms) == 0 && len(speakers) == 0) {
+			continue
+		}
+
+		score := float64(len(segments))
+		if len(terms) > 0 {
+			score = float64(countTermMatches(lowerText, terms))
+		}
+		if segments == nil {
+			segments = []TranscriptEntry{}
+		}
+
+		results = append(results, SearchResult{
+			ID:        metadata.ID,
+			Filename:  metadata.Filename,
+			Type:      metadata.Type,
+			Timestamp: metadata.Timestamp,
+			Labels:    metadata.Labels,
+			Speakers:  metadata.Speakers,
+			Segments:  segments,
+			Score:     score,
+		})
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Timestamp > results[j].Timestamp
+	})
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// Find the segments and captions nearest to the query in the embedding
+// index. Items are ordered by their nearest text and scored by its
+// similarity; each item's segments are nearest first. limit caps the number
+// of segments and captions returned.
+func semanticSearch(ctx context.Context, index *vectorIndex, allMetadata []MediaMetadata, query string, speakers []string, limit int) ([]SearchResult, error) {
+	vector, err := index.embedQuery(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	byFilename := make(map[string]*MediaMetadata)
+	for i := range allMetadata {
+		byFilename[allMetadata[i].Filename] = &allMetadata[i]
+	}
+	// Texts edited since they were embedded are left out until embedded again
+	keep := func(filename string, text embeddedText) bool {
+		metadata, ok := byFilename[filename]
+		if !ok {
+			return false
+		}
+		if text.Kind == "caption" {
+			return len(speakers) == 0 && text.Hash == sha256.Sum256([]byte(strings.TrimSpace(metadata.Transcription)))
+		}
+		if text.Segment >= len(metadata.Transcripts) {
+			return false
+		}
+		entry := metadata.Transcripts[text.Segment]
+		if len(speakers) > 0 && !segmentHasSpeaker(*metadata, entry, speakers) {
+			return false
+		}
+		return text.Hash == sha256.Sum256([]byte(strings.TrimSpace(entry.Text)))
+	}
+
+	results := []SearchResult{}
+	positions := make(map[string]int)
+	for _, match := range index.nearest(vector, limit, keep) {
+		metadata := byFilename[match.Filename]
+		position, ok := positions[match.Filename]
+		if !ok {
+			position = len(results)
+			positions[match.Filename] = position
+			results = append(results, SearchResult{
+				ID:        metadata.ID,
+				Filename:  metadata.Filename,
+				Type:      metadata.Type,
+				Timestamp: metadata.Timestamp,
+				Labels:    metadata.Labels,
+				Speakers:  metadata.Speakers,
+				Segments:  []TranscriptEntry{},
+				Score:     match.Score,
+			})
+		}
+		if match.Text.Kind == "caption" {
+			results[position].Caption = strings.TrimSpace(metadata.Transcription)
+		} else {
+			results[position].Segments = append(results[position].Segments, metadata.Transcripts[match.Text.Segment])
+		}
+	}
+	return results, nil
+}
+
+// Handler for searching transcripts: /api/search?q=words&speaker=Alice.
+// ?mode=semantic searches the embedding index instead of matching words.
+func handleSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	queryParams := r.URL.Query()
+	query := queryParams.Get("q")
+	speakers := splitListParam(queryParams.Get("speaker"))
+	mode := queryParams.Get("mode")
+	switch mode {
+	case "", "keyword":
+		if strings.TrimSpace(query) == "" && len(speakers) == 0 {
+			http.Error(w, "q or speaker is required", http.StatusBadRequest)
+			return
+		}
+	case "semantic":
+		if strings.TrimSpace(query) == "" {
+			http.Error(w, "q is required", http.StatusBadRequest)
+			return
+		}
+		if EIndex == nil {
+			http.Error(w, "Semantic search is not enabled", http.StatusConflict)
+			return
+		}
+	default:
+		http.Error(w, "mode must be keyword or semantic", http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultSearchLimit
+	if value := queryParams.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		limit = min(parsed, maxSearchLimit)
+	}
+
+	allMetadata, err := readAllMetadata()
+	if err != nil {
+		http.Error(w, "Failed to read metadata", http.StatusInternalServerError)
+		return
+	}
+
+	if mode == "semantic" {
+		results, err := semanticSearch(r.Context(), EIndex, allMetadata, query, speakers, limit)
+		if err != nil {
+			log.Printf("Semantic search failed: %v", err)
+			http.Error(w, "Failed to embed the query", http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(results)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(keywordSearch(allMetadata, query, speakers, limit))
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Subtitle files that sit next to a recording with the same basename
+// (clip.srt, clip.de.vtt) are imported as its transcript instead of running
+// the transcription engine. Transcripts from them are recorded with the
+// engine "sidecar". In the library they are kept in the media directory.
+const sidecarEngine = "sidecar"
+
+var subtitleExtensions = []string{".srt", ".vtt"}
+
+var (
+	// A WebVTT voice span at the start of a cue: <v Alice> or <v.loud Alice>
+	subtitleVoicePattern = regexp.MustCompile(`^<v(?:\.[^\s>]*)?\s+([^>]+)>`)
+	// Markup inside cue text: <i>, </b>, <00:01.000>, {\an8}
+	subtitleTagPattern = regexp.MustCompile(`<[^>]*>|\{\\[^}]*\}`)
+)
+
+var subtitleEntities = strings.NewReplacer("&amp;", "&", "&lt;", "<", "&gt;", ">", "&nbsp;", " ", "&lrm;", "", "&rlm;", "")
+
+// Whether a file is a subtitle file that can be imported as a transcript
+func isSubtitleFile(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	for _, subtitleExt := range subtitleExtensions {
+		if ext == subtitleExt {
+			return true
+		}
+	}
+	return false
+}
+
+// Filename without its extension
+func mediaBase(name string) string {
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// Split a subtitle filename into the media basename it belongs to and the
+// language in its name, if any: "clip.de.srt" -> "clip", "de"
+func splitSubtitleName(name string) (string, string) {
+	base := mediaBase(name)
+	if ext := strings.ToLower(filepath.Ext(base)); ext != "" && languageCodes()[ext[1:]] {
+		return mediaBase(base), ext[1:]
+	}
+	return base, ""
+}
+
+// Language codes recognised in subtitle filenames
+func languageCodes() map[string]bool {
+	codes := make(map[string]bool, len(languageNames))
+	for _, code := range languageNames {
+		codes[code] = true
+	}
+	return codes
+}
+
+// Find a subtitle file in dir belonging to a media file: <base>.srt or
+// <base>.vtt, else <base>.<language>.srt or .vtt. Returns the path and the
+// language in its name, or an empty path if there is none.
+func findSidecarSubtitle(dir, mediaName string) (string, string) {
+	base := mediaBase(mediaName)
+
+	var languages []string
+	for code := range languageCodes() {
+		languages = append(languages, code)
+	}
+	sort.Strings(languages)
+
+	candidates := []string{""}
+	for _, language := range languages {
+		candidates = append(candidates, "."+language)
+	}
+	for _, infix := range candidates {
+		for _, ext := range subtitleExtensions {
+			for _, name := range []string{base + infix + ext, base + infix + strings.ToUpper(ext)} {
+				path := filepath.Join(dir, name)
+				if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+					return path, strings.TrimPrefix(infix, ".")
+				}
+			}
+		}
+	}
+	return "", ""
+}
+
+// Name a sidecar gets in the library: the media file's library basename
+// followed by the sidecar's language and extension
+func librarySidecarName(sidecarPath, filename string) string {
+	_, language := splitSubtitleName(filepath.Base(sidecarPath))
+	name := mediaBase(filename)
+	if language != "" {
+		name += "." + language
+	}
+	return name + strings.ToLower(filepath.Ext(sidecarPath))
+}
+
+// Parse an SRT or WebVTT file into transcript segments. Cue numbers, the
+// WEBVTT header, NOTE/STYLE blocks, cue settings and markup are ignored;
+// WebVTT voice spans become the segment's speaker.
+func parseSubtitles(data []byte) ([]TranscriptEntry, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	text := strings.ReplaceAll(strings.ReplaceAll(string(data), "\r\n", "\n"), "\r", "\n")
+
+	var entries []TranscriptEntry
+	for _, block := range strings.Split(text, "\n\n") {
+		lines := strings.Split(strings.Trim(block, "\n"), "\n")
+
+		// The timing line is the first or, after a cue number or ID, the second line
+		timing := -1
+		for i := 0; i < len(lines) && i < 2; i++ {
+			if strings.Contains(lines[i], "-->") {
+				timing = i
+				break
+			}
+		}
+		if timing < 0 {
+			continue
+		}
+
+		startText, endText, _ := strings.Cut(lines[timing], "-->")
+		endFields := strings.Fields(endText)
+		if len(endFields) == 0 {
+			return nil, fmt.Errorf("invalid cue timing: %s", lines[timing])
+		}
+		start, err := parseTimestamp(strings.TrimSpace(startText))
+		if err != nil {
+			return nil, fmt.Errorf("invalid cue timing: %s", lines[timing])
+		}
+		end, err := parseTimestamp(endFields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cue timing: %s", lines[timing])
+		}
+
+		entry := TranscriptEntry{Start: start, End: end, Segment: len(entries)}
+		var cueText []string
+		for _, line := range lines[timing+1:] {
+			line = strings.TrimSpace(line)
+			if match := subtitleVoicePattern.FindStringSubmatch(line); match != nil && entry.Speaker == "" {
+				entry.Speaker = strings.TrimSpace(subtitleEntities.Replace(match[1]))
+			}
+			line = strings.TrimSpace(subtitleEntities.Replace(subtitleTagPattern.ReplaceAllString(line, "")))
+			if line != "" {
+				cueText = append(cueText, line)
+			}
+		}
+		entry.Text = strings.Join(cueText, " ")
+		if entry.Text == "" {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("no subtitle cues found")
+	}
+	return entries, nil
+}
+
+// Import a subtitle file as a media item's transcript
+func importSidecarTranscript(filename, subtitlePath string) error {
+	data, err := os.ReadFile(subtitlePath)
+	if err != nil {
+		return fmt.Errorf("failed to read subtitles: %v", err)
+	}
+	segments, err := parseSubtitles(data)
+	if err != nil {
+		return fmt.Errorf("failed to parse subtitles %s: %v", filepath.Base(subtitlePath), err)
+	}
+
+	_, language := splitSubtitleName(filepath.Base(subtitlePath))
+	transcript := TranscriptFile{
+		Engine:    sidecarEngine,
+		Model:     filepath.Base(subtitlePath),
+		CreatedAt: time.Now().Format(time.RFC3339),
+		Segments:  segments,
+		Language:  language,
+	}
+	transcriptPath := filepath.Join(transcriptsDir, filename+".json")
+	if err := storeTranscriptVersion(filename, transcript); err != nil {
+		return err
+	}
+	if err := updateMetadataWithTranscript(filename, transcriptPath); err != nil {
+		return fmt.Errorf("failed to update metadata: %v", err)
+	}
+
+	log.Printf("Imported %d subtitle cues from %s as the transcript of %s", len(segments), filepath.Base(subtitlePath), filename)
+	return nil
+}
+
+// Attach a subtitle file that is already in the media directory to the
+// library's audio and video files with the same basename. Queued
+// transcriptions of those files are no longer needed and are skipped.
+// Returns the media files the subtitles were attached to.
+func attachSidecarSubtitle(subtitleName string) ([]string, error) {
+	base, _ := splitSubtitleName(subtitleName)
+	files, err := os.ReadDir(mediaDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var attached []string
+	for _, file := range files {
+		mediaType := detectMediaType(file.Name())
+		if file.IsDir() || mediaBase(file.Name()) != base || (mediaType != "audio" && mediaType != "video") {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(metadataDir, file.Name()+mdExt)); err != nil {
+			continue // Not ingested yet; ingestion will find the subtitles
+		}
+		TQueue.SkipQueued(file.Name())
+		if err := importSidecarTranscript(file.Name(), filepath.Join(mediaDir, subtitleName)); err != nil {
+			return attached, err
+		}
+		attached = append(attached, file.Name())
+	}
+	return attached, nil
+}
+
+// Handle a subtitle file that arrived in the media directory: attach it to
+// matching media already in the library, or leave it there for media that
+// is ingested later to find
+func ingestSubtitleFile(filename string) (UploadFileResponse, error) {
+	attached, err := attachSidecarSubtitle(filename)
+	if err != nil {
+		return UploadFileResponse{}, err
+	}
+
+	response := UploadFileResponse{
+		Status:   "success",
+		Filename: filename,
+		Path:     "/media/" + filename,
+	}
+	if len(attached) > 0 {
+		response.Metadata = "/api/metadata/" + attached[0]
+	}
+	return response, nil
+}
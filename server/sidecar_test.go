@@ -0,0 +1,79 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSubtitles(t *testing.T) {
+	type cue struct {
+		start, end float64
+		text       string
+		speaker    string
+	}
+	tests := []struct {
+		name string
+		data string
+		want []cue
+	}{
+		{"srt with a BOM and CRLF",
+			"\xef\xbb\xbf1\r\n00:00:01,000 --> 00:00:03,500\r\nHello <i>there</i>,\r\nfriend.\r\n\r\n" +
+				"2\r\n00:00:04,000 --> 00:00:06,000\r\n{\\an8}Tom &amp; Jerry &lt;3\r\n",
+			[]cue{{1, 3.5, "Hello there, friend.", ""}, {4, 6, "Tom & Jerry <3", ""}}},
+		{"srt with CR line endings", "1\r00:00:01,000 --> 00:00:02,000\rHi\r\r2\r00:00:02,000 --> 00:00:03,000\rBye\r",
+			[]cue{{1, 2, "Hi", ""}, {2, 3, "Bye", ""}}},
+		{"srt with extra blank lines", "\n\n1\n00:00:01,000 --> 00:00:02,000\nHi\n\n\n\n2\n00:00:03,000 --> 00:00:04,000\nBye\n\n",
+			[]cue{{1, 2, "Hi", ""}, {3, 4, "Bye", ""}}},
+		{"vtt with notes, styles, cue IDs and settings",
+			"WEBVTT - A talk\n\n" +
+				"NOTE This is a comment\nover two lines\n\n" +
+				"STYLE\n::cue { color: lime }\n\n" +
+				"intro\n00:01.000 --> 00:02.500 align:start position:10%\n<v.loud Alice>Hi &amp; welcome</v>\n\n" +
+				"00:03.000 --> 00:04.000\n<v Bob Smith>Thanks.\n<00:03.500>Really&nbsp;<b>thanks</b>\n\n" +
+				"3\n01:00:05.000 --> 01:00:06.000\n<c.yellow>Coloured</c>&lrm;\n",
+			[]cue{
+				{1, 2.5, "Hi & welcome", "Alice"},
+				{3, 4, "Thanks. Really thanks", "Bob Smith"},
+				{3605, 3606, "Coloured", ""},
+			}},
+		{"vtt with entities in a voice and escaped markup",
+			"WEBVTT\n\n00:00.000 --> 00:01.000\n<v Tom &amp; Jerry>&lt;i&gt; is italics\n",
+			[]cue{{0, 1, "<i> is italics", "Tom & Jerry"}}},
+		{"cues without text are dropped",
+			"1\n00:00:01,000 --> 00:00:02,000\n<i></i>\n\n2\n00:00:03,000 --> 00:00:04,000\nKept\n\n3\n00:00:05,000 --> 00:00:06,000\n",
+			[]cue{{3, 4, "Kept", ""}}},
+	}
+	for _, test := range tests {
+		entries, err := parseSubtitles([]byte(test.data))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		var got []cue
+		for i, entry := range entries {
+			got = append(got, cue{entry.Start, entry.End, entry.Text, entry.Speaker})
+			if entry.Segment != i {
+				t.Errorf("%s: cue %d numbered %d", test.name, i, entry.Segment)
+			}
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: parseSubtitles = %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestParseSubtitlesErrors(t *testing.T) {
+	tests := map[string]string{
+		"empty":              "",
+		"header only":        "WEBVTT\n\nNOTE nothing here\n",
+		"missing end time":   "1\n00:00:01,000 -->\nHi\n",
+		"invalid start time": "1\nsoon --> 00:00:02,000\nHi\n",
+		"invalid end time":   "00:01.000 --> later\nHi\n",
+		"not subtitles":      "Just some notes.\nNothing timed.\n",
+	}
+	for name, data := range tests {
+		if entries, err := parseSubtitles([]byte(data)); err == nil {
+			t.Errorf("%s: parsed %+v", name, entries)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Segments keep the speaker IDs the diarizer produced (SPEAKER_00, ...).
+// Names are stored per item in the metadata's speakers map, so renaming
+// never touches the transcript and survives re-transcription.
+
+// RenameSpeakerRequest represents the request body for renaming a speaker
+type RenameSpeakerRequest struct {
+	ID   string `json:"id"`   // Media item; empty renames across the library
+	From string `json:"from"` // Speaker ID or current name
+	To   string `json:"to"`   // New name; empty reverts to the speaker ID
+}
+
+// SpeakerSummary describes a speaker name used in the library
+type SpeakerSummary struct {
+	Name     string `json:"name"`
+	Items    int    `json:"items"`
+	Segments int    `json:"segments"`
+}
+
+// Name shown for a segment's speaker ID
+func speakerName(metadata MediaMetadata, id string) string {
+	if name, ok := metadata.Speakers[id]; ok && name != "" {
+		return name
+	}
+	return id
+}
+
+// Rename a speaker in one item. from matches either a speaker ID or the
+// name it is currently shown as. Returns false if the item has no such speaker.
+func renameSpeaker(metadata *MediaMetadata, from, to string) bool {
+	ids := make(map[string]bool)
+	for _, entry := range metadata.Transcripts {
+		if entry.Speaker != "" && (entry.Speaker == from || speakerName(*metadata, entry.Speaker) == from) {
+			ids[entry.Speaker] = true
+		}
+	}
+	if len(ids) == 0 {
+		return false
+	}
+
+	if metadata.Speakers == nil {
+		metadata.Speakers = make(map[string]string)
+	}
+	for id := range ids {
+		if to == "" || to == id {
+			delete(metadata.Speakers, id)
+		} else {
+			metadata.Speakers[id] = to
+		}
+	}
+	if len(metadata.Speakers) == 0 {
+		metadata.Speakers = nil
+	}
+	return true
+}
+
+// Whether any segment of an item is spoken by one of the given speakers,
+// matched case-insensitively by ID or name
+func metadataHasSpeaker(metadata MediaMetadata, speakers []string) bool {
+	for _, entry := range metadata.Transcripts {
+		if segmentHasSpeaker(metadata, entry, speakers) {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether a segment is spoken by one of the given speakers
+func segmentHasSpeaker(metadata MediaMetadata, entry TranscriptEntry, speakers []string) bool {
+	if entry.Speaker == "" {
+		return false
+	}
+	name := speakerName(metadata, entry.Speaker)
+	for _, speaker := range speakers {
+		if strings.EqualFold(entry.Speaker, speaker) || strings.EqualFold(name, speaker) {
+			return true
+		}
+	}
+	return false
+}
+
+// Split a comma-separated query parameter into trimmed, non-empty values
+func splitListParam(value string) []string {
+	var values []string
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			values = append(values, part)
+		}
+	}
+	return values
+}
+
+// Handler for listing the speakers in the library
+func handleSpeakers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	allMetadata, err := readAllMetadata()
+	if err != nil {
+		http.Error(w, "Failed to read metadata", http.StatusInternalServerError)
+		return
+	}
+
+	summaries := make(map[string]*SpeakerSummary)
+	for _, metadata := range allMetadata {
+		seen := make(map[string]bool)
+		for _, entry := range metadata.Transcripts {
+			if entry.Speaker == "" {
+				continue
+			}
+			name := speakerName(metadata, entry.Speaker)
+			summary, ok := summaries[name]
+			if !ok {
+				summary = &SpeakerSummary{Name: name}
+				summaries[name] = summary
+			}
+			summary.Segments++
+			if !seen[name] {
+				seen[name] = true
+				summary.Items++
+			}
+		}
+	}
+
+	speakers := []SpeakerSummary{}
+	for _, summary := range summaries {
+		speakers = append(speakers, *summary)
+	}
+	sort.Slice(speakers, func(i, j int) bool {
+		return speakers[i].Name < speakers[j].Name
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(speakers)
+}
+
+// Handler for renaming a speaker in one item or across the library
+func handleRenameSpeaker(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req RenameSpeakerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	req.From = strings.TrimSpace(req.From)
+	req.To = strings.TrimSpace(req.To)
+	if req.From == "" {
+		http.Error(w, "from is required", http.StatusBadRequest)
+		return
+	}
+
+	var items []MediaMetadata
+	if req.ID != "" {
+		_, metadata, err := findMetadataByID(req.ID)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				http.Error(w, "Media item not found", http.StatusNotFound)
+			} else {
+				http.Error(w, "Failed to read metadata", http.StatusInternalServerError)
+			}
+			return
+		}
+		items = []MediaMetadata{metadata}
+	} else {
+		allMetadata, err := readAllMetadata()
+		if err != nil {
+			http.Error(w, "Failed to read metadata", http.StatusInternalServerError)
+			return
+		}
+		items = allMetadata
+	}
+
+	found := 0
+	updated := []string{}
+	for _, item := range items {
+		if !metadataHasSpeaker(item, []string{req.From}) {
+			continue
+		}
+		// Rename in the file as it is now, not as it was listed
+		metadata, renamed, err := updateMetadata(item.Filename, func(metadata *MediaMetadata) bool {
+			return renameSpeaker(metadata, req.From, req.To)
+		})
+		if err != nil {
+			log.Printf("Failed to rename speaker in %s: %v", item.Filename, err)
+			continue
+		}
+		if !renamed {
+			continue
+		}
+		found++
+		updated = append(updated, metadata.ID)
+	}
+
+	if req.ID != "" && found == 0 {
+		http.Error(w, "Speaker not found in media item", http.StatusNotFound)
+		return
+	}
+	log.Printf("Renamed speaker %q to %q in %d items", req.From, req.To, len(updated))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "success",
+		"updated": updated,
+		"count":   len(updated),
+	})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRenameSpeakerAcrossLibrary(t *testing.T) {
+	setupTranscriptionTest(t)
+	for _, filename := range []string{"a.mp3", "b.mp3", "c.mp3"} {
+		speaker := "SPEAKER_00"
+		if filename == "c.mp3" {
+			speaker = "SPEAKER_01"
+		}
+		err := writeMetadataFile(filepath.Join(metadataDir, filename+mdExt), MediaMetadata{
+			ID:          filename,
+			Filename:    filename,
+			Type:        "audio",
+			Labels:      []string{"family"},
+			Transcripts: []TranscriptEntry{{Start: 0, End: 2, Text: "Hi.", Speaker: speaker}},
+			Speakers:    map[string]string{speaker: "Mum"},
+			Summary:     "A greeting.",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	handleRenameSpeaker(w, httptest.NewRequest(http.MethodPost, "/api/speakers/rename", strings.NewReader(`{"from": "Mum", "to": "Alice"}`)))
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"count":3`) {
+		t.Fatalf("rename: %d %s", w.Code, w.Body)
+	}
+
+	for _, filename := range []string{"a.mp3", "b.mp3", "c.mp3"} {
+		var metadata MediaMetadata
+		if _, err := readMarkdownFile(filepath.Join(metadataDir, filename+mdExt), &metadata); err != nil {
+			t.Fatal(err)
+		}
+		speaker := metadata.Transcripts[0].Speaker
+		if metadata.Speakers[speaker] != "Alice" {
+			t.Errorf("%s has speakers %v", filename, metadata.Speakers)
+		}
+		if !reflect.DeepEqual(metadata.Labels, []string{"family"}) || metadata.Summary != "A greeting." {
+			t.Errorf("%s lost fields: %+v", filename, metadata)
+		}
+	}
+}
+
+func TestUpdateMetadata(t *testing.T) {
+	setupTranscriptionTest(t)
+	path := filepath.Join(metadataDir, "beach.jpg"+mdExt)
+	if err := writeMetadataFile(path, MediaMetadata{ID: "1", Filename: "beach.jpg", Type: "photo", Labels: []string{}, Transcription: "Sunset."}); err != nil {
+		t.Fatal(err)
+	}
+
+	metadata, changed, err := updateMetadata("beach.jpg", func(metadata *MediaMetadata) bool {
+		metadata.Labels = append(metadata.Labels, "holiday")
+		return true
+	})
+	if err != nil || !changed || !reflect.DeepEqual(metadata.Labels, []string{"holiday"}) {
+		t.Fatalf("updateMetadata = %+v, %v, %v", metadata, changed, err)
+	}
+
+	var written MediaMetadata
+	body, err := readMarkdownFile(path, &written)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(written.Labels, []string{"holiday"}) || strings.TrimSpace(body) != "Sunset." {
+		t.Errorf("file has labels %v and body %q", written.Labels, body)
+	}
+
+	if _, changed, err := updateMetadata("missing.jpg", func(*MediaMetadata) bool { return true }); err == nil || changed {
+		t.Errorf("updating a missing item: %v, %v", changed, err)
+	}
+}
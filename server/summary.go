@@ -0,0 +1,507 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Once a recording is transcribed, a summarizer writes a short summary and
+// chapter titles with start times to its metadata. The default summarizer
+// asks a chat model behind the configured llm endpoint; transcripts too long
+// for one prompt are summarized in parts whose summaries are then combined.
+// Summaries are off unless enabled in the configuration.
+
+// Chapter is a titled section of a recording
+type Chapter struct {
+	Start float64 `yaml:"start" json:"start"`
+	Title string  `yaml:"title" json:"title"`
+}
+
+// SummaryResult is what a summarizer made of a transcript
+type SummaryResult struct {
+	Summary  string
+	Chapters []Chapter
+	Model    string
+}
+
+// Summarizer turns an item's transcript into a summary and chapters
+type Summarizer interface {
+	Name() string
+	Model() string
+	Summarize(ctx context.Context, metadata MediaMetadata) (SummaryResult, error)
+}
+
+// SummaryResponse is an item's summary and where making a new one stands
+type SummaryResponse struct {
+	Summary      string    `json:"summary,omitempty"`
+	Chapters     []Chapter `json:"chapters"`
+	Model        string    `json:"model,omitempty"`
+	SummarizedAt string    `json:"summarizedAt,omitempty"`
+	Enabled      bool      `json:"enabled"`
+	Status       string    `json:"status,omitempty"` // "queued" or "running"
+	Error        string    `json:"error,omitempty"`  // Why the last attempt failed
+}
+
+// Create the summarizer selected in the configuration
+func newSummarizer(config Config) (Summarizer, error) {
+	switch config.Summary.Backend {
+	case "", "openai":
+		llm := config.LLM
+		if config.Summary.Model != "" {
+			llm.Model = config.Summary.Model
+		}
+		if llm.Endpoint == "" || llm.Model == "" {
+			return nil, fmt.Errorf("openai summarizer requires an llm endpoint and model")
+		}
+		return &llmSummarizer{client: newChatClient(llm), maxChunkChars: config.Summary.MaxChunkChars}, nil
+	case "fake":
+		return &fakeSummarizer{}, nil
+	default:
+		return nil, fmt.Errorf("unknown summary backend %q", config.Summary.Backend)
+	}
+}
+
+// Lines of a transcript as given to the model: one paragraph per line,
+// starting with its timecode and speaker
+func transcriptLines(metadata MediaMetadata) []string {
+	var lines []string
+	for _, paragraph := range transcriptParagraphs(metadata.Transcripts) {
+		var texts []string
+		for _, index := range paragraph.Segments {
+			if text := strings.TrimSpace(metadata.Transcripts[index].Text); text != "" {
+				texts = append(texts, text)
+			}
+		}
+		if len(texts) == 0 {
+			continue
+		}
+		line := formatTimecode(paragraph.Start)
+		if paragraph.Speaker != "" {
+			line += " " + speakerName(metadata, paragraph.Speaker) + ":"
+		}
+		lines = append(lines, line+" "+strings.Join(texts, " "))
+	}
+	return lines
+}
+
+// Group lines into chunks of at most maxChars characters. A line longer
+// than that gets a chunk of its own.
+func chunkLines(lines []string, maxChars int) [][]string {
+	var chunks [][]string
+	size := 0
+	for _, line := range lines {
+		if len(chunks) == 0 || (maxChars > 0 && size+len(line) > maxChars) {
+			chunks = append(chunks, nil)
+			size = 0
+		}
+		chunks[len(chunks)-1] = append(chunks[len(chunks)-1], line)
+		size += len(line) + 1
+	}
+	return chunks
+}
+
+// Parse a chapter start given as seconds or as a [hh:]mm:ss timecode
+func parseChapterStart(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, v >= 0
+	case string:
+		seconds := 0.0
+		for _, part := range strings.Split(strings.Trim(strings.TrimSpace(v), "[]"), ":") {
+			n, err := strconv.ParseFloat(part, 64)
+			if err != nil || n < 0 {
+				return 0, false
+			}
+			seconds = seconds*60 + n
+		}
+		return seconds, true
+	}
+	return 0, false
+}
+
+// Move chapters onto the start of the segment they begin at, sort them and
+// drop untitled and duplicate ones. Timecodes are whole seconds, so a chapter
+// starts at the first segment that starts in or after its second.
+func tidyChapters(chapters []Chapter, segments []TranscriptEntry) []Chapter {
+	var tidy []Chapter
+	for _, chapter := range chapters {
+		chapter.Title = strings.TrimSpace(chapter.Title)
+		if chapter.Title == "" {
+			continue
+		}
+		index := sort.Search(len(segments), func(i int) bool {
+			return segments[i].Start >= float64(int(chapter.Start))
+		})
+		if index == len(segments) {
+			continue // Past the end of the recording
+		}
+		chapter.Start = segments[index].Start
+		tidy = append(tidy, chapter)
+	}
+
+	sort.SliceStable(tidy, func(i, j int) bool { return tidy[i].Start < tidy[j].Start })
+	var unique []Chapter
+	for _, chapter := range tidy {
+		if len(unique) > 0 && unique[len(unique)-1].Start == chapter.Start {
+			continue
+		}
+		unique = append(unique, chapter)
+	}
+	return unique
+}
+
+// llmSummarizer asks a chat model for the summary and chapters
+type llmSummarizer struct {
+	client        *chatClient
+	maxChunkChars int
+}
+
+func (s *llmSummarizer) Name() string  { return "openai" }
+func (s *llmSummarizer) Model() string { return s.client.model }
+
+const summarySystemPrompt = "You summarize transcripts of recorded conversations. " +
+	"Write in the language of the transcript. Reply with a JSON object only."
+
+func (s *llmSummarizer) Summarize(ctx context.Context, metadata MediaMetadata) (SummaryResult, error) {
+	chunks := chunkLines(transcriptLines(metadata), s.maxChunkChars)
+	if len(chunks) == 0 {
+		return SummaryResult{}, fmt.Errorf("media item has no transcript")
+	}
+
+	var summaries []string
+	var chapters []Chapter
+	for i, chunk := range chunks {
+		summary, found, err := s.summarizeChunk(ctx, chunk, i, len(chunks))
+		if err != nil {
+			return SummaryResult{}, fmt.Errorf("failed to summarize part %d of %d: %v", i+1, len(chunks), err)
+		}
+		summaries = append(summaries, summary)
+		chapters = append(chapters, found...)
+	}
+
+	summary := summaries[0]
+	if len(summaries) > 1 {
+		combined, err := s.combineSummaries(ctx, summaries)
+		if err != nil {
+			return SummaryResult{}, fmt.Errorf("failed to combine summaries: %v", err)
+		}
+		summary = combined
+	}
+
+	return SummaryResult{
+		Summary:  summary,
+		Chapters: tidyChapters(chapters, metadata.Transcripts),
+		Model:    s.client.model,
+	}, nil
+}
+
+// Summarize one part of a transcript and find the chapters in it
+func (s *llmSummarizer) summarizeChunk(ctx context.Context, lines []string, index, count int) (string, []Chapter, error) {
+	var prompt strings.Builder
+	if count == 1 {
+		prompt.WriteString("Here is the transcript of a recording.")
+	} else {
+		fmt.Fprintf(&prompt, "Here is part %d of %d of the transcript of a recording.", index+1, count)
+	}
+	prompt.WriteString(" Each line starts with the time it was said and the speaker, if known.\n\n")
+	prompt.WriteString(strings.Join(lines, "\n"))
+	prompt.WriteString("\n\nReply with a JSON object with two fields:\n")
+	if count == 1 {
+		prompt.WriteString(`"summary": a summary of the recording in at most five sentences` + "\n")
+	} else {
+		prompt.WriteString(`"summary": a summary of this part in at most four sentences` + "\n")
+	}
+	prompt.WriteString(`"chapters": the topics discussed, in order, as a list of {"start": "hh:mm:ss", "title": "..."}, ` +
+		"where start is the time of the line where the topic begins and title has at most eight words\n")
+
+	reply, err := s.client.Complete(ctx, []ChatMessage{
+		{Role: "system", Content: summarySystemPrompt},
+		{Role: "user", Content: prompt.String()},
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	object, err := extractJSONObject(reply)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var parsed struct {
+		Summary  string `json:"summary"`
+		Chapters []struct {
+			Start interface{} `json:"start"`
+			Title string      `json:"title"`
+		} `json:"chapters"`
+	}
+	if err := json.Unmarshal([]byte(object), &parsed); err != nil {
+		return "", nil, fmt.Errorf("failed to parse reply: %v", err)
+	}
+	if strings.TrimSpace(parsed.Summary) == "" {
+		return "", nil, fmt.Errorf("reply has no summary")
+	}
+
+	var chapters []Chapter
+	for _, chapter := range parsed.Chapters {
+		if start, ok := parseChapterStart(chapter.Start); ok {
+			chapters = append(chapters, Chapter{Start: start, Title: chapter.Title})
+		}
+	}
+	return strings.TrimSpace(parsed.Summary), chapters, nil
+}
+
+// Turn the summaries of consecutive parts into one summary
+func (s *llmSummarizer) combineSummaries(ctx context.Context, summaries []string) (string, error) {
+	var prompt strings.Builder
+	prompt.WriteString("Here are summaries of consecutive parts of a recording, in order:\n\n")
+	for i, summary := range summaries {
+		fmt.Fprintf(&prompt, "Part %d: %s\n", i+1, summary)
+	}
+	prompt.WriteString("\nReply with a JSON object with one field, " +
+		`"summary": a summary of the whole recording in at most five sentences` + "\n")
+
+	reply, err := s.client.Complete(ctx, []ChatMessage{
+		{Role: "system", Content: summarySystemPrompt},
+		{Role: "user", Content: prompt.String()},
+	})
+	if err != nil {
+		return "", err
+	}
+	object, err := extractJSONObject(reply)
+	if err != nil {
+		return "", err
+	}
+	var parsed struct {
+		Summary string `json:"summary"`
+	}
+	if err := json.Unmarshal([]byte(object), &parsed); err != nil {
+		return "", fmt.Errorf("failed to parse reply: %v", err)
+	}
+	if strings.TrimSpace(parsed.Summary) == "" {
+		return "", fmt.Errorf("reply has no summary")
+	}
+	return strings.TrimSpace(parsed.Summary), nil
+}
+
+// fakeSummarizer makes a deterministic summary without a model, for tests
+// and for trying the pipeline on machines without a model server
+type fakeSummarizer struct{}
+
+func (s *fakeSummarizer) Name() string  { return "fake" }
+func (s *fakeSummarizer) Model() string { return "fake" }
+
+// Every paragraph becomes a chapter titled with its first words
+func (s *fakeSummarizer) Summarize(ctx context.Context, metadata MediaMetadata) (SummaryResult, error) {
+	if len(metadata.Transcripts) == 0 {
+		return SummaryResult{}, fmt.Errorf("media item has no transcript")
+	}
+	var chapters []Chapter
+	for _, paragraph := range transcriptParagraphs(metadata.Transcripts) {
+		words := strings.Fields(metadata.Transcripts[paragraph.Segments[0]].Text)
+		chapters = append(chapters, Chapter{Start: paragraph.Start, Title: strings.Join(words[:min(len(words), 4)], " ")})
+	}
+	return SummaryResult{
+		Summary:  fmt.Sprintf("Fake summary of %s: %d segments.", metadata.Filename, len(metadata.Transcripts)),
+		Chapters: tidyChapters(chapters, metadata.Transcripts),
+		Model:    "fake",
+	}, nil
+}
+
+// summaryQueue makes summaries one at a time in the background
+type summaryQueue struct {
+	summarizer Summarizer
+	minSeconds float64
+
+	mu      sync.Mutex
+	queue   []summaryJob
+	queued  map[string]bool
+	running string
+	errors  map[string]string // Last failure per item
+	wake    chan struct{}
+}
+
+// summaryJob asks for an item's summary; forced jobs skip the length check
+type summaryJob struct {
+	filename string
+	force    bool
+}
+
+// Global summary queue, nil while summaries are disabled
+var SQueue *summaryQueue
+
+// Start the summary worker if summaries are enabled
+func InitSummarizer() {
+	if !AppConfig.Summary.Enabled {
+		return
+	}
+	summarizer, err := newSummarizer(AppConfig)
+	if err != nil {
+		log.Printf("Summaries disabled: %v", err)
+		return
+	}
+	SQueue = &summaryQueue{
+		summarizer: summarizer,
+		minSeconds: float64(AppConfig.Summary.MinSeconds),
+		queued:     make(map[string]bool),
+		errors:     make(map[string]string),
+		wake:       make(chan struct{}, 1),
+	}
+	go SQueue.worker()
+	log.Printf("Summarizing transcripts with %s (%s)", summarizer.Name(), summarizer.Model())
+}
+
+// Queue a summary of an item unless one is already queued. Does nothing
+// while summaries are disabled.
+func queueSummary(filename string, force bool) bool {
+	q := SQueue
+	if q == nil {
+		return false
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.queued[filename] {
+		return true
+	}
+	q.queued[filename] = true
+	q.queue = append(q.queue, summaryJob{filename: filename, force: force})
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// Where making an item's summary stands: "queued", "running" or empty, and
+// the last failure
+func summaryStatus(filename string) (string, string) {
+	q := SQueue
+	if q == nil {
+		return "", ""
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	status := ""
+	if q.queued[filename] {
+		status = "queued"
+	} else if q.running == filename {
+		status = "running"
+	}
+	return status, q.errors[filename]
+}
+
+func (q *summaryQueue) worker() {
+	for {
+		q.mu.Lock()
+		if len(q.queue) == 0 {
+			q.mu.Unlock()
+			<-q.wake
+			continue
+		}
+		job := q.queue[0]
+		q.queue = q.queue[1:]
+		delete(q.queued, job.filename)
+		q.running = job.filename
+		q.mu.Unlock()
+
+		err := q.summarize(job)
+
+		q.mu.Lock()
+		q.running = ""
+		if err != nil {
+			q.errors[job.filename] = err.Error()
+		} else {
+			delete(q.errors, job.filename)
+		}
+		q.mu.Unlock()
+		if err != nil {
+			log.Printf("Failed to summarize %s: %v", job.filename, err)
+		}
+	}
+}
+
+// Summarize an item and write the result to its metadata
+func (q *summaryQueue) summarize(job summaryJob) error {
+	metadataPath := filepath.Join(metadataDir, job.filename+mdExt)
+	var metadata MediaMetadata
+	if _, err := readMarkdownFile(metadataPath, &metadata); err != nil {
+		return fmt.Errorf("failed to read metadata file: %v", err)
+	}
+	if len(metadata.Transcripts) == 0 {
+		return fmt.Errorf("media item has no transcript")
+	}
+	length := metadata.Duration
+	if end := metadata.Transcripts[len(metadata.Transcripts)-1].End; end > length {
+		length = end
+	}
+	if !job.force && length < q.minSeconds {
+		return nil // Too short to need a summary
+	}
+
+	start := time.Now()
+	result, err := q.summarizer.Summarize(context.Background(), metadata)
+	if err != nil {
+		return err
+	}
+
+	// Only the summary is set on the metadata as it is now, so changes made
+	// while the model was busy aren't lost
+	_, _, err = updateMetadata(job.filename, func(current *MediaMetadata) bool {
+		current.Summary = result.Summary
+		current.Chapters = result.Chapters
+		current.SummaryModel = result.Model
+		current.SummarizedAt = time.Now().Format(time.RFC3339)
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save summary: %v", err)
+	}
+	log.Printf("Summarized %s in %s (%d chapters)", job.filename, time.Since(start).Round(time.Second), len(result.Chapters))
+	return nil
+}
+
+// Serve an item's summary (GET) or queue a new one (POST)
+func handleSummary(w http.ResponseWriter, r *http.Request, metadata MediaMetadata) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		if SQueue == nil {
+			http.Error(w, "Summaries are not enabled", http.StatusConflict)
+			return
+		}
+		if len(metadata.Transcripts) == 0 {
+			http.Error(w, "Media item has no transcript", http.StatusBadRequest)
+			return
+		}
+		queueSummary(metadata.Filename, true)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	status, lastError := summaryStatus(metadata.Filename)
+	response := SummaryResponse{
+		Summary:      metadata.Summary,
+		Chapters:     metadata.Chapters,
+		Model:        metadata.SummaryModel,
+		SummarizedAt: metadata.SummarizedAt,
+		Enabled:      SQueue != nil,
+		Status:       status,
+		Error:        lastError,
+	}
+	if response.Chapters == nil {
+		response.Chapters = []Chapter{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodPost {
+		w.WriteHeader(http.StatusAccepted)
+	}
+	json.NewEncoder(w).Encode(response)
+}
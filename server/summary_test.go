@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// A recording with four paragraphs, the last by a named speaker
+func summaryTestItem() MediaMetadata {
+	return MediaMetadata{
+		Filename: "talk.mp3",
+		Transcripts: []TranscriptEntry{
+			{Start: 0, End: 4, Text: "Welcome to the meeting.", Segment: 0},
+			{Start: 10.4, End: 14, Text: "First, the kitchen tiles.", Segment: 1},
+			{Start: 20, End: 24, Text: "Blue is cheaper than green.", Segment: 2},
+			{Start: 65, End: 70, Text: "Now the garden.", Segment: 3, Speaker: "SPEAKER_00"},
+		},
+		Speakers: map[string]string{"SPEAKER_00": "Alice"},
+	}
+}
+
+func newTestSummarizer(endpoint string, maxChunkChars int) *llmSummarizer {
+	return &llmSummarizer{client: newChatClient(LLMConfig{Endpoint: endpoint, Model: "tiny"}), maxChunkChars: maxChunkChars}
+}
+
+func TestTranscriptLines(t *testing.T) {
+	want := []string{
+		"[00:00:00] Welcome to the meeting.",
+		"[00:00:10] First, the kitchen tiles.",
+		"[00:00:20] Blue is cheaper than green.",
+		"[00:01:05] Alice: Now the garden.",
+	}
+	if got := transcriptLines(summaryTestItem()); !reflect.DeepEqual(got, want) {
+		t.Errorf("transcriptLines = %q, want %q", got, want)
+	}
+}
+
+func TestChunkLines(t *testing.T) {
+	lines := []string{"aaaa", "bbbb", "cccc", "a line longer than the limit"}
+	want := [][]string{{"aaaa", "bbbb"}, {"cccc"}, {"a line longer than the limit"}}
+	if got := chunkLines(lines, 10); !reflect.DeepEqual(got, want) {
+		t.Errorf("chunkLines = %q, want %q", got, want)
+	}
+	if got := chunkLines(lines, 0); len(got) != 1 {
+		t.Errorf("chunkLines without a limit made %d chunks", len(got))
+	}
+	if got := chunkLines(nil, 10); got != nil {
+		t.Errorf("chunkLines(nil) = %q", got)
+	}
+}
+
+func TestParseChapterStart(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  float64
+		ok    bool
+	}{
+		{"00:01:05", 65, true},
+		{"[01:05]", 65, true},
+		{" 1:00:00 ", 3600, true},
+		{12.5, 12.5, true},
+		{-1.0, 0, false},
+		{"soon", 0, false},
+		{"00:-1:00", 0, false},
+		{nil, 0, false},
+	}
+	for _, test := range tests {
+		got, ok := parseChapterStart(test.value)
+		if ok != test.ok || (ok && got != test.want) {
+			t.Errorf("parseChapterStart(%v) = %v, %v; want %v, %v", test.value, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestTidyChapters(t *testing.T) {
+	segments := summaryTestItem().Transcripts
+	chapters := []Chapter{
+		{Start: 65, Title: "Garden"},
+		{Start: 10, Title: " Kitchen tiles "}, // Moves to the segment at 10.4
+		{Start: 10.9, Title: "Same second"},   // Duplicate start
+		{Start: 0, Title: "  "},               // Untitled
+		{Start: 500, Title: "After the end"},
+	}
+	want := []Chapter{{Start: 10.4, Title: "Kitchen tiles"}, {Start: 65, Title: "Garden"}}
+	if got := tidyChapters(chapters, segments); !reflect.DeepEqual(got, want) {
+		t.Errorf("tidyChapters = %+v, want %+v", got, want)
+	}
+}
+
+func TestLLMSummarizerSingleChunk(t *testing.T) {
+	server, requests := newChatServer(t, func(req chatRequest) string {
+		return "Here you go:\n```json\n" + `{"summary": " A meeting about the house. ", "chapters": [` +
+			`{"start": "00:00:10", "title": "Kitchen tiles"}, {"start": 65, "title": "Garden"}, {"start": "later", "title": "Dropped"}]}` +
+			"\n```"
+	})
+
+	result, err := newTestSummarizer(server.URL, 12000).Summarize(context.Background(), summaryTestItem())
+	if err != nil {
+		t.Fatalf("Summarize: %v", err)
+	}
+	if result.Summary != "A meeting about the house." || result.Model != "tiny" {
+		t.Errorf("unexpected result %+v", result)
+	}
+	wantChapters := []Chapter{{Start: 10.4, Title: "Kitchen tiles"}, {Start: 65, Title: "Garden"}}
+	if !reflect.DeepEqual(result.Chapters, wantChapters) {
+		t.Errorf("chapters = %+v, want %+v", result.Chapters, wantChapters)
+	}
+
+	if len(*requests) != 1 {
+		t.Fatalf("%d requests, want 1", len(*requests))
+	}
+	prompt := (*requests)[0].Messages[1].Content
+	if !strings.Contains(prompt, "Here is the transcript of a recording.") ||
+		!strings.Contains(prompt, "[00:01:05] Alice: Now the garden.") {
+		t.Errorf("unexpected prompt:\n%s", prompt)
+	}
+}
+
+func TestLLMSummarizerChunks(t *testing.T) {
+	server, requests := newChatServer(t, func(req chatRequest) string {
+		prompt := req.Messages[1].Content
+		switch {
+		case strings.Contains(prompt, "summaries of consecutive parts"):
+			return `{"summary": "The whole meeting."}`
+		case strings.Contains(prompt, "Welcome"):
+			return `{"summary": "Opening.", "chapters": [{"start": "00:00:00", "title": "Welcome"}]}`
+		case strings.Contains(prompt, "kitchen"):
+			return `{"summary": "Kitchen.", "chapters": [{"start": "00:00:10", "title": "Kitchen"}]}`
+		case strings.Contains(prompt, "Blue"):
+			return `{"summary": "Colours.", "chapters": []}`
+		default:
+			return `{"summary": "Garden.", "chapters": [{"start": "00:01:05", "title": "Garden"}]}`
+		}
+	})
+
+	// Each line is over 30 characters, so each gets its own part
+	result, err := newTestSummarizer(server.URL, 30).Summarize(context.Background(), summaryTestItem())
+	if err != nil {
+		t.Fatalf("Summarize: %v", err)
+	}
+	if result.Summary != "The whole meeting." {
+		t.Errorf("summary = %q", result.Summary)
+	}
+	wantChapters := []Chapter{{Start: 0, Title: "Welcome"}, {Start: 10.4, Title: "Kitchen"}, {Start: 65, Title: "Garden"}}
+	if !reflect.DeepEqual(result.Chapters, wantChapters) {
+		t.Errorf("chapters = %+v, want %+v", result.Chapters, wantChapters)
+	}
+
+	if len(*requests) != 5 {
+		t.Fatalf("%d requests, want 4 parts and 1 combination", len(*requests))
+	}
+	if prompt := (*requests)[0].Messages[1].Content; !strings.Contains(prompt, "part 1 of 4") {
+		t.Errorf("first prompt doesn't name its part:\n%s", prompt)
+	}
+	combine := (*requests)[4].Messages[1].Content
+	for _, part := range []string{"Part 1: Opening.", "Part 2: Kitchen.", "Part 3: Colours.", "Part 4: Garden."} {
+		if !strings.Contains(combine, part) {
+			t.Errorf("combination prompt lacks %q:\n%s", part, combine)
+		}
+	}
+}
+
+func TestLLMSummarizerMalformedReplies(t *testing.T) {
+	tests := []struct {
+		reply, want string
+	}{
+		{"Sorry, I can't summarize this.", "reply contains no JSON object"},
+		{`{"summary": 5}`, "failed to parse reply"},
+		{`{"summary": "  ", "chapters": []}`, "reply has no summary"},
+	}
+	for _, test := range tests {
+		server, _ := newChatServer(t, func(chatRequest) string { return test.reply })
+		_, err := newTestSummarizer(server.URL, 12000).Summarize(context.Background(), summaryTestItem())
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("reply %q: error = %v, want one containing %q", test.reply, err, test.want)
+		}
+	}
+
+	// A malformed combination fails the summary too
+	server, _ := newChatServer(t, func(req chatRequest) string {
+		if strings.Contains(req.Messages[1].Content, "summaries of consecutive parts") {
+			return "no JSON"
+		}
+		return `{"summary": "Part."}`
+	})
+	_, err := newTestSummarizer(server.URL, 30).Summarize(context.Background(), summaryTestItem())
+	if err == nil || !strings.Contains(err.Error(), "failed to combine summaries") {
+		t.Errorf("error = %v, want a failed combination", err)
+	}
+}
+
+func TestLLMSummarizerWithoutTranscript(t *testing.T) {
+	_, err := newTestSummarizer("http://127.0.0.1:0", 12000).Summarize(context.Background(), MediaMetadata{})
+	if err == nil || !strings.Contains(err.Error(), "no transcript") {
+		t.Errorf("error = %v, want no transcript", err)
+	}
+}
+
+// A summarizer that runs during while it is busy, as another writer would
+type busySummarizer struct {
+	during func()
+}
+
+func (s busySummarizer) Name() string  { return "busy" }
+func (s busySummarizer) Model() string { return "busy-model" }
+func (s busySummarizer) Summarize(ctx context.Context, metadata MediaMetadata) (SummaryResult, error) {
+	s.during()
+	return SummaryResult{Summary: "A talk.", Chapters: []Chapter{{Start: 0, Title: "Start"}}, Model: "busy-model"}, nil
+}
+
+func TestSummarizeKeepsConcurrentChanges(t *testing.T) {
+	setupTranscriptionTest(t)
+	metadataPath := filepath.Join(metadataDir, "talk.mp3"+mdExt)
+	item := summaryTestItem()
+	item.ID, item.Type, item.Language, item.Labels = "1", "audio", "de", []string{"work"}
+	if err := writeMetadataFile(metadataPath, item); err != nil {
+		t.Fatal(err)
+	}
+
+	// While the model is busy the language is cleared, a label added and a
+	// segment corrected
+	changed := item
+	changed.Language = ""
+	changed.Labels = []string{"work", "house"}
+	changed.Transcripts = append([]TranscriptEntry{}, item.Transcripts...)
+	changed.Transcripts[0].Text = "Welcome to our meeting."
+	queue := &summaryQueue{summarizer: busySummarizer{during: func() {
+		if err := writeMetadataFile(metadataPath, changed); err != nil {
+			t.Error(err)
+		}
+	}}}
+
+	if err := queue.summarize(summaryJob{filename: "talk.mp3", force: true}); err != nil {
+		t.Fatalf("summarize: %v", err)
+	}
+	var written MediaMetadata
+	if _, err := readMarkdownFile(metadataPath, &written); err != nil {
+		t.Fatal(err)
+	}
+	if written.Summary != "A talk." || len(written.Chapters) != 1 || written.SummaryModel != "busy-model" || written.SummarizedAt == "" {
+		t.Errorf("summary not written: %+v", written)
+	}
+	if written.Language != "" || !reflect.DeepEqual(written.Labels, changed.Labels) ||
+		written.Transcripts[0].Text != "Welcome to our meeting." {
+		t.Errorf("changes made while summarizing lost: language %q, labels %v, %+v",
+			written.Language, written.Labels, written.Transcripts[0])
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Transcriber turns an audio file into transcript segments
+type Transcriber interface {
+	// Name identifies the engine in stored transcripts, e.g. "whisperx"
+	Name() string
+	// Model identifies the model the engine runs, e.g. "base-en"
+	Model() string
+	// Transcribe runs the engine on an audio file. Cancelling ctx must stop
+	// any external process or request the engine started.
+	Transcribe(ctx context.Context, audioPath string, opts TranscribeOptions) (TranscribeResult, error)
+}
+
+// TranscribeOptions are the settings for transcribing one file
+type TranscribeOptions struct {
+	Language string // ISO 639-1 code; empty asks the engine to detect the language
+	Model    string // Model to run instead of the configured one, e.g. to compare models
+}
+
+// TranscribeResult is what a transcriber produced for one file
+type TranscribeResult struct {
+	Segments []TranscriptEntry
+	Model    string // Model actually run, which depends on the language
+
+	// Language of the audio, and the engine's confidence if it detected it
+	Language            string
+	LanguageProbability float64
+}
+
+// TranscriptFile is the on-disk format of data/transcripts/<filename>.json
+type TranscriptFile struct {
+	Engine    string            `json:"engine"`
+	Model     string            `json:"model,omitempty"`
+	CreatedAt string            `json:"createdAt"`
+	EditedAt  string            `json:"editedAt,omitempty"` // Last manual edit, if any
+	Segments  []TranscriptEntry `json:"segments"`
+
+	// Language of the transcript and whether the engine detected it
+	Language            string  `json:"language,omitempty"`
+	LanguageDetected    bool    `json:"languageDetected,omitempty"`
+	LanguageProbability float64 `json:"languageProbability,omitempty"`
+
+	// What cleanup changed in the engine's output, if anything
+	Cleanup *CleanupReport `json:"cleanup,omitempty"`
+
+	// Number of the version this transcript is; 0 for transcripts written
+	// before versions were kept
+	Version int `json:"version,omitempty"`
+}
+
+// Create the transcriber selected in the configuration
+func newTranscriber(config TranscriptionConfig) (Transcriber, error) {
+	switch config.Backend {
+	case "", "whisperx":
+		image := config.Image
+		if image == "" {
+			image = "ghcr.io/jim60105/whisperx:" + config.Model
+		}
+		return &whisperXTranscriber{
+			image:        image,
+			fixedImage:   config.Image != "",
+			model:        config.Model,
+			multilingual: config.MultilingualModel,
+			computeType:  config.ComputeType,
+			modelCache:   config.ModelCache,
+			diarize:      config.Diarize,
+			minSpeakers:  config.MinSpeakers,
+			maxSpeakers:  config.MaxSpeakers,
+			hfToken:      config.HFToken,
+		}, nil
+	case "whispercpp":
+		if config.Diarize {
+			log.Printf("Warning: the whispercpp backend does not support diarization; segments will have no speakers")
+		}
+		if config.ModelPath == "" {
+			return nil, fmt.Errorf("whispercpp backend requires modelPath")
+		}
+		return &whisperCppTranscriber{
+			binary:                config.Binary,
+			modelPath:             config.ModelPath,
+			multilingualModelPath: config.MultilingualModelPath,
+			model:                 config.Model,
+		}, nil
+	case "openai":
+		if config.Diarize {
+			log.Printf("Warning: the openai backend does not support diarization; segments will have no speakers")
+		}
+		if config.Endpoint == "" {
+			return nil, fmt.Errorf("openai backend requires endpoint")
+		}
+		return &openAITranscriber{
+			endpoint:     config.Endpoint,
+			apiKey:       config.APIKey,
+			model:        config.Model,
+			multilingual: config.MultilingualModel,
+		}, nil
+	case "fake":
+		return &fakeTranscriber{diarize: config.Diarize}, nil
+	default:
+		return nil, fmt.Errorf("unknown transcription backend: %s", config.Backend)
+	}
+}
+
+// Store a transcript produced by a transcriber as the item's new active
+// version. detected says whether the language was detected rather than requested.
+func writeTranscriptFile(filename string, transcriber Transcriber, result TranscribeResult, detected bool, cleanup *CleanupReport) error {
+	transcript := TranscriptFile{
+		Engine:    transcriber.Name(),
+		Model:     result.Model,
+		CreatedAt: time.Now().Format(time.RFC3339),
+		Segments:  result.Segments,
+		Language:  result.Language,
+		Cleanup:   cleanup,
+	}
+	if transcript.Model == "" {
+		transcript.Model = transcriber.Model()
+	}
+	if detected && result.Language != "" {
+		transcript.LanguageDetected = true
+		transcript.LanguageProbability = result.LanguageProbability
+	}
+	return storeTranscriptVersion(filename, transcript)
+}
+
+// Write a transcript file
+func saveTranscriptFile(transcriptPath string, transcript TranscriptFile) error {
+	data, err := json.MarshalIndent(transcript, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal transcript: %v", err)
+	}
+
+	if err := os.WriteFile(transcriptPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write transcript file: %v", err)
+	}
+
+	return nil
+}
+
+// Read a transcript file, accepting the older format that was a bare list of segments
+func readTranscriptFile(transcriptPath string) (TranscriptFile, error) {
+	data, err := os.ReadFile(transcriptPath)
+	if err != nil {
+		return TranscriptFile{}, fmt.Errorf("failed to read transcript file: %v", err)
+	}
+
+	var transcript TranscriptFile
+	if err := json.Unmarshal(data, &transcript); err != nil {
+		var segments []TranscriptEntry
+		if legacyErr := json.Unmarshal(data, &segments); legacyErr != nil {
+			return TranscriptFile{}, fmt.Errorf("failed to parse transcript: %v", err)
+		}
+		transcript = TranscriptFile{Engine: "whisperx", Segments: segments}
+	}
+
+	return transcript, nil
+}
+
+// fakeTranscriber produces a deterministic transcript without running any
+// engine, for tests and for trying the pipeline on machines without one
+type fakeTranscriber struct {
+	diarize bool // Alternate segments between two speakers
+}
+
+func (t *fakeTranscriber) Name() string  { return "fake" }
+func (t *fakeTranscriber) Model() string { return "fake" }
+
+// The fake "detects" English with a fixed probability
+func (t *fakeTranscriber) Transcribe(ctx context.Context, audioPath string, opts TranscribeOptions) (TranscribeResult, error) {
+	if _, err := os.Stat(audioPath); err != nil {
+		return TranscribeResult{}, fmt.Errorf("failed to read audio file: %v", err)
+	}
+	if err := ctx.Err(); err != nil {
+		return TranscribeResult{}, err
+	}
+
+	name := filepath.Base(audioPath)
+	var entries []TranscriptEntry
+	for i := 0; i < 3; i++ {
+		entry := TranscriptEntry{
+			Start:   float64(i * 5),
+			End:     float64(i*5 + 4),
+			Text:    fmt.Sprintf("Fake transcript segment %d of %s.", i+1, name),
+			Segment: i,
+		}
+		if t.diarize {
+			entry.Speaker = fmt.Sprintf("SPEAKER_%02d", i%2)
+		}
+
+		// Spread the words evenly over the segment; the number is "unsure"
+		words := strings.Fields(entry.Text)
+		step := (entry.End - entry.Start) / float64(len(words))
+		for j, text := range words {
+			score := 0.95
+			if strings.ContainsAny(text, "0123456789") {
+				score = 0.4
+			}
+			entry.Words = append(entry.Words, TranscriptWord{
+				Word:  text,
+				Start: entry.Start + float64(j)*step,
+				End:   entry.Start + float64(j+1)*step,
+				Score: &score,
+			})
+		}
+		entries = append(entries, entry)
+		reportProgress(ctx, float64(i*5+4))
+	}
+
+	result := TranscribeResult{Segments: entries, Model: t.Model(), Language: opts.Language}
+	if opts.Model != "" {
+		result.Model = opts.Model
+	}
+	if opts.Language == "" {
+		result.Language = "en"
+		result.LanguageProbability = 0.97
+	}
+	return result, nil
+}
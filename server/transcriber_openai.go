@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// openAITranscriber sends audio to an OpenAI-compatible
+// /v1/audio/transcriptions endpoint, such as a local faster-whisper server
+type openAITranscriber struct {
+	endpoint     string
+	apiKey       string
+	model        string
+	multilingual string
+}
+
+func (t *openAITranscriber) Name() string  { return "openai" }
+func (t *openAITranscriber) Model() string { return t.model }
+
+// Upload an audio file to the transcription endpoint. The endpoint reports
+// the language it detected but not how sure it is.
+func (t *openAITranscriber) Transcribe(ctx context.Context, audioPath string, opts TranscribeOptions) (TranscribeResult, error) {
+	audioFile, err := os.Open(audioPath)
+	if err != nil {
+		return TranscribeResult{}, fmt.Errorf("failed to open audio file: %v", err)
+	}
+	defer audioFile.Close()
+
+	model := modelForLanguage(t.model, t.multilingual, opts.Language)
+	if opts.Model != "" {
+		model = opts.Model
+	}
+
+	// Stream the multipart body so large recordings aren't held in memory
+	body, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+	go func() {
+		part, err := form.CreateFormFile("file", filepath.Base(audioPath))
+		if err == nil {
+			_, err = io.Copy(part, audioFile)
+		}
+		if err == nil && model != "" {
+			err = form.WriteField("model", model)
+		}
+		if err == nil && opts.Language != "" {
+			err = form.WriteField("language", opts.Language)
+		}
+		if err == nil {
+			err = form.WriteField("response_format", "verbose_json")
+		}
+		for _, granularity := range []string{"segment", "word"} {
+			if err == nil {
+				err = form.WriteField("timestamp_granularities[]", granularity)
+			}
+		}
+		if err == nil {
+			err = form.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+
+	url := strings.TrimSuffix(t.endpoint, "/") + "/v1/audio/transcriptions"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, body)
+	if err != nil {
+		return TranscribeResult{}, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if t.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+t.apiKey)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return TranscribeResult{}, fmt.Errorf("transcription request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return TranscribeResult{}, fmt.Errorf("failed to read transcription response: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return TranscribeResult{}, fmt.Errorf("transcription endpoint returned %s: %s", resp.Status, string(bytes.TrimSpace(data)))
+	}
+
+	result, err := parseWhisperOutput(data)
+	if err != nil {
+		return TranscribeResult{}, err
+	}
+	result.Model = model
+	if opts.Language != "" {
+		result.Language = opts.Language
+	}
+	return result, nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// whisper.cpp logs e.g. "auto-detected language: de (p = 0.981234)"
+var whisperCppLanguagePattern = regexp.MustCompile(`auto-detected language: (\w+) \(p = ([0-9.]+)\)`)
+
+// whisperCppTranscriber runs a local whisper.cpp CLI
+type whisperCppTranscriber struct {
+	binary                string
+	modelPath             string
+	multilingualModelPath string
+	model                 string
+}
+
+// whisperCppOffsets are start and end times in milliseconds
+type whisperCppOffsets struct {
+	From int64 `json:"from"`
+	To   int64 `json:"to"`
+}
+
+// whisperCppOutput is the part of whisper.cpp's --output-json-full format we use
+type whisperCppOutput struct {
+	Result struct {
+		Language string `json:"language"`
+	} `json:"result"`
+	Transcription []struct {
+		Offsets whisperCppOffsets `json:"offsets"`
+		Text    string            `json:"text"`
+		Tokens  []struct {
+			Text        string            `json:"text"`
+			Offsets     whisperCppOffsets `json:"offsets"`
+			Probability float64           `json:"p"`
+		} `json:"tokens"`
+	} `json:"transcription"`
+}
+
+func (t *whisperCppTranscriber) Name() string { return "whispercpp" }
+
+func (t *whisperCppTranscriber) Model() string {
+	if t.model != "" {
+		return t.model
+	}
+	return filepath.Base(t.modelPath)
+}
+
+// ggml model file for a language. English-only models (ggml-base.en.bin)
+// can't transcribe or detect other languages.
+func (t *whisperCppTranscriber) modelPathFor(language string) string {
+	if language == "en" {
+		return t.modelPath
+	}
+	if t.multilingualModelPath != "" {
+		return t.multilingualModelPath
+	}
+	if name := filepath.Base(t.modelPath); strings.Contains(name, ".en.") {
+		candidate := filepath.Join(filepath.Dir(t.modelPath), strings.Replace(name, ".en.", ".", 1))
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate
+		}
+	}
+	return t.modelPath
+}
+
+// Run whisper.cpp on an audio file
+func (t *whisperCppTranscriber) Transcribe(ctx context.Context, audioPath string, opts TranscribeOptions) (TranscribeResult, error) {
+	tempDir, err := os.MkdirTemp("", "whispercpp")
+	if err != nil {
+		return TranscribeResult{}, fmt.Errorf("failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// whisper.cpp only reads 16 kHz WAV, so convert whatever we were given
+	wavPath := filepath.Join(tempDir, "input.wav")
+	if err := extractAudioFromVideo(ctx, audioPath, wavPath); err != nil {
+		return TranscribeResult{}, fmt.Errorf("failed to convert audio: %v", err)
+	}
+
+	language := opts.Language
+	if language == "" {
+		language = "auto"
+	}
+	modelPath := t.modelPathFor(opts.Language)
+	if opts.Model != "" {
+		// Another ggml model file, looked for next to the configured one
+		modelPath = opts.Model
+		if !filepath.IsAbs(modelPath) {
+			modelPath = filepath.Join(filepath.Dir(t.modelPath), modelPath)
+		}
+	}
+	outputBase := filepath.Join(tempDir, "output")
+	cmd := exec.CommandContext(ctx, t.binary, "-m", modelPath, "-l", language, "-f", wavPath, "--output-json-full", "--output-file", outputBase)
+	// Segments are printed as they are transcribed; watch them for progress
+	var output bytes.Buffer
+	cmd.Stdout = io.MultiWriter(&output, newProgressWriter(ctx))
+	cmd.Stderr = cmd.Stdout
+	if err := cmd.Run(); err != nil {
+		return TranscribeResult{}, fmt.Errorf("whisper.cpp error: %v, output: %s", err, output.String())
+	}
+
+	data, err := os.ReadFile(outputBase + ".json")
+	if err != nil {
+		return TranscribeResult{}, fmt.Errorf("failed to read whisper.cpp output: %v", err)
+	}
+
+	var result whisperCppOutput
+	if err := json.Unmarshal(data, &result); err != nil {
+		return TranscribeResult{}, fmt.Errorf("failed to parse whisper.cpp output: %v", err)
+	}
+
+	var transcriptEntries []TranscriptEntry
+	for i, segment := range result.Transcription {
+		entry := TranscriptEntry{
+			Start:   float64(segment.Offsets.From) / 1000,
+			End:     float64(segment.Offsets.To) / 1000,
+			Text:    segment.Text,
+			Segment: i,
+		}
+
+		// Tokens are word pieces; a token starting with a space begins a new
+		// word. A word's score is its least confident token.
+		for _, token := range segment.Tokens {
+			if strings.HasPrefix(token.Text, "[_") || token.Text == "" {
+				continue // Special tokens such as [_BEG_]
+			}
+			probability := token.Probability
+			start := float64(token.Offsets.From) / 1000
+			end := float64(token.Offsets.To) / 1000
+			if last := len(entry.Words) - 1; last >= 0 && !strings.HasPrefix(token.Text, " ") {
+				entry.Words[last].Word += token.Text
+				entry.Words[last].End = end
+				if probability < *entry.Words[last].Score {
+					*entry.Words[last].Score = probability
+				}
+				continue
+			}
+			entry.Words = append(entry.Words, TranscriptWord{
+				Word:  strings.TrimSpace(token.Text),
+				Start: start,
+				End:   end,
+				Score: &probability,
+			})
+		}
+
+		transcriptEntries = append(transcriptEntries, entry)
+	}
+
+	transcribed := TranscribeResult{Segments: transcriptEntries, Model: t.Model(), Language: opts.Language}
+	if modelPath != t.modelPath {
+		transcribed.Model = filepath.Base(modelPath)
+	}
+	if opts.Language == "" {
+		transcribed.Language, transcribed.LanguageProbability = parseDetectedLanguage(output.String(), whisperCppLanguagePattern)
+		if transcribed.Language == "" {
+			transcribed.Language, _ = normalizeLanguage(result.Result.Language)
+		}
+	}
+	return transcribed, nil
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Image with no model baked in, which downloads the model it is asked for.
+// Used for every language but English, whose model is in the default image.
+const whisperXMultilingualImage = "ghcr.io/jim60105/whisperx:no_model"
+
+// Where the no_model image keeps downloaded models
+const whisperXCachePath = "/.cache"
+
+// whisperx logs e.g. "Detected language: de (0.98) in first 30s of audio..."
+var whisperXLanguagePattern = regexp.MustCompile(`Detected language: (\w+) \(([0-9.]+)\)`)
+
+// whisperXTranscriber runs whisperx in a podman container
+type whisperXTranscriber struct {
+	image        string
+	fixedImage   bool // image was configured, so use it for every language
+	model        string
+	multilingual string
+	computeType  string
+	modelCache   string // Volume mounted as the no_model image's model cache
+
+	// Speaker diarization with pyannote
+	diarize     bool
+	minSpeakers int
+	maxSpeakers int
+	hfToken     string
+}
+
+func (t *whisperXTranscriber) Name() string  { return "whisperx" }
+func (t *whisperXTranscriber) Model() string { return t.model }
+
+// Run whisperx on an audio file
+func (t *whisperXTranscriber) Transcribe(ctx context.Context, audioPath string, opts TranscribeOptions) (TranscribeResult, error) {
+	// Create a temporary directory for whisperx output
+	tempDir, err := os.MkdirTemp("", "whisperx")
+	if err != nil {
+		return TranscribeResult{}, fmt.Errorf("failed to create temp directory: %v", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// The container runs as a different user and must be able to write its output
+	if err := os.Chmod(tempDir, 0777); err != nil {
+		return TranscribeResult{}, fmt.Errorf("failed to make temp directory writable: %v", err)
+	}
+
+	audioFileName := filepath.Base(audioPath)
+	tempAudioPath := filepath.Join(tempDir, audioFileName)
+	if err := copyFile(audioPath, tempAudioPath); err != nil {
+		return TranscribeResult{}, fmt.Errorf("failed to copy audio file: %v", err)
+	}
+	if err := os.Chmod(tempAudioPath, 0666); err != nil {
+		return TranscribeResult{}, fmt.Errorf("failed to make audio file readable: %v", err)
+	}
+
+	// English runs the configured model in its image; other languages and
+	// detection need a multilingual model
+	model := modelForLanguage(t.model, t.multilingual, opts.Language)
+	if opts.Model != "" {
+		model = opts.Model
+	}
+	image := t.image
+	if model != t.model && !t.fixedImage {
+		image = whisperXMultilingualImage
+	}
+
+	// Run whisperx. The container is named so that cancelling can remove it;
+	// killing the podman client alone would leave the container running.
+	containerName := "timelineviewer-" + filepath.Base(tempDir)
+	args := []string{"run", "--rm", "--name", containerName, "-v", tempDir + ":/app:Z"}
+	if image == whisperXMultilingualImage && t.modelCache != "" {
+		// Keep downloaded models between runs; other images have theirs built in
+		args = append(args, "-v", t.modelCache+":"+whisperXCachePath+":Z")
+	}
+	args = append(args, image, "--", "--output_format", "json")
+	if model != t.model {
+		args = append(args, "--model", model)
+	}
+	if opts.Language != "" {
+		args = append(args, "--language", opts.Language)
+	}
+	if t.computeType != "" {
+		args = append(args, "--compute_type", t.computeType)
+	}
+	if t.diarize {
+		args = append(args, "--diarize")
+		if t.hfToken != "" {
+			args = append(args, "--hf_token", t.hfToken)
+		}
+		if t.minSpeakers > 0 {
+			args = append(args, "--min_speakers", strconv.Itoa(t.minSpeakers))
+		}
+		if t.maxSpeakers > 0 {
+			args = append(args, "--max_speakers", strconv.Itoa(t.maxSpeakers))
+		}
+	}
+	args = append(args, audioFileName)
+	cmd := exec.CommandContext(ctx, "podman", args...)
+	cmd.Cancel = func() error {
+		exec.Command("podman", "rm", "--force", containerName).Run()
+		return cmd.Process.Kill()
+	}
+	// Segments are printed as they are transcribed; watch them for progress
+	var output bytes.Buffer
+	cmd.Stdout = io.MultiWriter(&output, newProgressWriter(ctx))
+	cmd.Stderr = cmd.Stdout
+	if err := cmd.Run(); err != nil {
+		return TranscribeResult{}, fmt.Errorf("whisperx error: %v, output: %s", err, output.String())
+	}
+
+	// Find the JSON output file
+	files, err := os.ReadDir(tempDir)
+	if err != nil {
+		return TranscribeResult{}, fmt.Errorf("failed to read whisperx output directory: %v", err)
+	}
+
+	var jsonFile string
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), ".json") {
+			jsonFile = filepath.Join(tempDir, file.Name())
+			break
+		}
+	}
+
+	if jsonFile == "" {
+		return TranscribeResult{}, fmt.Errorf("no JSON output found from whisperx")
+	}
+
+	// Read the whisperx output
+	data, err := os.ReadFile(jsonFile)
+	if err != nil {
+		return TranscribeResult{}, fmt.Errorf("failed to read whisperx output: %v", err)
+	}
+
+	result, err := parseWhisperOutput(data)
+	if err != nil {
+		return TranscribeResult{}, err
+	}
+	result.Model = model
+	if opts.Language != "" {
+		result.Language = opts.Language
+	} else if language, probability := parseDetectedLanguage(output.String(), whisperXLanguagePattern); language != "" {
+		result.Language, result.LanguageProbability = language, probability
+	}
+	return result, nil
+}
+
+// Convert whisper-style JSON output ({"segments": [{"start", "end", "text"}]})
+// to our transcript format. Used for whisperx and OpenAI verbose_json output.
+// Diarized whisperx output also has a "speaker" on each segment, and aligned
+// output a "words" list; OpenAI-style output lists words for the whole file.
+// Both report the transcript's "language".
+func parseWhisperOutput(data []byte) (TranscribeResult, error) {
+	var whisperOutput map[string]interface{}
+	if err := json.Unmarshal(data, &whisperOutput); err != nil {
+		return TranscribeResult{}, fmt.Errorf("failed to parse whisper output: %v", err)
+	}
+
+	segments, ok := whisperOutput["segments"].([]interface{})
+	if !ok {
+		return TranscribeResult{}, fmt.Errorf("invalid whisper output format")
+	}
+
+	var transcriptEntries []TranscriptEntry
+	for i, seg := range segments {
+		segment, ok := seg.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		start, _ := segment["start"].(float64)
+		end, _ := segment["end"].(float64)
+		text, _ := segment["text"].(string)
+		speaker, _ := segment["speaker"].(string)
+
+		entry := TranscriptEntry{
+			Start:   start,
+			End:     end,
+			Text:    text,
+			Segment: i,
+			Speaker: speaker,
+			Words:   parseWhisperWords(segment["words"]),
+		}
+		if noSpeechProb, ok := segment["no_speech_prob"].(float64); ok {
+			entry.NoSpeechProb = &noSpeechProb
+		}
+
+		transcriptEntries = append(transcriptEntries, entry)
+	}
+
+	if words := parseWhisperWords(whisperOutput["words"]); len(words) > 0 {
+		assignWordsToSegments(transcriptEntries, words)
+	}
+
+	result := TranscribeResult{Segments: transcriptEntries}
+	if language, ok := whisperOutput["language"].(string); ok {
+		result.Language, _ = normalizeLanguage(language)
+	}
+	return result, nil
+}
+
+// Convert a whisper-style word list ([{"word", "start", "end", "score"}]).
+// Words the aligner couldn't place, such as numbers, have no times and are
+// given the end of the word before them.
+func parseWhisperWords(value interface{}) []TranscriptWord {
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil
+	}
+
+	var words []TranscriptWord
+	previousEnd := 0.0
+	for _, item := range list {
+		fields, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		word := TranscriptWord{Start: previousEnd, End: previousEnd}
+		word.Word, _ = fields["word"].(string)
+		word.Speaker, _ = fields["speaker"].(string)
+		if start, ok := fields["start"].(float64); ok {
+			word.Start = start
+			word.End = start
+		}
+		if end, ok := fields["end"].(float64); ok {
+			word.End = end
+		}
+		if score, ok := fields["score"].(float64); ok {
+			word.Score = &score
+		} else if probability, ok := fields["probability"].(float64); ok {
+			word.Score = &probability
+		}
+
+		previousEnd = word.End
+		words = append(words, word)
+	}
+
+	return words
+}
+
+// Distribute a file-wide word list over the segments by start time
+func assignWordsToSegments(entries []TranscriptEntry, words []TranscriptWord) {
+	if len(entries) == 0 {
+		return
+	}
+
+	segment := 0
+	for _, word := range words {
+		for segment < len(entries)-1 && word.Start >= entries[segment+1].Start {
+			segment++
+		}
+		entries[segment].Words = append(entries[segment].Words, word)
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Words scoring below this are flagged for review unless ?threshold= is given
+const defaultLowConfidence = 0.5
+
+// TranscriptResponse is a media item's transcript as served by the API
+type TranscriptResponse struct {
+	ID          string                   `json:"id"`
+	Filename    string                   `json:"filename"`
+	Engine      string                   `json:"engine,omitempty"`
+	Model       string                   `json:"model,omitempty"`
+	Language    string                   `json:"language,omitempty"`
+	Granularity string                   `json:"granularity"` // "segment" or "word"
+	Speakers    map[string]string        `json:"speakers,omitempty"`
+	Segments    []TranscriptEntry        `json:"segments"`
+	Words       []TranscriptWordResponse `json:"words,omitempty"`
+}
+
+// TranscriptWordResponse is a word with the segment it belongs to and
+// whether its confidence is below the review threshold
+type TranscriptWordResponse struct {
+	TranscriptWord
+	Segment       int  `json:"segment"`
+	LowConfidence bool `json:"lowConfidence,omitempty"`
+}
+
+// Copy segments without their word lists, which are too large for metadata files
+func segmentsWithoutWords(entries []TranscriptEntry) []TranscriptEntry {
+	if entries == nil {
+		return nil
+	}
+	stripped := make([]TranscriptEntry, len(entries))
+	for i, entry := range entries {
+		entry.Words = nil
+		entry.NoSpeechProb = nil
+		stripped[i] = entry
+	}
+	return stripped
+}
+
+// Load the full transcript of an item, falling back to the segments in its
+// metadata when the transcript file is missing
+func loadTranscript(metadata MediaMetadata) TranscriptFile {
+	transcriptPath := filepath.Join(transcriptsDir, metadata.Filename+".json")
+	transcript, err := readTranscriptFile(transcriptPath)
+	if err != nil {
+		if _, statErr := os.Stat(transcriptPath); !os.IsNotExist(statErr) {
+			log.Printf("Failed to read transcript for %s: %v", metadata.Filename, err)
+		}
+		return TranscriptFile{
+			Engine:   metadata.TranscriptEngine,
+			Model:    metadata.TranscriptModel,
+			Segments: metadata.Transcripts,
+		}
+	}
+	return transcript
+}
+
+// Handler for requests about a single media item: /api/media/{id}/...
+func handleMediaItem(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/media/")
+	id, resource, _ := strings.Cut(path, "/")
+	if id == "" || resource == "" {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	_, metadata, err := findMetadataByID(id)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			http.Error(w, "Media item not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to read metadata", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	switch {
+	case resource == "transcript":
+		handleTranscript(w, r, metadata)
+	case strings.HasPrefix(resource, "transcript/"):
+		handleTranscriptEdit(w, r, metadata, strings.TrimPrefix(resource, "transcript/"))
+	case strings.HasPrefix(resource, "transcript."):
+		handleTranscriptExport(w, r, metadata, strings.TrimPrefix(resource, "transcript."))
+	case resource == "language":
+		handleSetLanguage(w, r, metadata)
+	case resource == "summary":
+		handleSummary(w, r, metadata)
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+	}
+}
+
+// Serve an item's transcript. ?granularity=word adds a flat list of words
+// with timings and confidence; ?threshold= sets the low-confidence cutoff.
+func handleTranscript(w http.ResponseWriter, r *http.Request, metadata MediaMetadata) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	queryParams := r.URL.Query()
+	granularity := queryParams.Get("granularity")
+	if granularity == "" {
+		granularity = "segment"
+	}
+	if granularity != "segment" && granularity != "word" {
+		http.Error(w, "granularity must be segment or word", http.StatusBadRequest)
+		return
+	}
+
+	threshold := defaultLowConfidence
+	if value := queryParams.Get("threshold"); value != "" {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil || parsed < 0 || parsed > 1 {
+			http.Error(w, "threshold must be a number between 0 and 1", http.StatusBadRequest)
+			return
+		}
+		threshold = parsed
+	}
+
+	transcript := loadTranscript(metadata)
+	response := TranscriptResponse{
+		ID:          metadata.ID,
+		Filename:    metadata.Filename,
+		Engine:      transcript.Engine,
+		Model:       transcript.Model,
+		Language:    transcript.Language,
+		Granularity: granularity,
+		Speakers:    metadata.Speakers,
+		Segments:    transcript.Segments,
+	}
+	if response.Segments == nil {
+		response.Segments = []TranscriptEntry{}
+	}
+
+	if granularity == "word" {
+		response.Words = []TranscriptWordResponse{}
+		for _, segment := range transcript.Segments {
+			for _, word := range segment.Words {
+				if word.Speaker == "" {
+					word.Speaker = segment.Speaker
+				}
+				response.Words = append(response.Words, TranscriptWordResponse{
+					TranscriptWord: word,
+					Segment:        segment.Segment,
+					LowConfidence:  word.Score != nil && *word.Score < threshold,
+				})
+			}
+		}
+	} else {
+		response.Segments = segmentsWithoutWords(response.Segments)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
@@ -0,0 +1,577 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The Markdown body of a metadata file shows the transcript as paragraphs,
+// each starting with a [hh:mm:ss] timecode and the speaker's name when known:
+//
+//	[00:01:05] **Alice:** Welcome back. Today we...
+//
+// A new paragraph starts at a speaker change, after a pause, or when a
+// paragraph gets long. The body is rendered from the segments whenever the
+// metadata is written. A watcher notices when the body differs from the one
+// the app last wrote and applies the edits to the segments as a new
+// revision.
+
+const (
+	// Gap between segments that starts a new paragraph, in seconds
+	paragraphPause = 2.0
+	// Longest a paragraph runs before a new one starts at the next segment
+	paragraphMaxSeconds = 60.0
+
+	// How often metadata files are checked for edited bodies
+	transcriptBodyPollInterval = 5 * time.Second
+
+	// Metadata files changed more recently than this are checked at a later
+	// scan, once they are no longer being written
+	bodySettleTime = 3 * time.Second
+
+	// Author recorded for edits made to the body outside the app
+	externalEditAuthor = "external editor"
+
+	// Written once bodies without timecodes have been rendered again
+	transcriptBodyMigrationFile = "./data/transcript-bodies-migrated"
+)
+
+// Matches the timecode and optional speaker that start a paragraph
+var paragraphHeaderPattern = regexp.MustCompile(`^\[(\d+):(\d{2}):(\d{2})\]\s*(?:\*\*([^*]+?):\*\*\s*)?`)
+
+// Splits the body into paragraphs
+var paragraphBreakPattern = regexp.MustCompile(`\n[ \t]*\n`)
+
+// transcriptParagraph is a run of segments shown as one paragraph
+type transcriptParagraph struct {
+	Start    float64
+	Speaker  string // Speaker ID
+	Segments []int  // Indexes into the transcript's segments
+}
+
+// parsedParagraph is a paragraph read back from a body
+type parsedParagraph struct {
+	Timed   bool
+	Start   int // Whole seconds
+	Speaker string
+	Text    string
+}
+
+// Group segments into paragraphs
+func transcriptParagraphs(entries []TranscriptEntry) []transcriptParagraph {
+	var paragraphs []transcriptParagraph
+	for i, entry := range entries {
+		if len(paragraphs) > 0 {
+			current := &paragraphs[len(paragraphs)-1]
+			previous := entries[i-1]
+			if entry.Speaker == current.Speaker && entry.Start-previous.End < paragraphPause &&
+				entry.Start-current.Start < paragraphMaxSeconds {
+				current.Segments = append(current.Segments, i)
+				continue
+			}
+		}
+		paragraphs = append(paragraphs, transcriptParagraph{Start: entry.Start, Speaker: entry.Speaker, Segments: []int{i}})
+	}
+	return paragraphs
+}
+
+// Format a time as a [hh:mm:ss] timecode
+func formatTimecode(seconds float64) string {
+	total := int(math.Max(seconds, 0))
+	return fmt.Sprintf("[%02d:%02d:%02d]", total/3600, total/60%60, total%60)
+}
+
+// Build the Markdown body of a metadata file from its transcript segments
+func transcriptBody(metadata MediaMetadata) string {
+	var body strings.Builder
+	for i, paragraph := range transcriptParagraphs(metadata.Transcripts) {
+		if i > 0 {
+			body.WriteString("\n")
+		}
+		body.WriteString(formatTimecode(paragraph.Start))
+		if paragraph.Speaker != "" {
+			body.WriteString(" **" + speakerName(metadata, paragraph.Speaker) + ":**")
+		}
+		for _, index := range paragraph.Segments {
+			if text := strings.TrimSpace(metadata.Transcripts[index].Text); text != "" {
+				body.WriteString(" " + text)
+			}
+		}
+		body.WriteString("\n")
+	}
+	return body.String()
+}
+
+// Plain text of a transcript, for matching against
+func transcriptText(entries []TranscriptEntry) string {
+	var text strings.Builder
+	for _, entry := range entries {
+		text.WriteString(entry.Text)
+		text.WriteString(" ")
+	}
+	return text.String()
+}
+
+// Split a body into paragraphs with their timecodes and speakers
+func parseTranscriptBody(body string) []parsedParagraph {
+	body = strings.ReplaceAll(body, "\r\n", "\n")
+
+	var paragraphs []parsedParagraph
+	for _, block := range paragraphBreakPattern.Split(body, -1) {
+		text := strings.Join(strings.Fields(block), " ")
+		if text == "" {
+			continue
+		}
+
+		paragraph := parsedParagraph{Text: text}
+		if match := paragraphHeaderPattern.FindStringSubmatch(text); match != nil {
+			hours, _ := strconv.Atoi(match[1])
+			minutes, _ := strconv.Atoi(match[2])
+			seconds, _ := strconv.Atoi(match[3])
+			paragraph.Timed = true
+			paragraph.Start = hours*3600 + minutes*60 + seconds
+			paragraph.Speaker = strings.TrimSpace(match[4])
+			paragraph.Text = strings.TrimSpace(text[len(match[0]):])
+		}
+		paragraphs = append(paragraphs, paragraph)
+	}
+	return paragraphs
+}
+
+// Apply the edits made to a body to the segments it was rendered from.
+// Paragraphs are matched to the segments by their timecodes. A paragraph
+// without a timecode, or with one that matches no paragraph, is part of the
+// paragraph before it; paragraphs that were deleted drop their segments.
+// Returns false if the body has no timecodes to match, as bodies written
+// before they had them.
+func applyBodyEdits(metadata MediaMetadata, body string) ([]TranscriptEntry, bool) {
+	parsed := parseTranscriptBody(body)
+	timed := false
+	for _, paragraph := range parsed {
+		timed = timed || paragraph.Timed
+	}
+	if !timed {
+		return nil, false
+	}
+
+	groups := transcriptParagraphs(metadata.Transcripts)
+	edited := make([]*parsedParagraph, len(groups))
+	next := 0
+	var current *parsedParagraph
+	var leading []string // Text before the first matched paragraph
+	for _, paragraph := range parsed {
+		matched := -1
+		if paragraph.Timed {
+			for k := next; k < len(groups); k++ {
+				if int(groups[k].Start) == paragraph.Start {
+					matched = k
+					break
+				}
+			}
+		}
+
+		switch {
+		case matched >= 0:
+			p := paragraph
+			if len(leading) > 0 {
+				p.Text = strings.Join(append(leading, p.Text), " ")
+				leading = nil
+			}
+			edited[matched] = &p
+			current = &p
+			next = matched + 1
+		case current != nil:
+			current.Text += " " + paragraph.Text
+		default:
+			leading = append(leading, paragraph.Text)
+		}
+	}
+
+	var segments []TranscriptEntry
+	for k, group := range groups {
+		if edited[k] == nil {
+			continue // Paragraph deleted
+		}
+		groupSegments := make([]TranscriptEntry, 0, len(group.Segments))
+		for _, index := range group.Segments {
+			groupSegments = append(groupSegments, metadata.Transcripts[index])
+		}
+		groupSegments = distributeText(groupSegments, edited[k].Text)
+
+		if name := edited[k].Speaker; name != "" && name != speakerName(metadata, group.Speaker) {
+			speaker := speakerIDForName(metadata, name)
+			for i := range groupSegments {
+				groupSegments[i].Speaker = speaker
+			}
+		}
+		segments = append(segments, groupSegments...)
+	}
+	return segments, true
+}
+
+// Speaker ID shown as a name in an item, or the name itself for a new speaker
+func speakerIDForName(metadata MediaMetadata, name string) string {
+	for _, entry := range metadata.Transcripts {
+		if entry.Speaker != "" && (entry.Speaker == name || speakerName(metadata, entry.Speaker) == name) {
+			return entry.Speaker
+		}
+	}
+	return name
+}
+
+// Spread a paragraph's edited text over its segments. Words are aligned with
+// the old text; kept words stay in their segment and inserted words join the
+// segment of the word before them. Segments left without words are dropped.
+func distributeText(segments []TranscriptEntry, text string) []TranscriptEntry {
+	var oldTokens []string
+	var owners []int
+	for i, segment := range segments {
+		for _, token := range strings.Fields(segment.Text) {
+			oldTokens = append(oldTokens, token)
+			owners = append(owners, i)
+		}
+	}
+	newTokens := strings.Fields(text)
+	if strings.Join(oldTokens, " ") == strings.Join(newTokens, " ") {
+		return segments
+	}
+
+	// Longest common subsequence of the old and new words
+	lcs := make([][]int, len(oldTokens)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(newTokens)+1)
+	}
+	for i := len(oldTokens) - 1; i >= 0; i-- {
+		for j := len(newTokens) - 1; j >= 0; j-- {
+			if oldTokens[i] == newTokens[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	// Owner of each new word: its matched old word's segment, or for an
+	// inserted word the segment of the word before it
+	newOwners := make([]int, len(newTokens))
+	matched := make([]bool, len(newTokens))
+	for i, j := 0, 0; i < len(oldTokens) && j < len(newTokens); {
+		switch {
+		case oldTokens[i] == newTokens[j]:
+			newOwners[j], matched[j] = owners[i], true
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	owner := -1
+	for j := range newTokens {
+		if matched[j] {
+			owner = newOwners[j]
+		} else if owner >= 0 {
+			newOwners[j] = owner
+		} else {
+			// Inserted before any kept word: the segment of the first kept word
+			newOwners[j] = 0
+			for k := j + 1; k < len(newTokens); k++ {
+				if matched[k] {
+					newOwners[j] = newOwners[k]
+					break
+				}
+			}
+		}
+	}
+
+	texts := make([][]string, len(segments))
+	for j, token := range newTokens {
+		texts[newOwners[j]] = append(texts[newOwners[j]], token)
+	}
+
+	var result []TranscriptEntry
+	for i, segment := range segments {
+		if len(texts[i]) == 0 {
+			continue
+		}
+		newText := strings.Join(texts[i], " ")
+		if newText != strings.Join(strings.Fields(segment.Text), " ") {
+			segment.Words = retextWords(segment, newText)
+			segment.Text = newText
+		}
+		result = append(result, segment)
+	}
+	return result
+}
+
+// Initialize the watcher for bodies edited outside the app, after bringing
+// bodies from before timecodes up to date
+func InitTranscriptBodyWatcher() {
+	go func() {
+		migrateTranscriptBodies()
+		transcriptBodyWatcher()
+	}()
+}
+
+// Worker that checks changed metadata files for edited bodies
+func transcriptBodyWatcher() {
+	seen := make(map[string]metadataFileState)
+	for {
+		scanTranscriptBodies(seen, time.Now())
+		time.Sleep(transcriptBodyPollInterval)
+	}
+}
+
+// Check every metadata file that changed since the last scan, and queue
+// those whose body was changed outside the app for embedding; the app
+// queues its own changes as it makes them. The first scan checks them all.
+// Files changed within the last bodySettleTime are left for a later scan, so
+// an editor that is still writing one isn't read half way and a change in
+// the same instant as the last check isn't missed.
+func scanTranscriptBodies(seen map[string]metadataFileState, now time.Time) {
+	files, err := os.ReadDir(metadataDir)
+	if err != nil {
+		log.Printf("Failed to read metadata directory: %v", err)
+		return
+	}
+
+	present := make(map[string]bool)
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || !strings.HasSuffix(name, mdExt) {
+			continue
+		}
+		present[name] = true
+
+		info, err := file.Info()
+		if err != nil || now.Sub(info.ModTime()) < bodySettleTime {
+			continue
+		}
+		state := metadataFileState{modTime: info.ModTime(), size: info.Size()}
+		if previous, ok := seen[name]; ok && previous == state {
+			continue
+		}
+		seen[name] = state
+
+		filename := strings.TrimSuffix(name, mdExt)
+		if syncTranscriptBody(filename) {
+			queueEmbedding(filename)
+		}
+	}
+
+	for name := range seen {
+		if !present[name] {
+			delete(seen, name)
+			filename := strings.TrimSuffix(name, mdExt)
+			forgetBody(filename)
+			removeEmbeddings(filename)
+		}
+	}
+}
+
+// Bring an item's segments in line with its body if the body was edited
+// outside the app, and report whether it was. A body that can't be applied
+// is kept in a file next to the item's revisions rather than overwritten.
+func syncTranscriptBody(filename string) bool {
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+
+	metadataPath := filepath.Join(metadataDir, filename+mdExt)
+	var metadata MediaMetadata
+	body, err := readMarkdownFile(metadataPath, &metadata)
+	if err != nil {
+		log.Printf("Failed to read metadata of %s: %v", filename, err)
+		return false
+	}
+	if isKnownBody(filename, body) {
+		return false
+	}
+
+	if len(metadata.Transcripts) == 0 || strings.TrimSpace(body) == strings.TrimSpace(transcriptBody(metadata)) {
+		rememberBody(filename, body)
+		return true
+	}
+
+	edited, ok := applyBodyEdits(metadata, body)
+	if !ok {
+		keepUnappliedBody(filename, body, "it has no timecodes to match paragraphs to segments")
+		rememberBody(filename, body)
+		return true
+	}
+	if len(diffSegments(metadata.Transcripts, edited)) == 0 {
+		rememberBody(filename, body) // Only reformatted
+		return true
+	}
+
+	// Apply the edits to the full segments, which have word timings. The
+	// body is handled, so writing the result doesn't keep a copy of it.
+	rememberBody(filename, body)
+	edit := func(segments []TranscriptEntry) ([]TranscriptEntry, error) {
+		current := metadata
+		current.Transcripts = segments
+		edited, _ := applyBodyEdits(current, body)
+		if len(edited) == 0 {
+			return nil, invalidEdit("edited body has no transcript left")
+		}
+		return edited, nil
+	}
+	rev, _, err := editTranscript(filename, externalEditAuthor, "edit", 0, edit)
+	if err != nil {
+		keepUnappliedBody(filename, body, err.Error())
+		rememberBody(filename, body)
+		return true
+	}
+	if len(rev.Diff) > 0 {
+		log.Printf("Applied edits to the transcript body of %s (revision %d)", filename, rev.Revision)
+	}
+	return true
+}
+
+// Save a body whose edits couldn't be applied, so rendering the transcript
+// again doesn't lose them. A body that was saved before isn't saved again.
+func keepUnappliedBody(filename, body, reason string) {
+	dir := revisionDir(filename)
+	files, _ := os.ReadDir(dir)
+	for _, file := range files {
+		if !strings.HasPrefix(file.Name(), "body-") {
+			continue
+		}
+		if saved, err := os.ReadFile(filepath.Join(dir, file.Name())); err == nil && string(saved) == body {
+			return
+		}
+	}
+
+	path := filepath.Join(dir, "body-"+time.Now().Format("20060102-150405.000")+".md")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Printf("Failed to keep edited transcript body of %s: %v", filename, err)
+		return
+	}
+	if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+		log.Printf("Failed to keep edited transcript body of %s: %v", filename, err)
+		return
+	}
+	log.Printf("Could not apply the edited transcript body of %s (%s); saved it as %s", filename, reason, path)
+}
+
+// Before the app rewrites a metadata file, save its body if it was edited
+// outside the app since the watcher last looked, as the write replaces it.
+// A body that is just the rendering of the file's own segments wasn't
+// edited. Callers hold metadataMu, so the file can't change in between.
+func keepExternalBodyEdit(filename, metadataPath, body string) {
+	var current MediaMetadata
+	onDisk, err := readMarkdownFile(metadataPath, &current)
+	if err != nil || isKnownBody(filename, onDisk) {
+		return
+	}
+	edited := strings.TrimSpace(onDisk)
+	if edited == "" || edited == strings.TrimSpace(body) ||
+		(len(current.Transcripts) > 0 && edited == strings.TrimSpace(transcriptBody(current))) {
+		return
+	}
+	keepUnappliedBody(filename, onDisk, "the app rewrote the file before the edit was applied")
+}
+
+// Render bodies written before they had timecodes in the current format,
+// once. Bodies that were edited since are saved before they are replaced.
+func migrateTranscriptBodies() {
+	if _, err := os.Stat(transcriptBodyMigrationFile); err == nil {
+		return
+	}
+
+	files, err := os.ReadDir(metadataDir)
+	if err != nil {
+		log.Printf("Failed to read metadata directory: %v", err)
+		return
+	}
+
+	migrated := 0
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), mdExt) {
+			continue
+		}
+		if migrateTranscriptBody(strings.TrimSuffix(file.Name(), mdExt)) {
+			migrated++
+		}
+	}
+
+	if err := os.WriteFile(transcriptBodyMigrationFile, []byte(time.Now().Format(time.RFC3339)+"\n"), 0644); err != nil {
+		log.Printf("Failed to record transcript body migration: %v", err)
+	}
+	if migrated > 0 {
+		log.Printf("Rendered %d transcript bodies with timecodes", migrated)
+	}
+}
+
+// Render one item's body again if it has no timecodes
+func migrateTranscriptBody(filename string) bool {
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+
+	metadataPath := filepath.Join(metadataDir, filename+mdExt)
+	var metadata MediaMetadata
+	body, err := readMarkdownFile(metadataPath, &metadata)
+	if err != nil || len(metadata.Transcripts) == 0 {
+		return false
+	}
+	if _, ok := applyBodyEdits(metadata, body); ok {
+		return false
+	}
+
+	if strings.TrimSpace(body) != strings.TrimSpace(transcriptText(metadata.Transcripts)) {
+		keepUnappliedBody(filename, body, "it was edited before bodies had timecodes")
+	}
+	rememberBody(filename, body)
+	if err := writeMetadataFile(metadataPath, metadata); err != nil {
+		log.Printf("Failed to rewrite transcript body of %s: %v", filename, err)
+		return false
+	}
+	return true
+}
+
+// metadataFileState is what the watcher last saw of a metadata file
+type metadataFileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// Hash of the body each metadata file was last written with by the app, or
+// last handled by the watcher, so edits made outside the app can be told
+// from the app's own writes
+var (
+	knownBodiesMu sync.Mutex
+	knownBodies   = make(map[string][sha256.Size]byte)
+)
+
+func bodyHash(body string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(strings.TrimSpace(body)))
+}
+
+// Record the body a metadata file was written with or handled as
+func rememberBody(filename, body string) {
+	knownBodiesMu.Lock()
+	defer knownBodiesMu.Unlock()
+	knownBodies[filename] = bodyHash(body)
+}
+
+func isKnownBody(filename, body string) bool {
+	knownBodiesMu.Lock()
+	defer knownBodiesMu.Unlock()
+	known, ok := knownBodies[filename]
+	return ok && known == bodyHash(body)
+}
+
+func forgetBody(filename string) {
+	knownBodiesMu.Lock()
+	defer knownBodiesMu.Unlock()
+	delete(knownBodies, filename)
+}
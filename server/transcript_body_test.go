@@ -0,0 +1,243 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Write an item with a three-segment transcript and return its metadata path
+func writeBodyTestItem(t *testing.T, filename string) string {
+	t.Helper()
+	metadataPath := filepath.Join(metadataDir, filename+mdExt)
+	err := writeMetadataFile(metadataPath, MediaMetadata{
+		ID:       "1",
+		Filename: filename,
+		Type:     "audio",
+		Labels:   []string{},
+		Transcripts: []TranscriptEntry{
+			{Start: 0, End: 4, Text: "Hello there.", Segment: 0},
+			{Start: 10, End: 14, Text: "Second paragraph.", Segment: 1},
+			{Start: 20, End: 24, Text: "Third one.", Segment: 2},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return metadataPath
+}
+
+// Change a metadata file's body the way an editor outside the app would
+func editBody(t *testing.T, metadataPath string, edit func(string) string) {
+	t.Helper()
+	content, err := os.ReadFile(metadataPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	end := strings.Index(string(content), "\n---\n") + len("\n---\n")
+	edited := string(content[:end]) + edit(string(content[end:]))
+	if err := os.WriteFile(metadataPath, []byte(edited), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readBodyTestItem(t *testing.T, metadataPath string) (MediaMetadata, string) {
+	t.Helper()
+	var metadata MediaMetadata
+	body, err := readMarkdownFile(metadataPath, &metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return metadata, body
+}
+
+// Scan as if every file had settled
+func scanSettled(seen map[string]metadataFileState) {
+	scanTranscriptBodies(seen, time.Now().Add(time.Hour))
+}
+
+func TestScanTranscriptBodiesAppliesExternalEdits(t *testing.T) {
+	setupTranscriptionTest(t)
+	metadataPath := writeBodyTestItem(t, "edit.mp3")
+	seen := make(map[string]metadataFileState)
+	scanSettled(seen)
+
+	editBody(t, metadataPath, func(body string) string {
+		return strings.Replace(body, "Second paragraph.", "Second, corrected paragraph.", 1)
+	})
+	scanSettled(seen)
+
+	metadata, _ := readBodyTestItem(t, metadataPath)
+	if len(metadata.Transcripts) != 3 || metadata.Transcripts[1].Text != "Second, corrected paragraph." {
+		t.Fatalf("edit not applied: %+v", metadata.Transcripts)
+	}
+	revisions, err := listTranscriptRevisions("edit.mp3")
+	if err != nil || len(revisions) == 0 || revisions[len(revisions)-1].Author != externalEditAuthor {
+		t.Errorf("no revision by the external editor: %+v, %v", revisions, err)
+	}
+}
+
+func TestScanTranscriptBodiesKeepsBodiesItCannotApply(t *testing.T) {
+	setupTranscriptionTest(t)
+	metadataPath := writeBodyTestItem(t, "notes.mp3")
+	seen := make(map[string]metadataFileState)
+	scanSettled(seen)
+
+	notes := "My own notes about this recording, without any timecodes.\n"
+	editBody(t, metadataPath, func(string) string { return notes })
+	scanSettled(seen)
+
+	// The body is left alone, the segments unchanged and a copy saved
+	metadata, body := readBodyTestItem(t, metadataPath)
+	if strings.TrimSpace(body) != strings.TrimSpace(notes) {
+		t.Errorf("body was overwritten: %q", body)
+	}
+	if len(metadata.Transcripts) != 3 {
+		t.Errorf("segments changed: %+v", metadata.Transcripts)
+	}
+	saved, _ := filepath.Glob(filepath.Join(revisionDir("notes.mp3"), "body-*.md"))
+	if len(saved) != 1 {
+		t.Fatalf("saved bodies = %v, want one", saved)
+	}
+
+	// Scanning again, as after a restart, doesn't save it twice
+	scanTranscriptBodies(make(map[string]metadataFileState), time.Now().Add(time.Hour))
+	if again, _ := filepath.Glob(filepath.Join(revisionDir("notes.mp3"), "body-*.md")); len(again) != 1 {
+		t.Errorf("saved bodies after rescan = %v, want one", again)
+	}
+}
+
+func TestScanTranscriptBodiesWaitsForFilesToSettle(t *testing.T) {
+	setupTranscriptionTest(t)
+	metadataPath := writeBodyTestItem(t, "fresh.mp3")
+	seen := make(map[string]metadataFileState)
+
+	editBody(t, metadataPath, func(body string) string {
+		return strings.Replace(body, "Third one.", "Third one, edited.", 1)
+	})
+	scanTranscriptBodies(seen, time.Now())
+	if metadata, _ := readBodyTestItem(t, metadataPath); metadata.Transcripts[2].Text != "Third one." {
+		t.Fatalf("file still being written was applied: %+v", metadata.Transcripts)
+	}
+
+	scanSettled(seen)
+	if metadata, _ := readBodyTestItem(t, metadataPath); metadata.Transcripts[2].Text != "Third one, edited." {
+		t.Errorf("settled edit not applied: %+v", metadata.Transcripts)
+	}
+}
+
+func TestWriteMetadataKeepsUnscannedBodyEdits(t *testing.T) {
+	setupTranscriptionTest(t)
+	metadataPath := writeBodyTestItem(t, "busy.mp3")
+	addLabel := func() {
+		t.Helper()
+		_, _, err := updateMetadata("busy.mp3", func(metadata *MediaMetadata) bool {
+			metadata.Labels = append(metadata.Labels, "label")
+			return true
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	saved := func() []string {
+		files, _ := filepath.Glob(filepath.Join(revisionDir("busy.mp3"), "body-*.md"))
+		return files
+	}
+
+	// The app's own body, even when it was written before a restart, is
+	// not an edit
+	forgetBody("busy.mp3")
+	addLabel()
+	if files := saved(); len(files) != 0 {
+		t.Fatalf("unedited body saved: %v", files)
+	}
+
+	// The app writes the file before the watcher saw the edit
+	editBody(t, metadataPath, func(body string) string {
+		return strings.Replace(body, "Third one.", "Third one, edited.", 1)
+	})
+	addLabel()
+	files := saved()
+	if len(files) != 1 {
+		t.Fatalf("saved bodies = %v, want one", files)
+	}
+	if data, _ := os.ReadFile(files[0]); !strings.Contains(string(data), "Third one, edited.") {
+		t.Errorf("saved body %q", data)
+	}
+
+	// Edits the watcher applies aren't saved as well
+	editBody(t, metadataPath, func(body string) string {
+		return strings.Replace(body, "Hello there.", "Hello there, you.", 1)
+	})
+	scanSettled(make(map[string]metadataFileState))
+	if metadata, _ := readBodyTestItem(t, metadataPath); metadata.Transcripts[0].Text != "Hello there, you." {
+		t.Errorf("edit not applied: %+v", metadata.Transcripts)
+	}
+	if files := saved(); len(files) != 1 {
+		t.Errorf("saved bodies after an applied edit = %v", files)
+	}
+}
+
+func TestMigrateTranscriptBodies(t *testing.T) {
+	setupTranscriptionTest(t)
+	legacyPath := writeBodyTestItem(t, "legacy.mp3")
+	editedPath := writeBodyTestItem(t, "edited.mp3")
+
+	// Bodies from before timecodes were the segments' text on one line
+	metadata, _ := readBodyTestItem(t, legacyPath)
+	if err := writeMarkdownFile(legacyPath, metadata, transcriptText(metadata.Transcripts)); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeMarkdownFile(editedPath, metadata, "Hello there, edited by hand."); err != nil {
+		t.Fatal(err)
+	}
+
+	migrateTranscriptBodies()
+
+	for _, path := range []string{legacyPath, editedPath} {
+		if _, body := readBodyTestItem(t, path); !strings.HasPrefix(body, "\n[00:00:00] Hello there.") {
+			t.Errorf("%s not rendered with timecodes: %q", path, body)
+		}
+	}
+	if saved, _ := filepath.Glob(filepath.Join(revisionDir("legacy.mp3"), "body-*.md")); len(saved) != 0 {
+		t.Errorf("unedited legacy body saved: %v", saved)
+	}
+	if saved, _ := filepath.Glob(filepath.Join(revisionDir("edited.mp3"), "body-*.md")); len(saved) != 1 {
+		t.Errorf("edited legacy body not saved: %v", saved)
+	}
+
+	// The migration runs once
+	if err := writeMarkdownFile(legacyPath, metadata, transcriptText(metadata.Transcripts)); err != nil {
+		t.Fatal(err)
+	}
+	migrateTranscriptBodies()
+	if _, body := readBodyTestItem(t, legacyPath); strings.Contains(body, "[00:00:00]") {
+		t.Errorf("migration ran twice")
+	}
+}
+
+func TestWriteFileAtomicLeavesNoTemporaryFiles(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "item.md")
+	for _, content := range []string{"first", "second"} {
+		if err := writeFileAtomic(path, []byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("directory has %d entries, want only the file", len(entries))
+	}
+	if data, _ := os.ReadFile(path); string(data) != "second" {
+		t.Errorf("file has %q", data)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0644 {
+		t.Errorf("file mode %v, want 0644", info.Mode().Perm())
+	}
+}
@@ -0,0 +1,518 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// EditSegmentRequest represents the request body for editing a segment.
+// Omitted fields are left unchanged.
+type EditSegmentRequest struct {
+	Author  string   `json:"author"`
+	Text    *string  `json:"text"`
+	Start   *float64 `json:"start"`
+	End     *float64 `json:"end"`
+	Speaker *string  `json:"speaker"`
+}
+
+// SplitSegmentRequest represents the request body for splitting a segment in two
+type SplitSegmentRequest struct {
+	Author string  `json:"author"`
+	At     float64 `json:"at"` // Time in seconds where the second segment starts
+	// Character offset in the text where the second segment starts. When
+	// omitted the text is split by word timings, or in proportion to the time.
+	TextOffset *int `json:"textOffset"`
+}
+
+// TranscriptEditRequest represents the request body for edits that only
+// need an author: merging segments and restoring revisions
+type TranscriptEditRequest struct {
+	Author string `json:"author"`
+}
+
+// TranscriptEditResponse is the result of an edit
+type TranscriptEditResponse struct {
+	Revision TranscriptRevision `json:"revision"`
+	Segments []TranscriptEntry  `json:"segments"`
+}
+
+// invalidEditErr is an edit that can't be applied to the transcript as it is
+type invalidEditErr struct {
+	msg string
+}
+
+func (e *invalidEditErr) Error() string { return e.msg }
+
+func invalidEdit(format string, args ...interface{}) error {
+	return &invalidEditErr{msg: fmt.Sprintf(format, args...)}
+}
+
+// Apply a change to an item's segments, then rewrite its transcript file and
+// metadata and save the result as a new revision
+func applyTranscriptEdit(filename, author, action string, restoredFrom int, edit func([]TranscriptEntry) ([]TranscriptEntry, error)) (TranscriptRevision, []TranscriptEntry, error) {
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	return editTranscript(filename, author, action, restoredFrom, edit)
+}
+
+// applyTranscriptEdit for callers that already hold metadataMu
+func editTranscript(filename, author, action string, restoredFrom int, edit func([]TranscriptEntry) ([]TranscriptEntry, error)) (TranscriptRevision, []TranscriptEntry, error) {
+	// Re-read the metadata so concurrent changes aren't lost
+	metadataPath := filepath.Join(metadataDir, filename+mdExt)
+	var metadata MediaMetadata
+	if _, err := readMarkdownFile(metadataPath, &metadata); err != nil {
+		return TranscriptRevision{}, nil, err
+	}
+
+	transcript := loadTranscript(metadata)
+	if len(transcript.Segments) == 0 && action != "restore" {
+		return TranscriptRevision{}, nil, invalidEdit("media item has no transcript")
+	}
+
+	// Transcripts from before revisions were kept get their current state as
+	// the first revision, so the edit can be undone
+	numbers, err := listRevisionNumbers(filename)
+	if err != nil {
+		return TranscriptRevision{}, nil, err
+	}
+	if len(numbers) == 0 && len(transcript.Segments) > 0 {
+		if _, err := saveTranscriptRevision(filename, transcript.Engine, "transcribe", transcript.Version, 0, transcript.Segments); err != nil {
+			return TranscriptRevision{}, nil, err
+		}
+	}
+
+	segments := make([]TranscriptEntry, len(transcript.Segments))
+	copy(segments, transcript.Segments)
+	segments, err = edit(segments)
+	if err != nil {
+		return TranscriptRevision{}, nil, err
+	}
+	for i := range segments {
+		segments[i].Segment = i
+	}
+
+	transcript.Segments = segments
+	transcript.EditedAt = time.Now().Format(time.RFC3339)
+	if err := saveTranscriptFile(filepath.Join(transcriptsDir, filename+".json"), transcript); err != nil {
+		return TranscriptRevision{}, nil, err
+	}
+
+	metadata.Transcripts = segments
+	metadata.Transcription = transcriptBody(metadata)
+	if err := writeMetadataFile(metadataPath, metadata); err != nil {
+		return TranscriptRevision{}, nil, err
+	}
+	queueEmbedding(filename)
+
+	rev, err := saveTranscriptRevision(filename, author, action, transcript.Version, restoredFrom, segments)
+	if err != nil {
+		return TranscriptRevision{}, nil, err
+	}
+	return rev, segments, nil
+}
+
+// Change a segment's text, speaker or boundaries. Moving a boundary past a
+// neighbouring segment moves that segment's boundary too.
+func editSegment(segments []TranscriptEntry, index int, req EditSegmentRequest) ([]TranscriptEntry, error) {
+	segment := segments[index]
+
+	if req.Text != nil {
+		text := strings.TrimSpace(*req.Text)
+		if text == "" {
+			return nil, invalidEdit("text must not be empty")
+		}
+		segment.Words = retextWords(segment, text)
+		segment.Text = text
+	}
+	if req.Speaker != nil {
+		segment.Speaker = strings.TrimSpace(*req.Speaker)
+		for i := range segment.Words {
+			segment.Words[i].Speaker = segment.Speaker
+		}
+	}
+
+	if req.Start != nil || req.End != nil {
+		if req.Start != nil {
+			segment.Start = *req.Start
+		}
+		if req.End != nil {
+			segment.End = *req.End
+		}
+		if segment.Start < 0 || segment.End <= segment.Start {
+			return nil, invalidEdit("segment must start at or after 0 and end after it starts")
+		}
+		if index > 0 && segments[index-1].End > segment.Start {
+			if segment.Start <= segments[index-1].Start {
+				return nil, invalidEdit("start would overlap the whole previous segment")
+			}
+			segments[index-1].End = segment.Start
+			segments[index-1].Words = clampWords(segments[index-1])
+		}
+		if index < len(segments)-1 && segments[index+1].Start < segment.End {
+			if segment.End >= segments[index+1].End {
+				return nil, invalidEdit("end would overlap the whole next segment")
+			}
+			segments[index+1].Start = segment.End
+			segments[index+1].Words = clampWords(segments[index+1])
+		}
+		segment.Words = clampWords(segment)
+	}
+
+	segments[index] = segment
+	return segments, nil
+}
+
+// Split a segment in two at a point in time
+func splitSegment(segments []TranscriptEntry, index int, req SplitSegmentRequest) ([]TranscriptEntry, error) {
+	segment := segments[index]
+	if req.At <= segment.Start || req.At >= segment.End {
+		return nil, invalidEdit("at must be between the segment's start (%.3f) and end (%.3f)", segment.Start, segment.End)
+	}
+
+	var firstText, secondText string
+	if req.TextOffset != nil {
+		runes := []rune(segment.Text)
+		if *req.TextOffset <= 0 || *req.TextOffset >= len(runes) {
+			return nil, invalidEdit("textOffset must be inside the segment's text")
+		}
+		firstText, secondText = string(runes[:*req.TextOffset]), string(runes[*req.TextOffset:])
+	} else {
+		fields := strings.Fields(segment.Text)
+		if len(fields) < 2 {
+			return nil, invalidEdit("segment has too few words to split; give textOffset")
+		}
+		// Words starting before the split point stay in the first segment
+		count := 0
+		if len(segment.Words) == len(fields) {
+			for _, word := range segment.Words {
+				if word.Start < req.At {
+					count++
+				}
+			}
+		} else {
+			ratio := (req.At - segment.Start) / (segment.End - segment.Start)
+			count = int(math.Round(ratio * float64(len(fields))))
+		}
+		count = max(1, min(count, len(fields)-1))
+		firstText, secondText = strings.Join(fields[:count], " "), strings.Join(fields[count:], " ")
+	}
+	firstText, secondText = strings.TrimSpace(firstText), strings.TrimSpace(secondText)
+	if firstText == "" || secondText == "" {
+		return nil, invalidEdit("split would leave a segment without text")
+	}
+
+	first, second := segment, segment
+	first.End, first.Text, first.Words = req.At, firstText, nil
+	second.Start, second.Text, second.Words = req.At, secondText, nil
+	for _, word := range segment.Words {
+		if word.Start < req.At {
+			first.Words = append(first.Words, word)
+		} else {
+			second.Words = append(second.Words, word)
+		}
+	}
+	first.Words = clampWords(first)
+	second.Words = clampWords(second)
+
+	result := make([]TranscriptEntry, 0, len(segments)+1)
+	result = append(result, segments[:index]...)
+	result = append(result, first, second)
+	result = append(result, segments[index+1:]...)
+	return result, nil
+}
+
+// Merge a segment with the one after it. The merged segment keeps the first
+// segment's speaker.
+func mergeSegments(segments []TranscriptEntry, index int) ([]TranscriptEntry, error) {
+	if index >= len(segments)-1 {
+		return nil, invalidEdit("the last segment has no following segment to merge with")
+	}
+
+	first, second := segments[index], segments[index+1]
+	merged := first
+	merged.Start = math.Min(first.Start, second.Start)
+	merged.End = math.Max(first.End, second.End)
+	merged.Text = strings.TrimSpace(first.Text) + " " + strings.TrimSpace(second.Text)
+	merged.Words = append(append([]TranscriptWord{}, first.Words...), second.Words...)
+	if len(merged.Words) == 0 {
+		merged.Words = nil
+	}
+	if merged.Metadata == "" {
+		merged.Metadata = second.Metadata
+	}
+
+	result := make([]TranscriptEntry, 0, len(segments)-1)
+	result = append(result, segments[:index]...)
+	result = append(result, merged)
+	result = append(result, segments[index+2:]...)
+	return result, nil
+}
+
+// Word timings for a segment whose text was corrected. When the word count
+// is unchanged the timings are kept; otherwise the words are spread evenly
+// over the segment. Corrected words lose their engine confidence.
+func retextWords(segment TranscriptEntry, text string) []TranscriptWord {
+	if len(segment.Words) == 0 {
+		return nil
+	}
+
+	fields := strings.Fields(text)
+	if len(fields) == len(segment.Words) {
+		words := make([]TranscriptWord, len(fields))
+		for i, word := range segment.Words {
+			if word.Word != fields[i] {
+				word.Word = fields[i]
+				word.Score = nil
+			}
+			words[i] = word
+		}
+		return words
+	}
+
+	words := make([]TranscriptWord, len(fields))
+	step := (segment.End - segment.Start) / float64(len(fields))
+	for i, field := range fields {
+		words[i] = TranscriptWord{
+			Word:    field,
+			Start:   segment.Start + float64(i)*step,
+			End:     segment.Start + float64(i+1)*step,
+			Speaker: segment.Speaker,
+		}
+	}
+	return words
+}
+
+// Keep a segment's word timings inside its boundaries
+func clampWords(segment TranscriptEntry) []TranscriptWord {
+	for i := range segment.Words {
+		word := &segment.Words[i]
+		word.Start = math.Min(math.Max(word.Start, segment.Start), segment.End)
+		word.End = math.Min(math.Max(word.End, word.Start), segment.End)
+	}
+	return segment.Words
+}
+
+// Handler for transcript edits and revisions: /api/media/{id}/transcript/...
+//
+//	POST segments/{n}               edit a segment's text, speaker or boundaries
+//	POST segments/{n}/split         split a segment in two
+//	POST segments/{n}/merge         merge a segment with the next one
+//	GET  revisions                  list revisions
+//	GET  revisions/{n}              get a revision with its segments
+//	POST revisions/{n}/restore      make a revision current again
+//	... versions/...                transcript versions, see handleTranscriptVersions
+func handleTranscriptEdit(w http.ResponseWriter, r *http.Request, metadata MediaMetadata, path string) {
+	parts := strings.Split(path, "/")
+	if len(parts) > 3 || (len(parts) > 1 && parts[1] == "") {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	if parts[0] == "versions" {
+		handleTranscriptVersions(w, r, metadata, parts)
+		return
+	}
+	if parts[0] == "cleanup" {
+		switch {
+		case len(parts) == 1:
+			handleGetCleanup(w, r, metadata)
+		case len(parts) == 2 && parts[1] == "revert":
+			handleRevertCleanup(w, r, metadata)
+		default:
+			http.Error(w, "Not found", http.StatusNotFound)
+		}
+		return
+	}
+
+	var number int
+	if len(parts) > 1 {
+		var err error
+		number, err = strconv.Atoi(parts[1])
+		if err != nil || number < 0 {
+			http.Error(w, "Not found", http.StatusNotFound)
+			return
+		}
+	}
+	action := ""
+	if len(parts) == 3 {
+		action = parts[2]
+	}
+
+	switch {
+	case parts[0] == "revisions" && len(parts) == 1:
+		handleListRevisions(w, r, metadata)
+	case parts[0] == "revisions" && action == "":
+		handleGetRevision(w, r, metadata, number)
+	case parts[0] == "revisions" && action == "restore":
+		handleRestoreRevision(w, r, metadata, number)
+	case parts[0] == "segments" && len(parts) > 1:
+		handleSegmentEdit(w, r, metadata, number, action)
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+	}
+}
+
+// Handler for editing, splitting and merging segments
+func handleSegmentEdit(w http.ResponseWriter, r *http.Request, metadata MediaMetadata, index int, action string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var author string
+	var edit func([]TranscriptEntry) ([]TranscriptEntry, error)
+	switch action {
+	case "":
+		var req EditSegmentRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		author, action = req.Author, "edit"
+		edit = func(segments []TranscriptEntry) ([]TranscriptEntry, error) {
+			return editSegment(segments, index, req)
+		}
+	case "split":
+		var req SplitSegmentRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		author = req.Author
+		edit = func(segments []TranscriptEntry) ([]TranscriptEntry, error) {
+			return splitSegment(segments, index, req)
+		}
+	case "merge":
+		var req TranscriptEditRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		author = req.Author
+		edit = func(segments []TranscriptEntry) ([]TranscriptEntry, error) {
+			return mergeSegments(segments, index)
+		}
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	checkedEdit := func(segments []TranscriptEntry) ([]TranscriptEntry, error) {
+		if index >= len(segments) {
+			return nil, fmt.Errorf("segment %d: %w", index, os.ErrNotExist)
+		}
+		return edit(segments)
+	}
+	writeTranscriptEditResult(w, metadata, editAuthor(author), action, 0, checkedEdit)
+}
+
+// Handler for listing an item's transcript revisions
+func handleListRevisions(w http.ResponseWriter, r *http.Request, metadata MediaMetadata) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	revisions, err := listTranscriptRevisions(metadata.Filename)
+	if err != nil {
+		log.Printf("Failed to list revisions of %s: %v", metadata.Filename, err)
+		http.Error(w, "Failed to read revisions", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(revisions)
+}
+
+// Handler for reading a single revision with its segments
+func handleGetRevision(w http.ResponseWriter, r *http.Request, metadata MediaMetadata, number int) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	rev, err := readTranscriptRevision(metadata.Filename, number)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			http.Error(w, "Revision not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to read revision", http.StatusInternalServerError)
+		}
+		return
+	}
+	rev.Segments = segmentsWithoutWords(rev.Segments)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rev)
+}
+
+// Handler for restoring an earlier revision, which is saved as a new revision
+func handleRestoreRevision(w http.ResponseWriter, r *http.Request, metadata MediaMetadata, number int) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req TranscriptEditRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	rev, err := readTranscriptRevision(metadata.Filename, number)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			http.Error(w, "Revision not found", http.StatusNotFound)
+		} else {
+			http.Error(w, "Failed to read revision", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	restore := func([]TranscriptEntry) ([]TranscriptEntry, error) {
+		return rev.Segments, nil
+	}
+	writeTranscriptEditResult(w, metadata, editAuthor(req.Author), "restore", number, restore)
+}
+
+// Apply an edit and write the new revision and segments as the response
+func writeTranscriptEditResult(w http.ResponseWriter, metadata MediaMetadata, author, action string, restoredFrom int, edit func([]TranscriptEntry) ([]TranscriptEntry, error)) {
+	rev, segments, err := applyTranscriptEdit(metadata.Filename, author, action, restoredFrom, edit)
+	if err != nil {
+		var invalid *invalidEditErr
+		switch {
+		case errors.As(err, &invalid):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		case errors.Is(err, os.ErrNotExist):
+			http.Error(w, "Segment not found", http.StatusNotFound)
+		default:
+			log.Printf("Failed to %s transcript of %s: %v", action, metadata.Filename, err)
+			http.Error(w, "Failed to update transcript", http.StatusInternalServerError)
+		}
+		return
+	}
+	log.Printf("%s applied %s to transcript of %s (revision %d)", author, action, metadata.Filename, rev.Revision)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TranscriptEditResponse{
+		Revision: rev,
+		Segments: segmentsWithoutWords(segments),
+	})
+}
+
+// Author recorded for an edit when the request names none
+func editAuthor(author string) string {
+	if author = strings.TrimSpace(author); author != "" {
+		return author
+	}
+	return "anonymous"
+}
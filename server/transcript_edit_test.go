@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// A segment of four words, one a second
+func fourWordSegment() TranscriptEntry {
+	return TranscriptEntry{Start: 10, End: 14, Text: "one two three four", Speaker: "SPEAKER_00", Words: []TranscriptWord{
+		{Word: "one", Start: 10, End: 11},
+		{Word: "two", Start: 11, End: 12},
+		{Word: "three", Start: 12, End: 13},
+		{Word: "four", Start: 13, End: 14},
+	}}
+}
+
+func TestSplitSegment(t *testing.T) {
+	before := TranscriptEntry{Start: 0, End: 10, Text: "Before."}
+	after := TranscriptEntry{Start: 14, End: 16, Text: "After."}
+	offset := func(n int) *int { return &n }
+	untimed := TranscriptEntry{Start: 0, End: 10, Text: "a b c d e"}
+
+	tests := []struct {
+		name          string
+		segment       TranscriptEntry
+		req           SplitSegmentRequest
+		first, second TranscriptEntry
+	}{
+		{"between words", fourWordSegment(), SplitSegmentRequest{At: 12},
+			TranscriptEntry{Start: 10, End: 12, Text: "one two", Speaker: "SPEAKER_00", Words: []TranscriptWord{{Word: "one", Start: 10, End: 11}, {Word: "two", Start: 11, End: 12}}},
+			TranscriptEntry{Start: 12, End: 14, Text: "three four", Speaker: "SPEAKER_00", Words: []TranscriptWord{{Word: "three", Start: 12, End: 13}, {Word: "four", Start: 13, End: 14}}}},
+		// A word spanning the split stays in the first segment, cut short
+		{"inside a word", fourWordSegment(), SplitSegmentRequest{At: 12.5},
+			TranscriptEntry{Start: 10, End: 12.5, Text: "one two three", Speaker: "SPEAKER_00", Words: []TranscriptWord{{Word: "one", Start: 10, End: 11}, {Word: "two", Start: 11, End: 12}, {Word: "three", Start: 12, End: 12.5}}},
+			TranscriptEntry{Start: 12.5, End: 14, Text: "four", Speaker: "SPEAKER_00", Words: []TranscriptWord{{Word: "four", Start: 13, End: 14}}}},
+		{"before the second word", fourWordSegment(), SplitSegmentRequest{At: 10.5},
+			TranscriptEntry{Start: 10, End: 10.5, Text: "one", Speaker: "SPEAKER_00", Words: []TranscriptWord{{Word: "one", Start: 10, End: 10.5}}},
+			TranscriptEntry{Start: 10.5, End: 14, Text: "two three four", Speaker: "SPEAKER_00", Words: []TranscriptWord{{Word: "two", Start: 11, End: 12}, {Word: "three", Start: 12, End: 13}, {Word: "four", Start: 13, End: 14}}}},
+		// Without word timings the text is split in proportion to the time,
+		// leaving at least a word on each side
+		{"by time", untimed, SplitSegmentRequest{At: 3},
+			TranscriptEntry{Start: 0, End: 3, Text: "a b"},
+			TranscriptEntry{Start: 3, End: 10, Text: "c d e"}},
+		{"by time near the start", untimed, SplitSegmentRequest{At: 0.1},
+			TranscriptEntry{Start: 0, End: 0.1, Text: "a"},
+			TranscriptEntry{Start: 0.1, End: 10, Text: "b c d e"}},
+		{"by time near the end", untimed, SplitSegmentRequest{At: 9.9},
+			TranscriptEntry{Start: 0, End: 9.9, Text: "a b c d"},
+			TranscriptEntry{Start: 9.9, End: 10, Text: "e"}},
+		{"at a text offset", TranscriptEntry{Start: 0, End: 2, Text: "Héllo world"}, SplitSegmentRequest{At: 1, TextOffset: offset(5)},
+			TranscriptEntry{Start: 0, End: 1, Text: "Héllo"},
+			TranscriptEntry{Start: 1, End: 2, Text: "world"}},
+	}
+	for _, test := range tests {
+		segments := []TranscriptEntry{before, test.segment, after}
+		got, err := splitSegment(segments, 1, test.req)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		want := []TranscriptEntry{before, test.first, test.second, after}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: splitSegment = %+v, want %+v", test.name, got, want)
+		}
+	}
+}
+
+func TestSplitSegmentErrors(t *testing.T) {
+	offset := func(n int) *int { return &n }
+	tests := []struct {
+		name    string
+		segment TranscriptEntry
+		req     SplitSegmentRequest
+	}{
+		{"at the start", fourWordSegment(), SplitSegmentRequest{At: 10}},
+		{"at the end", fourWordSegment(), SplitSegmentRequest{At: 14}},
+		{"outside", fourWordSegment(), SplitSegmentRequest{At: 20}},
+		{"one word", TranscriptEntry{Start: 0, End: 2, Text: "Hello"}, SplitSegmentRequest{At: 1}},
+		{"offset at the start", fourWordSegment(), SplitSegmentRequest{At: 12, TextOffset: offset(0)}},
+		{"offset at the end", fourWordSegment(), SplitSegmentRequest{At: 12, TextOffset: offset(18)}},
+		{"offset leaving only spaces", TranscriptEntry{Start: 0, End: 2, Text: "Hello "}, SplitSegmentRequest{At: 1, TextOffset: offset(5)}},
+	}
+	for _, test := range tests {
+		var invalid *invalidEditErr
+		if _, err := splitSegment([]TranscriptEntry{test.segment}, 0, test.req); !errors.As(err, &invalid) {
+			t.Errorf("%s: error %v, want an invalid edit", test.name, err)
+		}
+	}
+}
+
+func TestMergeSegments(t *testing.T) {
+	first := TranscriptEntry{Start: 0, End: 2, Text: "Hello", Speaker: "SPEAKER_00", Words: []TranscriptWord{{Word: "Hello", Start: 0, End: 2}}}
+	second := TranscriptEntry{Start: 2.5, End: 5, Text: " there. ", Speaker: "SPEAKER_01", Metadata: "note", Words: []TranscriptWord{{Word: "there.", Start: 2.5, End: 5}}}
+	last := TranscriptEntry{Start: 6, End: 7, Text: "Bye."}
+
+	got, err := mergeSegments([]TranscriptEntry{first, second, last}, 0)
+	want := []TranscriptEntry{{
+		Start: 0, End: 5, Text: "Hello there.", Speaker: "SPEAKER_00", Metadata: "note",
+		Words: []TranscriptWord{{Word: "Hello", Start: 0, End: 2}, {Word: "there.", Start: 2.5, End: 5}},
+	}, last}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeSegments = %+v, %v; want %+v", got, err, want)
+	}
+
+	got, err = mergeSegments([]TranscriptEntry{first, second, last}, 1)
+	if err != nil || len(got) != 2 || got[1].Text != "there. Bye." || got[1].Words == nil {
+		t.Errorf("merging with a segment without words = %+v, %v", got, err)
+	}
+	got, _ = mergeSegments([]TranscriptEntry{last, last}, 0)
+	if got[0].Words != nil {
+		t.Errorf("merged segments without words have words %+v", got[0].Words)
+	}
+
+	var invalid *invalidEditErr
+	if _, err := mergeSegments([]TranscriptEntry{first, second}, 1); !errors.As(err, &invalid) {
+		t.Errorf("merging the last segment: %v", err)
+	}
+}
+
+func TestRetextWords(t *testing.T) {
+	score := 0.9
+	segment := TranscriptEntry{Start: 0, End: 3, Speaker: "SPEAKER_01", Text: "a b c", Words: []TranscriptWord{
+		{Word: "a", Start: 0, End: 0.5, Score: &score},
+		{Word: "b", Start: 1, End: 1.5, Score: &score},
+		{Word: "c", Start: 2, End: 2.5, Score: &score},
+	}}
+
+	// The same number of words keeps their timings; corrected words lose
+	// their confidence
+	want := []TranscriptWord{
+		{Word: "a", Start: 0, End: 0.5, Score: &score},
+		{Word: "B!", Start: 1, End: 1.5},
+		{Word: "c", Start: 2, End: 2.5, Score: &score},
+	}
+	if got := retextWords(segment, "a  B! c"); !reflect.DeepEqual(got, want) {
+		t.Errorf("retextWords with the same count = %+v", got)
+	}
+
+	// Otherwise the words are spread over the segment
+	want = []TranscriptWord{
+		{Word: "x", Start: 0, End: 1.5, Speaker: "SPEAKER_01"},
+		{Word: "y", Start: 1.5, End: 3, Speaker: "SPEAKER_01"},
+	}
+	if got := retextWords(segment, "x y"); !reflect.DeepEqual(got, want) {
+		t.Errorf("retextWords with fewer words = %+v", got)
+	}
+	if segment.Words[1].Word != "b" {
+		t.Errorf("retextWords changed the segment's words: %+v", segment.Words)
+	}
+
+	segment.Words = nil
+	if got := retextWords(segment, "x y"); got != nil {
+		t.Errorf("segment without words got %+v", got)
+	}
+}
+
+func TestEditSegmentClampsWords(t *testing.T) {
+	start, end := 10.5, 15.0
+	segments := []TranscriptEntry{
+		{Start: 0, End: 11, Text: "Before", Words: []TranscriptWord{{Word: "Before", Start: 9, End: 11}}},
+		fourWordSegment(),
+		{Start: 14, End: 18, Text: "after", Words: []TranscriptWord{{Word: "after", Start: 14, End: 16}}},
+	}
+	got, err := editSegment(segments, 1, EditSegmentRequest{Start: &start, End: &end})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The neighbours give way and every word stays inside its segment
+	if got[0].End != 10.5 || got[0].Words[0].End != 10.5 {
+		t.Errorf("previous segment %+v", got[0])
+	}
+	if got[1].Words[0].Start != 10.5 || got[1].Words[0].End != 11 {
+		t.Errorf("first word %+v", got[1].Words[0])
+	}
+	if got[2].Start != 15 || got[2].Words[0].Start != 15 || got[2].Words[0].End != 16 {
+		t.Errorf("next segment %+v", got[2])
+	}
+
+	whole := 20.0
+	var invalid *invalidEditErr
+	if _, err := editSegment(segments, 1, EditSegmentRequest{End: &whole}); !errors.As(err, &invalid) {
+		t.Errorf("end past the whole next segment: %v", err)
+	}
+}
@@ -1,94 +1,282 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"log"
+	"math"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 )
 
-// TranscriptionQueue manages the queue of files to be transcribed
+// TranscriptionQueue manages the queue of files to be transcribed.
+// Every job is persisted under data/jobs so history and pending work survive restarts.
 type TranscriptionQueue struct {
-	Queue     []string
-	InProcess map[string]bool
-	Completed map[string]bool
-	Failed    map[string]string // filename -> error message
+	Queue []string                     // Filenames waiting to be processed, highest priority first
+	Jobs  map[string]*TranscriptionJob // filename -> job, in any state
+
+	running   map[string]context.CancelFunc // filename -> cancel for in-flight jobs
+	cancelled map[string]bool               // Running jobs cancelled by the user
+	progress  map[string]float64            // filename -> seconds of audio transcribed so far
+	closed    bool                          // Set on shutdown; workers stop taking jobs
+	paused    bool                          // Workers don't start new jobs while set
+	wake      *sync.Cond                    // Signalled when a job is queued or the queue closes
+	workers   sync.WaitGroup
 	mu        sync.Mutex
 }
 
+// TranscriptionJob represents a file's transcription job and its history
+type TranscriptionJob struct {
+	Filename   string `json:"filename"`
+	Status     string `json:"status"`   // "queued", "processing", "retrying", "completed", "failed", "cancelled"
+	Priority   int    `json:"priority"` // Higher runs first; equal priorities run in order
+	CreatedAt  string `json:"createdAt"`
+	StartedAt  string `json:"startedAt,omitempty"`
+	FinishedAt string `json:"finishedAt,omitempty"`
+	Attempts   int    `json:"attempts"`
+	LastError  string `json:"lastError,omitempty"`
+
+	// Model requested for this job instead of the configured one
+	Model string `json:"model,omitempty"`
+
+	// Length of the audio in seconds, once known
+	AudioDuration float64 `json:"audioDuration,omitempty"`
+
+	// Set when the last attempt failed: "transient" errors are retried
+	// automatically until NextRetryAt, "permanent" ones are not
+	ErrorKind   string `json:"errorKind,omitempty"`
+	NextRetryAt string `json:"nextRetryAt,omitempty"`
+}
+
 // TranscriptionStatus represents the status of a transcription job
 type TranscriptionStatus struct {
-	Filename  string `json:"filename"`
-	Status    string `json:"status"` // "queued", "processing", "completed", "failed"
-	Error     string `json:"error,omitempty"`
-	Timestamp string `json:"timestamp"`
+	Filename   string `json:"filename"`
+	Status     string `json:"status"` // "queued", "processing", "retrying", "completed", "failed", "cancelled"
+	Priority   int    `json:"priority"`
+	Model      string `json:"model,omitempty"`
+	Error      string `json:"error,omitempty"`
+	ErrorKind  string `json:"errorKind,omitempty"`
+	Attempts   int    `json:"attempts,omitempty"`
+	NextRetry  string `json:"nextRetryAt,omitempty"`
+	CreatedAt  string `json:"createdAt"`
+	StartedAt  string `json:"startedAt,omitempty"`
+	FinishedAt string `json:"finishedAt,omitempty"`
+	Timestamp  string `json:"timestamp"` // Time of the most recent of the above
+
+	QueuePosition int     `json:"queuePosition,omitempty"` // 1-based, for queued jobs
+	Elapsed       float64 `json:"elapsed,omitempty"`       // Seconds spent processing
+	AudioDuration float64 `json:"audioDuration,omitempty"` // Seconds of audio
+	Progress      float64 `json:"progress,omitempty"`      // Estimated percentage done
+	ProgressFrom  string  `json:"progressFrom,omitempty"`  // "engine" or "estimate"
+}
+
+// TranscriptionStatusFilter selects which jobs GetAllStatuses returns
+type TranscriptionStatusFilter struct {
+	Statuses []string // Any of these states; all states if empty
+	Filename string   // Case-insensitive substring of the filename
 }
 
 const (
 	transcriptsDir = "./data/transcripts"
+
+	// How long shutdown waits for running jobs before cancelling them
+	transcriptionDrainTimeout = 30 * time.Second
 )
 
 var (
+	// Transcriber selected in the configuration
+	activeTranscriber Transcriber
+
 	// Global transcription queue
-	TQueue = &TranscriptionQueue{
+	TQueue = newTranscriptionQueue()
+)
+
+// Create an empty transcription queue
+func newTranscriptionQueue() *TranscriptionQueue {
+	tq := &TranscriptionQueue{
 		Queue:     []string{},
-		InProcess: make(map[string]bool),
-		Completed: make(map[string]bool),
-		Failed:    make(map[string]string),
+		Jobs:      make(map[string]*TranscriptionJob),
+		running:   make(map[string]context.CancelFunc),
+		cancelled: make(map[string]bool),
+		progress:  make(map[string]float64),
 	}
-)
+	tq.wake = sync.NewCond(&tq.mu)
+	return tq
+}
 
 // Initialize transcription system
 func InitTranscriptionSystem() {
-	// Ensure transcripts directory exists
-	if err := os.MkdirAll(transcriptsDir, 0755); err != nil {
-		log.Fatalf("Failed to create transcripts directory: %v", err)
+	// Ensure transcripts and jobs directories exist
+	if err := ensureTranscriptionDirs(); err != nil {
+		log.Fatalf("%v", err)
 	}
 
-	// Start the transcription worker
-	go transcriptionWorker()
+	// Create the configured transcription backend
+	transcriber, err := newTranscriber(AppConfig.Transcription)
+	if err != nil {
+		log.Fatalf("Failed to configure transcription: %v", err)
+	}
+	activeTranscriber = transcriber
+	log.Printf("Using %s transcription backend (model %s)", transcriber.Name(), transcriber.Model())
+
+	// Restore jobs from disk, re-queueing any that were interrupted
+	TQueue.LoadJobs()
+
+	// Start the transcription workers
+	TQueue.StartWorkers(AppConfig.Transcription.Concurrency)
 
 	// Check for existing audio/video files without transcripts
 	checkExistingMediaFiles()
 }
 
+func ensureTranscriptionDirs() error {
+	if err := os.MkdirAll(transcriptsDir, 0755); err != nil {
+		return fmt.Errorf("failed to create transcripts directory: %v", err)
+	}
+	if err := os.MkdirAll(jobsDir, 0755); err != nil {
+		return fmt.Errorf("failed to create jobs directory: %v", err)
+	}
+	return nil
+}
+
+// Open the job store without running anything, for the import command: files
+// it adds to the queue are persisted as queued jobs, and files that already
+// have a job keep it. The server picks the jobs up when it starts.
+func openJobStore() error {
+	if err := ensureTranscriptionDirs(); err != nil {
+		return err
+	}
+	jobs, err := loadJobFiles()
+	if err != nil {
+		return err
+	}
+
+	TQueue.mu.Lock()
+	defer TQueue.mu.Unlock()
+	for _, job := range jobs {
+		TQueue.Jobs[job.Filename] = job
+	}
+	return nil
+}
+
+// Restore persisted jobs. Jobs that were queued or mid-run when the server
+// stopped are queued again in the order they were created.
+func (tq *TranscriptionQueue) LoadJobs() {
+	jobs, err := loadJobFiles()
+	if err != nil {
+		log.Printf("Failed to load transcription jobs: %v", err)
+		return
+	}
+
+	tq.mu.Lock()
+	defer tq.mu.Unlock()
+
+	tq.paused = loadQueuePaused()
+	if tq.paused {
+		log.Printf("Transcription queue is paused")
+	}
+
+	sort.SliceStable(jobs, func(i, j int) bool {
+		return jobs[i].CreatedAt < jobs[j].CreatedAt
+	})
+
+	requeued := 0
+	for _, job := range jobs {
+		tq.Jobs[job.Filename] = job
+
+		switch job.Status {
+		case "processing":
+			log.Printf("Re-queueing interrupted transcription for %s", job.Filename)
+			job.Status = "queued"
+			job.StartedAt = ""
+			tq.saveJob(job)
+			fallthrough
+		case "queued":
+			tq.enqueue(job.Filename)
+			requeued++
+		case "retrying":
+			nextRetry, err := time.Parse(time.RFC3339, job.NextRetryAt)
+			if err != nil {
+				nextRetry = time.Now()
+			}
+			tq.scheduleRetry(job.Filename, time.Until(nextRetry))
+		}
+	}
+
+	log.Printf("Loaded %d transcription jobs (%d queued)", len(jobs), requeued)
+}
+
+// Persist a job, logging rather than failing so the queue keeps working
+func (tq *TranscriptionQueue) saveJob(job *TranscriptionJob) {
+	if err := saveJobFile(job); err != nil {
+		log.Printf("Failed to persist transcription job for %s: %v", job.Filename, err)
+	}
+}
+
 // Add a file to the transcription queue
-func (tq *TranscriptionQueue) AddToQueue(filename string) {
+func (tq *TranscriptionQueue) AddToQueue(filename string, priority int) {
 	tq.mu.Lock()
 	defer tq.mu.Unlock()
 
-	// Check if file is already in queue, in process, completed, or failed
-	if tq.isInQueue(filename) || tq.InProcess[filename] || tq.Completed[filename] || tq.Failed[filename] != "" {
+	// Check if file already has a job (queued, in process, completed, or failed)
+	if _, exists := tq.Jobs[filename]; exists {
 		return
 	}
+	tq.addJob(filename, priority, "")
+}
 
-	// Add to queue
-	tq.Queue = append(tq.Queue, filename)
+// Create a queued job for a file. Caller must hold tq.mu.
+func (tq *TranscriptionQueue) addJob(filename string, priority int, model string) {
+	job := &TranscriptionJob{
+		Filename:  filename,
+		Status:    "queued",
+		Priority:  priority,
+		CreatedAt: time.Now().Format(time.RFC3339),
+		Model:     model,
+	}
+	tq.Jobs[filename] = job
+	tq.enqueue(filename)
+	tq.saveJob(job)
+	tq.wake.Signal()
 	log.Printf("Added %s to transcription queue", filename)
 }
 
-// Check if a file is in the queue
-func (tq *TranscriptionQueue) isInQueue(filename string) bool {
-	for _, f := range tq.Queue {
-		if f == filename {
-			return true
-		}
+// Record a failure that happened before jobs were persisted (a .failed file)
+func (tq *TranscriptionQueue) addFailedJob(filename, errorMsg string) {
+	tq.mu.Lock()
+	defer tq.mu.Unlock()
+
+	if _, exists := tq.Jobs[filename]; exists {
+		return
 	}
-	return false
+
+	job := &TranscriptionJob{
+		Filename:  filename,
+		Status:    "failed",
+		CreatedAt: time.Now().Format(time.RFC3339),
+		LastError: errorMsg,
+	}
+	tq.Jobs[filename] = job
+	tq.saveJob(job)
 }
 
-// Get the next file from the queue
-func (tq *TranscriptionQueue) GetNext() (string, bool) {
+// Wait for the next file in the queue and mark it as in process.
+// The returned context is cancelled if the job is cancelled or the server
+// shuts down. Returns false once the queue has been closed.
+func (tq *TranscriptionQueue) WaitNext() (string, context.Context, bool) {
 	tq.mu.Lock()
 	defer tq.mu.Unlock()
 
-	if len(tq.Queue) == 0 {
-		return "", false
+	for (len(tq.Queue) == 0 || tq.paused) && !tq.closed {
+		tq.wake.Wait()
+	}
+	if tq.closed {
+		return "", nil, false
 	}
 
 	// Get the first file
@@ -98,9 +286,223 @@ func (tq *TranscriptionQueue) GetNext() (string, bool) {
 	tq.Queue = tq.Queue[1:]
 
 	// Mark as in process
-	tq.InProcess[filename] = true
+	job := tq.Jobs[filename]
+	job.Status = "processing"
+	job.StartedAt = time.Now().Format(time.RFC3339)
+	job.FinishedAt = ""
+	job.Attempts++
+	tq.saveJob(job)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	tq.running[filename] = cancel
+	ctx = withProgress(ctx, func(seconds float64) {
+		tq.setProgress(filename, seconds)
+	})
+
+	return filename, ctx, true
+}
+
+// Record how far a running job has got through its audio
+func (tq *TranscriptionQueue) setProgress(filename string, seconds float64) {
+	tq.mu.Lock()
+	defer tq.mu.Unlock()
+
+	if _, ok := tq.running[filename]; ok && seconds > tq.progress[filename] {
+		tq.progress[filename] = seconds
+	}
+}
+
+// Record the length of a job's audio, used to turn progress into a percentage
+func (tq *TranscriptionQueue) SetAudioDuration(filename string, seconds float64) {
+	tq.mu.Lock()
+	defer tq.mu.Unlock()
+
+	if job, ok := tq.Jobs[filename]; ok {
+		job.AudioDuration = seconds
+		tq.saveJob(job)
+	}
+}
+
+// Insert a file into the queue after every job of the same or higher
+// priority. Caller holds tq.mu.
+func (tq *TranscriptionQueue) enqueue(filename string) {
+	priority := tq.Jobs[filename].Priority
+	i := len(tq.Queue)
+	for i > 0 && tq.Jobs[tq.Queue[i-1]].Priority < priority {
+		i--
+	}
+	tq.Queue = append(tq.Queue, "")
+	copy(tq.Queue[i+1:], tq.Queue[i:])
+	tq.Queue[i] = filename
+}
+
+// Remove a file from the queue, returning false if it wasn't queued.
+// Caller holds tq.mu.
+func (tq *TranscriptionQueue) dequeue(filename string) bool {
+	for i, queued := range tq.Queue {
+		if queued == filename {
+			tq.Queue = append(tq.Queue[:i], tq.Queue[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// Change the priority of a queued job and move it to its new place
+func (tq *TranscriptionQueue) SetPriority(filename string, priority int) error {
+	tq.mu.Lock()
+	defer tq.mu.Unlock()
 
-	return filename, true
+	job, ok := tq.Jobs[filename]
+	if !ok {
+		return fmt.Errorf("no transcription job for %s: %w", filename, os.ErrNotExist)
+	}
+
+	job.Priority = priority
+	tq.saveJob(job)
+	if tq.dequeue(filename) {
+		tq.enqueue(filename)
+	}
+	return nil
+}
+
+// Move a queued job to the front by raising its priority above every other
+// queued job, so the order also holds after a restart
+func (tq *TranscriptionQueue) MoveToFront(filename string) error {
+	tq.mu.Lock()
+	defer tq.mu.Unlock()
+
+	job, ok := tq.Jobs[filename]
+	if !ok {
+		return fmt.Errorf("no transcription job for %s: %w", filename, os.ErrNotExist)
+	}
+	if job.Status != "queued" {
+		return fmt.Errorf("transcription for %s is %s", filename, job.Status)
+	}
+
+	tq.dequeue(filename)
+	if len(tq.Queue) > 0 {
+		if top := tq.Jobs[tq.Queue[0]].Priority; job.Priority <= top {
+			job.Priority = top + 1
+		}
+	}
+	tq.saveJob(job)
+	tq.Queue = append([]string{filename}, tq.Queue...)
+	log.Printf("Moved %s to the front of the transcription queue (priority %d)", filename, job.Priority)
+	return nil
+}
+
+// Cancel a queued, retrying or running job. A running job's external
+// process is killed and the worker marks the job cancelled when it stops.
+func (tq *TranscriptionQueue) Cancel(filename string) error {
+	tq.mu.Lock()
+	defer tq.mu.Unlock()
+
+	job, ok := tq.Jobs[filename]
+	if !ok {
+		return fmt.Errorf("no transcription job for %s: %w", filename, os.ErrNotExist)
+	}
+
+	switch job.Status {
+	case "processing":
+		if cancel, ok := tq.running[filename]; ok {
+			tq.cancelled[filename] = true
+			cancel()
+		}
+	case "queued", "retrying":
+		tq.dequeue(filename)
+		job.Status = "cancelled"
+		job.NextRetryAt = ""
+		job.FinishedAt = time.Now().Format(time.RFC3339)
+		tq.saveJob(job)
+	default:
+		return fmt.Errorf("transcription for %s is %s", filename, job.Status)
+	}
+
+	log.Printf("Cancelled transcription for %s", filename)
+	return nil
+}
+
+// Stop or resume starting new jobs. Running jobs are not affected.
+func (tq *TranscriptionQueue) SetPaused(paused bool) {
+	tq.mu.Lock()
+	defer tq.mu.Unlock()
+
+	tq.paused = paused
+	if err := saveQueuePaused(paused); err != nil {
+		log.Printf("Failed to persist queue pause state: %v", err)
+	}
+	if !paused {
+		tq.wake.Broadcast()
+	}
+}
+
+// TranscriptionQueueState summarises the queue as a whole
+type TranscriptionQueueState struct {
+	Paused  bool `json:"paused"`
+	Queued  int  `json:"queued"`
+	Running int  `json:"running"`
+}
+
+// Get the queue's pause state and job counts
+func (tq *TranscriptionQueue) State() TranscriptionQueueState {
+	tq.mu.Lock()
+	defer tq.mu.Unlock()
+
+	return TranscriptionQueueState{
+		Paused:  tq.paused,
+		Queued:  len(tq.Queue),
+		Running: len(tq.running),
+	}
+}
+
+// Start the worker pool
+func (tq *TranscriptionQueue) StartWorkers(concurrency int) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	for i := 0; i < concurrency; i++ {
+		tq.workers.Add(1)
+		go transcriptionWorker(i)
+	}
+	log.Printf("Started %d transcription workers", concurrency)
+}
+
+// Stop taking new jobs and wait for running ones to finish. Jobs still
+// running after the timeout are cancelled and checkpointed as queued, so
+// they are picked up again on the next start.
+func (tq *TranscriptionQueue) Shutdown(timeout time.Duration) {
+	tq.mu.Lock()
+	tq.closed = true
+	tq.wake.Broadcast()
+	inFlight := len(tq.running)
+	tq.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		tq.workers.Wait()
+		close(done)
+	}()
+
+	if inFlight > 0 {
+		log.Printf("Waiting up to %s for %d transcription jobs to finish", timeout, inFlight)
+	}
+
+	select {
+	case <-done:
+		return
+	case <-time.After(timeout):
+	}
+
+	tq.mu.Lock()
+	for filename, cancel := range tq.running {
+		log.Printf("Interrupting transcription for %s", filename)
+		cancel()
+	}
+	tq.mu.Unlock()
+
+	<-done
 }
 
 // Mark a file as completed
@@ -108,86 +510,406 @@ func (tq *TranscriptionQueue) MarkCompleted(filename string) {
 	tq.mu.Lock()
 	defer tq.mu.Unlock()
 
-	delete(tq.InProcess, filename)
-	tq.Completed[filename] = true
+	tq.finishRunning(filename)
+	job := tq.Jobs[filename]
+	job.Status = "completed"
+	job.FinishedAt = time.Now().Format(time.RFC3339)
+	job.LastError = ""
+	tq.saveJob(job)
 }
 
-// Mark a file as failed with an error message
-func (tq *TranscriptionQueue) MarkFailed(filename, errorMsg string) {
+// Record a failed attempt. Transient errors are retried with exponential
+// backoff until the attempt limit is reached; during shutdown the job is
+// queued for the next start instead. Returns true if the job will be
+// retried, false if it has failed for good.
+func (tq *TranscriptionQueue) MarkFailed(filename string, err error) bool {
 	tq.mu.Lock()
 	defer tq.mu.Unlock()
 
-	delete(tq.InProcess, filename)
-	tq.Failed[filename] = errorMsg
+	tq.finishRunning(filename)
+	job := tq.Jobs[filename]
+	job.FinishedAt = time.Now().Format(time.RFC3339)
+	job.LastError = err.Error()
+	job.ErrorKind = "transient"
+	if isPermanentError(err) {
+		job.ErrorKind = "permanent"
+	}
+
+	maxAttempts := AppConfig.Transcription.MaxAttempts
+	if job.ErrorKind == "transient" && job.Attempts < maxAttempts && tq.closed {
+		// No retry runs in this process any more; like an interrupted job,
+		// the next start picks it up from the job file
+		job.Status = "queued"
+		job.StartedAt = ""
+		job.FinishedAt = ""
+		job.NextRetryAt = ""
+		tq.saveJob(job)
+		tq.enqueue(filename)
+		log.Printf("Transcription for %s failed during shutdown; queued for the next start", filename)
+		return true
+	}
+	if job.ErrorKind == "transient" && job.Attempts < maxAttempts {
+		delay := retryDelay(job.Attempts)
+		job.Status = "retrying"
+		job.NextRetryAt = time.Now().Add(delay).Format(time.RFC3339)
+		tq.saveJob(job)
+		tq.scheduleRetry(filename, delay)
+		log.Printf("Retrying transcription for %s in %s (attempt %d of %d)", filename, delay, job.Attempts+1, maxAttempts)
+		return true
+	}
+
+	job.Status = "failed"
+	job.NextRetryAt = ""
+	tq.saveJob(job)
+	return false
+}
+
+// Requeue a job after a delay, unless it was retried manually in the
+// meantime. Caller holds tq.mu and has set the job's NextRetryAt.
+func (tq *TranscriptionQueue) scheduleRetry(filename string, delay time.Duration) {
+	scheduledFor := tq.Jobs[filename].NextRetryAt
+	time.AfterFunc(delay, func() {
+		tq.mu.Lock()
+		defer tq.mu.Unlock()
+
+		job, ok := tq.Jobs[filename]
+		if !ok || job.Status != "retrying" || job.NextRetryAt != scheduledFor {
+			return
+		}
+		job.Status = "queued"
+		job.NextRetryAt = ""
+		tq.saveJob(job)
+		tq.enqueue(filename)
+		tq.wake.Signal()
+	})
 }
 
-// Get all transcription statuses
-func (tq *TranscriptionQueue) GetAllStatuses() []TranscriptionStatus {
+// Put a failed, retrying or cancelled job back in the queue straight away and
+// reset its attempt count. Returns an error if the job is queued, running or done.
+func (tq *TranscriptionQueue) Retry(filename string) error {
 	tq.mu.Lock()
 	defer tq.mu.Unlock()
 
-	var statuses []TranscriptionStatus
+	job, ok := tq.Jobs[filename]
+	if !ok {
+		return fmt.Errorf("no transcription job for %s: %w", filename, os.ErrNotExist)
+	}
+	if job.Status != "failed" && job.Status != "retrying" && job.Status != "cancelled" {
+		return fmt.Errorf("transcription for %s is %s", filename, job.Status)
+	}
 
-	// Add queued files
-	for _, filename := range tq.Queue {
-		statuses = append(statuses, TranscriptionStatus{
-			Filename:  filename,
-			Status:    "queued",
-			Timestamp: time.Now().Format(time.RFC3339),
-		})
+	tq.requeue(job)
+	return nil
+}
+
+// Queue a file to be transcribed again, for example after its language
+// changed or with another model; an empty model uses the configured one.
+// A job that is already queued keeps its place; a running job can't be
+// restarted.
+func (tq *TranscriptionQueue) Retranscribe(filename, model string) error {
+	tq.mu.Lock()
+	defer tq.mu.Unlock()
+
+	job, ok := tq.Jobs[filename]
+	if !ok {
+		tq.addJob(filename, 0, model)
+		return nil
 	}
 
-	// Add in-process files
-	for filename := range tq.InProcess {
-		statuses = append(statuses, TranscriptionStatus{
-			Filename:  filename,
-			Status:    "processing",
-			Timestamp: time.Now().Format(time.RFC3339),
-		})
+	switch job.Status {
+	case "queued":
+		job.Model = model
+		tq.saveJob(job)
+		return nil
+	case "processing":
+		return fmt.Errorf("transcription for %s is processing", filename)
 	}
+	job.Model = model
+	tq.requeue(job)
+	return nil
+}
+
+// Model requested for a file's job; empty for the configured model
+func (tq *TranscriptionQueue) JobModel(filename string) string {
+	tq.mu.Lock()
+	defer tq.mu.Unlock()
 
-	// Add completed files
-	for filename := range tq.Completed {
-		statuses = append(statuses, TranscriptionStatus{
-			Filename:  filename,
-			Status:    "completed",
-			Timestamp: time.Now().Format(time.RFC3339),
-		})
+	if job, ok := tq.Jobs[filename]; ok {
+		return job.Model
 	}
+	return ""
+}
+
+// Take a queued job out of the queue and mark it completed, for files whose
+// transcript came from elsewhere. Returns false if the job wasn't queued.
+func (tq *TranscriptionQueue) SkipQueued(filename string) bool {
+	tq.mu.Lock()
+	defer tq.mu.Unlock()
+
+	job, ok := tq.Jobs[filename]
+	if !ok || job.Status != "queued" || !tq.dequeue(filename) {
+		return false
+	}
+	job.Status = "completed"
+	job.FinishedAt = time.Now().Format(time.RFC3339)
+	tq.saveJob(job)
+	log.Printf("Skipped transcription of %s", filename)
+	return true
+}
+
+// Put a finished job back in the queue with a fresh attempt count.
+// Caller must hold tq.mu.
+func (tq *TranscriptionQueue) requeue(job *TranscriptionJob) {
+	filename := job.Filename
+
+	// Forget the old failure so startup doesn't treat the file as failed
+	failedPath := filepath.Join(transcriptsDir, filename+".failed")
+	if err := os.Remove(failedPath); err != nil && !os.IsNotExist(err) {
+		log.Printf("Failed to remove failure file for %s: %v", filename, err)
+	}
+
+	job.Status = "queued"
+	job.Attempts = 0
+	job.NextRetryAt = ""
+	job.ErrorKind = ""
+	tq.saveJob(job)
+	tq.enqueue(filename)
+	tq.wake.Signal()
+	log.Printf("Re-queued %s for transcription", filename)
+}
+
+// Retry every failed job, returning the filenames that were re-queued
+func (tq *TranscriptionQueue) RetryAllFailed() []string {
+	tq.mu.Lock()
+	var failed []string
+	for filename, job := range tq.Jobs {
+		if job.Status == "failed" {
+			failed = append(failed, filename)
+		}
+	}
+	tq.mu.Unlock()
+
+	sort.Strings(failed)
+	retried := []string{}
+	for _, filename := range failed {
+		if err := tq.Retry(filename); err == nil {
+			retried = append(retried, filename)
+		}
+	}
+	return retried
+}
+
+// Mark a running job as cancelled by the user
+func (tq *TranscriptionQueue) MarkCancelled(filename string) {
+	tq.mu.Lock()
+	defer tq.mu.Unlock()
+
+	tq.finishRunning(filename)
+	job := tq.Jobs[filename]
+	job.Status = "cancelled"
+	job.FinishedAt = time.Now().Format(time.RFC3339)
+	tq.saveJob(job)
+}
+
+// Whether a running job was cancelled by the user
+func (tq *TranscriptionQueue) wasCancelled(filename string) bool {
+	tq.mu.Lock()
+	defer tq.mu.Unlock()
+	return tq.cancelled[filename]
+}
+
+// Put a job that was interrupted by shutdown back in the queue. It isn't
+// handed to a worker again; the next start picks it up from the job file.
+func (tq *TranscriptionQueue) MarkInterrupted(filename string) {
+	tq.mu.Lock()
+	defer tq.mu.Unlock()
+
+	tq.finishRunning(filename)
+	job := tq.Jobs[filename]
+	job.Status = "queued"
+	job.StartedAt = ""
+	tq.saveJob(job)
+	tq.enqueue(filename)
+}
+
+// Release the context of a job that stopped running. Caller holds tq.mu.
+func (tq *TranscriptionQueue) finishRunning(filename string) {
+	if cancel, ok := tq.running[filename]; ok {
+		cancel()
+		delete(tq.running, filename)
+	}
+	delete(tq.progress, filename)
+	delete(tq.cancelled, filename)
+}
+
+// Whether the queue is shutting down
+func (tq *TranscriptionQueue) isClosed() bool {
+	tq.mu.Lock()
+	defer tq.mu.Unlock()
+	return tq.closed
+}
+
+// Get the status of every job matching a filter. Queued jobs come first in
+// queue order, followed by the rest with the most recently active first.
+func (tq *TranscriptionQueue) GetAllStatuses(filter TranscriptionStatusFilter) []TranscriptionStatus {
+	tq.mu.Lock()
+	defer tq.mu.Unlock()
+
+	now := time.Now()
+	speed := tq.processingSpeed()
 
-	// Add failed files
-	for filename, errMsg := range tq.Failed {
-		statuses = append(statuses, TranscriptionStatus{
-			Filename:  filename,
-			Status:    "failed",
-			Error:     errMsg,
-			Timestamp: time.Now().Format(time.RFC3339),
-		})
+	positions := make(map[string]int)
+	for i, filename := range tq.Queue {
+		positions[filename] = i + 1
 	}
 
+	statuses := []TranscriptionStatus{}
+	for filename, job := range tq.Jobs {
+		if !filter.matches(job) {
+			continue
+		}
+
+		status := TranscriptionStatus{
+			Filename:      filename,
+			Status:        job.Status,
+			Priority:      job.Priority,
+			Model:         job.Model,
+			Error:         job.LastError,
+			ErrorKind:     job.ErrorKind,
+			Attempts:      job.Attempts,
+			NextRetry:     job.NextRetryAt,
+			CreatedAt:     job.CreatedAt,
+			StartedAt:     job.StartedAt,
+			FinishedAt:    job.FinishedAt,
+			Timestamp:     job.CreatedAt,
+			QueuePosition: positions[filename],
+			AudioDuration: job.AudioDuration,
+		}
+		if job.Status == "queued" {
+			// Earlier attempts' times don't describe the wait in the queue
+			status.StartedAt = ""
+			status.FinishedAt = ""
+		}
+		if status.FinishedAt != "" {
+			status.Timestamp = status.FinishedAt
+		} else if status.StartedAt != "" {
+			status.Timestamp = status.StartedAt
+		}
+
+		if started, err := time.Parse(time.RFC3339, status.StartedAt); err == nil {
+			finished, err := time.Parse(time.RFC3339, status.FinishedAt)
+			if err != nil {
+				finished = now
+			}
+			status.Elapsed = finished.Sub(started).Seconds()
+		}
+
+		switch job.Status {
+		case "completed":
+			status.Progress = 100
+		case "processing":
+			if seconds, ok := tq.progress[filename]; ok && job.AudioDuration > 0 {
+				status.Progress = math.Min(99, seconds/job.AudioDuration*100)
+				status.ProgressFrom = "engine"
+			} else if speed > 0 && job.AudioDuration > 0 {
+				// Assume this job runs at the same speed as earlier ones
+				status.Progress = math.Min(99, status.Elapsed/(job.AudioDuration*speed)*100)
+				status.ProgressFrom = "estimate"
+			}
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		a, b := statuses[i], statuses[j]
+		if (a.QueuePosition > 0) != (b.QueuePosition > 0) {
+			return a.QueuePosition > 0
+		}
+		if a.QueuePosition != b.QueuePosition {
+			return a.QueuePosition < b.QueuePosition
+		}
+		if a.Timestamp != b.Timestamp {
+			return a.Timestamp > b.Timestamp
+		}
+		return a.Filename < b.Filename
+	})
+
 	return statuses
 }
 
+// Seconds of processing per second of audio, averaged over completed jobs.
+// Returns 0 if no completed job has a known duration. Caller holds tq.mu.
+func (tq *TranscriptionQueue) processingSpeed() float64 {
+	var processing, audio float64
+	for _, job := range tq.Jobs {
+		if job.Status != "completed" || job.AudioDuration <= 0 {
+			continue
+		}
+		started, err := time.Parse(time.RFC3339, job.StartedAt)
+		if err != nil {
+			continue
+		}
+		finished, err := time.Parse(time.RFC3339, job.FinishedAt)
+		if err != nil {
+			continue
+		}
+		processing += finished.Sub(started).Seconds()
+		audio += job.AudioDuration
+	}
+
+	if audio == 0 {
+		return 0
+	}
+	return processing / audio
+}
+
+// Whether a job passes the filter
+func (f TranscriptionStatusFilter) matches(job *TranscriptionJob) bool {
+	if len(f.Statuses) > 0 {
+		found := false
+		for _, status := range f.Statuses {
+			if status == job.Status {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return f.Filename == "" || strings.Contains(strings.ToLower(job.Filename), strings.ToLower(f.Filename))
+}
+
 // Worker that processes the transcription queue
-func transcriptionWorker() {
+func transcriptionWorker(id int) {
+	defer TQueue.workers.Done()
+
 	for {
-		// Get next file from queue
-		filename, ok := TQueue.GetNext()
+		// Wait for the next file from the queue
+		filename, ctx, ok := TQueue.WaitNext()
 		if !ok {
-			// No files in queue, sleep and try again
-			time.Sleep(5 * time.Second)
-			continue
+			return
 		}
 
-		log.Printf("Processing transcription for %s", filename)
+		log.Printf("Worker %d processing transcription for %s", id, filename)
 
 		// Process the file
-		err := processTranscription(filename)
-		if err != nil {
+		err := processTranscription(ctx, filename)
+		if err != nil && ctx.Err() != nil && TQueue.wasCancelled(filename) {
+			log.Printf("Transcription for %s cancelled", filename)
+			TQueue.MarkCancelled(filename)
+		} else if err != nil && ctx.Err() != nil && TQueue.isClosed() {
+			log.Printf("Transcription for %s interrupted by shutdown", filename)
+			TQueue.MarkInterrupted(filename)
+		} else if err != nil {
 			log.Printf("Transcription failed for %s: %v", filename, err)
-			TQueue.MarkFailed(filename, err.Error())
+			if TQueue.MarkFailed(filename, err) {
+				continue
+			}
 
-			// Create a .failed file
+			// Create a .failed file once the job has failed for good
 			failedFilePath := filepath.Join(transcriptsDir, filename+".failed")
 			if err := os.WriteFile(failedFilePath, []byte(err.Error()), 0644); err != nil {
 				log.Printf("Failed to write failure file for %s: %v", filename, err)
@@ -226,9 +948,18 @@ func checkExistingMediaFiles() {
 			failedPath := filepath.Join(transcriptsDir, filename+".failed")
 
 			if _, err := os.Stat(transcriptPath); os.IsNotExist(err) {
-				if _, err := os.Stat(failedPath); os.IsNotExist(err) {
+				if errorMsg, err := os.ReadFile(failedPath); err == nil {
+					// Keep failures from before jobs were persisted in the history
+					TQueue.addFailedJob(filename, strings.TrimSpace(string(errorMsg)))
+				} else if subtitlePath, _ := findSidecarSubtitle(mediaDir, filename); subtitlePath != "" {
+					// Subtitles next to the file stand in for a transcript
+					if err := importSidecarTranscript(filename, subtitlePath); err != nil {
+						log.Printf("Failed to import subtitles for %s: %v", filename, err)
+						TQueue.AddToQueue(filename, 0)
+					}
+				} else {
 					// No transcript or failed file exists, add to queue
-					TQueue.AddToQueue(filename)
+					TQueue.AddToQueue(filename, 0)
 				}
 			}
 		}
@@ -236,12 +967,12 @@ func checkExistingMediaFiles() {
 }
 
 // Process a file for transcription
-func processTranscription(filename string) error {
+func processTranscription(ctx context.Context, filename string) error {
 	filePath := filepath.Join(mediaDir, filename)
 
 	// Check if file exists
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
-		return fmt.Errorf("file does not exist: %s", filePath)
+		return permanentError(fmt.Errorf("file does not exist: %s", filePath))
 	}
 
 	// Determine if it's an audio or video file
@@ -250,212 +981,175 @@ func processTranscription(filename string) error {
 	isAudio := strings.HasSuffix(lowerFilename, ".mp3") || strings.HasSuffix(lowerFilename, ".wav")
 
 	if !isVideo && !isAudio {
-		return fmt.Errorf("unsupported file type: %s", filename)
+		return permanentError(fmt.Errorf("unsupported file type: %s", filename))
 	}
 
-	// For video files, extract audio first
+	// Prepare the audio if configured; otherwise, for video files, extract audio first
 	var audioPath string
-	if isVideo {
-		// Extract audio using ffmpeg
+	var timeline audioTimeline
+	if preprocess := AppConfig.Transcription.Preprocess; preprocess.Enabled || isVideo {
 		audioPath = filepath.Join(transcriptsDir, filename+".wav")
-		if err := extractAudioFromVideo(filePath, audioPath); err != nil {
+		if preprocess.Enabled {
+			var err error
+			if timeline, err = preprocessAudio(ctx, filePath, audioPath, preprocess); err != nil {
+				os.Remove(audioPath)
+				return fmt.Errorf("failed to preprocess audio: %v", err)
+			}
+		} else if err := extractAudioFromVideo(ctx, filePath, audioPath); err != nil {
 			return fmt.Errorf("failed to extract audio: %v", err)
 		}
+
+		// Clean up the temporary audio file however transcription ends
+		defer func() {
+			if err := os.Remove(audioPath); err != nil && !os.IsNotExist(err) {
+				log.Printf("Warning: Failed to remove temporary audio file %s: %v", audioPath, err)
+			}
+		}()
 	} else {
 		// For audio files, use the original file
 		audioPath = filePath
 	}
 
-	// Run whisperx on the audio file
-	transcriptPath := filepath.Join(transcriptsDir, filename+".json")
-	if err := runWhisperX(audioPath, transcriptPath); err != nil {
-		return fmt.Errorf("whisperx transcription failed: %v", err)
-	}
-
-	// Clean up temporary audio file if it was extracted from video
-	if isVideo {
-		if err := os.Remove(audioPath); err != nil {
-			log.Printf("Warning: Failed to remove temporary audio file %s: %v", audioPath, err)
-		}
-	}
-
-	// Update the metadata file with transcript information
-	if err := updateMetadataWithTranscript(filename, transcriptPath); err != nil {
-		return fmt.Errorf("failed to update metadata: %v", err)
-	}
-
-	return nil
-}
-
-// Extract audio from a video file using ffmpeg
-func extractAudioFromVideo(videoPath, audioPath string) error {
-	cmd := exec.Command("ffmpeg", "-i", videoPath, "-vn", "-acodec", "pcm_s16le", "-ar", "16000", "-ac", "1", audioPath)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return fmt.Errorf("ffmpeg error: %v, output: %s", err, string(output))
-	}
-	return nil
-}
-
-// Run whisperx on an audio file
-func runWhisperX(audioPath, outputPath string) error {
-	// Create a temporary directory for whisperx output
-	tempDir, err := os.MkdirTemp("", "whisperx")
-	if err != nil {
-		return fmt.Errorf("failed to create temp directory: %v", err)
-	}
-	defer os.RemoveAll(tempDir)
-
-	err = os.Chmod(tempDir, 0777)
-	if err != nil {
-		log.Fatal(err)
+	// The audio length turns engine progress into a percentage
+	duration, err := probeAudioDuration(ctx, audioPath)
+	if err == nil {
+		TQueue.SetAudioDuration(filename, duration)
+	} else {
+		log.Printf("Could not determine duration of %s: %v", filename, err)
 	}
 
-	audioFileName := filepath.Base(audioPath)
-	tempAudioPath := filepath.Join(tempDir, audioFileName)
-	audioData, err := os.ReadFile(audioPath)
-	if err != nil {
-		return fmt.Errorf("failed to read audio file error: %v", err)
+	// Transcribe in the item's language, or let the engine detect it
+	var metadata MediaMetadata
+	if _, err := readMarkdownFile(filepath.Join(metadataDir, filename+mdExt), &metadata); err != nil {
+		log.Printf("Could not read metadata of %s, using the default language: %v", filename, err)
 	}
+	opts := TranscribeOptions{Language: transcriptionLanguage(metadata), Model: TQueue.JobModel(filename)}
 
-	if err := os.WriteFile(tempAudioPath, audioData, 0666); err != nil {
-		return fmt.Errorf("failed to read audio file error: %v", err)
+	// Run the configured transcriber on the audio file, in chunks if it's long
+	var result TranscribeResult
+	if shouldChunk(duration) {
+		result, err = transcribeInChunks(ctx, filename, audioPath, duration, timeline, opts)
+	} else {
+		result, err = activeTranscriber.Transcribe(ctx, audioPath, opts)
 	}
-
-	// Run whisperx
-	cmd := exec.Command("podman", "run", "-v", tempDir+":/app:Z", "ghcr.io/jim60105/whisperx:base-en", "--", "--output_format", "json", "--compute_type", "int8", audioFileName)
-	output, err := cmd.CombinedOutput()
 	if err != nil {
-		return fmt.Errorf("whisperx error: %v, output: %s", err, string(output))
+		return fmt.Errorf("%s transcription failed: %v", activeTranscriber.Name(), err)
 	}
-
-	// Find the JSON output file
-	files, err := os.ReadDir(tempDir)
-	if err != nil {
-		return fmt.Errorf("failed to read whisperx output directory: %v", err)
+	// Segment times from cut audio are moved back to the recording's
+	result.Segments = timeline.restore(result.Segments)
+	if opts.Language == "" && result.Language != "" {
+		log.Printf("Detected language %s (%.2f) in %s", result.Language, result.LanguageProbability, filename)
 	}
 
-	var jsonFile string
-	for _, file := range files {
-		if strings.HasSuffix(file.Name(), ".json") {
-			jsonFile = filepath.Join(tempDir, file.Name())
-			break
-		}
-	}
-
-	if jsonFile == "" {
-		return fmt.Errorf("no JSON output found from whisperx")
+	transcriptPath := filepath.Join(transcriptsDir, filename+".json")
+	// Take out repetitions and segments without speech
+	var cleanup *CleanupReport
+	result.Segments, cleanup = cleanupSegments(result.Segments, AppConfig.Transcription.Cleanup)
+	if cleanup != nil {
+		log.Printf("Cleanup changed %d segments of %s", len(cleanup.Changes), filename)
 	}
 
-	// Read the whisperx output
-	data, err := os.ReadFile(jsonFile)
-	if err != nil {
-		return fmt.Errorf("failed to read whisperx output: %v", err)
+	if err := writeTranscriptFile(filename, activeTranscriber, result, opts.Language == "", cleanup); err != nil {
+		return err
 	}
 
-	// Parse the whisperx output to extract segments
-	var whisperOutput map[string]interface{}
-	if err := json.Unmarshal(data, &whisperOutput); err != nil {
-		return fmt.Errorf("failed to parse whisperx output: %v", err)
-	}
+	removeChunks(filename)
 
-	// Convert to our transcript format
-	segments, ok := whisperOutput["segments"].([]interface{})
-	if !ok {
-		return fmt.Errorf("invalid whisperx output format")
+	// Update the metadata file with transcript information
+	if err := updateMetadataWithTranscript(filename, transcriptPath); err != nil {
+		return fmt.Errorf("failed to update metadata: %v", err)
 	}
 
-	var transcriptEntries []TranscriptEntry
-	for i, seg := range segments {
-		segment, ok := seg.(map[string]interface{})
-		if !ok {
-			continue
-		}
-
-		start, _ := segment["start"].(float64)
-		end, _ := segment["end"].(float64)
-		text, _ := segment["text"].(string)
-
-		entry := TranscriptEntry{
-			Start:   start,
-			End:     end,
-			Text:    text,
-			Segment: i,
-		}
-
-		transcriptEntries = append(transcriptEntries, entry)
-	}
+	return nil
+}
 
-	// Write the transcript to the output file
-	transcriptData, err := json.MarshalIndent(transcriptEntries, "", "  ")
+// Extract audio from a video file using ffmpeg
+func extractAudioFromVideo(ctx context.Context, videoPath, audioPath string) error {
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-y", "-i", videoPath, "-vn", "-acodec", "pcm_s16le", "-ar", "16000", "-ac", "1", audioPath)
+	output, err := cmd.CombinedOutput()
 	if err != nil {
-		return fmt.Errorf("failed to marshal transcript: %v", err)
-	}
-
-	if err := os.WriteFile(outputPath, transcriptData, 0644); err != nil {
-		return fmt.Errorf("failed to write transcript file: %v", err)
+		return fmt.Errorf("ffmpeg error: %v, output: %s", err, string(output))
 	}
-
 	return nil
 }
 
 // Update metadata file with transcript information
 func updateMetadataWithTranscript(filename, transcriptPath string) error {
 	// Read the transcript file
-	data, err := os.ReadFile(transcriptPath)
+	transcript, err := readTranscriptFile(transcriptPath)
 	if err != nil {
-		return fmt.Errorf("failed to read transcript file: %v", err)
+		return err
 	}
+	return writeTranscriptToMetadata(filename, transcript, transcript.Engine, "transcribe")
+}
 
-	var transcriptEntries []TranscriptEntry
-	if err := json.Unmarshal(data, &transcriptEntries); err != nil {
-		return fmt.Errorf("failed to parse transcript: %v", err)
-	}
+// Make a transcript the one in an item's metadata and record it as a
+// revision by author
+func writeTranscriptToMetadata(filename string, transcript TranscriptFile, author, action string) error {
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+	return replaceMetadataTranscript(filename, transcript, author, action)
+}
 
-	// Create a full transcription text
-	var fullText strings.Builder
-	for _, entry := range transcriptEntries {
-		fullText.WriteString(entry.Text)
-		fullText.WriteString(" ")
-	}
-	transcriptionText := fullText.String()
+// writeTranscriptToMetadata for callers that already hold metadataMu
+func replaceMetadataTranscript(filename string, transcript TranscriptFile, author, action string) error {
+	transcriptEntries := transcript.Segments
 
 	// Read the Markdown file with frontmatter
 	metadataPathMd := filepath.Join(metadataDir, filename+mdExt)
 	var metadata MediaMetadata
-	_, err = readMarkdownFile(metadataPathMd, &metadata)
-	if err != nil {
+	if _, err := readMarkdownFile(metadataPathMd, &metadata); err != nil {
 		return fmt.Errorf("failed to read metadata file: %v", err)
 	}
 
-	// Update metadata with transcript
+	// Update metadata with transcript and the engine that produced it
 	metadata.Transcripts = transcriptEntries
-
-	// Create frontmatter data
-	frontmatterData := struct {
-		ID          string            `yaml:"id"`
-		Filename    string            `yaml:"filename"`
-		Path        string            `yaml:"path"`
-		Type        string            `yaml:"type"`
-		Timestamp   string            `yaml:"timestamp"`
-		Duration    float64           `yaml:"duration,omitempty"`
-		Labels      []string          `yaml:"labels"`
-		Transcripts []TranscriptEntry `yaml:"transcripts,omitempty"`
-	}{
-		ID:          metadata.ID,
-		Filename:    metadata.Filename,
-		Path:        metadata.Path,
-		Type:        metadata.Type,
-		Timestamp:   metadata.Timestamp,
-		Duration:    metadata.Duration,
-		Labels:      metadata.Labels,
-		Transcripts: transcriptEntries,
+	metadata.TranscriptEngine = transcript.Engine
+	metadata.TranscriptModel = transcript.Model
+	metadata.TranscriptStatus = ""
+
+	// Only a detected language is recorded; a chosen one is already in the metadata
+	metadata.DetectedLanguage = ""
+	metadata.LanguageProbability = 0
+	if transcript.LanguageDetected {
+		metadata.DetectedLanguage = transcript.Language
+		metadata.LanguageProbability = transcript.LanguageProbability
 	}
 
 	// Write the Markdown file with frontmatter
-	if err := writeMarkdownFile(metadataPathMd, frontmatterData, transcriptionText); err != nil {
+	metadata.Transcription = transcriptBody(metadata)
+	applyLabelRules(&metadata)
+	if err := writeMetadataFile(metadataPathMd, metadata); err != nil {
 		return fmt.Errorf("failed to write updated metadata: %v", err)
 	}
 
+	// Keep the engine's output in the item's revision history
+	if _, err := saveTranscriptRevision(metadata.Filename, author, action, transcript.Version, 0, transcriptEntries); err != nil {
+		log.Printf("Failed to record transcript revision for %s: %v", filename, err)
+	}
+
+	// A new transcript needs a new summary, and its text embedding for
+	// semantic search
+	queueSummary(filename, false)
+	queueEmbedding(filename)
 	return nil
 }
+
+// Write the segments a running chunked transcription has finished so far to
+// an item's metadata. The transcript file and revisions are left alone until
+// the whole recording is done.
+func updateMetadataWithPartialTranscript(filename string, segments []TranscriptEntry) error {
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+
+	metadataPath := filepath.Join(metadataDir, filename+mdExt)
+	var metadata MediaMetadata
+	if _, err := readMarkdownFile(metadataPath, &metadata); err != nil {
+		return fmt.Errorf("failed to read metadata file: %v", err)
+	}
+
+	metadata.Transcripts = segments
+	metadata.TranscriptStatus = "partial"
+	metadata.Transcription = transcriptBody(metadata)
+	return writeMetadataFile(metadataPath, metadata)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SetPriorityRequest represents the request body for changing a job's priority
+type SetPriorityRequest struct {
+	Priority int `json:"priority"`
+}
+
+// RetranscribeRequest represents the request body for transcribing items
+// again; an empty model uses the configured one
+type RetranscribeRequest struct {
+	Model  string `json:"model"`
+	DryRun bool   `json:"dryRun"` // List the items that would be queued without queueing them
+}
+
+// BulkRetranscribeResponse lists the items queued for transcription again
+type BulkRetranscribeResponse struct {
+	Model   string                `json:"model,omitempty"`
+	DryRun  bool                  `json:"dryRun"`
+	Count   int                   `json:"count"`
+	Queued  []string              `json:"queued"`
+	Skipped []RetranscribeSkipped `json:"skipped"`
+}
+
+// RetranscribeSkipped is an item that couldn't be queued, and why
+type RetranscribeSkipped struct {
+	Filename string `json:"filename"`
+	Reason   string `json:"reason"`
+}
+
+// Handler for actions on a single transcription job:
+// POST /api/transcription/{filename}/{retry,cancel,front,priority,transcribe}
+func handleTranscriptionJob(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/api/transcription/")
+	slash := strings.LastIndex(path, "/")
+	if slash <= 0 {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	filename, action := path[:slash], path[slash+1:]
+
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var err error
+	switch action {
+	case "retry":
+		err = TQueue.Retry(filename)
+	case "transcribe":
+		// Run the engine even if the item has a transcript, e.g. from
+		// subtitles or to compare another model. The old one stays a version.
+		var req RetranscribeRequest
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request body", http.StatusBadRequest)
+				return
+			}
+		}
+		if mediaType := detectMediaType(filename); mediaType != "audio" && mediaType != "video" {
+			http.Error(w, "Only audio and video can be transcribed", http.StatusBadRequest)
+			return
+		}
+		if _, statErr := os.Stat(filepath.Join(mediaDir, filename)); statErr != nil {
+			err = fmt.Errorf("media file %s: %w", filename, os.ErrNotExist)
+		} else {
+			err = TQueue.Retranscribe(filename, strings.TrimSpace(req.Model))
+		}
+	case "cancel":
+		err = TQueue.Cancel(filename)
+	case "front":
+		err = TQueue.MoveToFront(filename)
+	case "priority":
+		var req SetPriorityRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		err = TQueue.SetPriority(filename, req.Priority)
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+		} else {
+			http.Error(w, err.Error(), http.StatusConflict)
+		}
+		return
+	}
+
+	statuses := TQueue.GetAllStatuses(TranscriptionStatusFilter{Filename: filename})
+	for _, status := range statuses {
+		if status.Filename == filename {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(status)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handler for transcribing every item matching the media listing filters
+// again, optionally with another model: POST /api/transcription/retranscribe?type=audio
+func handleBulkRetranscribe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req RetranscribeRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	req.Model = strings.TrimSpace(req.Model)
+
+	filter, err := parseMediaFilter(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	items, err := filterMetadata(filter)
+	if err != nil {
+		http.Error(w, "Failed to read metadata", http.StatusInternalServerError)
+		return
+	}
+
+	response := BulkRetranscribeResponse{Model: req.Model, DryRun: req.DryRun, Queued: []string{}, Skipped: []RetranscribeSkipped{}}
+	for _, metadata := range items {
+		if metadata.Type != "audio" && metadata.Type != "video" {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(mediaDir, metadata.Filename)); err != nil {
+			response.Skipped = append(response.Skipped, RetranscribeSkipped{Filename: metadata.Filename, Reason: "media file is missing"})
+			continue
+		}
+		if !req.DryRun {
+			if err := TQueue.Retranscribe(metadata.Filename, req.Model); err != nil {
+				response.Skipped = append(response.Skipped, RetranscribeSkipped{Filename: metadata.Filename, Reason: err.Error()})
+				continue
+			}
+		}
+		response.Queued = append(response.Queued, metadata.Filename)
+	}
+	response.Count = len(response.Queued)
+	if !req.DryRun {
+		log.Printf("Queued %d items for transcription again (model %q)", response.Count, req.Model)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Handler for retrying every failed transcription
+func handleRetryAllFailed(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	retried := TQueue.RetryAllFailed()
+	log.Printf("Re-queued %d failed transcriptions", len(retried))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":  "success",
+		"retried": retried,
+		"count":   len(retried),
+	})
+}
+
+// Handler for the queue as a whole: GET returns its state, POST to
+// /api/transcription/pause or /api/transcription/resume changes it
+func handleTranscriptionQueue(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/api/transcription/pause", "/api/transcription/resume":
+		if r.Method != http.MethodPost {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		paused := r.URL.Path == "/api/transcription/pause"
+		TQueue.SetPaused(paused)
+		log.Printf("Transcription queue paused: %v", paused)
+	default:
+		if r.Method != http.MethodGet {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TQueue.State())
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Run tests in an empty data directory with the fake transcriber
+func setupTranscriptionTest(t *testing.T) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	ensureDirectories()
+	if err := ensureTranscriptionDirs(); err != nil {
+		t.Fatal(err)
+	}
+
+	config, transcriber := AppConfig, activeTranscriber
+	t.Cleanup(func() { AppConfig, activeTranscriber = config, transcriber })
+	AppConfig = defaultConfig()
+	AppConfig.Transcription.Backend = "fake"
+	activeTranscriber = &fakeTranscriber{}
+}
+
+func TestProcessTranscription(t *testing.T) {
+	setupTranscriptionTest(t)
+
+	filename := "talk.mp3"
+	if err := os.WriteFile(filepath.Join(mediaDir, filename), []byte("not really audio"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	metadataPath := filepath.Join(metadataDir, filename+mdExt)
+	if err := writeMetadataFile(metadataPath, MediaMetadata{
+		ID:       "1",
+		Filename: filename,
+		Path:     "/media/" + filename,
+		Type:     "audio",
+		Labels:   []string{"meeting"},
+		Language: "de",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := processTranscription(context.Background(), filename); err != nil {
+		t.Fatalf("processTranscription: %v", err)
+	}
+
+	transcript, err := readTranscriptFile(filepath.Join(transcriptsDir, filename+".json"))
+	if err != nil {
+		t.Fatalf("transcript not written: %v", err)
+	}
+	if transcript.Language != "de" || len(transcript.Segments) != 3 {
+		t.Errorf("transcript has language %q and %d segments", transcript.Language, len(transcript.Segments))
+	}
+
+	var metadata MediaMetadata
+	body, err := readMarkdownFile(metadataPath, &metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metadata.Transcripts) != 3 || metadata.TranscriptEngine != "fake" {
+		t.Errorf("metadata has %d segments from %q", len(metadata.Transcripts), metadata.TranscriptEngine)
+	}
+	if len(metadata.Labels) != 1 || metadata.Labels[0] != "meeting" || metadata.Language != "de" {
+		t.Errorf("metadata fields lost: %+v", metadata)
+	}
+	if !strings.Contains(body, "Fake transcript segment 1 of talk.mp3.") {
+		t.Errorf("body doesn't show the transcript:\n%s", body)
+	}
+}
+
+func TestProcessTranscriptionRejectsMissingAndUnsupportedFiles(t *testing.T) {
+	setupTranscriptionTest(t)
+
+	if err := os.WriteFile(filepath.Join(mediaDir, "photo.jpg"), []byte("jpeg"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, filename := range []string{"missing.mp3", "photo.jpg"} {
+		err := processTranscription(context.Background(), filename)
+		if err == nil || !isPermanentError(err) {
+			t.Errorf("processTranscription(%s) = %v, want a permanent error", filename, err)
+		}
+	}
+}
+
+func TestMarkFailedDuringShutdown(t *testing.T) {
+	setupTranscriptionTest(t)
+	tq := newTranscriptionQueue()
+	tq.AddToQueue("talk.mp3", 0)
+	tq.AddToQueue("photo.jpg", 0)
+	for range 2 {
+		if _, _, ok := tq.WaitNext(); !ok {
+			t.Fatal("no job to start")
+		}
+	}
+	tq.closed = true
+
+	// A transient failure while stopping waits for the next start
+	if !tq.MarkFailed("talk.mp3", errors.New("podman pull interrupted")) {
+		t.Error("transient failure during shutdown failed the job")
+	}
+	if job := tq.Jobs["talk.mp3"]; job.Status != "queued" || job.NextRetryAt != "" {
+		t.Errorf("job after a transient failure during shutdown: %+v", job)
+	}
+	jobs, err := loadJobFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, job := range jobs {
+		if job.Filename == "talk.mp3" && job.Status != "queued" {
+			t.Errorf("job file has status %q, want queued", job.Status)
+		}
+	}
+
+	// A permanent one still fails it
+	if tq.MarkFailed("photo.jpg", permanentError(errors.New("unsupported file"))) {
+		t.Error("permanent failure during shutdown will be retried")
+	}
+	if job := tq.Jobs["photo.jpg"]; job.Status != "failed" {
+		t.Errorf("job after a permanent failure: %+v", job)
+	}
+}
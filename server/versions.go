@@ -0,0 +1,545 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Every engine run and subtitle import is kept as a numbered transcript
+// version in data/versions/<filename>/, so transcribing again with another
+// model doesn't lose the earlier result. The active version is the
+// transcript in data/transcripts/, which edits change; switching to another
+// version first saves the active one, edits included, back to its file.
+const transcriptVersionsDir = "./data/versions"
+
+// Segments of two versions that overlap by less than this many seconds are
+// not aligned with each other
+const versionAlignTolerance = 0.25
+
+// TranscriptVersion describes one version of an item's transcript
+type TranscriptVersion struct {
+	Version   int    `json:"version"`
+	Engine    string `json:"engine"`
+	Model     string `json:"model,omitempty"`
+	CreatedAt string `json:"createdAt"`
+	EditedAt  string `json:"editedAt,omitempty"`
+	Language  string `json:"language,omitempty"`
+	Segments  int    `json:"segments"`
+	Words     int    `json:"words"`
+	Active    bool   `json:"active"`
+}
+
+// TranscriptVersionResponse is a version with its segments
+type TranscriptVersionResponse struct {
+	TranscriptVersion
+	Segments []TranscriptEntry `json:"segments"`
+}
+
+// VersionDiffResponse compares two versions segment by segment
+type VersionDiffResponse struct {
+	From    TranscriptVersion  `json:"from"`
+	To      TranscriptVersion  `json:"to"`
+	Summary VersionDiffSummary `json:"summary"`
+	Rows    []VersionDiffRow   `json:"rows"`
+}
+
+// VersionDiffSummary counts the rows and words that differ
+type VersionDiffSummary struct {
+	Rows         int `json:"rows"`
+	Same         int `json:"same"`
+	Changed      int `json:"changed"`
+	Added        int `json:"added"`
+	Removed      int `json:"removed"`
+	WordsFrom    int `json:"wordsFrom"`
+	WordsTo      int `json:"wordsTo"`
+	WordsAdded   int `json:"wordsAdded"`
+	WordsRemoved int `json:"wordsRemoved"`
+}
+
+// VersionDiffRow is a stretch of time with the segments each version has in
+// it. Models cut segments differently, so a row can hold several segments
+// of either version.
+type VersionDiffRow struct {
+	Type  string            `json:"type"` // "same", "changed", "added" or "removed"
+	Start float64           `json:"start"`
+	End   float64           `json:"end"`
+	From  []TranscriptEntry `json:"from"`
+	To    []TranscriptEntry `json:"to"`
+	Words []WordChange      `json:"words,omitempty"` // How the text changed, for changed rows
+}
+
+// WordChange is a run of words both versions have, or only one of them
+type WordChange struct {
+	Type string `json:"type"` // "same", "removed" or "added"
+	Text string `json:"text"`
+}
+
+func versionDir(filename string) string {
+	return filepath.Join(transcriptVersionsDir, filename)
+}
+
+func versionFilePath(filename string, version int) string {
+	return filepath.Join(versionDir(filename), fmt.Sprintf("%06d.json", version))
+}
+
+// Read a version. Returns an os.ErrNotExist error if it doesn't exist.
+func readTranscriptVersion(filename string, version int) (TranscriptFile, error) {
+	path := versionFilePath(filename, version)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return TranscriptFile{}, fmt.Errorf("version %d of %s: %w", version, filename, os.ErrNotExist)
+	}
+	return readTranscriptFile(path)
+}
+
+// Write a transcript to the file of its version
+func writeTranscriptVersion(filename string, transcript TranscriptFile) error {
+	if err := os.MkdirAll(versionDir(filename), 0755); err != nil {
+		return fmt.Errorf("failed to create versions directory: %v", err)
+	}
+	return saveTranscriptFile(versionFilePath(filename, transcript.Version), transcript)
+}
+
+// Number the next version of an item gets
+func nextVersionNumber(filename string) (int, error) {
+	numbers, err := listNumberedFiles(versionDir(filename))
+	if err != nil {
+		return 0, err
+	}
+	if len(numbers) == 0 {
+		return 1, nil
+	}
+	return numbers[len(numbers)-1] + 1, nil
+}
+
+// Read an item's active transcript. One written before versions were kept
+// becomes a version first. Returns an empty transcript if there is none.
+// Caller must hold metadataMu.
+func activeTranscript(filename string) (TranscriptFile, error) {
+	transcriptPath := filepath.Join(transcriptsDir, filename+".json")
+	if _, err := os.Stat(transcriptPath); os.IsNotExist(err) {
+		return TranscriptFile{}, nil
+	}
+	transcript, err := readTranscriptFile(transcriptPath)
+	if err != nil || transcript.Version > 0 || len(transcript.Segments) == 0 {
+		return transcript, err
+	}
+
+	if transcript.Version, err = nextVersionNumber(filename); err != nil {
+		return TranscriptFile{}, err
+	}
+	if err := writeTranscriptVersion(filename, transcript); err != nil {
+		return TranscriptFile{}, err
+	}
+	if err := saveTranscriptFile(transcriptPath, transcript); err != nil {
+		return TranscriptFile{}, err
+	}
+	return transcript, nil
+}
+
+// Save a new transcript as the next version and make it the active one.
+// The transcript it replaces is kept, edits included, in its own version.
+func storeTranscriptVersion(filename string, transcript TranscriptFile) error {
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+
+	current, err := activeTranscript(filename)
+	if err != nil {
+		return err
+	}
+	if current.Version > 0 {
+		if err := writeTranscriptVersion(filename, current); err != nil {
+			return err
+		}
+	}
+
+	if transcript.Version, err = nextVersionNumber(filename); err != nil {
+		return err
+	}
+	if err := writeTranscriptVersion(filename, transcript); err != nil {
+		return err
+	}
+	return saveTranscriptFile(filepath.Join(transcriptsDir, filename+".json"), transcript)
+}
+
+// Make an earlier version the active transcript, recorded as a revision by author
+func activateTranscriptVersion(filename string, version int, author string) (TranscriptFile, error) {
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+
+	target, err := readTranscriptVersion(filename, version)
+	if err != nil {
+		return TranscriptFile{}, err
+	}
+	current, err := activeTranscript(filename)
+	if err != nil {
+		return TranscriptFile{}, err
+	}
+	if current.Version == version {
+		return current, nil
+	}
+	if current.Version > 0 {
+		if err := writeTranscriptVersion(filename, current); err != nil {
+			return TranscriptFile{}, err
+		}
+	}
+
+	if err := saveTranscriptFile(filepath.Join(transcriptsDir, filename+".json"), target); err != nil {
+		return TranscriptFile{}, err
+	}
+	if err := replaceMetadataTranscript(filename, target, author, "activate-version"); err != nil {
+		return TranscriptFile{}, fmt.Errorf("failed to update metadata: %v", err)
+	}
+	return target, nil
+}
+
+// Describe a version
+func summarizeVersion(transcript TranscriptFile, active int) TranscriptVersion {
+	words := 0
+	for _, segment := range transcript.Segments {
+		words += len(strings.Fields(segment.Text))
+	}
+	return TranscriptVersion{
+		Version:   transcript.Version,
+		Engine:    transcript.Engine,
+		Model:     transcript.Model,
+		CreatedAt: transcript.CreatedAt,
+		EditedAt:  transcript.EditedAt,
+		Language:  transcript.Language,
+		Segments:  len(transcript.Segments),
+		Words:     words,
+		Active:    transcript.Version == active,
+	}
+}
+
+// Every version of an item, oldest first, and the active one's number. The
+// active version is read from the active transcript so its edits show.
+func listTranscriptVersions(filename string) ([]TranscriptFile, int, error) {
+	metadataMu.Lock()
+	defer metadataMu.Unlock()
+
+	current, err := activeTranscript(filename)
+	if err != nil {
+		return nil, 0, err
+	}
+	numbers, err := listNumberedFiles(versionDir(filename))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var versions []TranscriptFile
+	for _, number := range numbers {
+		if number == current.Version {
+			versions = append(versions, current)
+			continue
+		}
+		transcript, err := readTranscriptVersion(filename, number)
+		if err != nil {
+			log.Printf("Skipping version %d of %s: %v", number, filename, err)
+			continue
+		}
+		transcript.Version = number
+		versions = append(versions, transcript)
+	}
+	return versions, current.Version, nil
+}
+
+// Line up the segments of two versions by time. Segments that overlap, in
+// either version, end up in the same row.
+func alignVersions(from, to []TranscriptEntry) []VersionDiffRow {
+	type sided struct {
+		segment TranscriptEntry
+		to      bool
+	}
+	var all []sided
+	for _, segment := range segmentsWithoutWords(from) {
+		all = append(all, sided{segment: segment})
+	}
+	for _, segment := range segmentsWithoutWords(to) {
+		all = append(all, sided{segment: segment, to: true})
+	}
+	sort.SliceStable(all, func(i, j int) bool { return all[i].segment.Start < all[j].segment.Start })
+
+	rows := []VersionDiffRow{}
+	for _, s := range all {
+		if len(rows) == 0 || s.segment.Start >= rows[len(rows)-1].End-versionAlignTolerance {
+			rows = append(rows, VersionDiffRow{Start: s.segment.Start, End: s.segment.End, From: []TranscriptEntry{}, To: []TranscriptEntry{}})
+		}
+		row := &rows[len(rows)-1]
+		if s.to {
+			row.To = append(row.To, s.segment)
+		} else {
+			row.From = append(row.From, s.segment)
+		}
+		row.End = max(row.End, s.segment.End)
+	}
+	return rows
+}
+
+// Compare two versions
+func diffTranscriptVersions(from, to []TranscriptEntry) ([]VersionDiffRow, VersionDiffSummary) {
+	rows := alignVersions(from, to)
+	summary := VersionDiffSummary{Rows: len(rows)}
+	for i := range rows {
+		row := &rows[i]
+		fromText := strings.Fields(transcriptText(row.From))
+		toText := strings.Fields(transcriptText(row.To))
+		summary.WordsFrom += len(fromText)
+		summary.WordsTo += len(toText)
+
+		switch {
+		case len(row.From) == 0:
+			row.Type = "added"
+			summary.Added++
+			summary.WordsAdded += len(toText)
+		case len(row.To) == 0:
+			row.Type = "removed"
+			summary.Removed++
+			summary.WordsRemoved += len(fromText)
+		case normalizeCleanupText(strings.Join(fromText, " ")) == normalizeCleanupText(strings.Join(toText, " ")):
+			row.Type = "same"
+			summary.Same++
+		default:
+			row.Type = "changed"
+			summary.Changed++
+			row.Words = diffWords(fromText, toText)
+			for _, change := range row.Words {
+				switch change.Type {
+				case "added":
+					summary.WordsAdded += len(strings.Fields(change.Text))
+				case "removed":
+					summary.WordsRemoved += len(strings.Fields(change.Text))
+				}
+			}
+		}
+	}
+	return rows, summary
+}
+
+// Word differences between two texts, ignoring case and punctuation. Runs of
+// words of the same kind are joined.
+func diffWords(from, to []string) []WordChange {
+	a := make([]string, len(from))
+	for i, word := range from {
+		a[i] = normalizeCleanupText(word)
+	}
+	b := make([]string, len(to))
+	for i, word := range to {
+		b[i] = normalizeCleanupText(word)
+	}
+
+	// Longest common subsequence, from the end
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var changes []WordChange
+	add := func(kind, word string) {
+		if last := len(changes) - 1; last >= 0 && changes[last].Type == kind {
+			changes[last].Text += " " + word
+			return
+		}
+		changes = append(changes, WordChange{Type: kind, Text: word})
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			add("same", to[j])
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			add("removed", from[i])
+			i++
+		default:
+			add("added", to[j])
+			j++
+		}
+	}
+	return changes
+}
+
+// Handler for an item's transcript versions: /api/media/{id}/transcript/versions...
+//
+//	GET  versions                      list versions
+//	GET  versions/{n}                  get a version with its segments
+//	POST versions/{n}/activate         make a version the active transcript
+//	GET  versions/diff?from=1&to=2     compare two versions; to defaults to the active one
+func handleTranscriptVersions(w http.ResponseWriter, r *http.Request, metadata MediaMetadata, parts []string) {
+	if len(parts) == 1 {
+		handleListVersions(w, r, metadata)
+		return
+	}
+	if parts[1] == "diff" && len(parts) == 2 {
+		handleDiffVersions(w, r, metadata)
+		return
+	}
+
+	number, err := strconv.Atoi(parts[1])
+	if err != nil || number <= 0 {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+	switch {
+	case len(parts) == 2:
+		handleGetVersion(w, r, metadata, number)
+	case len(parts) == 3 && parts[2] == "activate":
+		handleActivateVersion(w, r, metadata, number)
+	default:
+		http.Error(w, "Not found", http.StatusNotFound)
+	}
+}
+
+// Handler for listing an item's transcript versions
+func handleListVersions(w http.ResponseWriter, r *http.Request, metadata MediaMetadata) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	versions, active, err := listTranscriptVersions(metadata.Filename)
+	if err != nil {
+		log.Printf("Failed to list transcript versions of %s: %v", metadata.Filename, err)
+		http.Error(w, "Failed to read transcript versions", http.StatusInternalServerError)
+		return
+	}
+
+	response := []TranscriptVersion{}
+	for _, version := range versions {
+		response = append(response, summarizeVersion(version, active))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// Find a version among an item's versions
+func findVersion(versions []TranscriptFile, number int) (TranscriptFile, bool) {
+	for _, version := range versions {
+		if version.Version == number {
+			return version, true
+		}
+	}
+	return TranscriptFile{}, false
+}
+
+// Handler for reading a single version with its segments
+func handleGetVersion(w http.ResponseWriter, r *http.Request, metadata MediaMetadata, number int) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	versions, active, err := listTranscriptVersions(metadata.Filename)
+	if err != nil {
+		http.Error(w, "Failed to read transcript versions", http.StatusInternalServerError)
+		return
+	}
+	version, ok := findVersion(versions, number)
+	if !ok {
+		http.Error(w, "Version not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(TranscriptVersionResponse{
+		TranscriptVersion: summarizeVersion(version, active),
+		Segments:          segmentsWithoutWords(version.Segments),
+	})
+}
+
+// Handler for making a version the active transcript
+func handleActivateVersion(w http.ResponseWriter, r *http.Request, metadata MediaMetadata, number int) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req TranscriptEditRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+
+	transcript, err := activateTranscriptVersion(metadata.Filename, number, editAuthor(req.Author))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			http.Error(w, "Version not found", http.StatusNotFound)
+		} else {
+			log.Printf("Failed to activate version %d of %s: %v", number, metadata.Filename, err)
+			http.Error(w, "Failed to activate version", http.StatusInternalServerError)
+		}
+		return
+	}
+	log.Printf("Version %d (%s %s) is now the transcript of %s", number, transcript.Engine, transcript.Model, metadata.Filename)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summarizeVersion(transcript, transcript.Version))
+}
+
+// Handler for comparing two versions
+func handleDiffVersions(w http.ResponseWriter, r *http.Request, metadata MediaMetadata) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	versions, active, err := listTranscriptVersions(metadata.Filename)
+	if err != nil {
+		http.Error(w, "Failed to read transcript versions", http.StatusInternalServerError)
+		return
+	}
+
+	queryParams := r.URL.Query()
+	fromNumber, err := strconv.Atoi(queryParams.Get("from"))
+	if err != nil {
+		http.Error(w, "from must be a version number", http.StatusBadRequest)
+		return
+	}
+	toNumber := active
+	if value := queryParams.Get("to"); value != "" {
+		if toNumber, err = strconv.Atoi(value); err != nil {
+			http.Error(w, "to must be a version number", http.StatusBadRequest)
+			return
+		}
+	}
+
+	from, ok := findVersion(versions, fromNumber)
+	if !ok {
+		http.Error(w, fmt.Sprintf("Version %d not found", fromNumber), http.StatusNotFound)
+		return
+	}
+	to, ok := findVersion(versions, toNumber)
+	if !ok {
+		http.Error(w, fmt.Sprintf("Version %d not found", toNumber), http.StatusNotFound)
+		return
+	}
+
+	rows, summary := diffTranscriptVersions(from.Segments, to.Segments)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(VersionDiffResponse{
+		From:    summarizeVersion(from, active),
+		To:      summarizeVersion(to, active),
+		Summary: summary,
+		Rows:    rows,
+	})
+}
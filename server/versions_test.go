@@ -0,0 +1,110 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Texts of a row's segments from each version, as "from | to"
+func rowTexts(row VersionDiffRow) string {
+	return strings.TrimSpace(transcriptText(row.From)) + " | " + strings.TrimSpace(transcriptText(row.To))
+}
+
+func TestAlignVersions(t *testing.T) {
+	from := []TranscriptEntry{
+		{Start: 0, End: 4, Text: "One.", Words: []TranscriptWord{{Word: "One.", Start: 0, End: 1}}},
+		{Start: 5, End: 9, Text: "Two."},
+		{Start: 20, End: 24, Text: "Three."},
+	}
+	to := []TranscriptEntry{
+		{Start: 0.5, End: 4.5, Text: "1."},
+		{Start: 4.8, End: 7, Text: "2a."}, // Overlaps the row before by less than the tolerance
+		{Start: 7, End: 10, Text: "2b."},
+		{Start: 12, End: 14, Text: "Extra."},
+	}
+	rows := alignVersions(from, to)
+
+	want := []struct {
+		start, end float64
+		texts      string
+	}{
+		{0, 4.5, "One. | 1."},
+		{4.8, 10, "Two. | 2a. 2b."},
+		{12, 14, " | Extra."},
+		{20, 24, "Three. | "},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("%d rows, want %d: %+v", len(rows), len(want), rows)
+	}
+	for i, row := range rows {
+		if row.Start != want[i].start || row.End != want[i].end || rowTexts(row) != want[i].texts {
+			t.Errorf("row %d = %v-%v %q, want %v-%v %q", i, row.Start, row.End, rowTexts(row), want[i].start, want[i].end, want[i].texts)
+		}
+	}
+	if rows[0].From[0].Words != nil {
+		t.Errorf("row has words: %+v", rows[0].From[0])
+	}
+	if rows := alignVersions(nil, nil); rows == nil || len(rows) != 0 {
+		t.Errorf("empty versions aligned to %+v", rows)
+	}
+}
+
+func TestDiffTranscriptVersions(t *testing.T) {
+	from := []TranscriptEntry{
+		{Start: 0, End: 4, Text: "Hello there."},
+		{Start: 5, End: 9, Text: "Second part here."},
+		{Start: 10, End: 14, Text: "Removed bit."},
+		{Start: 20, End: 24, Text: "The end."},
+	}
+	to := []TranscriptEntry{
+		{Start: 0, End: 4, Text: "hello there"}, // Same but for case and punctuation
+		{Start: 5, End: 7, Text: "Second part"}, // Cut in two, with a word inserted
+		{Start: 7, End: 9, Text: "is here."},
+		{Start: 15, End: 18, Text: "New words."},
+		{Start: 20.1, End: 24, Text: "The end!"},
+	}
+	rows, summary := diffTranscriptVersions(from, to)
+
+	var types []string
+	for _, row := range rows {
+		types = append(types, row.Type)
+	}
+	if want := []string{"same", "changed", "removed", "added", "same"}; !reflect.DeepEqual(types, want) {
+		t.Fatalf("row types %v, want %v", types, want)
+	}
+	wantWords := []WordChange{{Type: "same", Text: "Second part"}, {Type: "added", Text: "is"}, {Type: "same", Text: "here."}}
+	if !reflect.DeepEqual(rows[1].Words, wantWords) {
+		t.Errorf("changed row words %+v, want %+v", rows[1].Words, wantWords)
+	}
+	for _, i := range []int{0, 2, 3, 4} {
+		if rows[i].Words != nil {
+			t.Errorf("%s row has words %+v", rows[i].Type, rows[i].Words)
+		}
+	}
+
+	wantSummary := VersionDiffSummary{
+		Rows: 5, Same: 2, Changed: 1, Added: 1, Removed: 1,
+		WordsFrom: 9, WordsTo: 10, WordsAdded: 3, WordsRemoved: 2,
+	}
+	if summary != wantSummary {
+		t.Errorf("summary %+v, want %+v", summary, wantSummary)
+	}
+}
+
+func TestDiffWords(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     []WordChange
+	}{
+		{"a b c", "a b c", []WordChange{{"same", "a b c"}}},
+		{"The cat sat.", "the dog sat", []WordChange{{"same", "the"}, {"removed", "cat"}, {"added", "dog"}, {"same", "sat"}}},
+		{"one two", "", []WordChange{{"removed", "one two"}}},
+		{"", "one two", []WordChange{{"added", "one two"}}},
+	}
+	for _, test := range tests {
+		if got := diffWords(strings.Fields(test.from), strings.Fields(test.to)); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("diffWords(%q, %q) = %+v, want %+v", test.from, test.to, got, test.want)
+		}
+	}
+}
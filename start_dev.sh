@@ -73,10 +73,10 @@ if [ ! -z "$PORT_PID" ]; then
 fi
 
 cd server
-go run main.go transcription.go > ../backend.log 2>&1 &
+go run . > ../backend.log 2>&1 &
 # Get the actual Go process PID, not the shell PID
 sleep 2
-BACKEND_PID=$(pgrep -f "go run main.go")
+BACKEND_PID=$(pgrep -f "go run .")
 # If pgrep fails, try to find by port
 if [ -z "$BACKEND_PID" ]; then
     BACKEND_PID=$(lsof -ti :8080)